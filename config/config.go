@@ -0,0 +1,95 @@
+// Package config resolves the settings shared by the examples: where
+// Redis and Kafka run and where HTTP servers listen.
+//
+// Values are resolved in the following order, later sources winning:
+// built-in defaults, the optional YAML file (-config), environment
+// variables (<PREFIX>_<FLAG_NAME>) and finally command line flags.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Load resolves a configuration of type T for program name from
+// defaults, YAML file, environment and the given command line arguments.
+// register binds the fields of T to flags, the positional arguments
+// after the flags are returned as well. Validation is left to the caller.
+func Load[T any](name, prefix string, args []string, defaults func() T, register func(*T, *flag.FlagSet)) (T, []string, error) {
+	cfg := new(T)
+	*cfg = defaults()
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv(EnvName(prefix, "config")), "path to optional YAML config file")
+	register(cfg, fs)
+
+	if err := fs.Parse(args); err != nil {
+		return *cfg, nil, err
+	}
+
+	// Remember what was given explicitly, so it can be re-applied
+	// on top of the file and the environment.
+	explicit := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	// Flags are bound to the fields of cfg, so resetting cfg in place
+	// keeps the bindings intact.
+	*cfg = defaults()
+	if *configPath != "" {
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			return *cfg, nil, fmt.Errorf("failed to read config file: %v", err)
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return *cfg, nil, fmt.Errorf("failed to parse config file: %v", err)
+		}
+	}
+
+	err := apply(fs, prefix, explicit)
+	return *cfg, fs.Args(), err
+}
+
+// ApplyEnv sets the flags registered by register from the environment,
+// for programs which take most of their settings from elsewhere.
+func ApplyEnv(prefix string, register func(*flag.FlagSet)) error {
+	fs := flag.NewFlagSet(prefix, flag.ContinueOnError)
+	register(fs)
+	return apply(fs, prefix, nil)
+}
+
+// apply sets every flag of fs to its explicit value, or to its
+// environment variable if it has one.
+func apply(fs *flag.FlagSet, prefix string, explicit map[string]string) error {
+	var setErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || setErr != nil {
+			return
+		}
+		value, ok := explicit[f.Name]
+		if !ok {
+			value, ok = os.LookupEnv(EnvName(prefix, f.Name))
+		}
+		if ok {
+			if err := fs.Set(f.Name, value); err != nil {
+				setErr = fmt.Errorf("invalid value %q for %s: %v", value, f.Name, err)
+			}
+		}
+	})
+	return setErr
+}
+
+// EnvName returns the environment variable consulted for a flag, e.g.
+// KAFKA_BOOTSTRAP_SERVERS, or REDIS_ADDR for flag redis-addr without prefix.
+func EnvName(prefix, flagName string) string {
+	name := strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+	if prefix == "" {
+		return name
+	}
+	return prefix + "_" + name
+}
@@ -0,0 +1,13 @@
+module kate.config
+
+go 1.24.1
+
+require (
+	github.com/go-redis/redis/v8 v8.11.5
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.1.2 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+)
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HTTPConfig is where an HTTP server listens.
+type HTTPConfig struct {
+	Addr         string        `yaml:"addr"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+}
+
+// DefaultHTTPConfig returns a server on port 8080 of all interfaces.
+func DefaultHTTPConfig() HTTPConfig {
+	return HTTPConfig{
+		Addr:         ":8080",
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+}
+
+// RegisterFlags binds the fields to flags of fs, prefixed with http-.
+func (c *HTTPConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Addr, "http-addr", c.Addr, "address to serve HTTP on")
+	fs.DurationVar(&c.ReadTimeout, "http-read-timeout", c.ReadTimeout, "how long reading a request may take, 0 for no limit")
+	fs.DurationVar(&c.WriteTimeout, "http-write-timeout", c.WriteTimeout, "how long writing a response may take, 0 for no limit")
+}
+
+// Validate checks the settings.
+func (c *HTTPConfig) Validate() error {
+	if c.Addr == "" {
+		return fmt.Errorf("http address is required")
+	}
+	if c.ReadTimeout < 0 || c.WriteTimeout < 0 {
+		return fmt.Errorf("http timeouts must not be negative")
+	}
+	return nil
+}
+
+// Server returns a server for handler with the configured address and
+// timeouts.
+func (c *HTTPConfig) Server(handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         c.Addr,
+		Handler:      handler,
+		ReadTimeout:  c.ReadTimeout,
+		WriteTimeout: c.WriteTimeout,
+	}
+}
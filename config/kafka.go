@@ -0,0 +1,108 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// KafkaConfig is the cluster to connect to and how to authenticate.
+type KafkaConfig struct {
+	BootstrapServers string `yaml:"bootstrap_servers"`
+
+	// Security settings
+	SecurityProtocol string `yaml:"security_protocol"`
+	SASLMechanism    string `yaml:"sasl_mechanism"`
+	SASLUsername     string `yaml:"sasl_username"`
+	SASLPassword     string `yaml:"sasl_password"`
+	SSLCALocation    string `yaml:"ssl_ca_location"`
+	SSLCertLocation  string `yaml:"ssl_certificate_location"`
+	SSLKeyLocation   string `yaml:"ssl_key_location"`
+	SSLKeyPassword   string `yaml:"ssl_key_password"`
+	// SSLSkipVerify disables broker hostname verification, for test clusters only.
+	SSLSkipVerify bool `yaml:"ssl_skip_verify"`
+}
+
+// DefaultKafkaConfig returns the broker of the docker-compose setup.
+func DefaultKafkaConfig() KafkaConfig {
+	return KafkaConfig{
+		BootstrapServers: "localhost:9092",
+		SecurityProtocol: "plaintext",
+	}
+}
+
+// RegisterFlags binds the fields to flags of fs.
+func (c *KafkaConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.BootstrapServers, "bootstrap-servers", c.BootstrapServers, "comma separated list of brokers")
+
+	fs.StringVar(&c.SecurityProtocol, "security-protocol", c.SecurityProtocol, "plaintext, ssl, sasl_plaintext or sasl_ssl")
+	fs.StringVar(&c.SASLMechanism, "sasl-mechanism", c.SASLMechanism, "PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512")
+	fs.StringVar(&c.SASLUsername, "sasl-username", c.SASLUsername, "SASL username")
+	fs.StringVar(&c.SASLPassword, "sasl-password", c.SASLPassword, "SASL password")
+	fs.StringVar(&c.SSLCALocation, "ssl-ca-location", c.SSLCALocation, "CA bundle to verify the brokers, system CAs if empty")
+	fs.StringVar(&c.SSLCertLocation, "ssl-certificate-location", c.SSLCertLocation, "client certificate for mutual TLS")
+	fs.StringVar(&c.SSLKeyLocation, "ssl-key-location", c.SSLKeyLocation, "private key of the client certificate")
+	fs.StringVar(&c.SSLKeyPassword, "ssl-key-password", c.SSLKeyPassword, "password of the private key")
+	fs.BoolVar(&c.SSLSkipVerify, "ssl-skip-verify", c.SSLSkipVerify, "do not verify the broker hostname")
+}
+
+// Validate checks the settings which would otherwise fail deep inside librdkafka.
+func (c *KafkaConfig) Validate() error {
+	if c.BootstrapServers == "" {
+		return fmt.Errorf("bootstrap servers are required")
+	}
+
+	protocol := strings.ToLower(c.SecurityProtocol)
+	switch protocol {
+	case "plaintext", "ssl", "sasl_plaintext", "sasl_ssl":
+	default:
+		return fmt.Errorf("unknown security protocol %q", c.SecurityProtocol)
+	}
+
+	usesSASL := strings.HasPrefix(protocol, "sasl_")
+	if usesSASL {
+		switch c.SASLMechanism {
+		case "PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512":
+		default:
+			return fmt.Errorf("security protocol %s needs sasl mechanism PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512, got %q", protocol, c.SASLMechanism)
+		}
+		if c.SASLUsername == "" || c.SASLPassword == "" {
+			return fmt.Errorf("security protocol %s needs sasl username and password", protocol)
+		}
+	} else if c.SASLMechanism != "" {
+		return fmt.Errorf("sasl mechanism needs security protocol sasl_plaintext or sasl_ssl")
+	}
+
+	if (c.SSLCertLocation == "") != (c.SSLKeyLocation == "") {
+		return fmt.Errorf("ssl certificate and key have to be given together")
+	}
+	return nil
+}
+
+// ClientProperties returns the connection and security settings as
+// librdkafka properties, shared by producers, consumers and admin clients.
+func (c *KafkaConfig) ClientProperties() map[string]string {
+	props := map[string]string{
+		"bootstrap.servers": c.BootstrapServers,
+		"security.protocol": c.SecurityProtocol,
+	}
+	if c.SASLMechanism != "" {
+		props["sasl.mechanism"] = c.SASLMechanism
+		props["sasl.username"] = c.SASLUsername
+		props["sasl.password"] = c.SASLPassword
+	}
+	if c.SSLCALocation != "" {
+		props["ssl.ca.location"] = c.SSLCALocation
+	}
+	if c.SSLCertLocation != "" {
+		props["ssl.certificate.location"] = c.SSLCertLocation
+		props["ssl.key.location"] = c.SSLKeyLocation
+		if c.SSLKeyPassword != "" {
+			props["ssl.key.password"] = c.SSLKeyPassword
+		}
+	}
+	if c.SSLSkipVerify {
+		props["ssl.endpoint.identification.algorithm"] = "none"
+	}
+	return props
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisConfig is the Redis server to connect to.
+type RedisConfig struct {
+	Addr     string `yaml:"addr"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+}
+
+// DefaultRedisConfig returns the server of the docker-compose setups.
+func DefaultRedisConfig() RedisConfig {
+	return RedisConfig{Addr: "localhost:6379"}
+}
+
+// RegisterFlags binds the fields to flags of fs, prefixed with redis-.
+func (c *RedisConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Addr, "redis-addr", c.Addr, "Redis host:port")
+	fs.StringVar(&c.Username, "redis-username", c.Username, "Redis ACL user, default user if empty")
+	fs.StringVar(&c.Password, "redis-password", c.Password, "Redis password")
+	fs.IntVar(&c.DB, "redis-db", c.DB, "Redis database number")
+}
+
+// Validate checks the settings.
+func (c *RedisConfig) Validate() error {
+	if c.Addr == "" {
+		return fmt.Errorf("redis address is required")
+	}
+	if c.DB < 0 {
+		return fmt.Errorf("redis db must not be negative, got %d", c.DB)
+	}
+	return nil
+}
+
+// Options returns the client options for the server.
+func (c *RedisConfig) Options() *redis.Options {
+	return &redis.Options{
+		Addr:     c.Addr,
+		Username: c.Username,
+		Password: c.Password,
+		DB:       c.DB,
+	}
+}
+
+// NewClient returns a client for the server.
+func (c *RedisConfig) NewClient() *redis.Client {
+	return redis.NewClient(c.Options())
+}
@@ -0,0 +1,92 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// checkTimeout bounds every health or readiness check.
+const checkTimeout = 2 * time.Second
+
+// Check returns an error when what it checks is not working.
+type Check func(ctx context.Context) error
+
+type check struct {
+	name string
+	fn   Check
+}
+
+// Health is the answer of /healthz and /readyz.
+type Health struct {
+	Healthy bool     `json:"healthy"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// AddLivenessCheck adds a check of /healthz. Failing liveness makes the
+// orchestrator restart the service, so check only what a restart fixes.
+func (s *Server) AddLivenessCheck(name string, fn Check) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.liveness = append(s.liveness, check{name: name, fn: fn})
+}
+
+// AddReadinessCheck adds a check of /readyz, e.g. that Redis answers.
+// While it fails the service gets no requests.
+func (s *Server) AddReadinessCheck(name string, fn Check) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readiness = append(s.readiness, check{name: name, fn: fn})
+}
+
+// Liveness runs the liveness checks.
+func (s *Server) Liveness(ctx context.Context) Health {
+	s.mu.Lock()
+	checks := s.liveness
+	s.mu.Unlock()
+	return runChecks(ctx, checks)
+}
+
+// Readiness runs the readiness checks, it is not ready while shutting
+// down.
+func (s *Server) Readiness(ctx context.Context) Health {
+	if s.shuttingDown.Load() {
+		return Health{Reasons: []string{"shutting down"}}
+	}
+	s.mu.Lock()
+	checks := s.readiness
+	s.mu.Unlock()
+	return runChecks(ctx, checks)
+}
+
+func runChecks(ctx context.Context, checks []check) Health {
+	h := Health{Healthy: true}
+	for _, c := range checks {
+		ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+		err := c.fn(ctx)
+		cancel()
+		if err != nil {
+			h.Healthy = false
+			h.Reasons = append(h.Reasons, fmt.Sprintf("%s: %v", c.name, err))
+		}
+	}
+	return h
+}
+
+func (s *Server) serveLiveness(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, s.Liveness(r.Context()))
+}
+
+func (s *Server) serveReadiness(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, s.Readiness(r.Context()))
+}
+
+func writeHealth(w http.ResponseWriter, h Health) {
+	w.Header().Set("Content-Type", "application/json")
+	if !h.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(h)
+}
@@ -0,0 +1,123 @@
+package httpserver
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics are the request metrics of a server, labelled by route, the
+// ServeMux pattern, rather than path so ids in paths add no series.
+type metrics struct {
+	inFlight prometheus.Gauge
+	duration *prometheus.HistogramVec
+}
+
+func newMetrics(registry *prometheus.Registry) *metrics {
+	m := &metrics{
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "http_server_requests_in_flight",
+			Help: "Requests being handled.",
+		}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_server_request_duration_seconds",
+			Help:    "Time the handler took per request.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 4, 10),
+		}, []string{"method", "route", "code"}),
+	}
+	registry.MustRegister(m.inFlight, m.duration)
+	return m
+}
+
+// statusWriter remembers the status code and size of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// code returns the status sent, 200 if the handler wrote nothing.
+func (w *statusWriter) code() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// logged logs every request with its status, size and duration.
+func (s *Server) logged(h http.Handler) http.Handler {
+	if !s.opts.AccessLog {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r)
+		log.Printf("%s %s %d %dB %v", r.Method, r.URL.RequestURI(), sw.code(), sw.bytes, time.Since(start).Round(time.Microsecond))
+	})
+}
+
+// measured records the duration and status of every request.
+func (s *Server) measured(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The pattern is known before the middleware of the service ran
+		route := "unmatched"
+		if _, pattern := s.mux.Handler(r); pattern != "" {
+			route = pattern
+		}
+
+		s.metrics.inFlight.Inc()
+		defer s.metrics.inFlight.Dec()
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r)
+		s.metrics.duration.WithLabelValues(r.Method, route, strconv.Itoa(sw.code())).Observe(time.Since(start).Seconds())
+	})
+}
+
+// recovered answers 500 if h panics, unless h already sent a response.
+// http.ErrAbortHandler is passed on, it aborts the response on purpose.
+func recovered(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			log.Printf("Panic serving %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+			if sw.status == 0 {
+				http.Error(sw, "Internal server error", http.StatusInternalServerError)
+			}
+		}()
+		h.ServeHTTP(sw, r)
+	})
+}
@@ -0,0 +1,225 @@
+// Package httpserver runs the HTTP servers of the examples: it serves the
+// handlers of a service behind a stack of middleware, answers health and
+// readiness probes, exports Prometheus metrics and shuts down gracefully
+// on SIGINT or SIGTERM.
+//
+// Every request is logged, counted and timed per route, a panicking
+// handler answers 500 instead of killing the connection, and handlers
+// running longer than RequestTimeout are answered with 503. /healthz,
+// /readyz and /metrics bypass the middleware of the service, so probes
+// are neither rate limited nor logged.
+//
+// On shutdown /readyz fails at once, so load balancers stop sending
+// requests, the server waits DrainDelay for them to notice, then stops
+// accepting connections and waits up to ShutdownTimeout for the requests
+// in flight. The OnShutdown hooks run last, e.g. to close Redis.
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"kate.config"
+)
+
+// Options configure the lifecycle and the middleware of a Server.
+type Options struct {
+	// RequestTimeout bounds the time a handler may take, 0 for no limit.
+	// Responses are buffered until the handler returns, so streaming
+	// handlers need it off.
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	// DrainDelay is how long readiness fails before the server stops
+	// accepting connections.
+	DrainDelay time.Duration `yaml:"drain_delay"`
+	// ShutdownTimeout bounds waiting for the requests in flight.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// AccessLog logs every request but the probes.
+	AccessLog bool `yaml:"access_log"`
+}
+
+// DefaultOptions returns a 30s request timeout, no drain delay, 10s to
+// shut down and the access log on.
+func DefaultOptions() Options {
+	return Options{
+		RequestTimeout:  30 * time.Second,
+		ShutdownTimeout: 10 * time.Second,
+		AccessLog:       true,
+	}
+}
+
+// RegisterFlags binds the fields to flags of fs.
+func (o *Options) RegisterFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&o.RequestTimeout, "request-timeout", o.RequestTimeout, "how long a handler may take, 0 for no limit")
+	fs.DurationVar(&o.DrainDelay, "drain-delay", o.DrainDelay, "how long readiness fails before shutting down")
+	fs.DurationVar(&o.ShutdownTimeout, "shutdown-timeout", o.ShutdownTimeout, "how long shutting down waits for requests in flight")
+	fs.BoolVar(&o.AccessLog, "access-log", o.AccessLog, "log every request")
+}
+
+// Validate checks the settings.
+func (o *Options) Validate() error {
+	if o.RequestTimeout < 0 || o.DrainDelay < 0 {
+		return fmt.Errorf("request timeout and drain delay must not be negative")
+	}
+	if o.ShutdownTimeout <= 0 {
+		return fmt.Errorf("shutdown timeout must be positive, got %v", o.ShutdownTimeout)
+	}
+	return nil
+}
+
+// Middleware wraps a handler, e.g. telemetry.Handler.
+type Middleware func(http.Handler) http.Handler
+
+// Server is an HTTP server with its routes, middleware, probes and
+// shutdown hooks, set up before Run.
+type Server struct {
+	name string
+	cfg  config.HTTPConfig
+	opts Options
+
+	mux        *http.ServeMux
+	middleware []Middleware
+	registry   *prometheus.Registry
+	metrics    *metrics
+
+	mu        sync.Mutex
+	liveness  []check
+	readiness []check
+	hooks     []func(context.Context) error
+
+	shuttingDown atomic.Bool
+}
+
+// New returns a server named name, which appears in its log lines, on
+// the address of cfg.
+func New(name string, cfg config.HTTPConfig, opts Options) *Server {
+	s := &Server{
+		name:     name,
+		cfg:      cfg,
+		opts:     opts,
+		mux:      http.NewServeMux(),
+		registry: prometheus.NewRegistry(),
+	}
+	s.metrics = newMetrics(s.registry)
+	s.registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return s
+}
+
+// Handle registers h for pattern, as http.ServeMux.Handle does.
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.mux.Handle(pattern, h)
+}
+
+// HandleFunc registers h for pattern, as http.ServeMux.HandleFunc does.
+func (s *Server) HandleFunc(pattern string, h func(http.ResponseWriter, *http.Request)) {
+	s.mux.HandleFunc(pattern, h)
+}
+
+// Use adds middleware of the service, the first added is outermost. It
+// runs inside the logging, metrics, recovery and timeout of the server.
+func (s *Server) Use(mw ...Middleware) {
+	s.middleware = append(s.middleware, mw...)
+}
+
+// Registry returns the registry served on /metrics, for the collectors
+// of the service.
+func (s *Server) Registry() *prometheus.Registry {
+	return s.registry
+}
+
+// OnShutdown adds a hook run after the server stopped, the last added
+// runs first.
+func (s *Server) OnShutdown(hook func(context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, hook)
+}
+
+// Handler returns the handler of all requests: the probes and /metrics,
+// and the routes of the service behind the middleware.
+func (s *Server) Handler() http.Handler {
+	var app http.Handler = s.mux
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		app = s.middleware[i](app)
+	}
+	if s.opts.RequestTimeout > 0 {
+		app = http.TimeoutHandler(app, s.opts.RequestTimeout, "Request timed out\n")
+	}
+	app = s.logged(s.measured(recovered(app)))
+
+	root := http.NewServeMux()
+	root.HandleFunc("GET /healthz", s.serveLiveness)
+	root.HandleFunc("GET /readyz", s.serveReadiness)
+	root.Handle("GET /metrics", promhttp.HandlerFor(s.registry, promhttp.HandlerOpts{}))
+	root.Handle("/", app)
+	return root
+}
+
+// Run serves until ctx is done or the process gets SIGINT or SIGTERM, and
+// then shuts down. It returns an error if the server failed to listen or
+// to shut down in time.
+func (s *Server) Run(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := s.cfg.Server(s.Handler())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+	log.Printf("%s listening on %s", s.name, s.cfg.Addr)
+
+	select {
+	case err := <-errc:
+		s.runHooks()
+		return fmt.Errorf("failed to serve http: %v", err)
+	case <-ctx.Done():
+	}
+	// A second signal kills the process
+	stop()
+
+	log.Printf("Shutting down %s", s.name)
+	s.shuttingDown.Store(true)
+	time.Sleep(s.opts.DrainDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.opts.ShutdownTimeout)
+	defer cancel()
+	err := srv.Shutdown(shutdownCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("requests still running after %v", s.opts.ShutdownTimeout)
+	}
+	s.runHooks()
+	if err != nil {
+		return fmt.Errorf("failed to shut down gracefully: %v", err)
+	}
+	return nil
+}
+
+// runHooks runs the shutdown hooks, last added first, within another
+// ShutdownTimeout and logs their errors.
+func (s *Server) runHooks() {
+	s.mu.Lock()
+	hooks := s.hooks
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.ShutdownTimeout)
+	defer cancel()
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := hooks[i](ctx); err != nil {
+			log.Printf("Shutdown hook of %s failed: %v", s.name, err)
+		}
+	}
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"kate.kafka.example/producer/producer"
+)
+
+const usage = `Usage: admin [flags] <command> [arguments]
+
+Commands:
+  topics                          list topics
+  describe <topic>                show partitions, replicas, ISR and configs
+  create <topic> [key=value ...]  create a topic, -num-partitions and
+                                  -replication-factor apply
+  delete <topic> ...              delete topics
+  groups                          list consumer groups
+  partitions [-dry-run] <topic> <count>
+                                  increase the partition count
+  alter-config [-dry-run] <topic> <key=value> ...
+                                  change topic configs, e.g. retention.ms
+  reset-offsets [-dry-run] <group> <to> <topic>[:partitions] ...
+                                  move the committed offsets of a group to
+                                  earliest, latest, a time (RFC 3339) or an
+                                  offset, for all or e.g. topic:0,2
+
+With -dry-run the current and proposed values are shown and the broker
+only validates the change, reset-offsets changes nothing at all.
+
+Connection flags are shared with the producer, see producer -h.
+`
+
+// command runs one admin command with its positional arguments.
+type command func(ctx context.Context, a *kafka.AdminClient, cfg producer.Config, args []string) error
+
+var commands = map[string]command{
+	"topics":   listTopics,
+	"describe": describeTopic,
+	"create":   createTopic,
+	"delete":   deleteTopics,
+	"groups":   listGroups,
+
+	"partitions":    alterPartitions,
+	"alter-config":  alterConfig,
+	"reset-offsets": resetOffsets,
+}
+
+func main() {
+	cfg, args, err := producer.LoadConfigArgs(os.Args[0], os.Args[1:])
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", args[0], usage)
+		os.Exit(2)
+	}
+
+	adminClient, err := kafka.NewAdminClient(cfg.ClientConfigMap())
+	if err != nil {
+		log.Fatal("Failed to create admin client: ", err)
+	}
+	defer adminClient.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := cmd(ctx, adminClient, cfg, args[1:]); err != nil {
+		log.Fatalf("%s failed: %v", args[0], err)
+	}
+}
+
+func listTopics(ctx context.Context, a *kafka.AdminClient, cfg producer.Config, args []string) error {
+	metadata, err := a.GetMetadata(nil, true, 10000)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(metadata.Topics))
+	for name := range metadata.Topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TOPIC\tPARTITIONS")
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%d\n", name, len(metadata.Topics[name].Partitions))
+	}
+	return w.Flush()
+}
+
+func describeTopic(ctx context.Context, a *kafka.AdminClient, cfg producer.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected exactly one topic")
+	}
+	topic := args[0]
+
+	result, err := a.DescribeTopics(ctx, kafka.NewTopicCollectionOfTopicNames([]string{topic}))
+	if err != nil {
+		return err
+	}
+	desc := result.TopicDescriptions[0]
+	if desc.Error.Code() != kafka.ErrNoError {
+		return desc.Error
+	}
+
+	fmt.Printf("Topic: %s (id %s)\n\n", desc.Name, desc.TopicID)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PARTITION\tLEADER\tREPLICAS\tISR")
+	for _, p := range desc.Partitions {
+		leader := "none"
+		if p.Leader != nil {
+			leader = fmt.Sprint(p.Leader.ID)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", p.Partition, leader, nodeIDs(p.Replicas), nodeIDs(p.Isr))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	configs, err := a.DescribeConfigs(ctx, []kafka.ConfigResource{{Type: kafka.ResourceTopic, Name: topic}})
+	if err != nil {
+		return err
+	}
+	if configs[0].Error.Code() != kafka.ErrNoError {
+		return configs[0].Error
+	}
+
+	names := make([]string, 0, len(configs[0].Config))
+	for name := range configs[0].Config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CONFIG\tVALUE\tSOURCE")
+	for _, name := range names {
+		entry := configs[0].Config[name]
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, entry.Value, entry.Source)
+	}
+	return w.Flush()
+}
+
+func createTopic(ctx context.Context, a *kafka.AdminClient, cfg producer.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected a topic")
+	}
+
+	cfg.Topic = args[0]
+	spec := cfg.TopicSpecification()
+	for _, pair := range args[1:] {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("topic config must be key=value, got %q", pair)
+		}
+		spec.Config[key] = value
+	}
+
+	results, err := a.CreateTopics(ctx, []kafka.TopicSpecification{spec},
+		kafka.SetAdminOperationTimeout(10*time.Second))
+	if err != nil {
+		return err
+	}
+	if err := topicResultsError(results); err != nil {
+		return err
+	}
+
+	fmt.Printf("Created topic %s with %d partitions, replication factor %d\n",
+		spec.Topic, spec.NumPartitions, spec.ReplicationFactor)
+	return nil
+}
+
+func deleteTopics(ctx context.Context, a *kafka.AdminClient, cfg producer.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected at least one topic")
+	}
+
+	results, err := a.DeleteTopics(ctx, args, kafka.SetAdminOperationTimeout(10*time.Second))
+	if err != nil {
+		return err
+	}
+	if err := topicResultsError(results); err != nil {
+		return err
+	}
+
+	fmt.Printf("Deleted %s\n", strings.Join(args, ", "))
+	return nil
+}
+
+func listGroups(ctx context.Context, a *kafka.AdminClient, cfg producer.Config, args []string) error {
+	result, err := a.ListConsumerGroups(ctx)
+	if err != nil {
+		return err
+	}
+	for _, err := range result.Errors {
+		fmt.Fprintf(os.Stderr, "Partial result: %v\n", err)
+	}
+
+	sort.Slice(result.Valid, func(i, j int) bool {
+		return result.Valid[i].GroupID < result.Valid[j].GroupID
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "GROUP\tSTATE\tSIMPLE")
+	for _, g := range result.Valid {
+		fmt.Fprintf(w, "%s\t%s\t%t\n", g.GroupID, g.State, g.IsSimpleConsumerGroup)
+	}
+	return w.Flush()
+}
+
+func alterPartitions(ctx context.Context, a *kafka.AdminClient, cfg producer.Config, args []string) error {
+	dryRun, args, err := parseDryRun("partitions", args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("expected a topic and the new partition count")
+	}
+	topic := args[0]
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid partition count %q", args[1])
+	}
+
+	metadata, err := a.GetMetadata(&topic, false, 10000)
+	if err != nil {
+		return err
+	}
+	current, ok := metadata.Topics[topic]
+	if !ok || current.Error.Code() != kafka.ErrNoError {
+		return fmt.Errorf("topic %s not found", topic)
+	}
+
+	fmt.Printf("Partitions of %s: current %d, proposed %d\n", topic, len(current.Partitions), count)
+	if count <= len(current.Partitions) {
+		return fmt.Errorf("partitions can only be increased")
+	}
+
+	results, err := a.CreatePartitions(ctx,
+		[]kafka.PartitionsSpecification{{Topic: topic, IncreaseTo: count}},
+		kafka.SetAdminValidateOnly(dryRun),
+		kafka.SetAdminOperationTimeout(10*time.Second))
+	if err != nil {
+		return err
+	}
+	if err := topicResultsError(results); err != nil {
+		return err
+	}
+
+	if dryRun {
+		fmt.Println("Dry run, the broker accepted the change")
+	} else {
+		fmt.Println("Partitions increased, keys may now map to different partitions")
+	}
+	return nil
+}
+
+func alterConfig(ctx context.Context, a *kafka.AdminClient, cfg producer.Config, args []string) error {
+	dryRun, args, err := parseDryRun("alter-config", args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("expected a topic and at least one key=value")
+	}
+	topic := args[0]
+
+	var entries []kafka.ConfigEntry
+	for _, pair := range args[1:] {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("topic config must be key=value, got %q", pair)
+		}
+		entries = append(entries, kafka.ConfigEntry{
+			Name:                 key,
+			Value:                value,
+			IncrementalOperation: kafka.AlterConfigOpTypeSet,
+		})
+	}
+
+	resource := kafka.ConfigResource{Type: kafka.ResourceTopic, Name: topic}
+	current, err := a.DescribeConfigs(ctx, []kafka.ConfigResource{resource})
+	if err != nil {
+		return err
+	}
+	if current[0].Error.Code() != kafka.ErrNoError {
+		return current[0].Error
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CONFIG\tCURRENT\tPROPOSED")
+	for _, e := range entries {
+		value := "<unknown>"
+		if entry, ok := current[0].Config[e.Name]; ok {
+			value = entry.Value
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", e.Name, value, e.Value)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	resource.Config = entries
+	results, err := a.IncrementalAlterConfigs(ctx, []kafka.ConfigResource{resource},
+		kafka.SetAdminValidateOnly(dryRun))
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Error.Code() != kafka.ErrNoError {
+			return fmt.Errorf("%s: %v", r.Name, r.Error)
+		}
+	}
+
+	if dryRun {
+		fmt.Println("Dry run, the broker accepted the change")
+	} else {
+		fmt.Println("Configs altered")
+	}
+	return nil
+}
+
+func resetOffsets(ctx context.Context, a *kafka.AdminClient, cfg producer.Config, args []string) error {
+	dryRun, args, err := parseDryRun("reset-offsets", args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 3 {
+		return fmt.Errorf("expected a group, where to reset to and at least one topic")
+	}
+	group, to := args[0], args[1]
+
+	partitions, err := selectPartitions(a, args[2:])
+	if err != nil {
+		return err
+	}
+
+	current, err := a.ListConsumerGroupOffsets(ctx, []kafka.ConsumerGroupTopicPartitions{{Group: group, Partitions: partitions}})
+	if err != nil {
+		return err
+	}
+	proposed, err := targetOffsets(ctx, a, partitions, to)
+	if err != nil {
+		return err
+	}
+
+	committed := map[string]kafka.Offset{}
+	for _, tp := range current.ConsumerGroupsTopicPartitions[0].Partitions {
+		committed[fmt.Sprintf("%s/%d", *tp.Topic, tp.Partition)] = tp.Offset
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TOPIC\tPARTITION\tCURRENT\tPROPOSED")
+	for _, tp := range proposed {
+		// Partitions without a committed offset show as unset.
+		current, ok := committed[fmt.Sprintf("%s/%d", *tp.Topic, tp.Partition)]
+		if !ok {
+			current = kafka.OffsetInvalid
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", *tp.Topic, tp.Partition, current, tp.Offset)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if dryRun {
+		fmt.Println("Dry run, offsets not changed")
+		return nil
+	}
+
+	// The coordinator refuses to change the offsets of active members.
+	groups, err := a.DescribeConsumerGroups(ctx, []string{group})
+	if err != nil {
+		return err
+	}
+	if desc := groups.ConsumerGroupDescriptions[0]; desc.State != kafka.ConsumerGroupStateEmpty && desc.State != kafka.ConsumerGroupStateDead {
+		return fmt.Errorf("group %s is %s with %d members, stop its consumers first", group, desc.State, len(desc.Members))
+	}
+
+	result, err := a.AlterConsumerGroupOffsets(ctx, []kafka.ConsumerGroupTopicPartitions{{Group: group, Partitions: proposed}})
+	if err != nil {
+		return err
+	}
+	for _, tp := range result.ConsumerGroupsTopicPartitions[0].Partitions {
+		if tp.Error != nil {
+			return fmt.Errorf("%s[%d]: %v", *tp.Topic, tp.Partition, tp.Error)
+		}
+	}
+	fmt.Println("Offsets reset")
+	return nil
+}
+
+// selectPartitions parses topic or topic:0,2 arguments, a plain topic
+// selects all of its partitions.
+func selectPartitions(a *kafka.AdminClient, args []string) ([]kafka.TopicPartition, error) {
+	var partitions []kafka.TopicPartition
+	for _, arg := range args {
+		topic, list, explicit := strings.Cut(arg, ":")
+		if explicit {
+			for _, item := range strings.Split(list, ",") {
+				p, err := strconv.Atoi(item)
+				if err != nil || p < 0 {
+					return nil, fmt.Errorf("invalid partition %q of %s", item, topic)
+				}
+				partitions = append(partitions, kafka.TopicPartition{Topic: &topic, Partition: int32(p)})
+			}
+			continue
+		}
+
+		metadata, err := a.GetMetadata(&topic, false, 10000)
+		if err != nil {
+			return nil, err
+		}
+		t, ok := metadata.Topics[topic]
+		if !ok || t.Error.Code() != kafka.ErrNoError {
+			return nil, fmt.Errorf("topic %s not found", topic)
+		}
+		ids := make([]int32, 0, len(t.Partitions))
+		for _, p := range t.Partitions {
+			ids = append(ids, p.ID)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		for _, id := range ids {
+			partitions = append(partitions, kafka.TopicPartition{Topic: &topic, Partition: id})
+		}
+	}
+	return partitions, nil
+}
+
+// targetOffsets returns partitions with the offsets to reset to: earliest,
+// latest, the first offset at an RFC 3339 time or the given offset.
+func targetOffsets(ctx context.Context, a *kafka.AdminClient, partitions []kafka.TopicPartition, to string) ([]kafka.TopicPartition, error) {
+	result := make([]kafka.TopicPartition, len(partitions))
+	copy(result, partitions)
+
+	if offset, err := strconv.ParseInt(to, 10, 64); err == nil {
+		if offset < 0 {
+			return nil, fmt.Errorf("offset must not be negative")
+		}
+		for i := range result {
+			result[i].Offset = kafka.Offset(offset)
+		}
+		return result, nil
+	}
+
+	var spec kafka.OffsetSpec
+	switch to {
+	case "earliest":
+		spec = kafka.EarliestOffsetSpec
+	case "latest":
+		spec = kafka.LatestOffsetSpec
+	default:
+		at, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return nil, fmt.Errorf("expected earliest, latest, an RFC 3339 time or an offset, got %q", to)
+		}
+		spec = kafka.NewOffsetSpecForTimestamp(at.UnixMilli())
+	}
+
+	offsets, err := listOffsets(ctx, a, partitions, spec)
+	if err != nil {
+		return nil, err
+	}
+	var latest map[string]kafka.Offset
+	for i, tp := range result {
+		offset := offsets[fmt.Sprintf("%s/%d", *tp.Topic, tp.Partition)]
+		if offset < 0 {
+			// No message since then, start after the last one.
+			if latest == nil {
+				if latest, err = listOffsets(ctx, a, partitions, kafka.LatestOffsetSpec); err != nil {
+					return nil, err
+				}
+			}
+			offset = latest[fmt.Sprintf("%s/%d", *tp.Topic, tp.Partition)]
+		}
+		result[i].Offset = offset
+	}
+	return result, nil
+}
+
+// listOffsets looks up spec for the partitions, keyed by topic/partition.
+func listOffsets(ctx context.Context, a *kafka.AdminClient, partitions []kafka.TopicPartition, spec kafka.OffsetSpec) (map[string]kafka.Offset, error) {
+	query := map[kafka.TopicPartition]kafka.OffsetSpec{}
+	for _, tp := range partitions {
+		query[tp] = spec
+	}
+	result, err := a.ListOffsets(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := map[string]kafka.Offset{}
+	for tp, info := range result.ResultInfos {
+		if info.Error.Code() != kafka.ErrNoError {
+			return nil, fmt.Errorf("%s[%d]: %v", *tp.Topic, tp.Partition, info.Error)
+		}
+		offsets[fmt.Sprintf("%s/%d", *tp.Topic, tp.Partition)] = info.Offset
+	}
+	return offsets, nil
+}
+
+// parseDryRun parses the -dry-run flag of a command.
+func parseDryRun(name string, args []string) (bool, []string, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "show the change and let the broker validate it only")
+	if err := fs.Parse(args); err != nil {
+		return false, nil, err
+	}
+	return *dryRun, fs.Args(), nil
+}
+
+// topicResultsError returns the first error of per topic results.
+func topicResultsError(results []kafka.TopicResult) error {
+	for _, r := range results {
+		if r.Error.Code() != kafka.ErrNoError {
+			return fmt.Errorf("%s: %v", r.Topic, r.Error)
+		}
+	}
+	return nil
+}
+
+func nodeIDs(nodes []kafka.Node) string {
+	ids := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		ids = append(ids, fmt.Sprint(n.ID))
+	}
+	return strings.Join(ids, ",")
+}
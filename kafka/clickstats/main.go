@@ -0,0 +1,257 @@
+// Click stats pipeline: produces synthetic click events to Kafka, consumes
+// them as member of a consumer group and counts them in Redis with the
+// page view counter of the pageviewstats example.
+//
+// Offsets are committed only after Redis counted an event, so events are
+// delivered at least once. Every event carries an id which is recorded in
+// the same Redis transaction as the counters, an event delivered again is
+// therefore counted once. With -dedup the ids go into a Bloom filter
+// instead, which needs far less memory than a key per event.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"kate.config"
+	"kate.kafka.example/consumer/consumer"
+	"kate.kafka.example/producer/producer"
+	"kate.redis.pageviewstats/stats"
+	"kate.telemetry"
+)
+
+const usage = `Usage: clickstats [flags] [<events>]
+
+Produces <events> (default 100) click events to the first of -topics and
+counts them in Redis, consuming -topics as member of -group-id. Every tenth
+event is produced twice, as by a producer retrying after a lost ack, and
+still counted once. Stops when all events were counted or on SIGINT.
+With -dedup the event ids are remembered in a filter of that kind rather
+than in a key each.
+
+Redis is set with REDIS_ADDR (default localhost:6379), REDIS_USERNAME,
+REDIS_PASSWORD and REDIS_DB, other flags are shared with the consumer, see
+consumer -h.
+`
+
+// event is a click on a page, the value of every message as JSON.
+type event struct {
+	ID   string    `json:"id"`
+	Page string    `json:"page"`
+	User string    `json:"user"`
+	Time time.Time `json:"time"`
+}
+
+var pages = []string{"home", "about", "pricing", "blog", "docs"}
+
+// pipeline counts the events it produced as they come back from Kafka.
+type pipeline struct {
+	counter *stats.StatsCounter
+
+	// produced holds the ids of the events not yet seen by the consumer,
+	// remaining counts them, stop is called once it drops to zero.
+	produced  sync.Map
+	remaining atomic.Int64
+	stop      context.CancelFunc
+}
+
+func main() {
+	cfg, args, err := consumer.LoadConfigArgs(os.Args[0], os.Args[1:])
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+	events, err := parseArgs(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n%s", err, usage)
+		os.Exit(2)
+	}
+
+	rcfg := config.DefaultRedisConfig()
+	if err := config.ApplyEnv("", rcfg.RegisterFlags); err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// With -otlp-endpoint the trace of an event spans its producer, the
+	// consumer and the Redis transaction counting it.
+	shutdownTracing, err := telemetry.Setup(context.Background(), "go-examples-clickstats", telemetry.Config{OTLPEndpoint: cfg.OTLPEndpoint})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rdb := rcfg.NewClient()
+	telemetry.InstrumentRedis(rdb)
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		log.Fatal("Redis connection failed: ", err)
+	}
+	defer rdb.Close()
+
+	counter := stats.NewStatsCounter(rdb)
+	if cfg.Dedup != "" {
+		filter, err := consumer.NewDedupFilter(ctx, cfg, rdb)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Remembering event ids in a %s filter", filter.Kind())
+		counter = counter.WithDedup(filter)
+	}
+
+	pcfg := producer.DefaultConfig()
+	pcfg.KafkaConfig = cfg.KafkaConfig
+	pcfg.Topic = cfg.Topics[0]
+	pcfg.Format = producer.FormatJSON
+	pcfg.ContentType = "application/json"
+	pcfg.Source = "go-examples-clickstats"
+	p, err := producer.NewProducer(pcfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	topicCtx, cancelTopic := context.WithTimeout(ctx, 10*time.Second)
+	err = p.EnsureTopic(topicCtx)
+	cancelTopic()
+	if err != nil {
+		p.Close()
+		log.Fatal(err)
+	}
+
+	c, err := consumer.NewConsumer(cfg)
+	if err != nil {
+		p.Close()
+		log.Fatal(err)
+	}
+
+	if cfg.OTLPEndpoint != "" {
+		p.Use(producer.NewTracingMiddleware(otel.GetTracerProvider(), pcfg.Topic))
+		c.UseTracing(otel.GetTracerProvider())
+	}
+
+	runCtx, cancelRun := context.WithCancel(ctx)
+	defer cancelRun()
+	pl := &pipeline{counter: counter, stop: cancelRun}
+	pl.remaining.Store(int64(events))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		pl.produce(runCtx, p, events, pcfg.FlushTimeoutMs)
+	}()
+
+	log.Printf("Counting %d click events on %s in Redis", events, pcfg.Topic)
+	if err := c.Run(runCtx, pl.handle); err != nil {
+		fmt.Fprintf(os.Stderr, "Consuming failed: %v\n", err)
+	}
+	cancelRun()
+	wg.Wait()
+
+	if err := c.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to commit offsets: %v\n", err)
+	}
+	p.Close()
+	if err := shutdownTracing(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to export spans: %v\n", err)
+	}
+
+	for _, page := range pages {
+		s, err := pl.counter.GetPageStats(page)
+		if err != nil {
+			log.Printf("Failed to get stats of %s: %v", page, err)
+			continue
+		}
+		fmt.Printf("%-8s total %v, today %v, unique visitors %v\n", page, s["total_views"], s["today_views"], s["unique_visitors"])
+	}
+}
+
+func parseArgs(args []string) (int, error) {
+	switch len(args) {
+	case 0:
+		return 100, nil
+	case 1:
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid number of events %q", args[0])
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("invalid arguments %q", args)
+}
+
+// produce sends n events keyed by page, so the clicks of a page stay in
+// order, and waits for their delivery.
+func (pl *pipeline) produce(ctx context.Context, p *producer.Producer, n, flushTimeoutMs int) {
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		ev := event{
+			ID:   uuid.New().String(),
+			Page: pages[i%len(pages)],
+			User: fmt.Sprintf("user-%d", i%20),
+			Time: time.Now(),
+		}
+		value, err := p.Serialize(ev)
+		if err != nil {
+			log.Printf("Failed to encode event %s: %v", ev.ID, err)
+			pl.done()
+			continue
+		}
+		pl.produced.Store(ev.ID, struct{}{})
+
+		msg := producer.Message{Key: []byte(ev.Page), Value: value}
+		copies := 1
+		if i%10 == 9 {
+			copies = 2
+		}
+		for j := 0; j < copies; j++ {
+			if err := p.Produce(msg); err != nil {
+				log.Printf("Failed to produce event %s: %v", ev.ID, err)
+			}
+		}
+	}
+
+	remaining := p.Shutdown(flushTimeoutMs)
+	s := p.Stats()
+	log.Printf("Produced events, delivered: %d, failed: %d, unsent: %d", s.Delivered, s.Failed, remaining)
+}
+
+// handle counts the event in msg. Malformed messages are skipped, a Redis
+// error makes the consumer deliver the message again.
+func (pl *pipeline) handle(ctx context.Context, msg *kafka.Message) error {
+	var ev event
+	if err := json.Unmarshal(msg.Value, &ev); err != nil || ev.ID == "" {
+		log.Printf("Skipping malformed event on %s", msg.TopicPartition)
+		return nil
+	}
+
+	tracked, err := pl.counter.WithContext(ctx).TrackPageViewOnce(ev.ID, ev.Page, ev.User)
+	if err != nil {
+		return err
+	}
+	if !tracked {
+		log.Printf("Skipping duplicate event %s on %s", ev.ID, msg.TopicPartition)
+	}
+
+	if _, ok := pl.produced.LoadAndDelete(ev.ID); ok {
+		pl.done()
+	}
+	return nil
+}
+
+// done marks one produced event as seen and stops the pipeline after the last.
+func (pl *pipeline) done() {
+	if pl.remaining.Add(-1) == 0 {
+		pl.stop()
+	}
+}
@@ -0,0 +1,107 @@
+package consumer
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// BatchHandler processes a batch of messages at once, e.g. with one bulk
+// insert. The offsets of the batch are committed after it returned nil,
+// an error makes the consumer deliver the whole batch again.
+type BatchHandler func(ctx context.Context, msgs []*kafka.Message) error
+
+// RunBatch polls up to BatchSize messages, or what arrived within
+// BatchTimeout, passes them to handler and commits once per batch, until
+// ctx is done. Messages skipped by the filters or quarantined are
+// committed with the batch but not passed to handler.
+func (c *Consumer) RunBatch(ctx context.Context, handler BatchHandler) error {
+	for ctx.Err() == nil && c.halted() == nil {
+		batch := c.collectBatch(ctx)
+		if len(batch) == 0 {
+			continue
+		}
+
+		matching, err := c.batchMatching(ctx, batch)
+		if err != nil {
+			log.Printf("Quarantining invalid messages of batch failed, retrying: %v", err)
+			time.Sleep(handlerRetryDelay)
+			c.rewind(batch)
+			continue
+		}
+
+		start := time.Now()
+		err = handler(ctx, matching)
+		c.observe(start, err)
+		if err != nil {
+			log.Printf("Handling batch of %d messages failed, retrying: %v", len(matching), err)
+			time.Sleep(handlerRetryDelay)
+			c.rewind(batch)
+			continue
+		}
+
+		if _, err := c.consumer.StoreOffsets(nextOffsets(batch)); err != nil {
+			log.Printf("Failed to store offsets of batch: %v", err)
+			continue
+		}
+		c.uncommitted.Add(int64(len(batch)))
+		if err := c.Commit(); err != nil {
+			log.Printf("Failed to commit offsets: %v", err)
+		}
+	}
+	return c.halted()
+}
+
+// batchMatching returns the messages of batch matching the filters,
+// without the invalid ones it quarantined.
+func (c *Consumer) batchMatching(ctx context.Context, batch []*kafka.Message) ([]*kafka.Message, error) {
+	var matching []*kafka.Message
+	for _, msg := range batch {
+		if !c.filter.matches(msg) {
+			continue
+		}
+		if c.quarantine != nil {
+			invalid, err := c.quarantine.check(ctx, msg)
+			if err != nil {
+				return nil, err
+			}
+			if invalid {
+				c.counters.quarantined.Add(1)
+				continue
+			}
+		}
+		matching = append(matching, msg)
+	}
+	return matching, nil
+}
+
+// collectBatch returns up to BatchSize messages, fewer when BatchTimeout
+// passed since the first one.
+func (c *Consumer) collectBatch(ctx context.Context) []*kafka.Message {
+	var batch []*kafka.Message
+	var deadline time.Time
+	for len(batch) < c.cfg.BatchSize && ctx.Err() == nil && c.halted() == nil {
+		timeout := c.cfg.PollTimeout
+		if len(batch) > 0 {
+			timeout = time.Until(deadline)
+			if timeout <= 0 {
+				break
+			}
+		}
+
+		msg, err := c.consumer.ReadMessage(timeout)
+		c.lastPoll.Store(time.Now().UnixNano())
+		if err != nil {
+			c.pollError(err)
+			continue
+		}
+		c.lastMessage.Store(time.Now().UnixNano())
+		if len(batch) == 0 {
+			deadline = time.Now().Add(c.cfg.BatchTimeout)
+		}
+		batch = append(batch, msg)
+	}
+	return batch
+}
@@ -0,0 +1,104 @@
+package consumer
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Commit strategies, see Config.CommitStrategy.
+const (
+	// CommitAuto lets librdkafka commit the stored offsets every
+	// CommitInterval in the background.
+	CommitAuto = "auto"
+	// CommitSync commits synchronously after every handled message.
+	CommitSync = "sync"
+	// CommitInterval commits asynchronously every CommitInterval.
+	CommitInterval = "interval"
+	// CommitBatch commits synchronously after CommitEvery handled messages.
+	CommitBatch = "batch"
+)
+
+// CommitStrategy decides when the offsets of handled messages are
+// committed. Offsets are only ever committed for handled messages, the
+// strategy trades durability of the progress against throughput.
+type CommitStrategy interface {
+	// Due reports whether to commit now, given the number of messages
+	// handled since the last commit. It is called from the poll loop.
+	Due(uncommitted int64) bool
+	// Async reports whether the poll loop goes on while committing.
+	Async() bool
+}
+
+// NewCommitStrategy returns the configured strategy.
+func NewCommitStrategy(cfg Config) (CommitStrategy, error) {
+	switch cfg.CommitStrategy {
+	case CommitAuto:
+		return autoCommit{}, nil
+	case CommitSync:
+		return &batchCommit{size: 1}, nil
+	case CommitInterval:
+		return &intervalCommit{interval: cfg.CommitInterval, last: time.Now()}, nil
+	case CommitBatch:
+		return &batchCommit{size: int64(cfg.CommitEvery)}, nil
+	}
+	return nil, fmt.Errorf("unknown commit strategy %q", cfg.CommitStrategy)
+}
+
+// autoCommit leaves committing to librdkafka, see ConsumerConfigMap.
+type autoCommit struct{}
+
+func (autoCommit) Due(int64) bool { return false }
+func (autoCommit) Async() bool    { return true }
+
+type batchCommit struct {
+	size int64
+}
+
+func (b *batchCommit) Due(uncommitted int64) bool { return uncommitted >= b.size }
+func (b *batchCommit) Async() bool                { return false }
+
+type intervalCommit struct {
+	interval time.Duration
+	last     time.Time
+}
+
+func (i *intervalCommit) Due(uncommitted int64) bool {
+	if uncommitted == 0 || time.Since(i.last) < i.interval {
+		return false
+	}
+	i.last = time.Now()
+	return true
+}
+
+func (i *intervalCommit) Async() bool { return true }
+
+// CommitWith replaces the configured commit strategy. Call it before Run.
+func (c *Consumer) CommitWith(s CommitStrategy) {
+	c.strategy = s
+}
+
+// maybeCommit commits when the strategy says so. An asynchronous commit
+// is skipped while the previous one is still running.
+func (c *Consumer) maybeCommit() {
+	if !c.strategy.Due(c.uncommitted.Load()) {
+		return
+	}
+	if !c.strategy.Async() {
+		if err := c.Commit(); err != nil {
+			log.Printf("Failed to commit offsets: %v", err)
+		}
+		return
+	}
+	if !c.committing.CompareAndSwap(false, true) {
+		return
+	}
+	c.background.Add(1)
+	go func() {
+		defer c.background.Done()
+		defer c.committing.Store(false)
+		if err := c.Commit(); err != nil {
+			log.Printf("Failed to commit offsets: %v", err)
+		}
+	}()
+}
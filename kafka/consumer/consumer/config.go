@@ -0,0 +1,526 @@
+package consumer
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"kate.config"
+	"kate.kafka.example/producer/producer"
+	"kate.redis.dedup"
+)
+
+// Config describes the cluster, what to consume and the consumer tunables.
+//
+// Values are resolved in the following order, later sources winning:
+// built-in defaults, the optional YAML file (-config), environment
+// variables (KAFKA_<FLAG_NAME>) and finally command line flags.
+type Config struct {
+	// The cluster and its security settings.
+	config.KafkaConfig `yaml:",inline"`
+
+	// Topics are subscribed to, or with ModeAssign the partitions of
+	// them are assigned. A topic starting with "^" is a regular expression
+	// matching all topics it fits, in ModeSubscribe only.
+	Topics  []string `yaml:"topics"`
+	GroupID string   `yaml:"group_id"`
+
+	// GroupInstanceID makes the consumer a static member of the group: a
+	// restart within SessionTimeout gets the same partitions back without
+	// a rebalance. It must be unique in the group and stable across
+	// restarts, e.g. the pod name of a StatefulSet.
+	GroupInstanceID string        `yaml:"group_instance_id"`
+	SessionTimeout  time.Duration `yaml:"session_timeout"`
+
+	// AssignmentStrategy balances the partitions over the group members:
+	// range or roundrobin with the eager protocol, which stops the whole
+	// group on every rebalance, or cooperative-sticky, which only moves
+	// the partitions that have to. All members must use the same protocol.
+	AssignmentStrategy string `yaml:"assignment_strategy"`
+
+	// How partitions are obtained, see the Mode constants. ModeAssign
+	// starts reading Partition at Offset: beginning, end, stored or a
+	// number.
+	Mode      string `yaml:"mode"`
+	Partition int    `yaml:"partition"`
+	Offset    string `yaml:"offset"`
+
+	// FromOffset or FromTimestamp (RFC 3339) replace the start offset of
+	// every partition the first time it is assigned, for replays.
+	FromOffset    int64  `yaml:"from_offset"`
+	FromTimestamp string `yaml:"from_timestamp"`
+
+	// Only messages with all FilterHeaders and a key matching
+	// FilterKeyRegex are handled, the others are skipped.
+	FilterHeaders  map[string]string `yaml:"filter_headers"`
+	FilterKeyRegex string            `yaml:"filter_key_regex"`
+
+	// MetadataRefresh is how often the topics are listed, so topics
+	// created later are consumed when they match a pattern of Topics.
+	MetadataRefresh time.Duration `yaml:"metadata_refresh"`
+
+	// With JSONSchemaFile payloads are validated before they are handled.
+	// Invalid messages go to QuarantineTopic, with the validation error
+	// in a header, or without one as JSON line to QuarantineFile.
+	JSONSchemaFile  string `yaml:"json_schema_file"`
+	QuarantineTopic string `yaml:"quarantine_topic"`
+	QuarantineFile  string `yaml:"quarantine_file"`
+
+	// AutoOffsetReset applies when the group has no committed offset.
+	AutoOffsetReset string        `yaml:"auto_offset_reset"`
+	PollTimeout     time.Duration `yaml:"poll_timeout"`
+
+	// CommitStrategy decides when the offsets of handled messages are
+	// committed, see the Commit constants. The batch strategy commits
+	// after CommitEvery messages, interval and auto every CommitInterval.
+	CommitStrategy string        `yaml:"commit_strategy"`
+	CommitEvery    int           `yaml:"commit_every"`
+	CommitInterval time.Duration `yaml:"commit_interval"`
+
+	// Workers handle messages concurrently. Offsets are committed up to
+	// the first message not yet handled, so nothing is skipped, but
+	// messages of a partition may be handled out of order.
+	Workers int `yaml:"workers"`
+	// PerPartition handles every assigned partition in its own goroutine
+	// instead: partitions in parallel, the messages of each in order.
+	PerPartition bool `yaml:"per_partition"`
+	// BufferSize messages wait for a worker at most, then the partitions
+	// are paused until half of them were handled.
+	BufferSize int `yaml:"buffer_size"`
+	// On revoke the workers get RevokeTimeout to finish their messages,
+	// so their offsets are committed. Messages taking longer, e.g. retried
+	// again and again, are handled again by the next owner.
+	RevokeTimeout time.Duration `yaml:"revoke_timeout"`
+
+	// BatchSize enables batch mode, see Consumer.RunBatch: up to that
+	// many messages, or those arriving within BatchTimeout of the first,
+	// are handled and committed together.
+	BatchSize    int           `yaml:"batch_size"`
+	BatchTimeout time.Duration `yaml:"batch_timeout"`
+
+	// With RetryAttempts a message the handler failed on is published to
+	// the retry topic of its topic, handled again after RetryDelay and
+	// after RetryAttempts published to the dead-letter topic instead.
+	// Without, it is handled again right away, blocking its partition.
+	RetryAttempts int           `yaml:"retry_attempts"`
+	RetryDelay    time.Duration `yaml:"retry_delay"`
+
+	// Without retry topics, a message failing PoisonAttempts times in a
+	// row is a poison pill and the PoisonPolicy applies, see the Poison
+	// constants. Its offset is logged or part of the halt error.
+	PoisonPolicy   string `yaml:"poison_policy"`
+	PoisonAttempts int    `yaml:"poison_attempts"`
+
+	// TransformTopic enables the exactly-once consume-transform-produce
+	// pipeline, see Consumer.RunTransform. The producer writing to it uses
+	// TransactionalID, which must be stable across restarts.
+	TransformTopic  string `yaml:"transform_topic"`
+	TransactionalID string `yaml:"transactional_id"`
+
+	// Every LagInterval the lag of the assigned partitions is logged,
+	// with a warning when a partition is more than LagAlert messages
+	// behind. 0 disables them.
+	LagInterval time.Duration `yaml:"lag_interval"`
+	LagAlert    int64         `yaml:"lag_alert"`
+
+	// Prometheus metrics on MetricsAddr.
+	MetricsAddr string `yaml:"metrics_addr"`
+
+	// HealthAddr serves /healthz and /status, see Consumer.Health. The
+	// consumer is unhealthy when the poll loop did not run for
+	// StallTimeout, e.g. because a handler hangs.
+	HealthAddr   string        `yaml:"health_addr"`
+	StallTimeout time.Duration `yaml:"stall_timeout"`
+
+	// With Dedup, the kind of filter of the dedup package, messages whose
+	// id was handled before are skipped, see Consumer.UseDedup. The id is
+	// the value of the DedupHeader header, by default the message id the
+	// producer sets per message. Empty DedupHeader takes the key, only for
+	// topics keyed by message id. Ids are remembered for one to two
+	// DedupWindows.
+	Dedup       string        `yaml:"dedup"`
+	DedupHeader string        `yaml:"dedup_header"`
+	DedupWindow time.Duration `yaml:"dedup_window"`
+
+	// OTLPEndpoint enables tracing: every handled message gets a consumer
+	// span in the trace of its producer, exported via OTLP over HTTP.
+	OTLPEndpoint string `yaml:"otlp_endpoint"`
+
+	// Payload format and schema registry settings. Writer schemas are
+	// fetched from the registry by the ID in the payload. With RawFallback
+	// payloads which cannot be decoded are passed on as raw bytes.
+	Format                 string `yaml:"format"`
+	RawFallback            bool   `yaml:"raw_fallback"`
+	SchemaRegistryURL      string `yaml:"schema_registry_url"`
+	SchemaRegistryUsername string `yaml:"schema_registry_username"`
+	SchemaRegistryPassword string `yaml:"schema_registry_password"`
+}
+
+// Modes of obtaining partitions.
+const (
+	// ModeSubscribe lets the group coordinator balance the partitions of
+	// Topics over the members of the group.
+	ModeSubscribe = "subscribe"
+	// ModeAssign reads Partition of every topic from Offset, without
+	// group management.
+	ModeAssign = "assign"
+)
+
+// DefaultConfig returns the settings the examples used to hard-code.
+func DefaultConfig() Config {
+	return Config{
+		KafkaConfig:        config.DefaultKafkaConfig(),
+		Topics:             []string{"myTopic2"},
+		GroupID:            "myGroup",
+		SessionTimeout:     45 * time.Second,
+		AssignmentStrategy: "range",
+		Mode:               ModeSubscribe,
+		Partition:          0,
+		Offset:             "beginning",
+		FromOffset:         -1,
+		MetadataRefresh:    30 * time.Second,
+		AutoOffsetReset:    "earliest",
+		PollTimeout:        time.Second,
+		CommitStrategy:     CommitBatch,
+		CommitEvery:        100,
+		CommitInterval:     5 * time.Second,
+		Workers:            1,
+		BufferSize:         1000,
+		RevokeTimeout:      30 * time.Second,
+		BatchTimeout:       500 * time.Millisecond,
+		RetryDelay:         30 * time.Second,
+		QuarantineFile:     "consumer-quarantine.log",
+		StallTimeout:       time.Minute,
+		PoisonPolicy:       PoisonRetry,
+		PoisonAttempts:     3,
+		DedupHeader:        producer.HeaderMessageID,
+		DedupWindow:        24 * time.Hour,
+		Format:             FormatRaw,
+	}
+}
+
+// LoadConfig resolves the configuration from defaults, YAML file,
+// environment and the given command line arguments.
+func LoadConfig(name string, args []string) (Config, error) {
+	cfg, _, err := LoadConfigArgs(name, args)
+	return cfg, err
+}
+
+// LoadConfigArgs is LoadConfig for programs taking positional arguments
+// after the flags, it returns them as well.
+func LoadConfigArgs(name string, args []string) (Config, []string, error) {
+	cfg, args, err := config.Load(name, "KAFKA", args, DefaultConfig, (*Config).RegisterFlags)
+	if err != nil {
+		return cfg, nil, err
+	}
+	return cfg, args, cfg.Validate()
+}
+
+// EnvName returns the environment variable consulted for a flag.
+func EnvName(flagName string) string {
+	return config.EnvName("KAFKA", flagName)
+}
+
+// RegisterFlags binds the config fields to flags of fs.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	c.KafkaConfig.RegisterFlags(fs)
+
+	fs.Var((*listFlag)(&c.Topics), "topics", "comma separated topics to consume")
+	fs.StringVar(&c.GroupID, "group-id", c.GroupID, "consumer group")
+	fs.StringVar(&c.GroupInstanceID, "group-instance-id", c.GroupInstanceID, "static group member id, unique and stable across restarts")
+	fs.DurationVar(&c.SessionTimeout, "session-timeout", c.SessionTimeout, "how long the group waits for a member before rebalancing")
+	fs.StringVar(&c.AssignmentStrategy, "assignment-strategy", c.AssignmentStrategy, "range, roundrobin or cooperative-sticky for incremental rebalances")
+	fs.StringVar(&c.Mode, "mode", c.Mode, "subscribe to the topics as group member, or assign a partition of them")
+	fs.IntVar(&c.Partition, "partition", c.Partition, "partition of every topic read in assign mode")
+	fs.Int64Var(&c.FromOffset, "from-offset", c.FromOffset, "start every partition at this offset, -1 for the normal start")
+	fs.StringVar(&c.FromTimestamp, "from-timestamp", c.FromTimestamp, "start every partition at the first message since, e.g. 2024-06-01T00:00:00Z")
+	fs.StringVar(&c.Offset, "offset", c.Offset, "where to start in assign mode: beginning, end, stored or an offset")
+
+	fs.Var((*mapFlag)(&c.FilterHeaders), "filter-header", "only handle messages with this header key=value, repeatable")
+	fs.StringVar(&c.FilterKeyRegex, "filter-key-regex", c.FilterKeyRegex, "only handle messages with a key matching this regular expression")
+
+	fs.DurationVar(&c.MetadataRefresh, "metadata-refresh", c.MetadataRefresh, "how often new topics matching a ^pattern are looked for")
+	fs.StringVar(&c.JSONSchemaFile, "json-schema-file", c.JSONSchemaFile, "validate payloads against this JSON Schema, quarantine invalid ones")
+	fs.StringVar(&c.QuarantineTopic, "quarantine-topic", c.QuarantineTopic, "topic for invalid messages, instead of the quarantine file")
+	fs.StringVar(&c.QuarantineFile, "quarantine-file", c.QuarantineFile, "file invalid messages are appended to as JSON lines")
+	fs.StringVar(&c.AutoOffsetReset, "auto-offset-reset", c.AutoOffsetReset, "earliest or latest, where to start without a committed offset")
+	fs.DurationVar(&c.PollTimeout, "poll-timeout", c.PollTimeout, "how long a poll waits for a message")
+	fs.StringVar(&c.CommitStrategy, "commit-strategy", c.CommitStrategy, "when to commit: auto, sync (every message), interval or batch (every commit-every messages)")
+	fs.IntVar(&c.CommitEvery, "commit-every", c.CommitEvery, "commit offsets after this many handled messages with the batch strategy")
+	fs.DurationVar(&c.CommitInterval, "commit-interval", c.CommitInterval, "how often the interval and auto strategies commit")
+	fs.IntVar(&c.Workers, "workers", c.Workers, "goroutines handling messages concurrently")
+	fs.BoolVar(&c.PerPartition, "per-partition", c.PerPartition, "handle every partition in its own goroutine, in order")
+	fs.IntVar(&c.BufferSize, "buffer-size", c.BufferSize, "messages waiting for a worker before the partitions are paused")
+	fs.DurationVar(&c.RevokeTimeout, "revoke-timeout", c.RevokeTimeout, "how long workers may finish their messages when partitions are revoked")
+	fs.IntVar(&c.BatchSize, "batch-size", c.BatchSize, "handle and commit up to this many messages at once, 0 handles them one by one")
+	fs.DurationVar(&c.BatchTimeout, "batch-timeout", c.BatchTimeout, "how long a batch waits for more messages after the first")
+	fs.IntVar(&c.RetryAttempts, "retry-attempts", c.RetryAttempts, "retries via <topic>.retry before a failed message goes to <topic>.DLT, 0 retries in place")
+	fs.StringVar(&c.PoisonPolicy, "poison-policy", c.PoisonPolicy, "what to do with a message failing poison-attempts times: retry, skip, dead-letter or halt")
+	fs.IntVar(&c.PoisonAttempts, "poison-attempts", c.PoisonAttempts, "failures in a row which make a message a poison pill")
+	fs.StringVar(&c.TransformTopic, "transform-topic", c.TransformTopic, "transform messages to this topic exactly once")
+	fs.StringVar(&c.TransactionalID, "transactional-id", c.TransactionalID, "transactional.id of the transform producer")
+	fs.StringVar(&c.Format, "format", c.Format, "payload format: raw, avro or protobuf")
+	fs.BoolVar(&c.RawFallback, "raw-fallback", c.RawFallback, "pass payloads which cannot be decoded on as raw bytes")
+	fs.StringVar(&c.SchemaRegistryURL, "schema-registry-url", c.SchemaRegistryURL, "schema registry, required by the avro and protobuf formats")
+	fs.StringVar(&c.SchemaRegistryUsername, "schema-registry-username", c.SchemaRegistryUsername, "schema registry basic auth user")
+	fs.StringVar(&c.SchemaRegistryPassword, "schema-registry-password", c.SchemaRegistryPassword, "schema registry basic auth password")
+	fs.DurationVar(&c.RetryDelay, "retry-delay", c.RetryDelay, "how long a message waits on the retry topic")
+	fs.DurationVar(&c.LagInterval, "lag-interval", c.LagInterval, "log the lag of the assigned partitions this often, 0 disables it")
+	fs.StringVar(&c.MetricsAddr, "metrics-addr", c.MetricsAddr, "serve Prometheus metrics on this address, e.g. :9102")
+	fs.StringVar(&c.HealthAddr, "health-addr", c.HealthAddr, "serve /healthz and /status on this address, e.g. :8089")
+	fs.DurationVar(&c.StallTimeout, "stall-timeout", c.StallTimeout, "unhealthy when the poll loop did not run for this long")
+	fs.StringVar(&c.Dedup, "dedup", c.Dedup, "skip messages handled before, remembered in Redis: auto, bloom, cuckoo or bitmap")
+	fs.StringVar(&c.DedupHeader, "dedup-header", c.DedupHeader, "header holding the message id for -dedup, the key if empty, which must then be unique per message")
+	fs.DurationVar(&c.DedupWindow, "dedup-window", c.DedupWindow, "how long -dedup remembers ids at least, at most twice as long")
+	fs.StringVar(&c.OTLPEndpoint, "otlp-endpoint", c.OTLPEndpoint, "export a span per handled message via OTLP/HTTP to this host:port, e.g. localhost:4318")
+	fs.Int64Var(&c.LagAlert, "lag-alert", c.LagAlert, "warn when a partition lags more messages than this, 0 disables it")
+}
+
+// listFlag is a comma separated list. Setting it replaces the list.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// mapFlag collects repeated -flag key=value flags. Several pairs can be
+// given comma separated, which is also how they are printed.
+type mapFlag map[string]string
+
+func (m *mapFlag) String() string {
+	if m == nil || *m == nil {
+		return ""
+	}
+	keys := make([]string, 0, len(*m))
+	for k := range *m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+(*m)[k])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m *mapFlag) Set(value string) error {
+	if *m == nil {
+		*m = map[string]string{}
+	}
+	for _, pair := range strings.Split(value, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || k == "" {
+			return fmt.Errorf("expected key=value, got %q", pair)
+		}
+		(*m)[k] = v
+	}
+	return nil
+}
+
+// Validate checks the settings which would otherwise fail deep inside librdkafka.
+func (c *Config) Validate() error {
+	if err := c.KafkaConfig.Validate(); err != nil {
+		return err
+	}
+	if len(c.Topics) == 0 {
+		return fmt.Errorf("at least one topic is required")
+	}
+	if c.GroupID == "" {
+		return fmt.Errorf("group id must not be empty")
+	}
+	for _, topic := range c.Topics {
+		if strings.HasPrefix(topic, "^") {
+			if _, err := regexp.Compile(topic); err != nil {
+				return fmt.Errorf("invalid topic pattern %q: %v", topic, err)
+			}
+		}
+	}
+	switch c.AssignmentStrategy {
+	case "range", "roundrobin", "cooperative-sticky":
+	default:
+		return fmt.Errorf("unknown assignment strategy %q", c.AssignmentStrategy)
+	}
+	switch c.Mode {
+	case ModeSubscribe:
+	case ModeAssign:
+		if c.Partition < 0 {
+			return fmt.Errorf("partition must not be negative, got %d", c.Partition)
+		}
+		if _, err := c.StartOffset(); err != nil {
+			return err
+		}
+		for _, topic := range c.Topics {
+			if strings.HasPrefix(topic, "^") {
+				return fmt.Errorf("topic patterns like %q need subscribe mode", topic)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown mode %q", c.Mode)
+	}
+	if _, err := regexp.Compile(c.FilterKeyRegex); err != nil {
+		return fmt.Errorf("invalid filter key regex: %v", err)
+	}
+	if c.JSONSchemaFile != "" && c.QuarantineTopic == "" && c.QuarantineFile == "" {
+		return fmt.Errorf("json schema file needs a quarantine topic or file")
+	}
+	if c.FromOffset >= 0 && c.FromTimestamp != "" {
+		return fmt.Errorf("from offset and from timestamp are mutually exclusive")
+	}
+	if c.FromTimestamp != "" {
+		if _, err := c.StartTime(); err != nil {
+			return err
+		}
+	}
+	switch c.AutoOffsetReset {
+	case "earliest", "latest", "error":
+	default:
+		return fmt.Errorf("unknown auto offset reset %q", c.AutoOffsetReset)
+	}
+	if c.PollTimeout <= 0 || c.MetadataRefresh <= 0 || c.SessionTimeout <= 0 || c.StallTimeout <= 0 {
+		return fmt.Errorf("poll timeout, metadata refresh, session timeout and stall timeout must be positive")
+	}
+	switch c.CommitStrategy {
+	case CommitAuto, CommitSync, CommitInterval, CommitBatch:
+	default:
+		return fmt.Errorf("unknown commit strategy %q", c.CommitStrategy)
+	}
+	if c.CommitInterval <= 0 {
+		return fmt.Errorf("commit interval must be positive")
+	}
+	if c.CommitEvery < 1 || c.Workers < 1 || c.BufferSize < 1 {
+		return fmt.Errorf("commit every, workers and buffer size must be at least 1")
+	}
+	if c.RevokeTimeout <= 0 {
+		return fmt.Errorf("revoke timeout must be positive, got %v", c.RevokeTimeout)
+	}
+	switch c.Format {
+	case FormatRaw:
+	case FormatAvro, FormatProtobuf:
+		if c.SchemaRegistryURL == "" {
+			return fmt.Errorf("format %s needs a schema registry url", c.Format)
+		}
+	default:
+		return fmt.Errorf("unknown format %q", c.Format)
+	}
+	if c.PerPartition && c.Workers > 1 {
+		return fmt.Errorf("per partition and workers are mutually exclusive")
+	}
+	if c.BatchSize < 0 {
+		return fmt.Errorf("batch size must not be negative")
+	}
+	if c.BatchSize > 0 {
+		if c.BatchTimeout <= 0 {
+			return fmt.Errorf("batch timeout must be positive")
+		}
+		if c.RetryAttempts > 0 || c.TransformTopic != "" {
+			return fmt.Errorf("batch mode supports neither retry topics nor the transform pipeline")
+		}
+	}
+	switch c.Dedup {
+	case "":
+	case dedup.KindAuto, dedup.KindBloom, dedup.KindCuckoo, dedup.KindBitmap:
+		if c.BatchSize > 0 || c.TransformTopic != "" {
+			return fmt.Errorf("dedup needs messages handled one by one, not batch mode or the transform pipeline")
+		}
+		if c.DedupWindow <= 0 {
+			return fmt.Errorf("dedup window must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown dedup filter %q", c.Dedup)
+	}
+	switch c.PoisonPolicy {
+	case PoisonRetry, PoisonSkip, PoisonDeadLetter, PoisonHalt:
+	default:
+		return fmt.Errorf("unknown poison policy %q", c.PoisonPolicy)
+	}
+	if c.PoisonAttempts < 1 {
+		return fmt.Errorf("poison attempts must be at least 1")
+	}
+	if c.PoisonPolicy != PoisonRetry && c.RetryAttempts > 0 {
+		return fmt.Errorf("poison policy %s needs retry attempts 0, retry topics handle failures themselves", c.PoisonPolicy)
+	}
+	if c.TransformTopic != "" && c.TransactionalID == "" {
+		return fmt.Errorf("transform topic needs a transactional id")
+	}
+	if c.LagInterval < 0 || c.LagAlert < 0 {
+		return fmt.Errorf("lag interval and alert must not be negative")
+	}
+	if c.RetryAttempts < 0 || c.RetryDelay < 0 {
+		return fmt.Errorf("retry attempts and delay must not be negative")
+	}
+	if c.RetryAttempts > 0 {
+		if c.Mode != ModeSubscribe {
+			return fmt.Errorf("retry topics need subscribe mode")
+		}
+		for _, topic := range c.Topics {
+			if strings.HasPrefix(topic, "^") {
+				return fmt.Errorf("retry topics need plain topics, not patterns like %q", topic)
+			}
+		}
+	}
+	return nil
+}
+
+// StartOffset returns the offset ModeAssign starts at.
+func (c *Config) StartOffset() (kafka.Offset, error) {
+	offset, err := kafka.NewOffset(c.Offset)
+	if err != nil {
+		return 0, fmt.Errorf("invalid offset %q: %v", c.Offset, err)
+	}
+	return offset, nil
+}
+
+// StartTime returns FromTimestamp parsed.
+func (c *Config) StartTime() (time.Time, error) {
+	at, err := time.Parse(time.RFC3339, c.FromTimestamp)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid from timestamp %q: %v", c.FromTimestamp, err)
+	}
+	return at, nil
+}
+
+// ConsumerConfigMap translates the settings into librdkafka properties.
+func (c *Config) ConsumerConfigMap() *kafka.ConfigMap {
+	m := &kafka.ConfigMap{
+		"group.id":                      c.GroupID,
+		"session.timeout.ms":            int(c.SessionTimeout.Milliseconds()),
+		"partition.assignment.strategy": c.AssignmentStrategy,
+		"auto.offset.reset":             c.AutoOffsetReset,
+		// Offsets are stored after the handler succeeded and committed
+		// by the commit strategy, see Consumer.Run. With CommitAuto
+		// librdkafka commits the stored offsets itself.
+		"enable.auto.commit":       c.CommitStrategy == CommitAuto,
+		"auto.commit.interval.ms":  int(c.CommitInterval.Milliseconds()),
+		"enable.auto.offset.store": false,
+		// Skip messages of aborted transactions, see Consumer.RunTransform.
+		"isolation.level": "read_committed",
+		// New topics matching a pattern are subscribed to on refresh.
+		"topic.metadata.refresh.interval.ms": int(c.MetadataRefresh.Milliseconds()),
+	}
+	for k, v := range c.KafkaConfig.ClientProperties() {
+		m.SetKey(k, v)
+	}
+	if c.GroupInstanceID != "" {
+		// Another member with the same id fences this one, see FencedError.
+		m.SetKey("group.instance.id", c.GroupInstanceID)
+	}
+	return m
+}
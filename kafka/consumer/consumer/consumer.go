@@ -0,0 +1,393 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"go.opentelemetry.io/otel/trace"
+	"kate.redis.dedup"
+)
+
+// Handler processes one message. Its offset is only committed after the
+// handler returned nil, an error makes the consumer deliver it again, or
+// with RetryAttempts publish it to the retry topic.
+type Handler func(ctx context.Context, msg *kafka.Message) error
+
+// PartitionsFunc is called with the partitions assigned to or revoked
+// from the consumer by a rebalance.
+type PartitionsFunc func(partitions []kafka.TopicPartition)
+
+// OffsetsFunc returns where to start reading the assigned partitions,
+// for offsets stored outside of Kafka. Partitions it leaves at
+// kafka.OffsetStored start at the offset committed to Kafka.
+type OffsetsFunc func(partitions []kafka.TopicPartition) ([]kafka.TopicPartition, error)
+
+// handlerRetryDelay is the pause before a failed message is handled again.
+const handlerRetryDelay = time.Second
+
+// Consumer wraps kafka.Consumer and commits offsets of handled messages
+// only, which gives at-least-once processing.
+type Consumer struct {
+	consumer *kafka.Consumer
+	cfg      Config
+
+	// uncommitted counts messages handled since the last commit, the
+	// strategy decides when to commit them.
+	uncommitted atomic.Int64
+	strategy    CommitStrategy
+	committing  atomic.Bool
+
+	// With Workers > 1, inflight counts the messages handed to workers
+	// and offsets tracks which of them may be committed.
+	inflight inflightCounter
+	offsets  *offsetTracker
+
+	// buffered counts the messages waiting for or in the hands of a
+	// worker, backpressure is set while the partitions are paused for it.
+	buffered     atomic.Int64
+	backpressure bool
+
+	// partitions are the goroutines per partition with PerPartition.
+	partitions *partitionQueues
+
+	onAssign      PartitionsFunc
+	onRevoke      PartitionsFunc
+	storedOffsets OffsetsFunc
+
+	// retrier is set with RetryAttempts, paused are the partitions of
+	// retry topics waiting for their next message to become due.
+	retrier *retrier
+	paused  []pausedPartition
+
+	// failures are the messages failing in a row per partition, to
+	// detect poison pills. deadLetter publishes them with
+	// PoisonDeadLetter.
+	failures   map[partitionKey]failedMessage
+	deadLetter *retrier
+
+	// haltErr is the error Run stops with: a poison pill or a fatal
+	// error like being fenced.
+	haltMu  sync.Mutex
+	haltErr error
+
+	// started are the partitions positioned by FromOffset or FromTimestamp.
+	started map[partitionKey]bool
+
+	deserializer Deserializer
+	filter       *filter
+	// quarantine is set with JSONSchemaFile.
+	quarantine *quarantine
+
+	// The lag monitor and topic watcher run in background until
+	// closing is closed, as do asynchronous commits until they are
+	// done. lag is the last measured lag.
+	mu         sync.Mutex
+	lag        []PartitionLag
+	closing    chan struct{}
+	background sync.WaitGroup
+
+	counters  counters
+	collector *Collector
+
+	// lastPoll and lastMessage are the UnixNano times of the last poll
+	// and message, for Health.
+	lastPoll    atomic.Int64
+	lastMessage atomic.Int64
+
+	// tracer is set by UseTracing.
+	tracer trace.Tracer
+	// dedup is set by UseDedup.
+	dedup *dedup.Filter
+}
+
+// NewConsumer creates the consumer and subscribes to or assigns the
+// configured topics.
+func NewConsumer(cfg Config) (*Consumer, error) {
+	deserializer, err := NewDeserializer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	strategy, err := NewCommitStrategy(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := kafka.NewConsumer(cfg.ConsumerConfigMap())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer: %v", err)
+	}
+
+	consumer := &Consumer{
+		consumer: c,
+		cfg:      cfg,
+		offsets:  newOffsetTracker(),
+		strategy: strategy,
+		started:  map[partitionKey]bool{},
+		failures: map[partitionKey]failedMessage{},
+
+		deserializer: deserializer,
+		filter:       newFilter(cfg),
+
+		closing: make(chan struct{}),
+	}
+	consumer.collector = newCollector(consumer)
+	if cfg.Mode == ModeAssign {
+		// Validate made sure the offset parses.
+		offset, _ := cfg.StartOffset()
+		var partitions []kafka.TopicPartition
+		for i := range cfg.Topics {
+			partitions = append(partitions, kafka.TopicPartition{
+				Topic:     &cfg.Topics[i],
+				Partition: int32(cfg.Partition),
+				Offset:    offset,
+			})
+		}
+		if partitions, err = consumer.startPositions(partitions); err == nil {
+			err = c.Assign(partitions)
+		}
+	} else {
+		topics := cfg.Topics
+		if cfg.RetryAttempts > 0 {
+			for _, topic := range cfg.Topics {
+				topics = append(topics, topic+RetrySuffix)
+			}
+		}
+		err = c.SubscribeTopics(topics, consumer.rebalance)
+	}
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("failed to get partitions: %v", err)
+	}
+
+	if cfg.RetryAttempts > 0 {
+		if consumer.retrier, err = newRetrier(cfg); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if cfg.JSONSchemaFile != "" {
+		if consumer.quarantine, err = newQuarantine(cfg); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if cfg.PoisonPolicy == PoisonDeadLetter {
+		// Without retry attempts failed messages go to the DLT directly.
+		if consumer.deadLetter, err = newRetrier(cfg); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	if cfg.LagInterval > 0 {
+		consumer.background.Add(1)
+		go consumer.monitorLag()
+	}
+	if cfg.Mode == ModeSubscribe && len(cfg.topicPatterns()) > 0 {
+		consumer.background.Add(1)
+		go consumer.watchTopics()
+	}
+
+	return consumer, nil
+}
+
+// OnAssign registers fn to be called when the group assigned partitions,
+// before their first message is handled. Use it to set up per-partition
+// state. It is not called in ModeAssign.
+func (c *Consumer) OnAssign(fn PartitionsFunc) {
+	c.onAssign = fn
+}
+
+// OnRevoke registers fn to be called when partitions are taken away, after
+// the offsets of their handled messages were committed. Use it to tear
+// down per-partition state. It is not called in ModeAssign.
+func (c *Consumer) OnRevoke(fn PartitionsFunc) {
+	c.onRevoke = fn
+}
+
+// StartFrom registers fn to look up the start offsets of assigned
+// partitions, e.g. from the database a sink commits them to together with
+// its writes. It is not called in ModeAssign.
+func (c *Consumer) StartFrom(fn OffsetsFunc) {
+	c.storedOffsets = fn
+}
+
+// rebalance is the rebalance callback of the subscription. It runs inside
+// ReadMessage. With the eager protocol every rebalance revokes all
+// partitions and assigns the new set; with the cooperative one only the
+// partitions moving to or from this member are passed, and the others
+// are consumed on meanwhile.
+func (c *Consumer) rebalance(kc *kafka.Consumer, ev kafka.Event) error {
+	cooperative := kc.GetRebalanceProtocol() == "COOPERATIVE"
+	switch e := ev.(type) {
+	case kafka.AssignedPartitions:
+		log.Printf("Assigned partitions %v", e.Partitions)
+		c.counters.assigns.Add(1)
+		if c.onAssign != nil {
+			c.onAssign(e.Partitions)
+		}
+		partitions, err := c.startPositions(e.Partitions)
+		if err != nil {
+			log.Printf("Starting at the committed offsets: %v", err)
+		}
+		if c.storedOffsets != nil {
+			if stored, err := c.storedOffsets(partitions); err != nil {
+				log.Printf("Starting at the offsets committed to Kafka: %v", err)
+			} else {
+				partitions = stored
+			}
+		}
+		if cooperative {
+			err = kc.IncrementalAssign(partitions)
+		} else {
+			err = kc.Assign(partitions)
+		}
+		if err != nil {
+			return err
+		}
+		if c.backpressure {
+			// New partitions wait for the buffer to drain as well.
+			return kc.Pause(partitions)
+		}
+	case kafka.RevokedPartitions:
+		log.Printf("Revoked partitions %v", e.Partitions)
+		c.counters.revokes.Add(1)
+		// Finish the messages in the hands of workers, so their offsets
+		// are committed while the partitions are still ours. That includes
+		// the messages of partitions we keep in a cooperative rebalance.
+		// Waiting longer than max.poll.interval.ms would get us evicted.
+		if !c.inflight.Wait(c.cfg.RevokeTimeout) {
+			log.Printf("Messages still handled after %v, they are handled again by the next owner", c.cfg.RevokeTimeout)
+		}
+		c.offsets.forget(e.Partitions)
+		if c.partitions != nil {
+			for _, tp := range e.Partitions {
+				c.stopPartition(partitionKey{*tp.Topic, tp.Partition})
+			}
+		}
+		c.forgetPaused(e.Partitions)
+		if kc.AssignmentLost() {
+			// Another member may own them already, committing would fail.
+			log.Printf("Assignment was lost, offsets since the last commit are not committed")
+		} else if err := c.Commit(); err != nil {
+			log.Printf("Failed to commit offsets on revoke: %v", err)
+		}
+		if c.onRevoke != nil {
+			c.onRevoke(e.Partitions)
+		}
+		if cooperative {
+			return kc.IncrementalUnassign(e.Partitions)
+		}
+		return kc.Unassign()
+	}
+	return nil
+}
+
+// Run polls messages and passes them to handler until ctx is done. With
+// Workers > 1 messages are handled concurrently, see runPool, with
+// PerPartition every partition by its own goroutine, see runPartitions.
+func (c *Consumer) Run(ctx context.Context, handler Handler) error {
+	handler = c.filtered(c.validated(c.deduped(c.observed(c.traced(handler)))))
+	if c.cfg.PerPartition {
+		return c.runPartitions(ctx, handler)
+	}
+	if c.cfg.Workers > 1 {
+		return c.runPool(ctx, handler)
+	}
+
+	for ctx.Err() == nil && c.halted() == nil {
+		c.maybeCommit()
+		c.resumeDue()
+		msg := c.poll()
+		if msg == nil || c.deferred(msg) {
+			continue
+		}
+
+		if err := handler(ctx, msg); err != nil {
+			if c.retrier == nil {
+				done, haltErr := c.poisonPill(ctx, msg, err, c.failed(msg))
+				if haltErr != nil {
+					c.halt(haltErr)
+					break
+				}
+				if !done {
+					log.Printf("Handling message on %s failed, retrying: %v", msg.TopicPartition, err)
+					c.redeliver(msg)
+					continue
+				}
+			} else if err := c.retrier.fail(ctx, msg, err); err != nil {
+				log.Printf("Failed to move failed message on %s aside, retrying: %v", msg.TopicPartition, err)
+				c.redeliver(msg)
+				continue
+			}
+		}
+
+		if _, err := c.consumer.StoreMessage(msg); err != nil {
+			log.Printf("Failed to store offset of %s: %v", msg.TopicPartition, err)
+			continue
+		}
+		c.uncommitted.Add(1)
+	}
+	return c.halted()
+}
+
+// poll returns the next message, or nil if there was none in time.
+func (c *Consumer) poll() *kafka.Message {
+	msg, err := c.consumer.ReadMessage(c.cfg.PollTimeout)
+	c.lastPoll.Store(time.Now().UnixNano())
+	if err != nil {
+		c.pollError(err)
+		return nil
+	}
+	c.lastMessage.Store(time.Now().UnixNano())
+	return msg
+}
+
+// redeliver rewinds the partition of msg, so msg is read again.
+func (c *Consumer) redeliver(msg *kafka.Message) {
+	time.Sleep(handlerRetryDelay)
+	if err := c.consumer.Seek(msg.TopicPartition, 0); err != nil {
+		log.Printf("Failed to seek back to %s: %v", msg.TopicPartition, err)
+	}
+}
+
+// Commit synchronously commits the offsets of the handled messages.
+func (c *Consumer) Commit() error {
+	if _, err := c.consumer.Commit(); err != nil {
+		// Nothing to commit is fine, e.g. when no message arrived.
+		if kerr, ok := err.(kafka.Error); !ok || kerr.Code() != kafka.ErrNoOffset {
+			c.counters.commitFails.Add(1)
+			return err
+		}
+	} else {
+		c.counters.commits.Add(1)
+	}
+	c.uncommitted.Store(0)
+	return nil
+}
+
+// Close commits the offsets of the handled messages and leaves the group.
+func (c *Consumer) Close() error {
+	close(c.closing)
+	c.background.Wait()
+
+	if c.retrier != nil {
+		c.retrier.close()
+	}
+	if c.deadLetter != nil {
+		c.deadLetter.close()
+	}
+	if c.quarantine != nil {
+		c.quarantine.close()
+	}
+	err := c.Commit()
+	if closeErr := c.consumer.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+//go:build integration
+
+package consumer
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"kate.testutil"
+)
+
+func TestRun(t *testing.T) {
+	kcfg := testutil.StartKafka(t)
+	testutil.CreateTopic(t, kcfg, "consumer-test", 1)
+	testutil.SeedKafka(t, kcfg, "consumer-test",
+		testutil.Record{Key: "a", Value: "one"},
+		testutil.Record{Key: "b", Value: "two"},
+		testutil.Record{Key: "c", Value: "three"},
+	)
+
+	cfg := DefaultConfig()
+	cfg.KafkaConfig = kcfg
+	cfg.Topics = []string{"consumer-test"}
+	cfg.GroupID = "consumer-test"
+
+	c, err := NewConsumer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var values []string
+	err = c.Run(ctx, func(ctx context.Context, msg *kafka.Message) error {
+		values = append(values, string(msg.Value))
+		if len(values) == 3 {
+			cancel()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []string{"one", "two", "three"} {
+		if i >= len(values) || values[i] != want {
+			t.Fatalf("got %q, want [one two three]", values)
+		}
+	}
+}
+
+func TestRunSkipsDuplicates(t *testing.T) {
+	kcfg := testutil.StartKafka(t)
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+	testutil.CreateTopic(t, kcfg, "dedup-test", 1)
+	testutil.SeedKafka(t, kcfg, "dedup-test",
+		testutil.Record{Key: "a", Value: "one"},
+		testutil.Record{Key: "a", Value: "one again"},
+		testutil.Record{Key: "b", Value: "two"},
+		testutil.Record{Key: "c", Value: "three"},
+	)
+
+	cfg := DefaultConfig()
+	cfg.KafkaConfig = kcfg
+	cfg.Topics = []string{"dedup-test"}
+	cfg.GroupID = "dedup-test"
+	cfg.Dedup = "bitmap"
+	// The records carry no headers, their keys are their ids
+	cfg.DedupHeader = ""
+
+	c, err := NewConsumer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := NewDedupFilter(context.Background(), cfg, rdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.UseDedup(filter)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var values []string
+	err = c.Run(ctx, func(ctx context.Context, msg *kafka.Message) error {
+		values = append(values, string(msg.Value))
+		if string(msg.Key) == "c" {
+			cancel()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"one", "two", "three"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("got %q, want %q", values, want)
+	}
+	if n := c.counters.duplicates.Load(); n != 1 {
+		t.Errorf("got %d duplicates, want 1", n)
+	}
+}
@@ -0,0 +1,70 @@
+package consumer
+
+import (
+	"context"
+	"log"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/go-redis/redis/v8"
+	"kate.redis.dedup"
+)
+
+// NewDedupFilter returns the filter of kind Dedup remembering ids for
+// DedupWindow in rdb. It is named after the group, every group handles
+// each message once.
+func NewDedupFilter(ctx context.Context, cfg Config, rdb *redis.Client) (*dedup.Filter, error) {
+	return dedup.New(ctx, rdb, "consumer:"+cfg.GroupID, dedup.Options{Kind: cfg.Dedup, Window: cfg.DedupWindow})
+}
+
+// UseDedup makes Run skip messages whose id filter has seen, e.g. sent
+// twice by a producer retrying after a lost ack. The id is the value of
+// the DedupHeader header, or the key if DedupHeader is empty; messages
+// without one are handled.
+//
+// An id is added after the handler succeeded, so a failed message is
+// handled again. A message redelivered after a crash between the two is
+// handled twice, and the filter drops new messages at its error rate.
+func (c *Consumer) UseDedup(filter *dedup.Filter) {
+	c.dedup = filter
+}
+
+// deduped skips handler for messages seen before, when a filter is used.
+// Their offsets are committed like those of handled messages.
+func (c *Consumer) deduped(handler Handler) Handler {
+	if c.dedup == nil {
+		return handler
+	}
+	return func(ctx context.Context, msg *kafka.Message) error {
+		id := c.messageID(msg)
+		if id == "" {
+			return handler(ctx, msg)
+		}
+
+		seen, err := c.dedup.Exists(ctx, id)
+		if err != nil {
+			return err
+		}
+		if seen {
+			c.counters.duplicates.Add(1)
+			log.Printf("Skipping duplicate message %s on %s", id, msg.TopicPartition)
+			return nil
+		}
+
+		if err := handler(ctx, msg); err != nil {
+			return err
+		}
+		if _, err := c.dedup.Add(ctx, id); err != nil {
+			log.Printf("Failed to remember message %s, a redelivery will be handled: %v", id, err)
+		}
+		return nil
+	}
+}
+
+// messageID returns the id of msg for deduplication.
+func (c *Consumer) messageID(msg *kafka.Message) string {
+	if c.cfg.DedupHeader == "" {
+		return string(msg.Key)
+	}
+	id, _ := header(msg, c.cfg.DedupHeader)
+	return id
+}
@@ -0,0 +1,116 @@
+package consumer
+
+import (
+	"fmt"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry"
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry/serde"
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry/serde/avrov2"
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry/serde/protobuf"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+
+	// Links the generated types, so protobuf payloads can be decoded into them.
+	_ "kate.kafka.example/events"
+)
+
+// Payload formats, see Config.Format.
+const (
+	FormatRaw      = "raw"
+	FormatAvro     = "avro"
+	FormatProtobuf = "protobuf"
+)
+
+// Deserializer decodes the payload of a message of a topic.
+type Deserializer interface {
+	Deserialize(topic string, payload []byte) (any, error)
+}
+
+// rawDeserializer returns the payload unchanged.
+type rawDeserializer struct{}
+
+func (rawDeserializer) Deserialize(topic string, payload []byte) (any, error) {
+	return payload, nil
+}
+
+// NewDeserializer returns the deserializer for the configured format.
+func NewDeserializer(cfg Config) (Deserializer, error) {
+	switch cfg.Format {
+	case FormatRaw:
+		return rawDeserializer{}, nil
+	case FormatAvro:
+		client, err := newSchemaRegistryClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		// The client caches schemas by ID, so the registry is asked once
+		// per writer schema.
+		deser, err := avrov2.NewDeserializer(client, serde.ValueSerde, avrov2.NewDeserializerConfig())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create avro deserializer: %v", err)
+		}
+		return wireFormat{deser}, nil
+	case FormatProtobuf:
+		client, err := newSchemaRegistryClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		deser, err := protobuf.NewDeserializer(client, serde.ValueSerde, protobuf.NewDeserializerConfig())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create protobuf deserializer: %v", err)
+		}
+		// The payload names its message type, which has to be one of the
+		// generated Go types linked into the program.
+		var regErr error
+		protoregistry.GlobalTypes.RangeMessages(func(mt protoreflect.MessageType) bool {
+			regErr = deser.ProtoRegistry.RegisterMessage(mt)
+			return regErr == nil
+		})
+		if regErr != nil {
+			return nil, fmt.Errorf("failed to register protobuf types: %v", regErr)
+		}
+		return wireFormat{deser}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", cfg.Format)
+	}
+}
+
+// wireFormat checks for the Confluent wire format, a zero magic byte and
+// the 4 byte schema ID, before decoding. Protobuf payloads continue with
+// the indexes of the message type in the schema, which the deserializer
+// resolves.
+type wireFormat struct {
+	Deserializer
+}
+
+func (w wireFormat) Deserialize(topic string, payload []byte) (any, error) {
+	if len(payload) < 5 || payload[0] != 0 {
+		return nil, fmt.Errorf("payload is not in the schema registry wire format")
+	}
+	return w.Deserializer.Deserialize(topic, payload)
+}
+
+func newSchemaRegistryClient(cfg Config) (schemaregistry.Client, error) {
+	srConfig := schemaregistry.NewConfig(cfg.SchemaRegistryURL)
+	if cfg.SchemaRegistryUsername != "" {
+		srConfig = schemaregistry.NewConfigWithBasicAuthentication(cfg.SchemaRegistryURL,
+			cfg.SchemaRegistryUsername, cfg.SchemaRegistryPassword)
+	}
+
+	client, err := schemaregistry.NewClient(srConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create schema registry client: %v", err)
+	}
+	return client, nil
+}
+
+// Decode deserializes the value of msg in the configured format. With
+// RawFallback a value which cannot be decoded is returned as raw bytes.
+func (c *Consumer) Decode(msg *kafka.Message) (any, error) {
+	value, err := c.deserializer.Deserialize(*msg.TopicPartition.Topic, msg.Value)
+	if err != nil && c.cfg.RawFallback {
+		return msg.Value, nil
+	}
+	return value, err
+}
@@ -0,0 +1,84 @@
+package consumer
+
+import (
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// metadataTimeoutMs bounds the metadata requests of the topic watcher.
+const metadataTimeoutMs = 10000
+
+// topicPatterns returns the regular expressions among Topics.
+func (c *Config) topicPatterns() []*regexp.Regexp {
+	var patterns []*regexp.Regexp
+	for _, topic := range c.Topics {
+		if strings.HasPrefix(topic, "^") {
+			// Validate made sure it compiles.
+			patterns = append(patterns, regexp.MustCompile(topic))
+		}
+	}
+	return patterns
+}
+
+// watchTopics logs the topics matching the patterns of the subscription
+// and how they change, until the consumer is closed. librdkafka itself
+// subscribes to new matching topics when it refreshes its metadata,
+// every MetadataRefresh.
+func (c *Consumer) watchTopics() {
+	defer c.background.Done()
+
+	patterns := c.cfg.topicPatterns()
+	var known []string
+	ticker := time.NewTicker(c.cfg.MetadataRefresh)
+	defer ticker.Stop()
+	for {
+		metadata, err := c.consumer.GetMetadata(nil, true, metadataTimeoutMs)
+		if err != nil {
+			log.Printf("Failed to get topics: %v", err)
+		} else {
+			var matching []string
+			for topic := range metadata.Topics {
+				if strings.HasPrefix(topic, "__") {
+					// Internal topics like __consumer_offsets.
+					continue
+				}
+				for _, p := range patterns {
+					if p.MatchString(topic) {
+						matching = append(matching, topic)
+						break
+					}
+				}
+			}
+			slices.Sort(matching)
+
+			if added, removed := diff(known, matching); len(added) > 0 || len(removed) > 0 {
+				log.Printf("Topics matching the subscription changed: added %v, removed %v", added, removed)
+			}
+			known = matching
+		}
+
+		select {
+		case <-c.closing:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// diff returns the items of the sorted lists only in b and only in a.
+func diff(a, b []string) (added, removed []string) {
+	for _, item := range b {
+		if _, found := slices.BinarySearch(a, item); !found {
+			added = append(added, item)
+		}
+	}
+	for _, item := range a {
+		if _, found := slices.BinarySearch(b, item); !found {
+			removed = append(removed, item)
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,62 @@
+package consumer
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// FencedError is returned by Run when another consumer joined the group
+// with the same GroupInstanceID. The consumer is unusable afterwards;
+// typically two instances were given the same id, or an old instance
+// came back after its replacement took over.
+type FencedError struct {
+	InstanceID string
+	Err        kafka.Error
+}
+
+func (e *FencedError) Error() string {
+	return fmt.Sprintf("fenced by another consumer with group instance id %q: %v", e.InstanceID, e.Err)
+}
+
+func (e *FencedError) Unwrap() error {
+	return e.Err
+}
+
+// pollError handles an error returned by ReadMessage. Fatal errors halt
+// the consumer; the others are logged, the client recovers from them.
+func (c *Consumer) pollError(err error) {
+	kerr, ok := err.(kafka.Error)
+	if ok && kerr.IsTimeout() {
+		// Raised by ReadMessage in absence of messages.
+		return
+	}
+	c.counters.pollErrors.Add(1)
+	if !ok || !kerr.IsFatal() {
+		log.Printf("Consumer error: %v", err)
+		return
+	}
+
+	if kerr.Code() == kafka.ErrFencedInstanceID {
+		c.halt(&FencedError{InstanceID: c.cfg.GroupInstanceID, Err: kerr})
+		return
+	}
+	c.halt(kerr)
+}
+
+// halt makes Run return err, the first error wins.
+func (c *Consumer) halt(err error) {
+	c.haltMu.Lock()
+	defer c.haltMu.Unlock()
+	if c.haltErr == nil {
+		c.haltErr = err
+	}
+}
+
+// halted returns the error Run stops with, if any.
+func (c *Consumer) halted() error {
+	c.haltMu.Lock()
+	defer c.haltMu.Unlock()
+	return c.haltErr
+}
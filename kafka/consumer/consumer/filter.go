@@ -0,0 +1,45 @@
+package consumer
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// filter selects the messages to handle by headers and key.
+type filter struct {
+	headers map[string]string
+	key     *regexp.Regexp
+}
+
+func newFilter(cfg Config) *filter {
+	f := &filter{headers: cfg.FilterHeaders}
+	if cfg.FilterKeyRegex != "" {
+		// Validate made sure it compiles.
+		f.key = regexp.MustCompile(cfg.FilterKeyRegex)
+	}
+	return f
+}
+
+// matches reports whether msg has all filter headers with their values
+// and a key matching the regular expression.
+func (f *filter) matches(msg *kafka.Message) bool {
+	for key, value := range f.headers {
+		if v, ok := header(msg, key); !ok || v != value {
+			return false
+		}
+	}
+	return f.key == nil || f.key.Match(msg.Key)
+}
+
+// filtered skips handler for messages not matching the filter. Their
+// offsets are committed like those of handled messages.
+func (c *Consumer) filtered(handler Handler) Handler {
+	return func(ctx context.Context, msg *kafka.Message) error {
+		if !c.filter.matches(msg) {
+			return nil
+		}
+		return handler(ctx, msg)
+	}
+}
@@ -0,0 +1,107 @@
+package consumer
+
+import (
+	"fmt"
+	"time"
+)
+
+// healthTimeoutMs bounds the metadata request checking the brokers.
+const healthTimeoutMs = 2000
+
+// Health tells whether the consumer is working, for liveness probes.
+type Health struct {
+	Healthy bool     `json:"healthy"`
+	Reasons []string `json:"reasons,omitempty"`
+	// LastPoll and LastMessage are how long ago the poll loop ran and the
+	// last message arrived. An idle topic is no reason to be unhealthy.
+	LastPoll    string `json:"last_poll"`
+	LastMessage string `json:"last_message,omitempty"`
+	Brokers     int    `json:"brokers"`
+}
+
+// Status is what the consumer is working on.
+type Status struct {
+	GroupID    string            `json:"group_id"`
+	Partitions []PartitionStatus `json:"partitions"`
+}
+
+// PartitionStatus is the progress on an assigned partition.
+type PartitionStatus struct {
+	PartitionLag
+	// Position is the offset of the next message read.
+	Position int64 `json:"position"`
+}
+
+// Health checks the poll loop ran within StallTimeout and the brokers
+// are reachable.
+func (c *Consumer) Health() Health {
+	h := Health{Healthy: true}
+	if c.isClosing() {
+		return Health{Reasons: []string{"consumer is closed"}}
+	}
+
+	if last := c.lastPoll.Load(); last == 0 {
+		h.LastPoll = "never"
+	} else {
+		age := time.Since(time.Unix(0, last))
+		h.LastPoll = age.Round(time.Millisecond).String()
+		if age > c.cfg.StallTimeout {
+			h.Healthy = false
+			h.Reasons = append(h.Reasons, fmt.Sprintf("poll loop stalled for %s", h.LastPoll))
+		}
+	}
+	if last := c.lastMessage.Load(); last != 0 {
+		h.LastMessage = time.Since(time.Unix(0, last)).Round(time.Millisecond).String()
+	}
+
+	metadata, err := c.consumer.GetMetadata(nil, false, healthTimeoutMs)
+	if err != nil {
+		h.Healthy = false
+		h.Reasons = append(h.Reasons, fmt.Sprintf("brokers unreachable: %v", err))
+	} else {
+		h.Brokers = len(metadata.Brokers)
+	}
+	return h
+}
+
+// Status returns the assigned partitions with their positions and lag.
+func (c *Consumer) Status() (Status, error) {
+	s := Status{GroupID: c.cfg.GroupID, Partitions: []PartitionStatus{}}
+	if c.isClosing() {
+		return s, fmt.Errorf("consumer is closed")
+	}
+
+	lags, err := c.Lag()
+	if err != nil {
+		return s, err
+	}
+	assigned, err := c.consumer.Assignment()
+	if err != nil {
+		return s, fmt.Errorf("failed to get assignment: %v", err)
+	}
+	positions, err := c.consumer.Position(assigned)
+	if err != nil {
+		return s, fmt.Errorf("failed to get positions: %v", err)
+	}
+	position := map[partitionKey]int64{}
+	for _, tp := range positions {
+		position[partitionKey{*tp.Topic, tp.Partition}] = int64(tp.Offset)
+	}
+
+	for _, l := range lags {
+		s.Partitions = append(s.Partitions, PartitionStatus{
+			PartitionLag: l,
+			Position:     position[partitionKey{l.Topic, l.Partition}],
+		})
+	}
+	return s, nil
+}
+
+func (c *Consumer) isClosing() bool {
+	select {
+	case <-c.closing:
+		return true
+	default:
+		return false
+	}
+}
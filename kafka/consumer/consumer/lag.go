@@ -0,0 +1,99 @@
+package consumer
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// lagTimeoutMs bounds the queries for committed offsets and watermarks.
+const lagTimeoutMs = 5000
+
+// PartitionLag is how far the group is behind on one partition.
+type PartitionLag struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	// Committed is the committed offset of the group, or the low
+	// watermark when it did not commit one yet.
+	Committed     int64 `json:"committed"`
+	HighWatermark int64 `json:"high_watermark"`
+	Lag           int64 `json:"lag"`
+}
+
+// Lag queries the committed offsets and high watermarks of the assigned
+// partitions.
+func (c *Consumer) Lag() ([]PartitionLag, error) {
+	assigned, err := c.consumer.Assignment()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get assignment: %v", err)
+	}
+	if len(assigned) == 0 {
+		return nil, nil
+	}
+	committed, err := c.consumer.Committed(assigned, lagTimeoutMs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get committed offsets: %v", err)
+	}
+
+	lags := make([]PartitionLag, 0, len(committed))
+	for _, tp := range committed {
+		low, high, err := c.consumer.QueryWatermarkOffsets(*tp.Topic, tp.Partition, lagTimeoutMs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get watermarks of %s [%d]: %v", *tp.Topic, tp.Partition, err)
+		}
+		offset := int64(tp.Offset)
+		if offset < 0 {
+			offset = low
+		}
+		lags = append(lags, PartitionLag{
+			Topic:         *tp.Topic,
+			Partition:     tp.Partition,
+			Committed:     offset,
+			HighWatermark: high,
+			Lag:           max(high-offset, 0),
+		})
+	}
+	return lags, nil
+}
+
+// LastLag returns the lag measured last by the lag monitor.
+func (c *Consumer) LastLag() []PartitionLag {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lag
+}
+
+// monitorLag logs the lag of every assigned partition each LagInterval
+// until the consumer is closed, and warns about partitions more than
+// LagAlert messages behind.
+func (c *Consumer) monitorLag() {
+	defer c.background.Done()
+
+	ticker := time.NewTicker(c.cfg.LagInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closing:
+			return
+		case <-ticker.C:
+		}
+
+		lags, err := c.Lag()
+		if err != nil {
+			log.Printf("Failed to measure lag: %v", err)
+			continue
+		}
+		c.mu.Lock()
+		c.lag = lags
+		c.mu.Unlock()
+
+		for _, l := range lags {
+			if c.cfg.LagAlert > 0 && l.Lag > c.cfg.LagAlert {
+				log.Printf("ALERT: lag of %s [%d] is %d, above %d", l.Topic, l.Partition, l.Lag, c.cfg.LagAlert)
+				continue
+			}
+			log.Printf("Lag of %s [%d]: %d (committed %d, high watermark %d)",
+				l.Topic, l.Partition, l.Lag, l.Committed, l.HighWatermark)
+		}
+	}
+}
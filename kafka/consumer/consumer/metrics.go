@@ -0,0 +1,109 @@
+package consumer
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	consumedDesc = prometheus.NewDesc("kafka_consumer_messages_total",
+		"Messages handled by outcome.", []string{"result"}, nil)
+	commitsDesc = prometheus.NewDesc("kafka_consumer_commits_total",
+		"Offset commits by outcome.", []string{"result"}, nil)
+	rebalancesDesc = prometheus.NewDesc("kafka_consumer_rebalances_total",
+		"Partitions assigned or revoked by the group.", []string{"type"}, nil)
+	errorsDesc = prometheus.NewDesc("kafka_consumer_errors_total",
+		"Errors reported by the consumer while polling.", nil, nil)
+	lagDesc = prometheus.NewDesc("kafka_consumer_lag_messages",
+		"Messages the group is behind, as of the last lag measurement.", []string{"topic", "partition"}, nil)
+)
+
+// counters are updated by the consumer and exported by the Collector.
+type counters struct {
+	handled     atomic.Int64
+	failed      atomic.Int64
+	commits     atomic.Int64
+	commitFails atomic.Int64
+	assigns     atomic.Int64
+	revokes     atomic.Int64
+	pollErrors  atomic.Int64
+	poisoned    atomic.Int64
+	quarantined atomic.Int64
+	duplicates  atomic.Int64
+}
+
+// Collector exports the counters, handler latency and lag of a Consumer.
+// Lag is only known when the lag monitor runs, see Config.LagInterval.
+type Collector struct {
+	consumer *Consumer
+	latency  prometheus.Histogram
+}
+
+func newCollector(c *Consumer) *Collector {
+	return &Collector{
+		consumer: c,
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "kafka_consumer_handler_duration_seconds",
+			Help:    "Time the handler took per message.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 4, 10),
+		}),
+	}
+}
+
+// Collector returns the Prometheus collector of the consumer metrics.
+func (c *Consumer) Collector() *Collector {
+	return c.collector
+}
+
+// observed records the outcome and duration of handler.
+func (c *Consumer) observed(handler Handler) Handler {
+	return func(ctx context.Context, msg *kafka.Message) error {
+		start := time.Now()
+		err := handler(ctx, msg)
+		c.observe(start, err)
+		return err
+	}
+}
+
+func (c *Consumer) observe(start time.Time, err error) {
+	c.collector.latency.Observe(time.Since(start).Seconds())
+	if err != nil {
+		c.counters.failed.Add(1)
+	} else {
+		c.counters.handled.Add(1)
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		consumedDesc, commitsDesc, rebalancesDesc, errorsDesc, lagDesc,
+	} {
+		ch <- d
+	}
+	c.latency.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	n := &c.consumer.counters
+	ch <- prometheus.MustNewConstMetric(consumedDesc, prometheus.CounterValue, float64(n.handled.Load()), "handled")
+	ch <- prometheus.MustNewConstMetric(consumedDesc, prometheus.CounterValue, float64(n.failed.Load()), "failed")
+	ch <- prometheus.MustNewConstMetric(consumedDesc, prometheus.CounterValue, float64(n.poisoned.Load()), "poisoned")
+	ch <- prometheus.MustNewConstMetric(consumedDesc, prometheus.CounterValue, float64(n.quarantined.Load()), "quarantined")
+	ch <- prometheus.MustNewConstMetric(consumedDesc, prometheus.CounterValue, float64(n.duplicates.Load()), "duplicate")
+	ch <- prometheus.MustNewConstMetric(commitsDesc, prometheus.CounterValue, float64(n.commits.Load()), "committed")
+	ch <- prometheus.MustNewConstMetric(commitsDesc, prometheus.CounterValue, float64(n.commitFails.Load()), "failed")
+	ch <- prometheus.MustNewConstMetric(rebalancesDesc, prometheus.CounterValue, float64(n.assigns.Load()), "assign")
+	ch <- prometheus.MustNewConstMetric(rebalancesDesc, prometheus.CounterValue, float64(n.revokes.Load()), "revoke")
+	ch <- prometheus.MustNewConstMetric(errorsDesc, prometheus.CounterValue, float64(n.pollErrors.Load()))
+	c.latency.Collect(ch)
+
+	for _, l := range c.consumer.LastLag() {
+		ch <- prometheus.MustNewConstMetric(lagDesc, prometheus.GaugeValue, float64(l.Lag),
+			l.Topic, strconv.Itoa(int(l.Partition)))
+	}
+}
@@ -0,0 +1,83 @@
+package consumer
+
+import (
+	"context"
+	"sync"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// partitionQueues are the channels of the partition goroutines of
+// runPartitions. They are only touched by the poll loop, which includes
+// the rebalance callback.
+type partitionQueues struct {
+	queues  map[partitionKey]chan *kafka.Message
+	running sync.WaitGroup
+}
+
+// runPartitions handles every assigned partition in its own goroutine:
+// partitions in parallel, the messages of each partition in order. A
+// goroutine is started with the first message of its partition and
+// stopped when the partition is revoked.
+//
+// Backpressure, retries and poison pills work as with runPool, up to
+// BufferSize messages wait for the goroutines of all partitions.
+func (c *Consumer) runPartitions(ctx context.Context, handler Handler) error {
+	c.partitions = &partitionQueues{queues: map[partitionKey]chan *kafka.Message{}}
+
+	for ctx.Err() == nil && c.halted() == nil {
+		c.maybeCommit()
+		c.resumeDue()
+		c.applyBackpressure()
+
+		msg := c.poll()
+		if msg == nil || c.deferred(msg) {
+			continue
+		}
+		c.offsets.start(msg)
+		c.inflight.Add(1)
+		c.buffered.Add(1)
+		c.partitionQueue(ctx, handler, msg.TopicPartition) <- msg
+	}
+
+	for key := range c.partitions.queues {
+		c.stopPartition(key)
+	}
+	c.partitions.running.Wait()
+	return c.halted()
+}
+
+// partitionQueue returns the channel of the goroutine of tp, starting
+// it if needed.
+func (c *Consumer) partitionQueue(ctx context.Context, handler Handler, tp kafka.TopicPartition) chan<- *kafka.Message {
+	key := partitionKey{*tp.Topic, tp.Partition}
+	if queue, ok := c.partitions.queues[key]; ok {
+		return queue
+	}
+
+	queue := make(chan *kafka.Message, c.cfg.BufferSize)
+	c.partitions.queues[key] = queue
+	c.partitions.running.Add(1)
+	go func() {
+		defer c.partitions.running.Done()
+		for msg := range queue {
+			if c.halted() != nil {
+				// Not handled, so never committed.
+				c.buffered.Add(-1)
+				c.inflight.Done()
+				continue
+			}
+			c.work(ctx, handler, msg)
+		}
+	}()
+	return queue
+}
+
+// stopPartition lets the goroutine of a partition finish its messages
+// and exit.
+func (c *Consumer) stopPartition(key partitionKey) {
+	if queue, ok := c.partitions.queues[key]; ok {
+		close(queue)
+		delete(c.partitions.queues, key)
+	}
+}
@@ -0,0 +1,80 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// Poison pill policies, see Config.PoisonPolicy.
+const (
+	// PoisonRetry handles a failing message again until it succeeds.
+	PoisonRetry = "retry"
+	// PoisonSkip logs the message and commits past it.
+	PoisonSkip = "skip"
+	// PoisonDeadLetter publishes the message to <topic>.DLT.
+	PoisonDeadLetter = "dead-letter"
+	// PoisonHalt stops the consumer with a PoisonPillError.
+	PoisonHalt = "halt"
+)
+
+// PoisonPillError is returned by Run when a message failed
+// PoisonAttempts times in a row and the policy is PoisonHalt. The
+// message is not committed, so it is the first one read after a restart.
+type PoisonPillError struct {
+	TopicPartition kafka.TopicPartition
+	Attempts       int
+	Err            error
+}
+
+func (e *PoisonPillError) Error() string {
+	return fmt.Sprintf("message on %s failed %d times: %v", e.TopicPartition, e.Attempts, e.Err)
+}
+
+func (e *PoisonPillError) Unwrap() error {
+	return e.Err
+}
+
+// failedMessage counts how often the message at offset failed in a row.
+type failedMessage struct {
+	offset   kafka.Offset
+	attempts int
+}
+
+// failed returns how often msg failed in a row, including this time.
+func (c *Consumer) failed(msg *kafka.Message) int {
+	key := partitionKey{*msg.TopicPartition.Topic, msg.TopicPartition.Partition}
+	f := c.failures[key]
+	if f.offset != msg.TopicPartition.Offset {
+		f = failedMessage{offset: msg.TopicPartition.Offset}
+	}
+	f.attempts++
+	c.failures[key] = f
+	return f.attempts
+}
+
+// poisonPill applies the PoisonPolicy to msg, which failed attempts
+// times in a row with cause. It returns whether msg is done with and may
+// be committed, or the error to halt with.
+func (c *Consumer) poisonPill(ctx context.Context, msg *kafka.Message, cause error, attempts int) (bool, error) {
+	if c.cfg.PoisonPolicy == PoisonRetry || attempts < c.cfg.PoisonAttempts {
+		return false, nil
+	}
+
+	switch c.cfg.PoisonPolicy {
+	case PoisonSkip:
+		log.Printf("Skipping poison pill at %s after %d attempts: %v", msg.TopicPartition, attempts, cause)
+	case PoisonDeadLetter:
+		if err := c.deadLetter.fail(ctx, msg, cause); err != nil {
+			log.Printf("Failed to dead-letter poison pill at %s, retrying: %v", msg.TopicPartition, err)
+			return false, nil
+		}
+		log.Printf("Dead-lettered poison pill at %s after %d attempts: %v", msg.TopicPartition, attempts, cause)
+	case PoisonHalt:
+		return false, &PoisonPillError{TopicPartition: msg.TopicPartition, Attempts: attempts, Err: cause}
+	}
+	c.counters.poisoned.Add(1)
+	return true, nil
+}
@@ -0,0 +1,243 @@
+package consumer
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// runPool hands messages to Workers goroutines. A message whose handler
+// fails is moved to the retry topic or, without one or if that fails,
+// retried by its worker until it succeeds or ctx is done.
+//
+// Up to BufferSize messages wait for a worker. When the buffer is full,
+// all partitions are paused until it drained to half, polling goes on
+// meanwhile, so the consumer stays in the group however slow the handler.
+func (c *Consumer) runPool(ctx context.Context, handler Handler) error {
+	jobs := make(chan *kafka.Message, c.cfg.BufferSize)
+	var workers sync.WaitGroup
+	for i := 0; i < c.cfg.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for msg := range jobs {
+				c.work(ctx, handler, msg)
+			}
+		}()
+	}
+
+	for ctx.Err() == nil && c.halted() == nil {
+		c.maybeCommit()
+		c.resumeDue()
+		c.applyBackpressure()
+
+		msg := c.poll()
+		if msg == nil || c.deferred(msg) {
+			continue
+		}
+		c.offsets.start(msg)
+		c.inflight.Add(1)
+		c.buffered.Add(1)
+		jobs <- msg
+	}
+
+	close(jobs)
+	workers.Wait()
+	return c.halted()
+}
+
+// work handles msg and stores the offset the partition may be committed
+// up to afterwards.
+func (c *Consumer) work(ctx context.Context, handler Handler, msg *kafka.Message) {
+	defer c.inflight.Done()
+	defer c.buffered.Add(-1)
+
+	for attempts := 1; ; attempts++ {
+		err := handler(ctx, msg)
+		if err == nil {
+			break
+		}
+		if c.retrier != nil {
+			if err = c.retrier.fail(ctx, msg, err); err == nil {
+				break
+			}
+		} else {
+			done, haltErr := c.poisonPill(ctx, msg, err, attempts)
+			if haltErr != nil {
+				// Not finished, so its offset is never committed.
+				c.halt(haltErr)
+				return
+			}
+			if done {
+				break
+			}
+		}
+		log.Printf("Handling message on %s failed, retrying: %v", msg.TopicPartition, err)
+		select {
+		case <-ctx.Done():
+			// Not finished, so its offset is never committed.
+			return
+		case <-time.After(handlerRetryDelay):
+		}
+	}
+
+	if tp, ok := c.offsets.finish(msg); ok {
+		if _, err := c.consumer.StoreOffsets([]kafka.TopicPartition{tp}); err != nil {
+			log.Printf("Failed to store offset of %s: %v", tp, err)
+		}
+	}
+	c.uncommitted.Add(1)
+}
+
+// applyBackpressure pauses the assigned partitions when the buffer is
+// full and resumes them once it drained to half.
+func (c *Consumer) applyBackpressure() {
+	buffered := c.buffered.Load()
+	switch {
+	case !c.backpressure && buffered >= int64(c.cfg.BufferSize):
+		assigned, err := c.consumer.Assignment()
+		if err == nil {
+			err = c.consumer.Pause(assigned)
+		}
+		if err != nil {
+			log.Printf("Failed to pause partitions: %v", err)
+			return
+		}
+		log.Printf("Buffer full with %d messages, paused %d partitions", buffered, len(assigned))
+		c.backpressure = true
+	case c.backpressure && buffered <= int64(c.cfg.BufferSize/2):
+		assigned, err := c.consumer.Assignment()
+		if err == nil {
+			err = c.consumer.Resume(c.withoutDeferred(assigned))
+		}
+		if err != nil {
+			log.Printf("Failed to resume partitions: %v", err)
+			return
+		}
+		log.Printf("Buffer drained to %d messages, resumed partitions", buffered)
+		c.backpressure = false
+	}
+}
+
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+// offsetTracker remembers the messages handed to workers per partition,
+// in the order they were read.
+type offsetTracker struct {
+	mu      sync.Mutex
+	pending map[partitionKey][]*kafka.Message
+	// done tells the pending messages which were handled.
+	done map[*kafka.Message]bool
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{
+		pending: map[partitionKey][]*kafka.Message{},
+		done:    map[*kafka.Message]bool{},
+	}
+}
+
+func (t *offsetTracker) start(msg *kafka.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := partitionKey{*msg.TopicPartition.Topic, msg.TopicPartition.Partition}
+	t.pending[key] = append(t.pending[key], msg)
+	t.done[msg] = false
+}
+
+// finish marks msg as handled. It returns the offset to commit when the
+// oldest pending messages of the partition are done: the one after the
+// last of them, as Kafka expects. Messages of forgotten partitions are
+// ignored.
+func (t *offsetTracker) finish(msg *kafka.Message) (kafka.TopicPartition, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.done[msg]; !ok {
+		return kafka.TopicPartition{}, false
+	}
+	t.done[msg] = true
+
+	tp := msg.TopicPartition
+	key := partitionKey{*tp.Topic, tp.Partition}
+	pending := t.pending[key]
+	committable := kafka.Offset(-1)
+	for len(pending) > 0 && t.done[pending[0]] {
+		committable = pending[0].TopicPartition.Offset + 1
+		delete(t.done, pending[0])
+		pending = pending[1:]
+	}
+	t.pending[key] = pending
+
+	if committable < 0 {
+		return kafka.TopicPartition{}, false
+	}
+	return kafka.TopicPartition{Topic: tp.Topic, Partition: tp.Partition, Offset: committable}, true
+}
+
+// forget drops what is known about revoked partitions.
+func (t *offsetTracker) forget(partitions []kafka.TopicPartition) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, tp := range partitions {
+		key := partitionKey{*tp.Topic, tp.Partition}
+		for _, msg := range t.pending[key] {
+			delete(t.done, msg)
+		}
+		delete(t.pending, key)
+	}
+}
+
+// inflightCounter counts the messages in the hands of workers.
+type inflightCounter struct {
+	mu sync.Mutex
+	n  int
+	// idle is closed when n drops to 0.
+	idle chan struct{}
+}
+
+func (c *inflightCounter) Add(delta int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.n == 0 && delta > 0 {
+		c.idle = make(chan struct{})
+	}
+	c.n += delta
+	if c.n == 0 {
+		close(c.idle)
+	}
+}
+
+func (c *inflightCounter) Done() {
+	c.Add(-1)
+}
+
+// Wait waits up to timeout for the counter to drop to 0. It returns
+// false if it did not.
+func (c *inflightCounter) Wait(timeout time.Duration) bool {
+	c.mu.Lock()
+	if c.n == 0 {
+		c.mu.Unlock()
+		return true
+	}
+	idle := c.idle
+	c.mu.Unlock()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-idle:
+		return true
+	case <-t.C:
+		return false
+	}
+}
@@ -0,0 +1,145 @@
+package consumer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+func TestOffsetTracker(t *testing.T) {
+	topic := "orders"
+	message := func(partition int32, offset kafka.Offset) *kafka.Message {
+		return &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition, Offset: offset}}
+	}
+
+	type step struct {
+		finish int // index into msgs
+		want   kafka.Offset
+	}
+	tests := []struct {
+		name  string
+		msgs  []*kafka.Message
+		steps []step
+	}{
+		{
+			name: "in order",
+			msgs: []*kafka.Message{message(0, 10), message(0, 11), message(0, 12)},
+			steps: []step{
+				{finish: 0, want: 11},
+				{finish: 1, want: 12},
+				{finish: 2, want: 13},
+			},
+		},
+		{
+			name: "out of order",
+			msgs: []*kafka.Message{message(0, 10), message(0, 11), message(0, 12)},
+			steps: []step{
+				{finish: 2, want: -1},
+				{finish: 1, want: -1},
+				{finish: 0, want: 13},
+			},
+		},
+		{
+			name: "gap in offsets",
+			msgs: []*kafka.Message{message(0, 10), message(0, 15), message(0, 20)},
+			steps: []step{
+				{finish: 1, want: -1},
+				{finish: 0, want: 16},
+				{finish: 2, want: 21},
+			},
+		},
+		{
+			name: "slow message holds back its partition only",
+			msgs: []*kafka.Message{message(0, 10), message(1, 10), message(0, 11)},
+			steps: []step{
+				{finish: 2, want: -1},
+				{finish: 1, want: 11},
+				{finish: 0, want: 12},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newOffsetTracker()
+			for _, msg := range tt.msgs {
+				tracker.start(msg)
+			}
+			for _, s := range tt.steps {
+				msg := tt.msgs[s.finish]
+				tp, ok := tracker.finish(msg)
+				got := kafka.Offset(-1)
+				if ok {
+					got = tp.Offset
+					if tp.Partition != msg.TopicPartition.Partition || *tp.Topic != topic {
+						t.Errorf("finish(%v) committed %v", msg.TopicPartition, tp)
+					}
+				}
+				if got != s.want {
+					t.Errorf("finish(%v) = %v, want %v", msg.TopicPartition, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestOffsetTrackerForget(t *testing.T) {
+	topic := "orders"
+	message := func(partition int32, offset kafka.Offset) *kafka.Message {
+		return &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition, Offset: offset}}
+	}
+
+	tracker := newOffsetTracker()
+	revoked, kept := message(0, 10), message(1, 10)
+	tracker.start(revoked)
+	tracker.start(kept)
+	tracker.forget([]kafka.TopicPartition{revoked.TopicPartition})
+
+	// A worker finishing after the revoke commits nothing
+	if tp, ok := tracker.finish(revoked); ok {
+		t.Errorf("finish of revoked partition committed %v", tp)
+	}
+	if tp, ok := tracker.finish(kept); !ok || tp.Offset != 11 {
+		t.Errorf("finish of kept partition = %v, %v, want offset 11", tp, ok)
+	}
+
+	// Reassigned, the same offset read again is tracked anew: the
+	// message of the old assignment does not finish it.
+	again := message(0, 10)
+	tracker.start(again)
+	if tp, ok := tracker.finish(revoked); ok {
+		t.Errorf("finish of old message committed %v", tp)
+	}
+	if tp, ok := tracker.finish(again); !ok || tp.Offset != 11 {
+		t.Errorf("finish after reassignment = %v, %v, want offset 11", tp, ok)
+	}
+}
+
+func TestInflightCounterWait(t *testing.T) {
+	var c inflightCounter
+	if !c.Wait(time.Millisecond) {
+		t.Error("Wait of unused counter timed out")
+	}
+
+	c.Add(2)
+	c.Done()
+	if c.Wait(10 * time.Millisecond) {
+		t.Error("Wait returned with a message in flight")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		c.Done()
+	}()
+	if !c.Wait(time.Second) {
+		t.Error("Wait timed out after the last message was done")
+	}
+
+	// Reusable after dropping to 0
+	c.Add(1)
+	if c.Wait(time.Millisecond) {
+		t.Error("Wait returned with a message in flight after reuse")
+	}
+	c.Done()
+}
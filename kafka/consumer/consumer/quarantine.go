@@ -0,0 +1,156 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"kate.kafka.example/producer/producer"
+)
+
+// Headers of a message on the quarantine topic.
+const (
+	HeaderQuarantineError     = "quarantine-error"
+	HeaderQuarantineTopic     = "quarantine-original-topic"
+	HeaderQuarantinePartition = "quarantine-original-partition"
+	HeaderQuarantineOffset    = "quarantine-original-offset"
+)
+
+// quarantineRecord is one line of the quarantine file.
+type quarantineRecord struct {
+	Topic     string            `json:"topic"`
+	Partition int32             `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Key       string            `json:"key"`
+	Value     string            `json:"value"`
+	Headers   map[string]string `json:"headers"`
+	Error     string            `json:"error"`
+	At        time.Time         `json:"at"`
+}
+
+// quarantine validates payloads against the JSON Schema and sets invalid
+// messages aside, to QuarantineTopic or QuarantineFile, instead of
+// passing them to the handler.
+type quarantine struct {
+	validator producer.Validator
+	topic     string
+	producer  *producer.Producer
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newQuarantine(cfg Config) (*quarantine, error) {
+	schema, err := os.ReadFile(cfg.JSONSchemaFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read json schema: %v", err)
+	}
+	validator, err := producer.NewJSONSchemaValidator(cfg.JSONSchemaFile, schema)
+	if err != nil {
+		return nil, err
+	}
+	q := &quarantine{validator: validator, topic: cfg.QuarantineTopic}
+
+	if cfg.QuarantineTopic != "" {
+		pcfg := producer.DefaultConfig()
+		pcfg.KafkaConfig = cfg.KafkaConfig
+		pcfg.Source = "go-examples-consumer"
+		if q.producer, err = producer.NewProducer(pcfg); err != nil {
+			return nil, fmt.Errorf("failed to create quarantine producer: %v", err)
+		}
+	} else {
+		q.file, err = os.OpenFile(cfg.QuarantineFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open quarantine file: %v", err)
+		}
+	}
+	return q, nil
+}
+
+// check validates msg. An invalid message is set aside and check
+// returns true; the error tells that setting it aside failed.
+func (q *quarantine) check(ctx context.Context, msg *kafka.Message) (bool, error) {
+	invalid := q.validator.Validate(*msg.TopicPartition.Topic, msg.Value)
+	if invalid == nil {
+		return false, nil
+	}
+	if q.producer != nil {
+		return true, q.produce(ctx, msg, invalid)
+	}
+	return true, q.write(msg, invalid)
+}
+
+func (q *quarantine) produce(ctx context.Context, msg *kafka.Message, invalid error) error {
+	out := producer.Message{
+		Topic:   q.topic,
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: msg.Headers,
+	}
+	out.SetHeader(HeaderQuarantineError, invalid.Error())
+	out.SetHeader(HeaderQuarantineTopic, *msg.TopicPartition.Topic)
+	out.SetHeader(HeaderQuarantinePartition, strconv.Itoa(int(msg.TopicPartition.Partition)))
+	out.SetHeader(HeaderQuarantineOffset, msg.TopicPartition.Offset.String())
+
+	_, err := q.producer.ProduceSync(ctx, out)
+	return err
+}
+
+func (q *quarantine) write(msg *kafka.Message, invalid error) error {
+	record := quarantineRecord{
+		Topic:     *msg.TopicPartition.Topic,
+		Partition: msg.TopicPartition.Partition,
+		Offset:    int64(msg.TopicPartition.Offset),
+		Key:       string(msg.Key),
+		Value:     string(msg.Value),
+		Headers:   map[string]string{},
+		Error:     invalid.Error(),
+		At:        time.Now().UTC(),
+	}
+	for _, h := range msg.Headers {
+		record.Headers[h.Key] = string(h.Value)
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, err = q.file.Write(append(line, '\n'))
+	return err
+}
+
+func (q *quarantine) close() {
+	if q.producer != nil {
+		q.producer.Shutdown(5000)
+		q.producer.Close()
+	}
+	if q.file != nil {
+		q.file.Close()
+	}
+}
+
+// validated passes only valid messages to handler. Invalid ones count
+// as handled once they were set aside.
+func (c *Consumer) validated(handler Handler) Handler {
+	if c.quarantine == nil {
+		return handler
+	}
+	return func(ctx context.Context, msg *kafka.Message) error {
+		invalid, err := c.quarantine.check(ctx, msg)
+		if err != nil {
+			return fmt.Errorf("failed to quarantine invalid message: %v", err)
+		}
+		if invalid {
+			c.counters.quarantined.Add(1)
+			return nil
+		}
+		return handler(ctx, msg)
+	}
+}
@@ -0,0 +1,190 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"kate.kafka.example/producer/producer"
+)
+
+// RetrySuffix is appended to a topic to name its retry topic.
+const RetrySuffix = ".retry"
+
+// Headers of a message on a retry topic.
+const (
+	HeaderRetryAttempt       = "retry-attempt"
+	HeaderRetryAt            = "retry-at"
+	HeaderRetryOriginalTopic = "retry-original-topic"
+)
+
+// retrier moves failed messages out of the way: to the retry topic of
+// their topic, to be handled again after RetryDelay, and after
+// RetryAttempts to the dead-letter topic.
+type retrier struct {
+	producer *producer.Producer
+	dlq      *producer.DLQProducer
+	attempts int
+	delay    time.Duration
+}
+
+func newRetrier(cfg Config) (*retrier, error) {
+	pcfg := producer.DefaultConfig()
+	pcfg.KafkaConfig = cfg.KafkaConfig
+	pcfg.Source = "go-examples-consumer"
+
+	p, err := producer.NewProducer(pcfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create retry producer: %v", err)
+	}
+	return &retrier{
+		producer: p,
+		dlq:      producer.NewDLQProducer(p),
+		attempts: cfg.RetryAttempts,
+		delay:    cfg.RetryDelay,
+	}, nil
+}
+
+// fail publishes msg, which the handler failed with cause, to the retry
+// or dead-letter topic and waits until it was written. Only then the
+// offset of msg may be committed.
+func (r *retrier) fail(ctx context.Context, msg *kafka.Message, cause error) error {
+	topic := *msg.TopicPartition.Topic
+	if original, ok := header(msg, HeaderRetryOriginalTopic); ok {
+		topic = original
+	}
+	attempt := 1
+	if v, ok := header(msg, HeaderRetryAttempt); ok {
+		attempt, _ = strconv.Atoi(v)
+		attempt++
+	}
+
+	if attempt > r.attempts {
+		// Dead-letter it under its original topic, not the retry topic.
+		dead := *msg
+		dead.TopicPartition.Topic = &topic
+		log.Printf("Message on %s failed %d times, dead-lettering it: %v", msg.TopicPartition, attempt, cause)
+		return r.dlq.Send(&dead, cause, attempt)
+	}
+
+	retry := producer.Message{
+		Topic:   topic + RetrySuffix,
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: msg.Headers,
+	}
+	retry.SetHeader(HeaderRetryOriginalTopic, topic)
+	retry.SetHeader(HeaderRetryAttempt, strconv.Itoa(attempt))
+	retry.SetHeader(HeaderRetryAt, time.Now().Add(r.delay).UTC().Format(time.RFC3339Nano))
+
+	_, err := r.producer.ProduceSync(ctx, retry)
+	return err
+}
+
+func (r *retrier) close() {
+	r.producer.Shutdown(5000)
+	r.producer.Close()
+}
+
+// retryAt returns when a message of a retry topic is due.
+func retryAt(msg *kafka.Message) (time.Time, bool) {
+	v, ok := header(msg, HeaderRetryAt)
+	if !ok {
+		return time.Time{}, false
+	}
+	at, err := time.Parse(time.RFC3339Nano, v)
+	return at, err == nil
+}
+
+func header(msg *kafka.Message, key string) (string, bool) {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
+
+// pausedPartition waits for its first message to become due.
+type pausedPartition struct {
+	tp    kafka.TopicPartition
+	until time.Time
+}
+
+// deferred pauses the partition of a retry message that is not due yet,
+// rewound to the message. Messages of a retry topic are due in order, so
+// the rest of the partition waits as well.
+func (c *Consumer) deferred(msg *kafka.Message) bool {
+	at, ok := retryAt(msg)
+	if !ok || !time.Now().Before(at) {
+		return false
+	}
+
+	tp := msg.TopicPartition
+	if err := c.consumer.Pause([]kafka.TopicPartition{tp}); err != nil {
+		log.Printf("Failed to pause %s: %v", tp, err)
+		return false
+	}
+	if err := c.consumer.Seek(tp, 0); err != nil {
+		log.Printf("Failed to seek back to %s: %v", tp, err)
+	}
+	c.paused = append(c.paused, pausedPartition{tp: tp, until: at})
+	return true
+}
+
+// resumeDue resumes the paused partitions whose message is due.
+func (c *Consumer) resumeDue() {
+	now := time.Now()
+	waiting := c.paused[:0]
+	for _, p := range c.paused {
+		if now.Before(p.until) {
+			waiting = append(waiting, p)
+			continue
+		}
+		if c.backpressure {
+			// Resumed with the others once the buffer drained.
+			continue
+		}
+		if err := c.consumer.Resume([]kafka.TopicPartition{p.tp}); err != nil {
+			log.Printf("Failed to resume %s: %v", p.tp, err)
+		}
+	}
+	c.paused = waiting
+}
+
+// withoutDeferred returns the partitions not waiting for a due message.
+func (c *Consumer) withoutDeferred(partitions []kafka.TopicPartition) []kafka.TopicPartition {
+	var result []kafka.TopicPartition
+	for _, tp := range partitions {
+		deferred := false
+		for _, p := range c.paused {
+			if *tp.Topic == *p.tp.Topic && tp.Partition == p.tp.Partition {
+				deferred = true
+			}
+		}
+		if !deferred {
+			result = append(result, tp)
+		}
+	}
+	return result
+}
+
+// forgetPaused drops revoked partitions from the paused ones.
+func (c *Consumer) forgetPaused(partitions []kafka.TopicPartition) {
+	waiting := c.paused[:0]
+	for _, p := range c.paused {
+		revoked := false
+		for _, tp := range partitions {
+			if *tp.Topic == *p.tp.Topic && tp.Partition == p.tp.Partition {
+				revoked = true
+			}
+		}
+		if !revoked {
+			waiting = append(waiting, p)
+		}
+	}
+	c.paused = waiting
+}
@@ -0,0 +1,61 @@
+package consumer
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// startPositions sets the start offset of partitions the consumer did not
+// read before to FromOffset or the first offset at FromTimestamp. Later
+// assignments of the same partition continue where the group left off.
+func (c *Consumer) startPositions(partitions []kafka.TopicPartition) ([]kafka.TopicPartition, error) {
+	if c.cfg.FromOffset < 0 && c.cfg.FromTimestamp == "" {
+		return partitions, nil
+	}
+
+	var fresh []kafka.TopicPartition
+	for _, tp := range partitions {
+		key := partitionKey{*tp.Topic, tp.Partition}
+		if !c.started[key] {
+			c.started[key] = true
+			fresh = append(fresh, tp)
+		}
+	}
+	if len(fresh) == 0 {
+		return partitions, nil
+	}
+
+	positions := map[partitionKey]kafka.Offset{}
+	if c.cfg.FromOffset >= 0 {
+		for _, tp := range fresh {
+			positions[partitionKey{*tp.Topic, tp.Partition}] = kafka.Offset(c.cfg.FromOffset)
+		}
+	} else {
+		// Validate made sure the timestamp parses.
+		at, _ := c.cfg.StartTime()
+		query := make([]kafka.TopicPartition, len(fresh))
+		for i, tp := range fresh {
+			query[i] = kafka.TopicPartition{Topic: tp.Topic, Partition: tp.Partition, Offset: kafka.Offset(at.UnixMilli())}
+		}
+		// A partition without messages since then yields its end offset.
+		found, err := c.consumer.OffsetsForTimes(query, 10000)
+		if err != nil {
+			return partitions, fmt.Errorf("failed to look up offsets for %s: %v", c.cfg.FromTimestamp, err)
+		}
+		for _, tp := range found {
+			positions[partitionKey{*tp.Topic, tp.Partition}] = tp.Offset
+		}
+	}
+
+	result := make([]kafka.TopicPartition, len(partitions))
+	for i, tp := range partitions {
+		if offset, ok := positions[partitionKey{*tp.Topic, tp.Partition}]; ok {
+			tp.Offset = offset
+			log.Printf("Starting %s[%d] at offset %v", *tp.Topic, tp.Partition, offset)
+		}
+		result[i] = tp
+	}
+	return result, nil
+}
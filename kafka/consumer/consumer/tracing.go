@@ -0,0 +1,78 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// headerCarrier lets the propagator read the headers of a message.
+type headerCarrier struct {
+	msg *kafka.Message
+}
+
+func (c headerCarrier) Get(key string) string {
+	v, _ := header(c.msg, key)
+	return v
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c.msg.Headers = append(c.msg.Headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.msg.Headers))
+	for _, h := range c.msg.Headers {
+		keys = append(keys, h.Key)
+	}
+	return keys
+}
+
+// UseTracing makes Run start a consumer span around every handler call.
+// The span continues the trace of the W3C traceparent header the
+// producer sent, and its context is passed to the handler.
+func (c *Consumer) UseTracing(tp trace.TracerProvider) {
+	c.tracer = tp.Tracer("kate.kafka.example/consumer")
+}
+
+// traced wraps handler in a span when tracing is used.
+func (c *Consumer) traced(handler Handler) Handler {
+	if c.tracer == nil {
+		return handler
+	}
+	return func(ctx context.Context, msg *kafka.Message) error {
+		topic := *msg.TopicPartition.Topic
+		producerCtx := propagation.TraceContext{}.Extract(ctx, headerCarrier{msg: msg})
+
+		opts := []trace.SpanStartOption{
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(
+				semconv.MessagingSystemKafka,
+				semconv.MessagingOperationTypeDeliver,
+				semconv.MessagingDestinationName(topic),
+				semconv.MessagingKafkaConsumerGroup(c.cfg.GroupID),
+				semconv.MessagingDestinationPartitionID(fmt.Sprint(msg.TopicPartition.Partition)),
+				attribute.Int64("messaging.kafka.offset", int64(msg.TopicPartition.Offset)),
+			),
+		}
+		if len(msg.Key) > 0 {
+			opts = append(opts, trace.WithAttributes(semconv.MessagingKafkaMessageKey(string(msg.Key))))
+		}
+		// The producer span is the parent, so the message flow is one trace.
+		ctx, span := c.tracer.Start(producerCtx, topic+" process", opts...)
+		defer span.End()
+
+		err := handler(ctx, msg)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	}
+}
@@ -0,0 +1,122 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"kate.kafka.example/producer/producer"
+)
+
+// TransformFunc maps a consumed message to the messages produced for it,
+// by default to TransformTopic.
+type TransformFunc func(msg *kafka.Message) ([]producer.Message, error)
+
+// RunTransform consumes, transforms and produces exactly once until ctx
+// is done: the output of up to CommitEvery messages and their offsets are
+// committed in one transaction. When it fails, the batch is consumed
+// again; read_committed consumers downstream never see its output.
+func (c *Consumer) RunTransform(ctx context.Context, fn TransformFunc) error {
+	pcfg := producer.DefaultConfig()
+	pcfg.KafkaConfig = c.cfg.KafkaConfig
+	pcfg.Topic = c.cfg.TransformTopic
+	pcfg.TransactionalID = c.cfg.TransactionalID
+	pcfg.Source = "go-examples-consumer"
+
+	p, err := producer.NewProducer(pcfg)
+	if err != nil {
+		return fmt.Errorf("failed to create transactional producer: %v", err)
+	}
+	defer p.Close()
+
+	for ctx.Err() == nil && c.halted() == nil {
+		batch := c.pollBatch(ctx)
+		if len(batch) == 0 {
+			continue
+		}
+		if err := c.transformBatch(ctx, p, fn, batch); err != nil {
+			log.Printf("Transaction of %d messages failed, consuming them again: %v", len(batch), err)
+			c.rewind(batch)
+		}
+	}
+	return c.halted()
+}
+
+// pollBatch returns up to CommitEvery messages, fewer when a poll timed out.
+func (c *Consumer) pollBatch(ctx context.Context) []*kafka.Message {
+	var batch []*kafka.Message
+	for len(batch) < c.cfg.CommitEvery && ctx.Err() == nil {
+		msg := c.poll()
+		if msg == nil {
+			break
+		}
+		batch = append(batch, msg)
+	}
+	return batch
+}
+
+func (c *Consumer) transformBatch(ctx context.Context, p *producer.Producer, fn TransformFunc, batch []*kafka.Message) error {
+	var out []producer.Message
+	for _, msg := range batch {
+		if !c.filter.matches(msg) {
+			continue
+		}
+		if c.quarantine != nil {
+			invalid, err := c.quarantine.check(ctx, msg)
+			if err != nil {
+				return fmt.Errorf("failed to quarantine invalid message on %s: %v", msg.TopicPartition, err)
+			}
+			if invalid {
+				c.counters.quarantined.Add(1)
+				continue
+			}
+		}
+		start := time.Now()
+		msgs, err := fn(msg)
+		c.observe(start, err)
+		if err != nil {
+			return fmt.Errorf("failed to transform message on %s: %v", msg.TopicPartition, err)
+		}
+		out = append(out, msgs...)
+	}
+
+	group, err := c.consumer.GetConsumerGroupMetadata()
+	if err != nil {
+		return fmt.Errorf("failed to get group metadata: %v", err)
+	}
+	return p.ProduceConsumed(ctx, out, nextOffsets(batch), group)
+}
+
+// nextOffsets returns the offset after the last message of every
+// partition in batch, which is what gets committed.
+func nextOffsets(batch []*kafka.Message) []kafka.TopicPartition {
+	next := map[partitionKey]kafka.TopicPartition{}
+	for _, msg := range batch {
+		tp := msg.TopicPartition
+		tp.Offset++
+		next[partitionKey{*tp.Topic, tp.Partition}] = tp
+	}
+
+	offsets := make([]kafka.TopicPartition, 0, len(next))
+	for _, tp := range next {
+		offsets = append(offsets, tp)
+	}
+	return offsets
+}
+
+// rewind seeks every partition in batch back to its first message.
+func (c *Consumer) rewind(batch []*kafka.Message) {
+	first := map[partitionKey]bool{}
+	for _, msg := range batch {
+		key := partitionKey{*msg.TopicPartition.Topic, msg.TopicPartition.Partition}
+		if first[key] {
+			continue
+		}
+		first[key] = true
+		if err := c.consumer.Seek(msg.TopicPartition, 0); err != nil {
+			log.Printf("Failed to seek back to %s: %v", msg.TopicPartition, err)
+		}
+	}
+}
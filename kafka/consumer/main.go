@@ -0,0 +1,197 @@
+// Consumer prints the messages of the configured topics, either as member
+// of a consumer group (-mode subscribe, the default) or reading one
+// partition from a given offset (-mode assign -partition 2 -offset beginning).
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"kate.config"
+	"kate.kafka.example/consumer/consumer"
+	"kate.kafka.example/producer/producer"
+	"kate.telemetry"
+)
+
+func main() {
+	cfg, err := consumer.LoadConfig(os.Args[0], os.Args[1:])
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	c, err := consumer.NewConsumer(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.MetricsAddr != "" {
+		serveMetrics(cfg.MetricsAddr, c)
+	}
+
+	if cfg.HealthAddr != "" {
+		serveHealth(cfg.HealthAddr, c)
+	}
+
+	shutdownTracing, err := telemetry.Setup(context.Background(), "go-examples-consumer", telemetry.Config{OTLPEndpoint: cfg.OTLPEndpoint})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.OTLPEndpoint != "" {
+		c.UseTracing(otel.GetTracerProvider())
+	}
+
+	// The ids of handled messages are remembered in the Redis of
+	// REDIS_ADDR, REDIS_USERNAME, REDIS_PASSWORD and REDIS_DB.
+	if cfg.Dedup != "" {
+		rcfg := config.DefaultRedisConfig()
+		if err := config.ApplyEnv("", rcfg.RegisterFlags); err != nil {
+			log.Fatal("Invalid configuration: ", err)
+		}
+		rdb := rcfg.NewClient()
+		defer rdb.Close()
+		filter, err := consumer.NewDedupFilter(context.Background(), cfg, rdb)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Skipping duplicate messages with a %s filter", filter.Kind())
+		c.UseDedup(filter)
+	}
+
+	// SIGINT or SIGTERM stop polling, the offsets of the handled messages
+	// are committed on close.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch {
+	case cfg.TransformTopic != "":
+		err = c.RunTransform(ctx, upperCase)
+	case cfg.BatchSize > 0:
+		err = c.RunBatch(ctx, func(ctx context.Context, msgs []*kafka.Message) error {
+			fmt.Printf("Batch of %d messages\n", len(msgs))
+			for _, msg := range msgs {
+				if err := printMessage(c, msg); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	default:
+		err = c.Run(ctx, func(ctx context.Context, msg *kafka.Message) error {
+			return printMessage(c, msg)
+		})
+	}
+	var poison *consumer.PoisonPillError
+	var fenced *consumer.FencedError
+	switch {
+	case errors.As(err, &poison):
+		fmt.Fprintf(os.Stderr, "Halted at poison pill %s: %v\n", poison.TopicPartition, poison.Err)
+	case errors.As(err, &fenced):
+		fmt.Fprintf(os.Stderr, "Another consumer took over instance %s, stopping\n", fenced.InstanceID)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Consuming failed: %v\n", err)
+	}
+
+	if err := c.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to commit offsets: %v\n", err)
+	}
+	// Export the spans of the last messages.
+	if err := shutdownTracing(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to export spans: %v\n", err)
+	}
+}
+
+// serveMetrics exposes the consumer metrics on /metrics in the background.
+func serveMetrics(addr string, c *consumer.Consumer) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c.Collector())
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	go func() {
+		log.Printf("Serving metrics on http://%s/metrics", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Metrics server failed: %v", err)
+		}
+	}()
+}
+
+// serveHealth exposes /healthz and /status of the consumer in the
+// background.
+func serveHealth(addr string, c *consumer.Consumer) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		health := c.Health()
+		status := http.StatusOK
+		if !health.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, health)
+	})
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		status, err := c.Status()
+		if err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	})
+
+	go func() {
+		log.Printf("Serving health on http://%s/healthz", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Health server failed: %v", err)
+		}
+	}()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// upperCase is the example transformation.
+func upperCase(msg *kafka.Message) ([]producer.Message, error) {
+	return []producer.Message{{
+		Key:   msg.Key,
+		Value: bytes.ToUpper(msg.Value),
+	}}, nil
+}
+
+// printMessage prints the value of msg, decoded values as JSON.
+func printMessage(c *consumer.Consumer, msg *kafka.Message) error {
+	value, err := c.Decode(msg)
+	if err != nil {
+		return err
+	}
+
+	var text []byte
+	switch v := value.(type) {
+	case []byte:
+		text = v
+	case proto.Message:
+		text, err = protojson.Marshal(v)
+	default:
+		text, err = json.Marshal(v)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Message on %s: %s\n", msg.TopicPartition, text)
+	return nil
+}
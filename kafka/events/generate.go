@@ -0,0 +1,4 @@
+// Package events holds the protobuf messages produced and consumed by the examples.
+package events
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative greeting.proto
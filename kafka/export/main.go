@@ -0,0 +1,300 @@
+// Export writes the messages of a topic to NDJSON files, one per
+// partition, for audits and offline analysis.
+//
+// Partitions are assigned directly, without joining a group, and read up
+// to the end they had when the export started. No offsets are committed.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"kate.kafka.example/consumer/consumer"
+)
+
+const usage = `Usage: export [flags] <topic> <dir> [-partitions 0-2,5] [-from time] [-to time]
+
+Writes every message of <topic> to <dir>/<topic>-<partition>.ndjson, one
+JSON object per line with topic, partition, offset, timestamp, key, value
+and headers. Keys and values which are not UTF-8 are base64 encoded, the
+record then has "encoding": "base64".
+
+-from and -to (RFC 3339) restrict the export to messages in that time
+range, by default every partition is read from the beginning to its
+current end. Connection flags are shared with the consumer, see consumer -h.
+`
+
+const timeoutMs = 10000
+
+// record is one line of an export file.
+type record struct {
+	Topic     string            `json:"topic"`
+	Partition int32             `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Timestamp time.Time         `json:"timestamp"`
+	Key       string            `json:"key,omitempty"`
+	Value     string            `json:"value"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Encoding  string            `json:"encoding,omitempty"`
+}
+
+// options are the flags following topic and directory.
+type options struct {
+	partitions map[int32]bool
+	from, to   time.Time
+}
+
+func main() {
+	cfg, args, err := consumer.LoadConfigArgs(os.Args[0], os.Args[1:])
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+	if len(args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	topic, dir := args[0], args[1]
+	opts, err := parseOptions(args[2:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n%s", err, usage)
+		os.Exit(2)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Fatal(err)
+	}
+
+	configMap := cfg.ConsumerConfigMap()
+	// Partitions ending in a transaction marker never deliver their last
+	// offset, the end of partition event tells they are done.
+	configMap.SetKey("enable.partition.eof", true)
+	c, err := kafka.NewConsumer(configMap)
+	if err != nil {
+		log.Fatal("Failed to create consumer: ", err)
+	}
+	defer c.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	n, err := export(ctx, c, topic, dir, opts)
+	log.Printf("Exported %d messages of %s to %s", n, topic, dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func parseOptions(args []string) (options, error) {
+	var opts options
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	partitions := fs.String("partitions", "", "comma separated partitions or ranges, all by default")
+	from := fs.String("from", "", "first message time, RFC 3339")
+	to := fs.String("to", "", "last message time, RFC 3339")
+	if err := fs.Parse(args); err != nil {
+		return opts, err
+	}
+	if fs.NArg() > 0 {
+		return opts, fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+
+	var err error
+	if *partitions != "" {
+		if opts.partitions, err = parsePartitions(*partitions); err != nil {
+			return opts, err
+		}
+	}
+	if *from != "" {
+		if opts.from, err = time.Parse(time.RFC3339, *from); err != nil {
+			return opts, fmt.Errorf("invalid -from: %v", err)
+		}
+	}
+	if *to != "" {
+		if opts.to, err = time.Parse(time.RFC3339, *to); err != nil {
+			return opts, fmt.Errorf("invalid -to: %v", err)
+		}
+	}
+	return opts, nil
+}
+
+// parsePartitions parses e.g. "0-2,5".
+func parsePartitions(list string) (map[int32]bool, error) {
+	partitions := map[int32]bool{}
+	for _, item := range strings.Split(list, ",") {
+		first, last, isRange := strings.Cut(item, "-")
+		lo, err := strconv.Atoi(first)
+		hi := lo
+		if err == nil && isRange {
+			hi, err = strconv.Atoi(last)
+		}
+		if err != nil || lo < 0 || hi < lo {
+			return nil, fmt.Errorf("invalid partitions %q", item)
+		}
+		for p := lo; p <= hi; p++ {
+			partitions[int32(p)] = true
+		}
+	}
+	return partitions, nil
+}
+
+// export assigns the selected partitions of topic and writes them to
+// dir until every partition reached its end. It returns the number of
+// exported messages.
+func export(ctx context.Context, c *kafka.Consumer, topic, dir string, opts options) (int, error) {
+	start, err := startOffsets(c, topic, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	// end is the offset after the last message to export per partition.
+	end := map[int32]int64{}
+	files := map[int32]*bufio.Writer{}
+	var assign []kafka.TopicPartition
+	for _, tp := range start {
+		low, high, err := c.QueryWatermarkOffsets(topic, tp.Partition, timeoutMs)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get end of partition %d: %v", tp.Partition, err)
+		}
+		if tp.Offset == kafka.OffsetBeginning {
+			tp.Offset = kafka.Offset(low)
+		}
+		if tp.Offset < 0 || int64(tp.Offset) >= high {
+			// Empty, or nothing since -from.
+			continue
+		}
+		end[tp.Partition] = high
+
+		f, err := os.Create(filepath.Join(dir, fmt.Sprintf("%s-%d.ndjson", topic, tp.Partition)))
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		w := bufio.NewWriter(f)
+		defer w.Flush()
+		files[tp.Partition] = w
+		assign = append(assign, tp)
+	}
+	if len(assign) == 0 {
+		return 0, nil
+	}
+	if err := c.Assign(assign); err != nil {
+		return 0, fmt.Errorf("failed to assign partitions: %v", err)
+	}
+
+	var n int
+	for len(end) > 0 && ctx.Err() == nil {
+		var msg *kafka.Message
+		switch e := c.Poll(1000).(type) {
+		case *kafka.Message:
+			if e.TopicPartition.Error != nil {
+				log.Printf("Consumer error: %v", e.TopicPartition.Error)
+				continue
+			}
+			msg = e
+		case kafka.PartitionEOF:
+			delete(end, e.Partition)
+			continue
+		case kafka.Error:
+			log.Printf("Consumer error: %v", e)
+			continue
+		default:
+			continue
+		}
+
+		partition := msg.TopicPartition.Partition
+		offset := int64(msg.TopicPartition.Offset)
+		if _, ok := end[partition]; !ok {
+			continue
+		}
+		if !opts.to.IsZero() && msg.Timestamp.After(opts.to) {
+			// Timestamps of a partition grow, apart from producers
+			// setting their own.
+			delete(end, partition)
+			continue
+		}
+
+		line, err := json.Marshal(newRecord(msg))
+		if err != nil {
+			return n, err
+		}
+		if _, err := files[partition].Write(append(line, '\n')); err != nil {
+			return n, err
+		}
+		n++
+
+		if offset+1 >= end[partition] {
+			delete(end, partition)
+		}
+	}
+	return n, ctx.Err()
+}
+
+// startOffsets returns the selected partitions of topic positioned at
+// the beginning or the first message since opts.from.
+func startOffsets(c *kafka.Consumer, topic string, opts options) ([]kafka.TopicPartition, error) {
+	metadata, err := c.GetMetadata(&topic, false, timeoutMs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get partitions: %v", err)
+	}
+	t, ok := metadata.Topics[topic]
+	if !ok || t.Error.Code() == kafka.ErrUnknownTopicOrPart {
+		return nil, fmt.Errorf("topic %s does not exist", topic)
+	}
+
+	var partitions []kafka.TopicPartition
+	for _, p := range t.Partitions {
+		if opts.partitions != nil && !opts.partitions[p.ID] {
+			continue
+		}
+		tp := kafka.TopicPartition{Topic: &topic, Partition: p.ID, Offset: kafka.OffsetBeginning}
+		if !opts.from.IsZero() {
+			tp.Offset = kafka.Offset(opts.from.UnixMilli())
+		}
+		partitions = append(partitions, tp)
+	}
+	if opts.from.IsZero() || len(partitions) == 0 {
+		return partitions, nil
+	}
+
+	// A partition without messages since then yields its end offset.
+	partitions, err = c.OffsetsForTimes(partitions, timeoutMs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up offsets for %s: %v", opts.from, err)
+	}
+	return partitions, nil
+}
+
+func newRecord(msg *kafka.Message) record {
+	r := record{
+		Topic:     *msg.TopicPartition.Topic,
+		Partition: msg.TopicPartition.Partition,
+		Offset:    int64(msg.TopicPartition.Offset),
+		Timestamp: msg.Timestamp.UTC(),
+		Key:       string(msg.Key),
+		Value:     string(msg.Value),
+	}
+	if !utf8.Valid(msg.Key) || !utf8.Valid(msg.Value) {
+		r.Encoding = "base64"
+		r.Key = base64.StdEncoding.EncodeToString(msg.Key)
+		r.Value = base64.StdEncoding.EncodeToString(msg.Value)
+	}
+	if len(msg.Headers) > 0 {
+		r.Headers = map[string]string{}
+		for _, h := range msg.Headers {
+			r.Headers[h.Key] = string(h.Value)
+		}
+	}
+	return r
+}
@@ -0,0 +1,89 @@
+// Order projection: consumes the order events the outbox relay produces
+// and projects them into Redis, see the orders package.
+//
+// Offsets are committed after Redis accepted an event, so events are
+// delivered at least once. The projection skips events older than the
+// order it has, a redelivered or duplicate event changes nothing.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"kate.config"
+	"kate.kafka.example/consumer/consumer"
+	"kate.kafka.example/orders/orders"
+)
+
+const usage = `Usage: orderprojection [flags]
+
+Consumes the order events of -topics (usually orders) and keeps the hashes
+orders:order:<id>, the sets orders:customer:<customer> and the counts per
+status in orders:status current. Redis is set with REDIS_ADDR (default
+localhost:6379), REDIS_USERNAME, REDIS_PASSWORD and REDIS_DB, other flags
+are shared with the consumer, see consumer -h.
+`
+
+func main() {
+	cfg, args, err := consumer.LoadConfigArgs(os.Args[0], os.Args[1:])
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+	if len(args) > 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	rcfg := config.DefaultRedisConfig()
+	if err := config.ApplyEnv("", rcfg.RegisterFlags); err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	rdb := rcfg.NewClient()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		log.Fatal("Redis connection failed: ", err)
+	}
+	defer rdb.Close()
+	projection := orders.NewProjection(rdb)
+
+	c, err := consumer.NewConsumer(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Projecting order events of %v into Redis", cfg.Topics)
+	err = c.Run(ctx, func(ctx context.Context, msg *kafka.Message) error {
+		var e orders.Event
+		if err := json.Unmarshal(msg.Value, &e); err != nil {
+			// Retrying does not fix a malformed event
+			log.Printf("Skipping malformed event at %s: %v", msg.TopicPartition, err)
+			return nil
+		}
+		applied, err := projection.Apply(ctx, e)
+		if err != nil {
+			return err
+		}
+		if applied {
+			log.Printf("%s: order %d of %s is %s", e.Type, e.Order.ID, e.Order.Customer, e.Order.Status)
+		} else {
+			log.Printf("Skipping %s of order %d version %d, seen before", e.Type, e.Order.ID, e.Order.Version)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Consuming failed: %v\n", err)
+	}
+
+	if err := c.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to commit offsets: %v\n", err)
+	}
+}
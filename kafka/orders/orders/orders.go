@@ -0,0 +1,165 @@
+// Package orders keeps orders in PostgreSQL and publishes their changes
+// with the transactional outbox pattern.
+//
+// Every change of an order inserts an event into the outbox table in the
+// same transaction, so the event exists if and only if the change was
+// committed. The outbox relay produces the events to Kafka, keyed by
+// order so the events of an order stay in order, and Projection applies
+// them to a read model in Redis. Events carry the whole order and its
+// version, a projection skips events older than what it has, which makes
+// the duplicates the relay may produce harmless.
+package orders
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Topic is where the outbox relay produces the events.
+const Topic = "orders"
+
+// Event types.
+const (
+	EventPlaced    = "OrderPlaced"
+	EventCancelled = "OrderCancelled"
+)
+
+// Order statuses.
+const (
+	StatusPlaced    = "placed"
+	StatusCancelled = "cancelled"
+)
+
+var (
+	// ErrInvalidOrder is returned for orders without customer or with
+	// invalid items.
+	ErrInvalidOrder = errors.New("invalid order")
+	// ErrNotFound is returned for orders which do not exist.
+	ErrNotFound = errors.New("order not found")
+	// ErrNotCancellable is returned when cancelling an order which is
+	// not placed, e.g. because it was cancelled before.
+	ErrNotCancellable = errors.New("order can not be cancelled")
+)
+
+type Item struct {
+	SKU        string `json:"sku"`
+	Quantity   int    `json:"quantity"`
+	PriceCents int64  `json:"price_cents"`
+}
+
+type Order struct {
+	ID         int64     `json:"id"`
+	Customer   string    `json:"customer"`
+	Items      []Item    `json:"items"`
+	TotalCents int64     `json:"total_cents"`
+	Status     string    `json:"status"`
+	Version    int64     `json:"version"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Event is the value of the messages on Topic.
+type Event struct {
+	Type  string `json:"type"`
+	Order Order  `json:"order"`
+}
+
+// Store reads and writes orders and their events.
+type Store struct {
+	pool *pgxpool.Pool
+}
+
+func NewStore(pool *pgxpool.Pool) *Store {
+	return &Store{pool: pool}
+}
+
+const orderColumns = "id, customer, items, total_cents, status, version, updated_at"
+
+func scanOrder(row pgx.Row) (Order, error) {
+	var o Order
+	err := row.Scan(&o.ID, &o.Customer, &o.Items, &o.TotalCents, &o.Status, &o.Version, &o.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return o, ErrNotFound
+	}
+	return o, err
+}
+
+// Place creates an order of customer for items.
+func (s *Store) Place(ctx context.Context, customer string, items []Item) (Order, error) {
+	if customer == "" || len(items) == 0 {
+		return Order{}, fmt.Errorf("%w: a customer and items are required", ErrInvalidOrder)
+	}
+	var total int64
+	for _, it := range items {
+		if it.SKU == "" || it.Quantity < 1 || it.PriceCents < 0 {
+			return Order{}, fmt.Errorf("%w: item %+v", ErrInvalidOrder, it)
+		}
+		total += int64(it.Quantity) * it.PriceCents
+	}
+
+	var o Order
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		var err error
+		o, err = scanOrder(tx.QueryRow(ctx, `
+			INSERT INTO orders (customer, items, total_cents, status)
+			VALUES ($1, $2, $3, $4)
+			RETURNING `+orderColumns, customer, items, total, StatusPlaced))
+		if err != nil {
+			return fmt.Errorf("failed to insert order: %v", err)
+		}
+		return publish(ctx, tx, EventPlaced, o)
+	})
+	return o, err
+}
+
+// Cancel cancels the placed order id.
+func (s *Store) Cancel(ctx context.Context, id int64) (Order, error) {
+	var o Order
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		var err error
+		o, err = scanOrder(tx.QueryRow(ctx, `
+			UPDATE orders SET status = $2, version = version + 1, updated_at = now()
+			WHERE id = $1 AND status = $3
+			RETURNING `+orderColumns, id, StatusCancelled, StatusPlaced))
+		if errors.Is(err, ErrNotFound) {
+			// Tell a missing order from one in another status
+			if _, err := scanOrder(tx.QueryRow(ctx, `SELECT `+orderColumns+` FROM orders WHERE id = $1`, id)); err != nil {
+				return err
+			}
+			return ErrNotCancellable
+		}
+		if err != nil {
+			return fmt.Errorf("failed to cancel order: %v", err)
+		}
+		return publish(ctx, tx, EventCancelled, o)
+	})
+	return o, err
+}
+
+// Get returns the order id.
+func (s *Store) Get(ctx context.Context, id int64) (Order, error) {
+	return scanOrder(s.pool.QueryRow(ctx, `SELECT `+orderColumns+` FROM orders WHERE id = $1`, id))
+}
+
+// publish inserts the event of type typ for o into the outbox, keyed by
+// the order id.
+func publish(ctx context.Context, tx pgx.Tx, typ string, o Order) error {
+	payload, err := json.Marshal(Event{Type: typ, Order: o})
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO outbox (topic, key, payload, headers)
+		VALUES ($1, $2, $3, $4)`,
+		Topic, []byte(strconv.FormatInt(o.ID, 10)), payload, map[string]string{"event-type": typ})
+	if err != nil {
+		return fmt.Errorf("failed to insert outbox entry: %v", err)
+	}
+	return nil
+}
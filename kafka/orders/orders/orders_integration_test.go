@@ -0,0 +1,153 @@
+//go:build integration
+
+package orders
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"kate.testutil"
+)
+
+func newTestStore(t *testing.T) (*Store, *pgxpool.Pool) {
+	t.Helper()
+	url := testutil.StartPostgres(t, "../../outbox/schema.sql", "../schema.sql")
+	pool, err := pgxpool.New(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return NewStore(pool), pool
+}
+
+// outboxEvents returns the events in the outbox, oldest first.
+func outboxEvents(t *testing.T, pool *pgxpool.Pool) []Event {
+	t.Helper()
+	rows, err := pool.Query(context.Background(), `SELECT payload FROM outbox WHERE topic = $1 ORDER BY id`, Topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var payload []byte
+		var e Event
+		if err := rows.Scan(&payload); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(payload, &e); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestChangesWriteOutboxEvents(t *testing.T) {
+	ctx := context.Background()
+	store, pool := newTestStore(t)
+
+	placed, err := store.Place(ctx, "ada", []Item{{SKU: "book", Quantity: 2, PriceCents: 1500}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if placed.TotalCents != 3000 || placed.Status != StatusPlaced || placed.Version != 1 {
+		t.Errorf("placed order = %+v", placed)
+	}
+
+	cancelled, err := store.Cancel(ctx, placed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cancelled.Status != StatusCancelled || cancelled.Version != 2 {
+		t.Errorf("cancelled order = %+v", cancelled)
+	}
+
+	if _, err := store.Cancel(ctx, placed.ID); !errors.Is(err, ErrNotCancellable) {
+		t.Errorf("cancelling twice: got %v, want ErrNotCancellable", err)
+	}
+	if _, err := store.Cancel(ctx, placed.ID+1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("cancelling a missing order: got %v, want ErrNotFound", err)
+	}
+	if _, err := store.Place(ctx, "", nil); !errors.Is(err, ErrInvalidOrder) {
+		t.Errorf("placing an empty order: got %v, want ErrInvalidOrder", err)
+	}
+
+	var types []string
+	for _, e := range outboxEvents(t, pool) {
+		types = append(types, e.Type)
+	}
+	if want := []string{EventPlaced, EventCancelled}; !reflect.DeepEqual(types, want) {
+		t.Errorf("outbox events = %v, want %v", types, want)
+	}
+}
+
+func TestOrderRolledBackWithoutOutbox(t *testing.T) {
+	ctx := context.Background()
+	store, pool := newTestStore(t)
+
+	// The event can not be written, so the order must not be either
+	if _, err := pool.Exec(ctx, `ALTER TABLE outbox RENAME TO outbox_gone`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Place(ctx, "ada", []Item{{SKU: "book", Quantity: 1, PriceCents: 1500}}); err == nil {
+		t.Fatal("order placed without outbox")
+	}
+
+	var n int
+	if err := pool.QueryRow(ctx, `SELECT count(*) FROM orders`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("%d orders written without their event", n)
+	}
+}
+
+func TestProjectionSkipsOldEvents(t *testing.T) {
+	ctx := context.Background()
+	p := NewProjection(testutil.NewRedisClient(t, testutil.StartRedis(t)))
+
+	placed := Event{Type: EventPlaced, Order: Order{ID: 1, Customer: "ada", TotalCents: 3000, Status: StatusPlaced, Version: 1}}
+	cancelled := placed
+	cancelled.Type = EventCancelled
+	cancelled.Order.Status = StatusCancelled
+	cancelled.Order.Version = 2
+
+	for i, tc := range []struct {
+		event Event
+		want  bool
+	}{
+		{placed, true},
+		{placed, false}, // duplicate from the relay
+		{cancelled, true},
+		{placed, false}, // redelivered after the cancel
+	} {
+		applied, err := p.Apply(ctx, tc.event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if applied != tc.want {
+			t.Errorf("event %d applied = %v, want %v", i, applied, tc.want)
+		}
+	}
+
+	counts, err := p.StatusCounts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[StatusPlaced] != 0 || counts[StatusCancelled] != 1 {
+		t.Errorf("status counts = %v, want 1 cancelled", counts)
+	}
+	ids, err := p.CustomerOrders(ctx, "ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []int64{1}) {
+		t.Errorf("orders of ada = %v, want [1]", ids)
+	}
+}
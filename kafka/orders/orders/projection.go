@@ -0,0 +1,84 @@
+package orders
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const statusKey = "orders:status"
+
+func orderKey(id int64) string { return fmt.Sprintf("orders:order:%d", id) }
+
+func customerKey(customer string) string { return fmt.Sprintf("orders:customer:%s", customer) }
+
+// applyScript writes the order unless the projection has its version or a
+// later one, and moves it between the counts per status. It returns 1 if
+// it wrote the order.
+var applyScript = redis.NewScript(`
+local version = tonumber(redis.call('HGET', KEYS[1], 'version') or '0')
+if tonumber(ARGV[1]) <= version then
+  return 0
+end
+local old = redis.call('HGET', KEYS[1], 'status')
+redis.call('HSET', KEYS[1], 'version', ARGV[1], 'customer', ARGV[2], 'status', ARGV[3],
+  'total_cents', ARGV[4], 'updated_at', ARGV[5])
+redis.call('SADD', KEYS[2], ARGV[6])
+if old then
+  redis.call('HINCRBY', KEYS[3], old, -1)
+end
+redis.call('HINCRBY', KEYS[3], ARGV[3], 1)
+return 1
+`)
+
+// Projection is the read model of the orders in Redis: a hash per order,
+// the ids of the orders of every customer and the number of orders in
+// every status.
+type Projection struct {
+	rdb *redis.Client
+}
+
+func NewProjection(rdb *redis.Client) *Projection {
+	return &Projection{rdb: rdb}
+}
+
+// Apply writes the order of e, it reports false for events the projection
+// has seen already.
+func (p *Projection) Apply(ctx context.Context, e Event) (bool, error) {
+	o := e.Order
+	keys := []string{orderKey(o.ID), customerKey(o.Customer), statusKey}
+	n, err := applyScript.Run(ctx, p.rdb, keys,
+		o.Version, o.Customer, o.Status, o.TotalCents, o.UpdatedAt.UnixMilli(), o.ID).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to project order %d: %v", o.ID, err)
+	}
+	return n == 1, nil
+}
+
+// StatusCounts returns the number of orders in every status.
+func (p *Projection) StatusCounts(ctx context.Context) (map[string]int64, error) {
+	fields, err := p.rdb.HGetAll(ctx, statusKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(fields))
+	for status, n := range fields {
+		counts[status], _ = strconv.ParseInt(n, 10, 64)
+	}
+	return counts, nil
+}
+
+// CustomerOrders returns the ids of the orders of customer.
+func (p *Projection) CustomerOrders(ctx context.Context, customer string) ([]int64, error) {
+	members, err := p.rdb.Sort(ctx, customerKey(customer), &redis.Sort{}).Result()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, len(members))
+	for i, m := range members {
+		ids[i], _ = strconv.ParseInt(m, 10, 64)
+	}
+	return ids, nil
+}
@@ -0,0 +1,13 @@
+-- Orders of the orders example. Every change is written in the same
+-- transaction as an outbox row (see ../outbox/schema.sql) carrying the new
+-- state of the order, version counts the changes.
+CREATE TABLE IF NOT EXISTS orders (
+    id          bigserial   PRIMARY KEY,
+    customer    text        NOT NULL,
+    items       jsonb       NOT NULL,
+    total_cents bigint      NOT NULL,
+    status      text        NOT NULL,
+    version     bigint      NOT NULL DEFAULT 1,
+    created_at  timestamptz NOT NULL DEFAULT now(),
+    updated_at  timestamptz NOT NULL DEFAULT now()
+);
@@ -0,0 +1,25 @@
+-- Outbox table read by the relay. The application inserts a row in the
+-- same database transaction as its business change.
+CREATE TABLE IF NOT EXISTS outbox (
+    id         bigserial   PRIMARY KEY,
+    topic      text        NOT NULL,
+    key        bytea,
+    payload    bytea       NOT NULL,
+    headers    jsonb       NOT NULL DEFAULT '{}',
+    created_at timestamptz NOT NULL DEFAULT now(),
+    sent_at    timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS outbox_unsent ON outbox (id) WHERE sent_at IS NULL;
+
+-- Wake up the relay right away instead of waiting for the next poll.
+CREATE OR REPLACE FUNCTION outbox_notify() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('outbox', NEW.id::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS outbox_notify ON outbox;
+CREATE TRIGGER outbox_notify AFTER INSERT ON outbox
+    FOR EACH ROW EXECUTE FUNCTION outbox_notify();
@@ -0,0 +1,16 @@
+-- Offsets of the PostgreSQL sink. They are written in the same database
+-- transaction as the rows of a batch, so every message is applied once.
+CREATE TABLE IF NOT EXISTS kafka_offsets (
+    group_id    text    NOT NULL,
+    topic       text    NOT NULL,
+    partition   integer NOT NULL,
+    next_offset bigint  NOT NULL,
+    PRIMARY KEY (group_id, topic, partition)
+);
+
+-- Example target table: pgsink users id,name,email id
+CREATE TABLE IF NOT EXISTS users (
+    id    bigint PRIMARY KEY,
+    name  text,
+    email text
+);
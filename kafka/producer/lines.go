@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"kate.kafka.example/producer/producer"
+)
+
+// produceLines produces every line of the configured input as a message,
+// like kcat -P does, until the input ends or ctx is done.
+func produceLines(ctx context.Context, p *producer.Producer, cfg producer.Config) error {
+	var input io.Reader = os.Stdin
+	if cfg.Input != "-" {
+		f, err := os.Open(cfg.Input)
+		if err != nil {
+			return fmt.Errorf("failed to open input: %v", err)
+		}
+		defer f.Close()
+		input = f
+	}
+
+	// Reading blocks, so it happens in its own goroutine to be able to
+	// stop on ctx while waiting for input.
+	lines := make(chan string)
+	scanErr := make(chan error, 1)
+	go func() {
+		defer close(lines)
+
+		scanner := bufio.NewScanner(input)
+		// A longer line could not be produced anyway.
+		scanner.Buffer(make([]byte, 64*1024), cfg.MessageMaxBytes)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+		scanErr <- scanner.Err()
+	}()
+
+	lineNo := 0
+	for {
+		var line string
+		select {
+		case <-ctx.Done():
+			return nil
+		case l, ok := <-lines:
+			if !ok {
+				select {
+				case err := <-scanErr:
+					return err
+				default:
+					return nil
+				}
+			}
+			line = l
+		}
+
+		lineNo++
+		if line == "" {
+			continue
+		}
+
+		key, value := cfg.Key, line
+		if cfg.ParseKey {
+			if k, v, ok := strings.Cut(line, "\t"); ok {
+				key, value = k, v
+			}
+		}
+
+		msg := producer.Message{Value: []byte(value)}
+		if key != "" {
+			msg.Key = []byte(key)
+		}
+		for k, v := range cfg.Headers {
+			msg.SetHeader(k, v)
+		}
+
+		if err := p.Produce(msg); err != nil {
+			fmt.Fprintf(os.Stderr, "Line %d not produced: %v\n", lineNo, err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"kate.kafka.example/producer/producer"
+)
+
+// latencies collects the delivery latency of every message.
+type latencies struct {
+	mu     sync.Mutex
+	values []time.Duration
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.mu.Lock()
+	l.values = append(l.values, d)
+	l.mu.Unlock()
+}
+
+// percentile returns the p-th percentile, values must be sorted.
+func percentile(values []time.Duration, p float64) time.Duration {
+	if len(values) == 0 {
+		return 0
+	}
+	return values[int(float64(len(values)-1)*p)]
+}
+
+// produceLoad produces synthetic messages at the configured rate, until
+// the count or duration is reached or ctx is done, and reports the
+// achieved throughput and delivery latency percentiles.
+func produceLoad(ctx context.Context, p *producer.Producer, cfg producer.Config) {
+	payload := make([]byte, cfg.PayloadSize)
+	rand.Read(payload)
+
+	var lat latencies
+	p.OnDelivery(func(msg *kafka.Message, err error) {
+		if err != nil {
+			return
+		}
+		for _, h := range msg.Headers {
+			if h.Key == producer.HeaderProducedAt {
+				if at, err := time.Parse(time.RFC3339Nano, string(h.Value)); err == nil {
+					lat.add(time.Since(at))
+				}
+			}
+		}
+	})
+
+	var interval time.Duration
+	if cfg.Rate > 0 {
+		interval = time.Second / time.Duration(cfg.Rate)
+	}
+
+	start := time.Now()
+	sent := 0
+	for cfg.Count == 0 || sent < cfg.Count {
+		if cfg.Duration > 0 && time.Since(start) >= cfg.Duration {
+			break
+		}
+
+		// Pace against the start time, so a slow iteration is caught up.
+		if interval > 0 {
+			if wait := time.Until(start.Add(time.Duration(sent) * interval)); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		// Produce blocks while the local queue is full, which throttles
+		// the offered rate to what the cluster takes.
+		err := p.Produce(producer.Message{Value: payload})
+		if err != nil {
+			fmt.Printf("Produce failed: %v\n", err)
+		}
+		sent++
+	}
+	produced := time.Since(start)
+
+	p.Flush(cfg.FlushTimeoutMs)
+	elapsed := time.Since(start)
+
+	lat.mu.Lock()
+	values := lat.values
+	lat.mu.Unlock()
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	stats := p.Stats()
+	seconds := elapsed.Seconds()
+	fmt.Printf("Sent %d messages in %v (%.0f msg/s offered)\n", sent, produced.Round(time.Millisecond), float64(sent)/produced.Seconds())
+	fmt.Printf("Delivered %d, failed %d in %v: %.0f msg/s, %.2f MB/s\n",
+		stats.Delivered, stats.Failed, elapsed.Round(time.Millisecond),
+		float64(stats.Delivered)/seconds, float64(stats.Delivered)*float64(cfg.PayloadSize)/seconds/1e6)
+	fmt.Printf("Latency p50 %v, p90 %v, p99 %v, max %v\n",
+		percentile(values, 0.5), percentile(values, 0.9), percentile(values, 0.99), percentile(values, 1))
+}
@@ -2,67 +2,240 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"kate.kafka.example/events"
+	"kate.kafka.example/producer/producer"
+	"kate.telemetry"
 )
 
 func main() {
-	adminClient, err := kafka.NewAdminClient(&kafka.ConfigMap{
-		"bootstrap.servers": "localhost:9092",
-	})
+	cfg, err := producer.LoadConfig(os.Args[0], os.Args[1:])
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
 
-	fmt.Println("err = ", err)
-
-	results, err := adminClient.CreateTopics(context.Background(),
-		[]kafka.TopicSpecification{{
-			Topic:             "myTopic2",
-			NumPartitions:     6, // Choose appropriate number
-			ReplicationFactor: 0, // Choose appropriate replication
-			Config: map[string]string{
-				"retention.ms": "604800000", // 7 days
-			},
-		}},
-	)
-	fmt.Println("results = ", results, err)
-
-	p, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": "localhost"})
+	fmt.Fprintln(os.Stderr, "Effective producer settings:")
+	producer.PrintConfigMap(os.Stderr, cfg.ProducerConfigMap())
+
+	p, err := producer.NewProducer(cfg)
 	if err != nil {
 		panic(err)
 	}
 
-	defer p.Close()
+	// Fail fast instead of losing messages to a missing topic or
+	// partition. The REST proxy produces to the topics it is asked for.
+	if cfg.Mode != producer.ModeHTTP {
+		topicCtx, cancelTopic := context.WithTimeout(context.Background(), 10*time.Second)
+		err := p.EnsureTopic(topicCtx)
+		cancelTopic()
+		if err != nil {
+			p.Close()
+			log.Fatal(err)
+		}
+	}
+
+	if cfg.LogDeliveries {
+		p.Use(producer.NewLogMiddleware(os.Stdout))
+	}
+
+	if len(cfg.Routes) > 0 {
+		p.Use(producer.NewRouter(cfg.Routes))
+	}
+
+	if cfg.DeadLetter {
+		p.Use(producer.NewDLQProducer(p).Middleware())
+	}
+
+	// The provider is global, the REST proxy traces its requests with it.
+	shutdownTracing, err := telemetry.Setup(context.Background(), cfg.Source, telemetry.Config{OTLPEndpoint: cfg.OTLPEndpoint})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.OTLPEndpoint != "" {
+		p.Use(producer.NewTracingMiddleware(otel.GetTracerProvider(), cfg.Topic))
+	}
+
+	if cfg.MetricsAddr != "" {
+		serveMetrics(cfg.MetricsAddr, p)
+	}
+
+	// SIGINT or SIGTERM stop producing new messages, what was already
+	// produced is flushed below.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	demoCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	switch {
+	case cfg.Mode == producer.ModeLines:
+		if err := produceLines(ctx, p, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Reading input failed: %v\n", err)
+		}
+	case cfg.Mode == producer.ModeLoad:
+		produceLoad(ctx, p, cfg)
+	case cfg.Mode == producer.ModePartitions:
+		comparePartitioners(ctx, cfg)
+	case cfg.Mode == producer.ModeHTTP:
+		if err := serveHTTP(ctx, p, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "HTTP server failed: %v\n", err)
+		}
+	case p.Transactional():
+		produceTransaction(demoCtx, p)
+	case cfg.Profile == producer.ProfileExactlyOnce:
+		produceSequence(demoCtx, p, cfg)
+	default:
+		produceWords(demoCtx, p, cfg)
+	}
+
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "Interrupted, flushing produced messages")
+	}
+
+	// Wait for message deliveries before shutting down
+	remaining := p.Shutdown(cfg.FlushTimeoutMs)
+
+	stats := p.Stats()
+	fmt.Printf("Delivered: %d, Failed: %d, Retried: %d\n", stats.Delivered, stats.Failed, stats.Retried)
+
+	p.Close()
+	// Export the spans of the last deliveries.
+	if err := shutdownTracing(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to export spans: %v\n", err)
+	}
+	if remaining > 0 {
+		fmt.Fprintf(os.Stderr, "%d messages remained unsent\n", remaining)
+		os.Exit(1)
+	}
+}
+
+// serveMetrics exposes the producer metrics on /metrics in the background.
+func serveMetrics(addr string, p *producer.Producer) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(p.Collector())
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
 
-	// Delivery report handler for produced messages
 	go func() {
-		for e := range p.Events() {
-			switch ev := e.(type) {
-			case *kafka.Message:
-				if ev.TopicPartition.Error != nil {
-					fmt.Printf("Delivery failed: %v\n", ev.TopicPartition)
-				} else {
-					fmt.Printf("Delivered message to %v\n", ev.TopicPartition)
-				}
-			}
+		log.Printf("Serving metrics on http://%s/metrics", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Metrics server failed: %v", err)
 		}
 	}()
+}
 
-	// Produce messages to topic (asynchronously)
-	topic := "myTopic2"
-	for _, word := range []string{"Welcome", "to", "the", "Confluent", "Kafka", "Golang", "client"} {
-		p.Produce(&kafka.Message{
-			TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 1},
-			Value:          []byte(word),
-		}, nil)
+// Greeting is the record of the example, see greeting.avsc and
+// greeting.schema.json.
+type Greeting struct {
+	User     string `avro:"user" json:"user"`
+	Word     string `avro:"word" json:"word"`
+	Position int    `avro:"position" json:"position"`
+}
+
+// payload encodes a word in the configured format.
+func payload(p *producer.Producer, cfg producer.Config, key string, position int, word string) ([]byte, error) {
+	switch cfg.Format {
+	case producer.FormatJSON, producer.FormatAvro:
+		return p.Serialize(&Greeting{User: key, Word: word, Position: position})
+	case producer.FormatProtobuf:
+		return p.Serialize(&events.Greeting{User: key, Word: word, Position: int32(position)})
+	default:
+		return p.Serialize(word)
 	}
+}
 
-	for _, word := range []string{"Welcome", "to", "the", "Confluent", "Kafka", "Golang", "client"} {
-		p.Produce(&kafka.Message{
-			TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 2},
-			Value:          []byte(word),
-		}, nil)
+var words = []string{"Welcome", "to", "the", "Confluent", "Kafka", "Golang", "client"}
+
+// produceWords produces the words asynchronously for two keys, then one
+// more message synchronously.
+func produceWords(ctx context.Context, p *producer.Producer, cfg producer.Config) {
+	// Messages with the same key end up on the same partition.
+	for _, key := range []string{"user-1", "user-2"} {
+		for i, word := range words {
+			value, err := payload(p, cfg, key, i, word)
+			if err != nil {
+				fmt.Printf("Failed to serialize %s: %v\n", word, err)
+				continue
+			}
+
+			msg := producer.Message{
+				Key:   []byte(key),
+				Value: value,
+			}
+			msg.SetHeader("example", "welcome-words")
+
+			err = p.Produce(msg)
+			var validationErr *producer.ValidationError
+			if errors.As(err, &validationErr) {
+				fmt.Printf("Rejected %s: %v\n", word, err)
+			} else if err != nil {
+				fmt.Printf("Failed to produce %s: %v\n", word, err)
+			}
+		}
 	}
 
-	// Wait for message deliveries before shutting down
-	p.Flush(15 * 1000)
+	// Produce one more message and wait for the broker to confirm it
+	tp, err := p.ProduceSync(ctx, producer.Message{
+		Key:   []byte("user-1"),
+		Value: []byte("confirmed"),
+	})
+	if err != nil {
+		fmt.Printf("Synchronous produce failed: %v\n", err)
+	} else {
+		fmt.Printf("Synchronously produced to partition %d at offset %v\n", tp.Partition, tp.Offset)
+	}
+}
+
+// produceTransaction writes the words for all keys in one transaction,
+// so they appear atomically on several partitions.
+func produceTransaction(ctx context.Context, p *producer.Producer) {
+	var msgs []producer.Message
+	for _, key := range []string{"user-1", "user-2", "user-3"} {
+		for _, word := range words {
+			msgs = append(msgs, producer.Message{
+				Key:   []byte(key),
+				Value: []byte(word),
+			})
+		}
+	}
+
+	if err := p.ProduceTransaction(ctx, msgs); err != nil {
+		fmt.Printf("Transaction failed: %v\n", err)
+		return
+	}
+	fmt.Printf("Committed transaction with %d messages\n", len(msgs))
+}
+
+// produceSequence produces numbered messages with the idempotent producer
+// and reads them back to prove that no duplicates were introduced.
+func produceSequence(ctx context.Context, p *producer.Producer, cfg producer.Config) {
+	const count = 1000
+
+	start, err := p.ProduceSequence(ctx, "sequence", count)
+	if err != nil {
+		fmt.Printf("Producing sequence failed: %v\n", err)
+		return
+	}
+	if remaining := p.Flush(cfg.FlushTimeoutMs); remaining > 0 {
+		fmt.Printf("%d messages of the sequence were not delivered in time\n", remaining)
+	}
+
+	report, err := producer.VerifySequence(cfg, start, count, 30*time.Second)
+	if err != nil {
+		fmt.Printf("Verifying sequence failed: %v\n", err)
+		return
+	}
+	fmt.Printf("Sequence on %v: %v\n", start, report)
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"kate.kafka.example/producer/producer"
+)
+
+// strategy is one way of spreading messages over partitions.
+type strategy struct {
+	name        string
+	partitioner string
+	keyed       bool
+	properties  map[string]string
+}
+
+var strategies = []strategy{
+	{name: "murmur2, 100 keys", partitioner: "murmur2", keyed: true},
+	{name: "fnv1a, 100 keys", partitioner: "fnv1a", keyed: true},
+	// Keyless messages stick to one partition for a batch, then switch.
+	{name: "murmur2_random, keyless, sticky", partitioner: "murmur2_random"},
+	{name: "murmur2_random, keyless, not sticky", partitioner: "murmur2_random",
+		properties: map[string]string{"sticky.partitioning.linger.ms": "0"}},
+	// The hash of a missing key is always the same.
+	{name: "murmur2, keyless", partitioner: "murmur2"},
+}
+
+// comparePartitioners produces the configured count of messages with
+// every strategy and reports how many ended up on each partition.
+func comparePartitioners(ctx context.Context, cfg producer.Config) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STRATEGY\tPARTITIONS\tDISTRIBUTION")
+
+	for _, s := range strategies {
+		if ctx.Err() != nil {
+			break
+		}
+
+		counts, err := distribution(ctx, cfg, s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Strategy %s failed: %v\n", s.name, err)
+			continue
+		}
+
+		partitions := slices.Sorted(maps.Keys(counts))
+		var dist string
+		for _, partition := range partitions {
+			dist += fmt.Sprintf("%d:%d ", partition, counts[partition])
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", s.name, len(partitions), dist)
+	}
+	w.Flush()
+}
+
+// distribution produces with one strategy and counts the delivered
+// messages per partition.
+func distribution(ctx context.Context, cfg producer.Config, s strategy) (map[int32]int, error) {
+	cfg.Partitioner = s.partitioner
+	cfg.Partition = -1
+	cfg.Properties = maps.Clone(cfg.Properties)
+	if cfg.Properties == nil {
+		cfg.Properties = map[string]string{}
+	}
+	maps.Copy(cfg.Properties, s.properties)
+
+	p, err := producer.NewProducer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+
+	var mu sync.Mutex
+	counts := map[int32]int{}
+	p.OnDelivery(func(msg *kafka.Message, err error) {
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		counts[msg.TopicPartition.Partition]++
+		mu.Unlock()
+	})
+
+	for i := 0; i < cfg.Count && ctx.Err() == nil; i++ {
+		msg := producer.Message{Value: []byte(fmt.Sprint(i))}
+		if s.keyed {
+			msg.Key = []byte(fmt.Sprintf("key-%d", i%100))
+		}
+		if err := p.Produce(msg); err != nil {
+			return nil, err
+		}
+	}
+	if remaining := p.Flush(cfg.FlushTimeoutMs); remaining > 0 {
+		return nil, fmt.Errorf("%d messages were not delivered in time", remaining)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	return counts, nil
+}
@@ -0,0 +1,109 @@
+package producer
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry"
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry/serde"
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry/serde/avrov2"
+)
+
+// avroSerializer emits the Confluent wire format: a zero magic byte, the
+// 4 byte schema ID and the Avro binary encoding of the value.
+//
+// A schema file configured for the topic is registered under the subject
+// "<topic>-value", otherwise the latest registered schema is looked up.
+// Unless forced, a schema file must be compatible with the latest version
+// of the subject.
+type avroSerializer struct {
+	client  schemaregistry.Client
+	schemas map[string]string // topic -> schema file
+	force   bool
+
+	mu          sync.Mutex
+	serializers map[string]*avrov2.Serializer
+}
+
+func newAvroSerializer(client schemaregistry.Client, cfg Config) *avroSerializer {
+	schemas := map[string]string{}
+	if cfg.AvroSchemaFile != "" {
+		schemas[cfg.Topic] = cfg.AvroSchemaFile
+	}
+	for topic, file := range cfg.TopicAvroSchemas {
+		schemas[topic] = file
+	}
+
+	return &avroSerializer{
+		client:      client,
+		schemas:     schemas,
+		force:       cfg.ForceSchema,
+		serializers: map[string]*avrov2.Serializer{},
+	}
+}
+
+// Serialize encodes value, a pointer to a struct with avro tags or to a
+// map[string]any matching the schema.
+func (s *avroSerializer) Serialize(topic string, value any) ([]byte, error) {
+	ser, err := s.serializer(topic)
+	if err != nil {
+		return nil, err
+	}
+	return ser.Serialize(topic, value)
+}
+
+func (s *avroSerializer) serializer(topic string) (*avrov2.Serializer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ser, ok := s.serializers[topic]; ok {
+		return ser, nil
+	}
+
+	conf := avrov2.NewSerializerConfig()
+	conf.AutoRegisterSchemas = false
+
+	if file, ok := s.schemas[topic]; ok {
+		id, err := s.register(topic, file)
+		if err != nil {
+			return nil, err
+		}
+		conf.UseSchemaID = id
+	} else {
+		conf.UseLatestVersion = true
+	}
+
+	ser, err := avrov2.NewSerializer(s.client, serde.ValueSerde, conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create avro serializer for %s: %v", topic, err)
+	}
+	s.serializers[topic] = ser
+	return ser, nil
+}
+
+// register registers the schema, returning the existing ID if the
+// registry already knows it.
+func (s *avroSerializer) register(topic, file string) (int, error) {
+	schema, err := os.ReadFile(file)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read avro schema: %v", err)
+	}
+
+	subject := topic + "-value"
+	info := schemaregistry.SchemaInfo{
+		Schema:     string(schema),
+		SchemaType: "AVRO",
+	}
+	if !s.force {
+		if err := checkCompatibility(s.client, subject, info); err != nil {
+			return 0, err
+		}
+	}
+
+	id, err := s.client.Register(subject, info, false)
+	if err != nil {
+		return 0, fmt.Errorf("failed to register avro schema for %s: %v", topic, err)
+	}
+	return id, nil
+}
@@ -0,0 +1,135 @@
+package producer
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// What happens to a message when the local queue and the buffer are full,
+// see Config.BufferPolicy.
+const (
+	// BufferBlock makes Produce wait until the buffer has room.
+	BufferBlock = "block"
+	// BufferDropOldest discards the oldest buffered message, which is
+	// reported as failed.
+	BufferDropOldest = "drop-oldest"
+	// BufferError makes Produce return ErrBufferFull.
+	BufferError = "error"
+)
+
+// ErrBufferFull is returned by Produce with BufferError when the local
+// queue and the buffer are full.
+var ErrBufferFull = errors.New("producer buffer is full")
+
+// errDropped is the failure of messages discarded by BufferDropOldest.
+var errDropped = errors.New("dropped from full producer buffer")
+
+// errClosedWaiting is returned by add if the buffer was closed while it
+// waited for room, the message counts as produced and failed.
+var errClosedWaiting = errors.New("producer buffer closed while waiting")
+
+// sendBuffer holds messages librdkafka had no room for, in order, until
+// they are handed over by Producer.drain.
+type sendBuffer struct {
+	size   int
+	policy string
+
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	msgs     []*kafka.Message
+	inflight int
+	closed   bool
+}
+
+func newSendBuffer(size int, policy string) *sendBuffer {
+	b := &sendBuffer{size: size, policy: policy}
+	b.notEmpty = sync.NewCond(&b.mu)
+	b.notFull = sync.NewCond(&b.mu)
+	return b
+}
+
+// add appends km according to the policy. It returns the messages
+// dropped to make room for it.
+func (b *sendBuffer) add(km *kafka.Message) ([]*kafka.Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrShuttingDown
+	}
+
+	var dropped []*kafka.Message
+	for len(b.msgs) >= b.size && !b.closed {
+		switch b.policy {
+		case BufferDropOldest:
+			dropped = append(dropped, b.msgs[0])
+			b.msgs = b.msgs[1:]
+		case BufferError:
+			return nil, ErrBufferFull
+		default:
+			b.notFull.Wait()
+		}
+	}
+	if b.closed {
+		return dropped, errClosedWaiting
+	}
+
+	b.msgs = append(b.msgs, km)
+	b.notEmpty.Signal()
+	return dropped, nil
+}
+
+// next removes the oldest message, waiting for one. It returns false
+// once the buffer is closed. Every message has to be confirmed by done.
+func (b *sendBuffer) next() (*kafka.Message, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for len(b.msgs) == 0 && !b.closed {
+		b.notEmpty.Wait()
+	}
+	if b.closed {
+		return nil, false
+	}
+
+	km := b.msgs[0]
+	b.msgs = b.msgs[1:]
+	b.inflight++
+	b.notFull.Signal()
+	return km, true
+}
+
+// done marks a message returned by next as handed over.
+func (b *sendBuffer) done() {
+	b.mu.Lock()
+	b.inflight--
+	b.mu.Unlock()
+}
+
+// len returns the number of messages not yet handed to librdkafka.
+func (b *sendBuffer) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.msgs) + b.inflight
+}
+
+// discard removes and returns the buffered messages.
+func (b *sendBuffer) discard() []*kafka.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	msgs := b.msgs
+	b.msgs = nil
+	return msgs
+}
+
+// close wakes up all waiters, the messages left have to be discarded.
+func (b *sendBuffer) close() {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	b.notEmpty.Broadcast()
+	b.notFull.Broadcast()
+}
@@ -0,0 +1,40 @@
+package producer
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry"
+)
+
+// IncompatibleSchemaError is returned when a schema would break the
+// consumers of a subject under its compatibility level.
+type IncompatibleSchemaError struct {
+	Subject string
+}
+
+func (e *IncompatibleSchemaError) Error() string {
+	return fmt.Sprintf("schema is not compatible with the latest version of %s, pass -force-schema to produce anyway", e.Subject)
+}
+
+// checkCompatibility asks the registry whether info may be registered
+// under subject. A subject without versions accepts any schema.
+func checkCompatibility(client schemaregistry.Client, subject string, info schemaregistry.SchemaInfo) error {
+	subjects, err := client.GetAllSubjects()
+	if err != nil {
+		return fmt.Errorf("failed to list subjects: %v", err)
+	}
+	if !slices.Contains(subjects, subject) {
+		return nil
+	}
+
+	// -1 is the latest version.
+	compatible, err := client.TestCompatibility(subject, -1, info)
+	if err != nil {
+		return fmt.Errorf("failed to test compatibility with %s: %v", subject, err)
+	}
+	if !compatible {
+		return &IncompatibleSchemaError{Subject: subject}
+	}
+	return nil
+}
@@ -0,0 +1,506 @@
+package producer
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"kate.config"
+)
+
+// Config describes the cluster, the topic and the producer tunables.
+//
+// Values are resolved in the following order, later sources winning:
+// built-in defaults, the optional YAML file (-config), environment
+// variables (KAFKA_<FLAG_NAME>) and finally command line flags.
+type Config struct {
+	// The cluster and its security settings.
+	config.KafkaConfig `yaml:",inline"`
+
+	// What the producer program does, see the Mode constants.
+	Mode string `yaml:"mode"`
+
+	// Input of ModeLines: a file, or "-" for stdin. Every line is a
+	// message, with ParseKey the text before the first tab is its key.
+	Input    string            `yaml:"input"`
+	ParseKey bool              `yaml:"parse_key"`
+	Key      string            `yaml:"key"`
+	Headers  map[string]string `yaml:"headers"`
+
+	// ModeLoad produces Count synthetic messages of PayloadSize bytes at
+	// Rate messages per second, or stops after Duration if that is earlier.
+	// Zero Rate means as fast as possible, zero Count or Duration no limit.
+	Rate        int           `yaml:"rate"`
+	Count       int           `yaml:"count"`
+	PayloadSize int           `yaml:"payload_size"`
+	Duration    time.Duration `yaml:"duration"`
+
+	// ModeHTTP serves a REST proxy on HTTPAddr.
+	HTTPAddr string `yaml:"http_addr"`
+
+	// Topic settings
+	Topic             string `yaml:"topic"`
+	NumPartitions     int    `yaml:"num_partitions"`
+	ReplicationFactor int    `yaml:"replication_factor"`
+	RetentionMs       int64  `yaml:"retention_ms"`
+	// Routes send messages to other topics than Topic based on their
+	// headers or payload, see Router. They can only be set in the YAML file.
+	Routes []Route `yaml:"routes"`
+	// AutoCreate creates a missing topic with the settings above instead
+	// of failing, see Producer.EnsureTopic.
+	AutoCreate bool `yaml:"auto_create"`
+
+	// Partitioning: a librdkafka partitioner working on the message key,
+	// or "explicit" to always write to Partition. A non-negative
+	// Partition always wins over the partitioner.
+	Partitioner string `yaml:"partitioner"`
+	Partition   int    `yaml:"partition"`
+
+	// Producer tunables
+	Profile          string `yaml:"profile"`
+	Acks             string `yaml:"acks"`
+	Retries          int    `yaml:"retries"`
+	MessageTimeoutMs int    `yaml:"message_timeout_ms"`
+	FlushTimeoutMs   int    `yaml:"flush_timeout_ms"`
+
+	// Compression and batching
+	CompressionType  string `yaml:"compression_type"`
+	LingerMs         int    `yaml:"linger_ms"`
+	BatchNumMessages int    `yaml:"batch_num_messages"`
+	MessageMaxBytes  int    `yaml:"message_max_bytes"`
+
+	// TransactionalID enables transactions, see Producer.ProduceTransaction.
+	TransactionalID string `yaml:"transactional_id"`
+
+	// Delivery handling on top of librdkafka's own retries
+	DeliveryRetries   int    `yaml:"delivery_retries"`
+	RetryBackoffMs    int    `yaml:"retry_backoff_ms"`
+	MaxRetryBackoffMs int    `yaml:"max_retry_backoff_ms"`
+	FailureLogPath    string `yaml:"failure_log_path"`
+	// Messages the full producer queue has no room for wait in a buffer
+	// of BufferSize messages. BufferPolicy decides what happens when it is
+	// full as well, see the Buffer constants.
+	BufferSize   int    `yaml:"buffer_size"`
+	BufferPolicy string `yaml:"buffer_policy"`
+	// DeadLetter publishes permanently failed messages to the topic
+	// with DeadLetterSuffix as well, see DLQProducer.
+	DeadLetter bool `yaml:"dead_letter"`
+
+	// Payload format and schema registry settings
+	Format                 string            `yaml:"format"`
+	SchemaRegistryURL      string            `yaml:"schema_registry_url"`
+	SchemaRegistryUsername string            `yaml:"schema_registry_username"`
+	SchemaRegistryPassword string            `yaml:"schema_registry_password"`
+	AvroSchemaFile         string            `yaml:"avro_schema_file"`
+	TopicAvroSchemas       map[string]string `yaml:"topic_avro_schemas"`
+	// ForceSchema registers schema files even if the registry considers
+	// them incompatible with the latest version of the subject.
+	ForceSchema bool `yaml:"force_schema"`
+
+	// JSON payloads are validated against a schema from a file or the
+	// latest version of a schema registry subject.
+	JSONSchemaFile    string `yaml:"json_schema_file"`
+	JSONSchemaSubject string `yaml:"json_schema_subject"`
+
+	// StatsIntervalMs enables librdkafka statistics, exported as
+	// Prometheus metrics on MetricsAddr.
+	StatsIntervalMs int    `yaml:"stats_interval_ms"`
+	MetricsAddr     string `yaml:"metrics_addr"`
+
+	// LogDeliveries prints every delivery report, see LogMiddleware.
+	LogDeliveries bool `yaml:"log_deliveries"`
+
+	// OTLPEndpoint enables tracing: every message gets a producer span,
+	// exported via OTLP over HTTP, and a traceparent header.
+	OTLPEndpoint string `yaml:"otlp_endpoint"`
+
+	// Values of the standard content-type and source headers
+	ContentType string `yaml:"content_type"`
+	Source      string `yaml:"source"`
+
+	// Properties are passed to librdkafka as is, e.g. "client.id". They
+	// must not override the settings of the exactly-once profile or of
+	// transactions.
+	Properties map[string]string `yaml:"properties"`
+}
+
+// guaranteeKeys are the librdkafka settings the exactly-once guarantees
+// of idempotence and transactions rely on.
+var guaranteeKeys = []string{"acks", "enable.idempotence", "max.in.flight.requests.per.connection", "retries", "transactional.id"}
+
+// PartitionerExplicit disables key based partitioning in favour of Config.Partition.
+const PartitionerExplicit = "explicit"
+
+// keyPartitioners are the librdkafka partitioners, see the "partitioner" property.
+var keyPartitioners = map[string]bool{
+	"random":            true,
+	"consistent":        true,
+	"consistent_random": true,
+	"murmur2":           true,
+	"murmur2_random":    true,
+	"fnv1a":             true,
+	"fnv1a_random":      true,
+}
+
+// Modes of the producer program.
+const (
+	// ModeDemo produces the example messages.
+	ModeDemo = "demo"
+	// ModeLines produces every line of Input as a message.
+	ModeLines = "lines"
+	// ModeLoad generates synthetic load and reports throughput and latency.
+	ModeLoad = "load"
+	// ModeHTTP produces messages posted to POST /topics/{topic}.
+	ModeHTTP = "http"
+	// ModePartitions compares how partitioning strategies spread Count
+	// messages over the partitions.
+	ModePartitions = "partitions"
+)
+
+// Profiles select a consistent set of delivery guarantees.
+const (
+	// ProfileDefault uses the tunables as configured.
+	ProfileDefault = "default"
+	// ProfileExactlyOnce enables the idempotent producer: retries can
+	// neither duplicate nor reorder messages within a partition.
+	ProfileExactlyOnce = "exactly-once"
+)
+
+// DefaultConfig returns the settings the example used to hard-code.
+func DefaultConfig() Config {
+	return Config{
+		KafkaConfig:       config.DefaultKafkaConfig(),
+		Mode:              ModeDemo,
+		Input:             "-",
+		Rate:              1000,
+		Count:             10000,
+		PayloadSize:       100,
+		HTTPAddr:          ":8088",
+		Topic:             "myTopic2",
+		NumPartitions:     6,
+		ReplicationFactor: 1,
+		RetentionMs:       604800000, // 7 days
+		Partitioner:       "murmur2_random",
+		Partition:         -1,
+		Profile:           ProfileDefault,
+		Acks:              "all",
+		Retries:           2147483647,
+		MessageTimeoutMs:  300000,
+		FlushTimeoutMs:    15000,
+		CompressionType:   "none",
+		LingerMs:          5,
+		BatchNumMessages:  10000,
+		MessageMaxBytes:   1000000,
+		DeliveryRetries:   3,
+		RetryBackoffMs:    100,
+		MaxRetryBackoffMs: 5000,
+		BufferSize:        10000,
+		BufferPolicy:      BufferBlock,
+		Format:            FormatRaw,
+		StatsIntervalMs:   5000,
+		ContentType:       "text/plain",
+		Source:            "go-examples-producer",
+	}
+}
+
+// LoadConfig resolves the configuration from defaults, YAML file,
+// environment and the given command line arguments.
+func LoadConfig(name string, args []string) (Config, error) {
+	cfg, _, err := LoadConfigArgs(name, args)
+	return cfg, err
+}
+
+// LoadConfigArgs is LoadConfig for programs taking positional arguments
+// after the flags, it returns them as well.
+func LoadConfigArgs(name string, args []string) (Config, []string, error) {
+	cfg, args, err := config.Load(name, "KAFKA", args, DefaultConfig, (*Config).RegisterFlags)
+	if err != nil {
+		return cfg, nil, err
+	}
+	return cfg, args, cfg.Validate()
+}
+
+// EnvName returns the environment variable consulted for a flag.
+func EnvName(flagName string) string {
+	return config.EnvName("KAFKA", flagName)
+}
+
+// RegisterFlags binds the config fields to flags of fs.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	c.KafkaConfig.RegisterFlags(fs)
+
+	fs.StringVar(&c.Mode, "mode", c.Mode, "demo, lines, load, http or partitions")
+	fs.StringVar(&c.Input, "input", c.Input, "file to read messages from in lines mode, - for stdin")
+	fs.BoolVar(&c.ParseKey, "parse-key", c.ParseKey, "split every line at the first tab into key and value")
+	fs.StringVar(&c.Key, "key", c.Key, "key of every message without a parsed key")
+	fs.Var((*headersFlag)(&c.Headers), "header", "header key=value added to every message, repeatable")
+	fs.IntVar(&c.Rate, "rate", c.Rate, "messages per second in load mode, 0 for unlimited")
+	fs.IntVar(&c.Count, "count", c.Count, "messages to produce in load mode, 0 for unlimited, or per strategy in partitions mode")
+	fs.IntVar(&c.PayloadSize, "payload-size", c.PayloadSize, "bytes per message in load mode")
+	fs.DurationVar(&c.Duration, "duration", c.Duration, "how long to produce in load mode, 0 for unlimited")
+	fs.StringVar(&c.HTTPAddr, "http-addr", c.HTTPAddr, "address of the REST proxy in http mode")
+
+	fs.StringVar(&c.Topic, "topic", c.Topic, "topic to produce to")
+	fs.IntVar(&c.NumPartitions, "num-partitions", c.NumPartitions, "partitions of the topic when it is created")
+	fs.IntVar(&c.ReplicationFactor, "replication-factor", c.ReplicationFactor, "replication factor of the topic when it is created")
+	fs.Int64Var(&c.RetentionMs, "retention-ms", c.RetentionMs, "retention.ms of the topic when it is created")
+	fs.BoolVar(&c.AutoCreate, "auto-create", c.AutoCreate, "create the topic if it does not exist instead of failing")
+
+	fs.StringVar(&c.Partitioner, "partitioner", c.Partitioner, "murmur2, murmur2_random, consistent, consistent_random, fnv1a, random or explicit")
+	fs.IntVar(&c.Partition, "partition", c.Partition, "partition to write to, overrides the partitioner when not negative")
+
+	fs.StringVar(&c.Profile, "profile", c.Profile, "delivery profile: default or exactly-once")
+	fs.StringVar(&c.Acks, "acks", c.Acks, "required acks: 0, 1 or all")
+	fs.IntVar(&c.Retries, "retries", c.Retries, "how many times to retry sending a failing message")
+	fs.IntVar(&c.MessageTimeoutMs, "message-timeout-ms", c.MessageTimeoutMs, "local delivery timeout of a message")
+	fs.IntVar(&c.FlushTimeoutMs, "flush-timeout-ms", c.FlushTimeoutMs, "how long to wait for outstanding deliveries on exit")
+
+	fs.StringVar(&c.CompressionType, "compression-type", c.CompressionType, "none, gzip, snappy, lz4 or zstd")
+	fs.IntVar(&c.LingerMs, "linger-ms", c.LingerMs, "how long to wait for more messages before sending a batch")
+	fs.IntVar(&c.BatchNumMessages, "batch-num-messages", c.BatchNumMessages, "maximum number of messages in a batch")
+	fs.IntVar(&c.MessageMaxBytes, "message-max-bytes", c.MessageMaxBytes, "maximum size of a request, and so of a single message")
+
+	fs.StringVar(&c.TransactionalID, "transactional-id", c.TransactionalID, "enables transactions, must be stable across restarts of the same producer")
+
+	fs.IntVar(&c.DeliveryRetries, "delivery-retries", c.DeliveryRetries, "how many times a message failing with a retriable error is produced again")
+	fs.IntVar(&c.RetryBackoffMs, "retry-backoff-ms", c.RetryBackoffMs, "initial backoff before producing a failed message again")
+	fs.IntVar(&c.MaxRetryBackoffMs, "max-retry-backoff-ms", c.MaxRetryBackoffMs, "upper bound of the exponential retry backoff")
+	fs.StringVar(&c.FailureLogPath, "failure-log", c.FailureLogPath, "file to append permanently failed messages to, stderr if empty")
+	fs.IntVar(&c.BufferSize, "buffer-size", c.BufferSize, "messages buffered while the producer queue is full")
+	fs.StringVar(&c.BufferPolicy, "buffer-policy", c.BufferPolicy, "when the buffer is full: block, drop-oldest or error")
+	fs.BoolVar(&c.DeadLetter, "dead-letter", c.DeadLetter, "publish permanently failed messages to <topic>.DLT")
+
+	fs.StringVar(&c.Format, "format", c.Format, "payload format: raw, json, avro or protobuf")
+	fs.StringVar(&c.SchemaRegistryURL, "schema-registry-url", c.SchemaRegistryURL, "schema registry, required by the avro and protobuf formats")
+	fs.StringVar(&c.SchemaRegistryUsername, "schema-registry-username", c.SchemaRegistryUsername, "schema registry basic auth user")
+	fs.StringVar(&c.SchemaRegistryPassword, "schema-registry-password", c.SchemaRegistryPassword, "schema registry basic auth password")
+	fs.StringVar(&c.AvroSchemaFile, "avro-schema", c.AvroSchemaFile, "avro schema file registered for the topic, the latest registered schema is used if empty")
+	fs.BoolVar(&c.ForceSchema, "force-schema", c.ForceSchema, "register the avro schema even if it is incompatible with the latest version")
+
+	fs.StringVar(&c.JSONSchemaFile, "json-schema", c.JSONSchemaFile, "validate payloads against this JSON Schema file before producing")
+	fs.StringVar(&c.JSONSchemaSubject, "json-schema-subject", c.JSONSchemaSubject, "validate payloads against the latest JSON Schema of this registry subject")
+
+	fs.IntVar(&c.StatsIntervalMs, "stats-interval-ms", c.StatsIntervalMs, "how often librdkafka emits statistics, 0 disables them")
+	fs.StringVar(&c.MetricsAddr, "metrics-addr", c.MetricsAddr, "serve Prometheus metrics on this address, e.g. :9101")
+	fs.BoolVar(&c.LogDeliveries, "log-deliveries", c.LogDeliveries, "print every delivered or failed message")
+	fs.StringVar(&c.OTLPEndpoint, "otlp-endpoint", c.OTLPEndpoint, "export a span per message via OTLP/HTTP to this host:port, e.g. localhost:4318")
+
+	fs.StringVar(&c.ContentType, "content-type", c.ContentType, "value of the content-type header")
+	fs.StringVar(&c.Source, "source", c.Source, "value of the source header")
+}
+
+// headersFlag collects repeated -header key=value flags. Several headers
+// can be given comma separated, which is also how they are printed.
+type headersFlag map[string]string
+
+func (h *headersFlag) String() string {
+	if h == nil || *h == nil {
+		return ""
+	}
+	keys := make([]string, 0, len(*h))
+	for k := range *h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+(*h)[k])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (h *headersFlag) Set(value string) error {
+	if *h == nil {
+		*h = map[string]string{}
+	}
+	for _, pair := range strings.Split(value, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || k == "" {
+			return fmt.Errorf("header must be key=value, got %q", pair)
+		}
+		(*h)[k] = v
+	}
+	return nil
+}
+
+// Validate checks the settings which would otherwise fail deep inside librdkafka.
+func (c *Config) Validate() error {
+	if err := c.KafkaConfig.Validate(); err != nil {
+		return err
+	}
+	if c.Topic == "" {
+		return fmt.Errorf("topic is required")
+	}
+	if c.NumPartitions < 1 {
+		return fmt.Errorf("num partitions must be positive, got %d", c.NumPartitions)
+	}
+	if c.ReplicationFactor < 1 {
+		return fmt.Errorf("replication factor must be positive, got %d", c.ReplicationFactor)
+	}
+	if (c.Format == FormatAvro || c.Format == FormatProtobuf) && c.SchemaRegistryURL == "" {
+		return fmt.Errorf("format %s requires a schema registry url", c.Format)
+	}
+	switch c.CompressionType {
+	case "none", "gzip", "snappy", "lz4", "zstd":
+	default:
+		return fmt.Errorf("unknown compression type %q", c.CompressionType)
+	}
+	if c.LingerMs < 0 || c.BatchNumMessages < 1 || c.MessageMaxBytes < 1000 {
+		return fmt.Errorf("linger must not be negative, batches need a message and message max bytes at least 1000")
+	}
+	switch c.Mode {
+	case ModeDemo, ModeLines:
+	case ModeLoad:
+		if c.Count == 0 && c.Duration == 0 {
+			return fmt.Errorf("load mode needs a count or a duration")
+		}
+		if c.Rate < 0 || c.Count < 0 || c.PayloadSize < 0 || c.Duration < 0 {
+			return fmt.Errorf("rate, count, payload size and duration must not be negative")
+		}
+	case ModePartitions:
+		if c.Count < 1 {
+			return fmt.Errorf("partitions mode needs a count")
+		}
+	case ModeHTTP:
+		if c.HTTPAddr == "" {
+			return fmt.Errorf("http mode needs an http address")
+		}
+	default:
+		return fmt.Errorf("unknown mode %q", c.Mode)
+	}
+	switch c.BufferPolicy {
+	case BufferBlock, BufferDropOldest, BufferError:
+	default:
+		return fmt.Errorf("unknown buffer policy %q", c.BufferPolicy)
+	}
+	if c.BufferSize < 1 {
+		return fmt.Errorf("buffer size must be at least 1")
+	}
+	for _, route := range c.Routes {
+		if err := route.validate(); err != nil {
+			return err
+		}
+	}
+	if c.JSONSchemaFile != "" && c.JSONSchemaSubject != "" {
+		return fmt.Errorf("json schema file and subject are mutually exclusive")
+	}
+	if c.JSONSchemaSubject != "" && c.SchemaRegistryURL == "" {
+		return fmt.Errorf("json schema subject requires a schema registry url")
+	}
+	switch c.Profile {
+	case ProfileDefault:
+	case ProfileExactlyOnce:
+		if c.Acks != "all" && c.Acks != "-1" {
+			return fmt.Errorf("profile %s requires acks=all, got %s", c.Profile, c.Acks)
+		}
+	default:
+		return fmt.Errorf("unknown profile %q", c.Profile)
+	}
+	if c.TransactionalID != "" && c.Acks != "all" && c.Acks != "-1" {
+		return fmt.Errorf("transactions require acks=all, got %s", c.Acks)
+	}
+	if c.Profile == ProfileExactlyOnce || c.TransactionalID != "" {
+		for _, k := range guaranteeKeys {
+			if _, ok := c.Properties[k]; ok {
+				return fmt.Errorf("property %s must not be set with profile %s or a transactional id", k, ProfileExactlyOnce)
+			}
+		}
+	}
+	if c.DeliveryRetries < 0 {
+		return fmt.Errorf("delivery retries must not be negative, got %d", c.DeliveryRetries)
+	}
+	if c.RetryBackoffMs <= 0 || c.MaxRetryBackoffMs < c.RetryBackoffMs {
+		return fmt.Errorf("retry backoff must be positive and not above the max retry backoff")
+	}
+	if c.Partitioner == PartitionerExplicit {
+		if c.Partition < 0 || c.Partition >= c.NumPartitions {
+			return fmt.Errorf("explicit partition must be in [0, %d), got %d", c.NumPartitions, c.Partition)
+		}
+	} else if !keyPartitioners[c.Partitioner] {
+		return fmt.Errorf("unknown partitioner %q", c.Partitioner)
+	}
+	return nil
+}
+
+// ClientConfigMap returns the connection and security settings shared by
+// the producer and the admin client.
+func (c *Config) ClientConfigMap() *kafka.ConfigMap {
+	cm := &kafka.ConfigMap{}
+	for k, v := range c.KafkaConfig.ClientProperties() {
+		cm.SetKey(k, v)
+	}
+	return cm
+}
+
+// ProducerConfigMap returns the full producer configuration.
+func (c *Config) ProducerConfigMap() *kafka.ConfigMap {
+	cm := c.ClientConfigMap()
+	cm.SetKey("acks", c.Acks)
+	cm.SetKey("retries", c.Retries)
+	cm.SetKey("message.timeout.ms", c.MessageTimeoutMs)
+	cm.SetKey("compression.type", c.CompressionType)
+	cm.SetKey("linger.ms", c.LingerMs)
+	cm.SetKey("batch.num.messages", c.BatchNumMessages)
+	cm.SetKey("message.max.bytes", c.MessageMaxBytes)
+	if c.StatsIntervalMs > 0 {
+		cm.SetKey("statistics.interval.ms", c.StatsIntervalMs)
+	}
+	if c.Partitioner != PartitionerExplicit {
+		cm.SetKey("partitioner", c.Partitioner)
+	}
+	if c.Profile == ProfileExactlyOnce {
+		// librdkafka keeps ordering with up to 5 requests in flight
+		// when idempotence is enabled, and retries until message.timeout.ms.
+		cm.SetKey("enable.idempotence", true)
+		cm.SetKey("max.in.flight.requests.per.connection", 5)
+		cm.SetKey("retries", 2147483647)
+	}
+	if c.TransactionalID != "" {
+		cm.SetKey("transactional.id", c.TransactionalID)
+	}
+	for k, v := range c.Properties {
+		cm.SetKey(k, v)
+	}
+	return cm
+}
+
+// secretKeys are masked by PrintConfigMap.
+var secretKeys = map[string]bool{
+	"sasl.password":    true,
+	"ssl.key.password": true,
+}
+
+// PrintConfigMap writes the settings sorted by key, one per line.
+func PrintConfigMap(w io.Writer, cm *kafka.ConfigMap) {
+	keys := make([]string, 0, len(*cm))
+	for k := range *cm {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := (*cm)[k]
+		if secretKeys[k] {
+			v = "********"
+		}
+		fmt.Fprintf(w, "  %s = %v\n", k, v)
+	}
+}
+
+// TopicSpecification describes the topic for the admin client.
+func (c *Config) TopicSpecification() kafka.TopicSpecification {
+	return kafka.TopicSpecification{
+		Topic:             c.Topic,
+		NumPartitions:     c.NumPartitions,
+		ReplicationFactor: c.ReplicationFactor,
+		Config: map[string]string{
+			"retention.ms": strconv.FormatInt(c.RetentionMs, 10),
+		},
+	}
+}
@@ -0,0 +1,239 @@
+package producer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// DeliveryStats counts the outcome of produced messages.
+type DeliveryStats struct {
+	Delivered int64
+	Failed    int64
+	Retried   int64
+}
+
+// DeliveryFunc is called once for every message sent through Produce,
+// when it was delivered (err is nil) or failed for good.
+type DeliveryFunc func(msg *kafka.Message, err error)
+
+// attempt is stored as the Opaque of every produced message.
+type attempt struct {
+	count int
+}
+
+// failureRecord is one line of the failure log.
+type failureRecord struct {
+	Topic     string            `json:"topic"`
+	Partition int32             `json:"partition"`
+	Key       string            `json:"key"`
+	Value     string            `json:"value"`
+	Headers   map[string]string `json:"headers"`
+	Error     string            `json:"error"`
+	Attempts  int               `json:"attempts"`
+	FailedAt  time.Time         `json:"failed_at"`
+}
+
+// DeliveryManager consumes delivery reports, re-produces messages that
+// failed with a retriable error and records permanent failures.
+type DeliveryManager struct {
+	producer *kafka.Producer
+	policy   RetryPolicy
+
+	mu         sync.Mutex
+	failureLog io.Writer
+	closed     bool
+	onDelivery DeliveryFunc
+	onStats    func(statsJSON string)
+
+	pendingRetries sync.WaitGroup
+	retrying       atomic.Int64
+
+	delivered atomic.Int64
+	failed    atomic.Int64
+	retried   atomic.Int64
+
+	done chan struct{}
+}
+
+func NewDeliveryManager(producer *kafka.Producer, cfg Config, failureLog io.Writer) *DeliveryManager {
+	dm := &DeliveryManager{
+		producer:   producer,
+		policy:     NewRetryPolicy(cfg),
+		failureLog: failureLog,
+		done:       make(chan struct{}),
+	}
+
+	// A message produced again would end up outside of its transaction,
+	// failed transactions are aborted by ProduceTransaction instead.
+	// For the idempotent producer it would be a new message, so a
+	// duplicate if the first attempt was written after all.
+	if cfg.TransactionalID != "" || cfg.Profile == ProfileExactlyOnce {
+		dm.policy.MaxRetries = 0
+	}
+
+	go dm.run()
+
+	return dm
+}
+
+func (dm *DeliveryManager) run() {
+	defer close(dm.done)
+
+	for e := range dm.producer.Events() {
+		switch ev := e.(type) {
+		case *kafka.Message:
+			dm.handleReport(ev)
+		case *kafka.Stats:
+			dm.mu.Lock()
+			onStats := dm.onStats
+			dm.mu.Unlock()
+			if onStats != nil {
+				onStats(ev.String())
+			}
+		case kafka.Error:
+			log.Printf("Producer error: %v", ev)
+		}
+	}
+}
+
+func (dm *DeliveryManager) handleReport(msg *kafka.Message) {
+	err := msg.TopicPartition.Error
+	if err == nil {
+		dm.delivered.Add(1)
+		dm.notify(msg, nil)
+		return
+	}
+
+	a, _ := msg.Opaque.(*attempt)
+	if a == nil {
+		a = &attempt{}
+		msg.Opaque = a
+	}
+	a.count++
+
+	if Classify(err) == ErrorRetriable && a.count <= dm.policy.MaxRetries {
+		dm.scheduleRetry(msg, a.count)
+		return
+	}
+
+	dm.recordFailure(msg, err, a.count)
+}
+
+// scheduleRetry re-produces the message after an exponential backoff.
+func (dm *DeliveryManager) scheduleRetry(msg *kafka.Message, count int) {
+	backoff := dm.policy.Backoff(count)
+
+	dm.retried.Add(1)
+	dm.retrying.Add(1)
+	dm.pendingRetries.Add(1)
+	time.AfterFunc(backoff, func() {
+		defer dm.pendingRetries.Done()
+		defer dm.retrying.Add(-1)
+
+		dm.mu.Lock()
+		closed := dm.closed
+		dm.mu.Unlock()
+		if closed {
+			dm.recordFailure(msg, fmt.Errorf("producer closed before retry"), count)
+			return
+		}
+
+		msg.TopicPartition.Error = nil
+		if err := dm.producer.Produce(msg, nil); err != nil {
+			dm.recordFailure(msg, err, count)
+		}
+	})
+}
+
+// OnDelivery registers fn to be called for every final delivery report.
+func (dm *DeliveryManager) OnDelivery(fn DeliveryFunc) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.onDelivery = fn
+}
+
+// OnStats registers fn to be called with the statistics JSON of librdkafka.
+func (dm *DeliveryManager) OnStats(fn func(statsJSON string)) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.onStats = fn
+}
+
+func (dm *DeliveryManager) notify(msg *kafka.Message, err error) {
+	dm.mu.Lock()
+	fn := dm.onDelivery
+	dm.mu.Unlock()
+
+	if fn != nil {
+		fn(msg, err)
+	}
+}
+
+func (dm *DeliveryManager) recordFailure(msg *kafka.Message, err error, attempts int) {
+	dm.failed.Add(1)
+	defer dm.notify(msg, err)
+
+	record := failureRecord{
+		Partition: msg.TopicPartition.Partition,
+		Key:       string(msg.Key),
+		Value:     string(msg.Value),
+		Headers:   map[string]string{},
+		Error:     err.Error(),
+		Attempts:  attempts,
+		FailedAt:  time.Now().UTC(),
+	}
+	if msg.TopicPartition.Topic != nil {
+		record.Topic = *msg.TopicPartition.Topic
+	}
+	for _, h := range msg.Headers {
+		record.Headers[h.Key] = string(h.Value)
+	}
+
+	line, jsonErr := json.Marshal(record)
+	if jsonErr != nil {
+		log.Printf("Failed to encode failure record: %v", jsonErr)
+		return
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	if _, err := dm.failureLog.Write(append(line, '\n')); err != nil {
+		log.Printf("Failed to write failure record: %v", err)
+	}
+}
+
+// Stats returns the delivery counters.
+func (dm *DeliveryManager) Stats() DeliveryStats {
+	return DeliveryStats{
+		Delivered: dm.delivered.Load(),
+		Failed:    dm.failed.Load(),
+		Retried:   dm.retried.Load(),
+	}
+}
+
+// PendingRetries returns how many messages wait for their next attempt.
+func (dm *DeliveryManager) PendingRetries() int {
+	return int(dm.retrying.Load())
+}
+
+// Close makes outstanding retries fail instead of producing again.
+// It must be called before the underlying producer is closed.
+func (dm *DeliveryManager) Close() {
+	dm.mu.Lock()
+	dm.closed = true
+	dm.mu.Unlock()
+
+	dm.pendingRetries.Wait()
+}
+
+// Wait blocks until the events channel of the producer is closed.
+func (dm *DeliveryManager) Wait() {
+	<-dm.done
+}
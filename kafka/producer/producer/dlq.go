@@ -0,0 +1,91 @@
+package producer
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// DeadLetterSuffix is appended to a topic to name its dead-letter topic.
+const DeadLetterSuffix = ".DLT"
+
+// Headers of a dead-letter message, describing why and where it failed.
+const (
+	HeaderDLTOriginalTopic     = "dlt-original-topic"
+	HeaderDLTOriginalPartition = "dlt-original-partition"
+	HeaderDLTOriginalOffset    = "dlt-original-offset"
+	HeaderDLTError             = "dlt-error"
+	HeaderDLTErrorClass        = "dlt-error-class"
+	HeaderDLTAttempts          = "dlt-attempts"
+	HeaderDLTFailedAt          = "dlt-failed-at"
+)
+
+// DLQProducer publishes messages which could not be produced or
+// processed to the dead-letter topic of their original topic. The
+// dead-letter message keeps key, value and headers of the original and
+// adds the dlt-* headers.
+type DLQProducer struct {
+	p *Producer
+}
+
+// NewDLQProducer returns a DLQProducer publishing through p.
+func NewDLQProducer(p *Producer) *DLQProducer {
+	return &DLQProducer{p: p}
+}
+
+// Send publishes msg, which failed with cause after the given number of
+// attempts, to the dead-letter topic. Consumers use it for messages they
+// failed to process.
+func (d *DLQProducer) Send(msg *kafka.Message, cause error, attempts int) error {
+	var topic string
+	if msg.TopicPartition.Topic != nil {
+		topic = *msg.TopicPartition.Topic
+	}
+	if strings.HasSuffix(topic, DeadLetterSuffix) {
+		return fmt.Errorf("message of dead-letter topic %s is not dead-lettered again: %v", topic, cause)
+	}
+
+	dead := Message{
+		Topic:   topic + DeadLetterSuffix,
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: append([]kafka.Header(nil), msg.Headers...),
+	}
+	dead.SetHeader(HeaderDLTOriginalTopic, topic)
+	dead.SetHeader(HeaderDLTOriginalPartition, strconv.Itoa(int(msg.TopicPartition.Partition)))
+	if msg.TopicPartition.Offset >= 0 {
+		dead.SetHeader(HeaderDLTOriginalOffset, strconv.FormatInt(int64(msg.TopicPartition.Offset), 10))
+	}
+	dead.SetHeader(HeaderDLTError, cause.Error())
+	dead.SetHeader(HeaderDLTErrorClass, Classify(cause).String())
+	dead.SetHeader(HeaderDLTAttempts, strconv.Itoa(attempts))
+	dead.SetHeader(HeaderDLTFailedAt, time.Now().UTC().Format(time.RFC3339Nano))
+
+	return d.p.Produce(dead)
+}
+
+// Middleware returns a Middleware dead-lettering every message whose
+// delivery failed for good.
+func (d *DLQProducer) Middleware() Middleware {
+	return MiddlewareFuncs{After: func(msg *kafka.Message, err error) {
+		if err == nil {
+			return
+		}
+
+		attempts := 1
+		if a, ok := msg.Opaque.(*attempt); ok && a.count > 0 {
+			attempts = a.count
+		}
+		// Produce may block on a full queue, which must not stall the
+		// delivery goroutine.
+		go func() {
+			if err := d.Send(msg, err, attempts); err != nil {
+				log.Printf("Failed to dead-letter message: %v", err)
+			}
+		}()
+	}}
+}
@@ -0,0 +1,99 @@
+package producer
+
+import (
+	"errors"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// ErrorClass tells how a produce or delivery error has to be handled.
+type ErrorClass int
+
+const (
+	// ErrorFatal errors won't go away by trying again, e.g. a message
+	// which is too large or missing authorization. They are returned to
+	// the caller immediately.
+	ErrorFatal ErrorClass = iota
+	// ErrorRetriable errors are transient, e.g. a full local queue or a
+	// broker which is temporarily unreachable.
+	ErrorRetriable
+)
+
+func (c ErrorClass) String() string {
+	if c == ErrorRetriable {
+		return "retriable"
+	}
+	return "fatal"
+}
+
+// Classify returns the class of an error returned by Produce or reported
+// on delivery. Errors which are not known to be transient are fatal.
+func Classify(err error) ErrorClass {
+	var kerr kafka.Error
+	if !errors.As(err, &kerr) {
+		return ErrorFatal
+	}
+	if kerr.IsFatal() {
+		return ErrorFatal
+	}
+
+	switch kerr.Code() {
+	case kafka.ErrQueueFull,
+		kafka.ErrMsgTimedOut,
+		kafka.ErrTimedOut,
+		kafka.ErrTransport,
+		kafka.ErrAllBrokersDown,
+		kafka.ErrRequestTimedOut,
+		kafka.ErrLeaderNotAvailable,
+		kafka.ErrNotLeaderForPartition,
+		kafka.ErrNotEnoughReplicas,
+		kafka.ErrNotEnoughReplicasAfterAppend,
+		kafka.ErrNetworkException:
+		return ErrorRetriable
+	case kafka.ErrMsgSizeTooLarge,
+		kafka.ErrInvalidMsgSize,
+		kafka.ErrRecordListTooLarge,
+		kafka.ErrTopicAuthorizationFailed,
+		kafka.ErrClusterAuthorizationFailed,
+		kafka.ErrSaslAuthenticationFailed,
+		kafka.ErrAuthentication,
+		kafka.ErrUnknownTopic,
+		kafka.ErrUnknownTopicOrPart,
+		kafka.ErrInvalidArg:
+		return ErrorFatal
+	}
+
+	// librdkafka already retries internally, so a retriable flag means
+	// the error outlived its own retries.
+	if kerr.IsRetriable() {
+		return ErrorRetriable
+	}
+	return ErrorFatal
+}
+
+// RetryPolicy bounds how often and how fast retriable errors are retried.
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// NewRetryPolicy returns the policy configured by the delivery retry settings.
+func NewRetryPolicy(cfg Config) RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: cfg.DeliveryRetries,
+		BaseDelay:  time.Duration(cfg.RetryBackoffMs) * time.Millisecond,
+		MaxDelay:   time.Duration(cfg.MaxRetryBackoffMs) * time.Millisecond,
+	}
+}
+
+// Backoff returns the delay before the given retry, starting at 1,
+// doubling with every retry up to MaxDelay.
+func (rp RetryPolicy) Backoff(retry int) time.Duration {
+	delay := rp.BaseDelay << (retry - 1)
+	if delay > rp.MaxDelay || delay <= 0 {
+		delay = rp.MaxDelay
+	}
+	return delay
+}
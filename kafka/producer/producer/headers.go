@@ -0,0 +1,66 @@
+package producer
+
+import (
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/google/uuid"
+)
+
+// Standard headers attached to every produced message. HeaderMessageID is
+// a uuid per message which callers cannot set, e.g. to deduplicate; the
+// correlation id may be set to the same value on related messages.
+const (
+	HeaderMessageID     = "message-id"
+	HeaderCorrelationID = "correlation-id"
+	HeaderContentType   = "content-type"
+	HeaderProducedAt    = "produced-at"
+	HeaderSource        = "source"
+)
+
+// SetHeader adds a header to the message, replacing any header with the same key.
+func (m *Message) SetHeader(key, value string) {
+	for i := range m.Headers {
+		if m.Headers[i].Key == key {
+			m.Headers[i].Value = []byte(value)
+			return
+		}
+	}
+	m.Headers = append(m.Headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+// Header returns the value of the header with the given key.
+func (m *Message) Header(key string) (string, bool) {
+	for _, h := range m.Headers {
+		if h.Key == key {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
+
+// headers returns the standard headers followed by the caller's ones.
+// Standard headers explicitly set on the message are not duplicated, but
+// the message id of the caller is replaced.
+func (p *Producer) headers(msg Message) []kafka.Header {
+	standard := []kafka.Header{
+		{Key: HeaderMessageID, Value: []byte(uuid.New().String())},
+		{Key: HeaderCorrelationID, Value: []byte(uuid.New().String())},
+		{Key: HeaderContentType, Value: []byte(p.cfg.ContentType)},
+		{Key: HeaderProducedAt, Value: []byte(time.Now().UTC().Format(time.RFC3339Nano))},
+		{Key: HeaderSource, Value: []byte(p.cfg.Source)},
+	}
+
+	headers := make([]kafka.Header, 0, len(standard)+len(msg.Headers))
+	for _, h := range standard {
+		if _, ok := msg.Header(h.Key); !ok || h.Key == HeaderMessageID {
+			headers = append(headers, h)
+		}
+	}
+	for _, h := range msg.Headers {
+		if h.Key != HeaderMessageID {
+			headers = append(headers, h)
+		}
+	}
+	return headers
+}
@@ -0,0 +1,136 @@
+package producer
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// librdkafkaStats is the part of the statistics JSON exported as metrics,
+// see STATISTICS.md of librdkafka for all fields.
+type librdkafkaStats struct {
+	MsgCnt     int64 `json:"msg_cnt"`
+	MsgSize    int64 `json:"msg_size"`
+	TxMsgs     int64 `json:"txmsgs"`
+	TxMsgBytes int64 `json:"txmsg_bytes"`
+	Tx         int64 `json:"tx"`
+	Brokers    map[string]struct {
+		State       string `json:"state"`
+		TxErrs      int64  `json:"txerrs"`
+		TxRetries   int64  `json:"txretries"`
+		ReqTimeouts int64  `json:"req_timeouts"`
+		OutbufCnt   int64  `json:"outbuf_msg_cnt"`
+		Rtt         struct {
+			Avg int64 `json:"avg"`
+			P99 int64 `json:"p99"`
+		} `json:"rtt"`
+	} `json:"brokers"`
+}
+
+var (
+	queueMessagesDesc = prometheus.NewDesc("kafka_producer_queue_messages",
+		"Messages waiting in the producer queue.", nil, nil)
+	queueBytesDesc = prometheus.NewDesc("kafka_producer_queue_bytes",
+		"Bytes of the messages waiting in the producer queue.", nil, nil)
+	txMessagesDesc = prometheus.NewDesc("kafka_producer_tx_messages_total",
+		"Messages transmitted to brokers.", nil, nil)
+	txBytesDesc = prometheus.NewDesc("kafka_producer_tx_message_bytes_total",
+		"Message bytes transmitted to brokers.", nil, nil)
+	txRequestsDesc = prometheus.NewDesc("kafka_producer_tx_requests_total",
+		"Requests sent to brokers.", nil, nil)
+
+	brokerUpDesc = prometheus.NewDesc("kafka_producer_broker_up",
+		"Whether the connection to the broker is up.", []string{"broker"}, nil)
+	brokerRttDesc = prometheus.NewDesc("kafka_producer_broker_rtt_seconds",
+		"Broker round trip time of the last statistics window.", []string{"broker", "quantile"}, nil)
+	brokerOutbufDesc = prometheus.NewDesc("kafka_producer_broker_outbuf_messages",
+		"Messages waiting to be sent to the broker.", []string{"broker"}, nil)
+	brokerErrorsDesc = prometheus.NewDesc("kafka_producer_broker_errors_total",
+		"Errors talking to the broker by kind.", []string{"broker", "kind"}, nil)
+
+	deliveriesDesc = prometheus.NewDesc("kafka_producer_deliveries_total",
+		"Final delivery outcome of produced messages.", []string{"result"}, nil)
+	retriesDesc = prometheus.NewDesc("kafka_producer_delivery_retries_total",
+		"Messages produced again after a retriable failure.", nil, nil)
+
+	bufferedDesc = prometheus.NewDesc("kafka_producer_buffered_messages",
+		"Messages buffered because the producer queue was full.", nil, nil)
+	droppedDesc = prometheus.NewDesc("kafka_producer_buffer_dropped_total",
+		"Buffered messages dropped to make room for newer ones.", nil, nil)
+)
+
+// Collector exports the latest librdkafka statistics together with the
+// delivery counters of a Producer. Statistics are only available when
+// statistics.interval.ms is set, see Config.StatsIntervalMs.
+type Collector struct {
+	producer *Producer
+
+	mu    sync.Mutex
+	stats *librdkafkaStats
+}
+
+func newCollector(p *Producer) *Collector {
+	return &Collector{producer: p}
+}
+
+// update parses a statistics event of librdkafka.
+func (c *Collector) update(statsJSON string) {
+	var stats librdkafkaStats
+	if err := json.Unmarshal([]byte(statsJSON), &stats); err != nil {
+		log.Printf("Failed to parse producer statistics: %v", err)
+		return
+	}
+
+	c.mu.Lock()
+	c.stats = &stats
+	c.mu.Unlock()
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		queueMessagesDesc, queueBytesDesc, txMessagesDesc, txBytesDesc, txRequestsDesc,
+		brokerUpDesc, brokerRttDesc, brokerOutbufDesc, brokerErrorsDesc,
+		deliveriesDesc, retriesDesc, bufferedDesc, droppedDesc,
+	} {
+		ch <- d
+	}
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	delivery := c.producer.Stats()
+	ch <- prometheus.MustNewConstMetric(deliveriesDesc, prometheus.CounterValue, float64(delivery.Delivered), "delivered")
+	ch <- prometheus.MustNewConstMetric(deliveriesDesc, prometheus.CounterValue, float64(delivery.Failed), "failed")
+	ch <- prometheus.MustNewConstMetric(retriesDesc, prometheus.CounterValue, float64(delivery.Retried))
+	ch <- prometheus.MustNewConstMetric(bufferedDesc, prometheus.GaugeValue, float64(c.producer.buffer.len()))
+	ch <- prometheus.MustNewConstMetric(droppedDesc, prometheus.CounterValue, float64(c.producer.dropped.Load()))
+
+	c.mu.Lock()
+	stats := c.stats
+	c.mu.Unlock()
+	if stats == nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(queueMessagesDesc, prometheus.GaugeValue, float64(stats.MsgCnt))
+	ch <- prometheus.MustNewConstMetric(queueBytesDesc, prometheus.GaugeValue, float64(stats.MsgSize))
+	ch <- prometheus.MustNewConstMetric(txMessagesDesc, prometheus.CounterValue, float64(stats.TxMsgs))
+	ch <- prometheus.MustNewConstMetric(txBytesDesc, prometheus.CounterValue, float64(stats.TxMsgBytes))
+	ch <- prometheus.MustNewConstMetric(txRequestsDesc, prometheus.CounterValue, float64(stats.Tx))
+
+	for name, b := range stats.Brokers {
+		up := 0.0
+		if b.State == "UP" {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(brokerUpDesc, prometheus.GaugeValue, up, name)
+		// librdkafka reports microseconds
+		ch <- prometheus.MustNewConstMetric(brokerRttDesc, prometheus.GaugeValue, float64(b.Rtt.Avg)/1e6, name, "avg")
+		ch <- prometheus.MustNewConstMetric(brokerRttDesc, prometheus.GaugeValue, float64(b.Rtt.P99)/1e6, name, "0.99")
+		ch <- prometheus.MustNewConstMetric(brokerOutbufDesc, prometheus.GaugeValue, float64(b.OutbufCnt), name)
+		ch <- prometheus.MustNewConstMetric(brokerErrorsDesc, prometheus.CounterValue, float64(b.TxErrs), name, "tx")
+		ch <- prometheus.MustNewConstMetric(brokerErrorsDesc, prometheus.CounterValue, float64(b.TxRetries), name, "retry")
+		ch <- prometheus.MustNewConstMetric(brokerErrorsDesc, prometheus.CounterValue, float64(b.ReqTimeouts), name, "timeout")
+	}
+}
@@ -0,0 +1,129 @@
+package producer
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// Middleware hooks cross-cutting concerns like enrichment, logging,
+// metrics or tracing into every message of a Producer.
+type Middleware interface {
+	// BeforeProduce is called before the message is validated and
+	// enqueued. It may modify msg; an error rejects the message.
+	BeforeProduce(msg *Message) error
+
+	// AfterDelivery is called once the message was delivered (err is nil)
+	// or failed for good. It is called from the delivery goroutine, so it
+	// must not block.
+	AfterDelivery(msg *kafka.Message, err error)
+}
+
+// Rejecter is implemented by middleware which has to know about messages
+// rejected after its BeforeProduce, by later middleware, the validator or
+// a full buffer, e.g. to end a span. AfterDelivery is not called for
+// them, the caller of Produce gets the error instead.
+type Rejecter interface {
+	Rejected(msg *kafka.Message, err error)
+}
+
+// MiddlewareFuncs adapts plain functions to a Middleware. Nil functions
+// are skipped.
+type MiddlewareFuncs struct {
+	Before func(msg *Message) error
+	After  func(msg *kafka.Message, err error)
+}
+
+func (m MiddlewareFuncs) BeforeProduce(msg *Message) error {
+	if m.Before == nil {
+		return nil
+	}
+	return m.Before(msg)
+}
+
+func (m MiddlewareFuncs) AfterDelivery(msg *kafka.Message, err error) {
+	if m.After != nil {
+		m.After(msg, err)
+	}
+}
+
+// Use appends middleware to the chain. BeforeProduce runs in the order
+// the middleware was added, AfterDelivery in reverse order.
+func (p *Producer) Use(mw ...Middleware) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.middleware = append(p.middleware[:len(p.middleware):len(p.middleware)], mw...)
+}
+
+func (p *Producer) chain() []Middleware {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.middleware
+}
+
+// beforeProduce runs the chain on msg and stops at the first error, of
+// which the middleware before is told.
+func (p *Producer) beforeProduce(msg *Message) error {
+	chain := p.chain()
+	for i, mw := range chain {
+		if err := mw.BeforeProduce(msg); err != nil {
+			rejected(chain[:i], p.convert(*msg), err)
+			return err
+		}
+	}
+	return nil
+}
+
+// rejected tells the chain, in reverse, that km failed before it was
+// handed to librdkafka.
+func rejected(chain []Middleware, km *kafka.Message, err error) {
+	for i := len(chain) - 1; i >= 0; i-- {
+		if r, ok := chain[i].(Rejecter); ok {
+			r.Rejected(km, err)
+		}
+	}
+}
+
+// afterDelivery runs the chain in reverse, then the OnDelivery callback.
+func (p *Producer) afterDelivery(msg *kafka.Message, err error) {
+	chain := p.chain()
+	for i := len(chain) - 1; i >= 0; i-- {
+		chain[i].AfterDelivery(msg, err)
+	}
+
+	p.mu.Lock()
+	fn := p.onDelivery
+	p.mu.Unlock()
+	if fn != nil {
+		fn(msg, err)
+	}
+}
+
+// LogMiddleware writes one line per delivered or failed message.
+type LogMiddleware struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewLogMiddleware(w io.Writer) *LogMiddleware {
+	return &LogMiddleware{w: w}
+}
+
+func (l *LogMiddleware) BeforeProduce(msg *Message) error {
+	return nil
+}
+
+func (l *LogMiddleware) AfterDelivery(msg *kafka.Message, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now().Format(time.RFC3339)
+	if err != nil {
+		fmt.Fprintf(l.w, "%s failed %s key=%s: %v\n", now, msg.TopicPartition, msg.Key, err)
+		return
+	}
+	fmt.Fprintf(l.w, "%s delivered %s key=%s\n", now, msg.TopicPartition, msg.Key)
+}
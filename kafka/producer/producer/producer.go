@@ -0,0 +1,406 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// Message is a single record to be produced, by default to the configured topic.
+type Message struct {
+	// Topic overrides the configured topic when set.
+	Topic string
+
+	Key   []byte
+	Value []byte
+
+	// Headers are sent after the standard headers, see SetHeader.
+	Headers []kafka.Header
+
+	// attempt identifies the message from BeforeProduce to its delivery
+	// report, it becomes the Opaque of the kafka.Message.
+	attempt *attempt
+}
+
+// Producer wraps kafka.Producer and applies the configured topic and
+// partitioning to every message.
+type Producer struct {
+	producer   *kafka.Producer
+	cfg        Config
+	delivery   *DeliveryManager
+	failureLog io.WriteCloser
+	serializer Serializer
+	validator  Validator
+	collector  *Collector
+	buffer     *sendBuffer
+	drained    chan struct{}
+	dropped    atomic.Int64
+
+	// syncWaiters wait for the reports of ProduceSync calls whose ctx
+	// ended, until closed.
+	syncWaiters sync.WaitGroup
+	closed      chan struct{}
+
+	mu         sync.Mutex
+	middleware []Middleware
+	onDelivery DeliveryFunc
+
+	shuttingDown atomic.Bool
+}
+
+func NewProducer(cfg Config) (*Producer, error) {
+	serializer, err := NewSerializer(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	validator, err := NewValidator(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var failureLog io.WriteCloser = os.Stderr
+	if cfg.FailureLogPath != "" {
+		f, err := os.OpenFile(cfg.FailureLogPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open failure log: %v", err)
+		}
+		failureLog = f
+	}
+
+	p, err := kafka.NewProducer(cfg.ProducerConfigMap())
+	if err != nil {
+		if failureLog != os.Stderr {
+			failureLog.Close()
+		}
+		return nil, fmt.Errorf("failed to create producer: %v", err)
+	}
+
+	if cfg.TransactionalID != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.MessageTimeoutMs)*time.Millisecond)
+		defer cancel()
+
+		if err := p.InitTransactions(ctx); err != nil {
+			p.Close()
+			if failureLog != os.Stderr {
+				failureLog.Close()
+			}
+			return nil, fmt.Errorf("failed to init transactions: %v", err)
+		}
+	}
+
+	pr := &Producer{
+		producer:   p,
+		cfg:        cfg,
+		delivery:   NewDeliveryManager(p, cfg, failureLog),
+		failureLog: failureLog,
+		serializer: serializer,
+		validator:  validator,
+		buffer:     newSendBuffer(cfg.BufferSize, cfg.BufferPolicy),
+		drained:    make(chan struct{}),
+		closed:     make(chan struct{}),
+	}
+	pr.collector = newCollector(pr)
+	pr.delivery.OnStats(pr.collector.update)
+	pr.delivery.OnDelivery(pr.afterDelivery)
+	go pr.drain()
+
+	return pr, nil
+}
+
+// ErrShuttingDown is returned for messages produced after Shutdown.
+var ErrShuttingDown = errors.New("producer is shutting down")
+
+// Produce enqueues the message asynchronously. Delivery is tracked by
+// the delivery manager, see Stats.
+//
+// When the local queue is full, the message is kept in a bounded buffer
+// and handed over as soon as there is room. With the buffer full as well,
+// the buffer policy decides whether Produce blocks, drops the oldest
+// buffered message or fails. Other retriable errors are retried according
+// to the retry policy, fatal errors are returned immediately, see Classify.
+// Messages still buffered or waiting for room on Close fail with
+// ErrShuttingDown.
+func (p *Producer) Produce(msg Message) error {
+	km, err := p.kafkaMessage(msg)
+	if err != nil {
+		return err
+	}
+	if err := p.produce(km); err != nil {
+		rejected(p.chain(), km, err)
+		return err
+	}
+	return nil
+}
+
+// produce hands km to librdkafka, or to the buffer if its queue is full.
+func (p *Producer) produce(km *kafka.Message) error {
+	policy := NewRetryPolicy(p.cfg)
+	for retry := 1; ; retry++ {
+		// Queue up behind buffered messages to keep the order.
+		if p.buffer.len() > 0 {
+			return p.enqueue(km)
+		}
+
+		err := p.producer.Produce(km, nil)
+		if err == nil {
+			return nil
+		}
+		if isQueueFull(err) {
+			return p.enqueue(km)
+		}
+		if Classify(err) == ErrorFatal {
+			return err
+		}
+		if retry > policy.MaxRetries {
+			return fmt.Errorf("giving up after %d retries: %w", policy.MaxRetries, err)
+		}
+		time.Sleep(policy.Backoff(retry))
+	}
+}
+
+// enqueue buffers km, reporting messages dropped for it as failed.
+func (p *Producer) enqueue(km *kafka.Message) error {
+	dropped, err := p.buffer.add(km)
+	p.dropped.Add(int64(len(dropped)))
+	for _, d := range dropped {
+		p.delivery.recordFailure(d, errDropped, 1)
+	}
+	if err == errClosedWaiting {
+		p.delivery.recordFailure(km, ErrShuttingDown, 0)
+		return nil
+	}
+	return err
+}
+
+// drain hands buffered messages to librdkafka until the buffer is closed,
+// then reports the messages left as failed.
+func (p *Producer) drain() {
+	defer close(p.drained)
+
+	policy := NewRetryPolicy(p.cfg)
+	for {
+		km, ok := p.buffer.next()
+		if !ok {
+			for _, km := range p.buffer.discard() {
+				p.delivery.recordFailure(km, ErrShuttingDown, 0)
+			}
+			return
+		}
+
+		for retry := 1; ; retry++ {
+			err := p.producer.Produce(km, nil)
+			if err == nil {
+				break
+			}
+			if isQueueFull(err) {
+				// Backpressure: wait for deliveries to make room in the queue.
+				p.producer.Flush(int(policy.Backoff(retry).Milliseconds()))
+				continue
+			}
+			if Classify(err) == ErrorFatal || retry > policy.MaxRetries {
+				p.delivery.recordFailure(km, err, retry)
+				break
+			}
+			time.Sleep(policy.Backoff(retry))
+		}
+		p.buffer.done()
+	}
+}
+
+func isQueueFull(err error) bool {
+	kerr, ok := err.(kafka.Error)
+	return ok && kerr.Code() == kafka.ErrQueueFull
+}
+
+// Serialize encodes value in the configured format for the configured topic.
+func (p *Producer) Serialize(value any) ([]byte, error) {
+	return p.serializer.Serialize(p.cfg.Topic, value)
+}
+
+// ProduceSync produces the message and blocks until the broker
+// acknowledged it or ctx is done. It returns where the message was written.
+// Behind messages buffered by Produce, or with the local queue full, it
+// waits according to the retry policy.
+func (p *Producer) ProduceSync(ctx context.Context, msg Message) (kafka.TopicPartition, error) {
+	// Buffered, so a report arriving after ctx is done does not block librdkafka.
+	deliveryChan := make(chan kafka.Event, 1)
+
+	km, err := p.kafkaMessage(msg)
+	if err != nil {
+		return kafka.TopicPartition{}, err
+	}
+	if err := p.produceSync(ctx, km, deliveryChan); err != nil {
+		rejected(p.chain(), km, err)
+		return kafka.TopicPartition{}, err
+	}
+
+	select {
+	case e := <-deliveryChan:
+		m := e.(*kafka.Message)
+		p.reportSync(m)
+		return m.TopicPartition, m.TopicPartition.Error
+	case <-ctx.Done():
+		// The message may still be delivered, report it when it is.
+		p.syncWaiters.Add(1)
+		go func() {
+			defer p.syncWaiters.Done()
+			select {
+			case e := <-deliveryChan:
+				p.reportSync(e.(*kafka.Message))
+			case <-p.closed:
+				km.TopicPartition.Error = ErrShuttingDown
+				p.reportSync(km)
+			}
+		}()
+		return kafka.TopicPartition{}, ctx.Err()
+	}
+}
+
+// produceSync hands km to librdkafka, retrying while messages are
+// buffered ahead of it, to keep the order, or the queue is full.
+func (p *Producer) produceSync(ctx context.Context, km *kafka.Message, deliveryChan chan kafka.Event) error {
+	policy := NewRetryPolicy(p.cfg)
+	for retry := 1; ; retry++ {
+		err := ErrBufferFull
+		if p.buffer.len() == 0 {
+			err = p.producer.Produce(km, deliveryChan)
+			if err == nil {
+				return nil
+			}
+			if Classify(err) == ErrorFatal {
+				return err
+			}
+		}
+		if retry > policy.MaxRetries {
+			return fmt.Errorf("giving up after %d retries: %w", policy.MaxRetries, err)
+		}
+		if werr := wait(ctx, policy.Backoff(retry)); werr != nil {
+			return werr
+		}
+	}
+}
+
+// reportSync runs the middleware and the OnDelivery callback for a
+// message produced by ProduceSync and counts it.
+func (p *Producer) reportSync(m *kafka.Message) {
+	p.afterDelivery(m, m.TopicPartition.Error)
+	if m.TopicPartition.Error != nil {
+		p.delivery.failed.Add(1)
+		return
+	}
+	p.delivery.delivered.Add(1)
+}
+
+// kafkaMessage runs the middleware on msg, validates it and converts it
+// for the underlying producer.
+func (p *Producer) kafkaMessage(msg Message) (*kafka.Message, error) {
+	if p.shuttingDown.Load() {
+		return nil, ErrShuttingDown
+	}
+	msg.attempt = &attempt{}
+	if err := p.beforeProduce(&msg); err != nil {
+		return nil, err
+	}
+
+	km := p.convert(msg)
+	if p.validator != nil {
+		if err := p.validator.Validate(*km.TopicPartition.Topic, msg.Value); err != nil {
+			rejected(p.chain(), km, err)
+			return nil, err
+		}
+	}
+	return km, nil
+}
+
+// convert returns msg for the underlying producer, with the standard headers.
+func (p *Producer) convert(msg Message) *kafka.Message {
+	topic, partition := p.cfg.Topic, p.partition()
+	if msg.Topic != "" && msg.Topic != p.cfg.Topic {
+		// The explicit partition only applies to the configured topic.
+		topic, partition = msg.Topic, kafka.PartitionAny
+	}
+
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{
+			Topic:     &topic,
+			Partition: partition,
+		},
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: p.headers(msg),
+		Opaque:  msg.attempt,
+	}
+}
+
+// partition returns the explicit partition, or lets the configured
+// partitioner pick one based on the key.
+func (p *Producer) partition() int32 {
+	if p.cfg.Partition >= 0 {
+		return int32(p.cfg.Partition)
+	}
+	return kafka.PartitionAny
+}
+
+// OnDelivery registers fn to be called for every message once it is
+// delivered or failed for good, after the middleware. It is called from
+// the delivery goroutine, so it must not block.
+func (p *Producer) OnDelivery(fn DeliveryFunc) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onDelivery = fn
+}
+
+// Collector returns the Prometheus collector of the producer metrics.
+func (p *Producer) Collector() *Collector {
+	return p.collector
+}
+
+// Stats returns how many messages were delivered, retried and failed so far.
+func (p *Producer) Stats() DeliveryStats {
+	return p.delivery.Stats()
+}
+
+// Flush waits up to timeoutMs for outstanding deliveries, including
+// scheduled retries, and returns the number of messages not yet done.
+func (p *Producer) Flush(timeoutMs int) int {
+	deadline := time.Now().Add(time.Duration(timeoutMs) * time.Millisecond)
+	for {
+		remaining := p.producer.Flush(int(time.Until(deadline).Milliseconds()))
+		remaining += p.delivery.PendingRetries() + p.buffer.len()
+		if remaining == 0 || time.Now().After(deadline) {
+			return remaining
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Shutdown stops accepting new messages and waits up to timeoutMs for
+// the outstanding ones. It returns how many messages remained unsent.
+func (p *Producer) Shutdown(timeoutMs int) int {
+	p.shuttingDown.Store(true)
+	return p.Flush(timeoutMs)
+}
+
+// Close stops the producer. Buffered messages are reported as failed,
+// call Shutdown first to deliver them.
+func (p *Producer) Close() {
+	p.buffer.close()
+	<-p.drained
+	p.delivery.Close()
+	p.producer.Close()
+	p.delivery.Wait()
+	// No report arrives after closing, the messages count as failed.
+	close(p.closed)
+	p.syncWaiters.Wait()
+	if p.failureLog != os.Stderr {
+		p.failureLog.Close()
+	}
+}
@@ -0,0 +1,46 @@
+//go:build integration
+
+package producer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"kate.testutil"
+)
+
+func TestProduceSync(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.KafkaConfig = testutil.StartKafka(t)
+	cfg.Topic = "producer-test"
+	cfg.NumPartitions = 1
+
+	p, err := NewProducer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := p.EnsureTopic(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{"one", "two", "three"} {
+		if _, err := p.ProduceSync(ctx, Message{Key: []byte("k"), Value: []byte(value)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msgs := testutil.ConsumeKafka(t, cfg.KafkaConfig, cfg.Topic, 3)
+	for i, want := range []string{"one", "two", "three"} {
+		if got := string(msgs[i].Value); got != want {
+			t.Errorf("message %d: got %q, want %q", i, got, want)
+		}
+	}
+	if stats := p.Stats(); stats.Delivered != 3 {
+		t.Errorf("got %d delivered, want 3", stats.Delivered)
+	}
+}
@@ -0,0 +1,35 @@
+package producer
+
+import (
+	"fmt"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry"
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry/serde"
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry/serde/protobuf"
+	"google.golang.org/protobuf/proto"
+)
+
+// protobufSerializer emits the Confluent protobuf wire format: magic byte,
+// schema ID, the message indexes within the .proto file and the message.
+//
+// The schema is derived from the generated Go type and registered under
+// "<topic>-value" on first use, together with the files it imports.
+type protobufSerializer struct {
+	serializer *protobuf.Serializer
+}
+
+func newProtobufSerializer(client schemaregistry.Client) (*protobufSerializer, error) {
+	ser, err := protobuf.NewSerializer(client, serde.ValueSerde, protobuf.NewSerializerConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create protobuf serializer: %v", err)
+	}
+	return &protobufSerializer{serializer: ser}, nil
+}
+
+// Serialize encodes value, which must be a generated protobuf message.
+func (s *protobufSerializer) Serialize(topic string, value any) ([]byte, error) {
+	if _, ok := value.(proto.Message); !ok {
+		return nil, fmt.Errorf("protobuf format needs a proto.Message, got %T", value)
+	}
+	return s.serializer.Serialize(topic, value)
+}
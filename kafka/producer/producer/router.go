@@ -0,0 +1,108 @@
+package producer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// Route sends messages with a matching header or JSON payload field to
+// Topic. Exactly one of Header and Field is set; an empty Value matches
+// any value.
+type Route struct {
+	Header string `yaml:"header"`
+	// Field is a dot separated path into the JSON payload, e.g. "user.region".
+	Field string `yaml:"field"`
+	Value string `yaml:"value"`
+	Topic string `yaml:"topic"`
+}
+
+func (r Route) validate() error {
+	if (r.Header == "") == (r.Field == "") {
+		return fmt.Errorf("route to %q needs either a header or a field", r.Topic)
+	}
+	if r.Topic == "" {
+		return fmt.Errorf("route on %s%s needs a topic", r.Header, r.Field)
+	}
+	return nil
+}
+
+// Router is a Middleware setting the topic of every message from the
+// first matching route. Messages which already name a topic or match no
+// route go to the configured topic.
+type Router struct {
+	routes []Route
+}
+
+func NewRouter(routes []Route) *Router {
+	return &Router{routes: routes}
+}
+
+func (r *Router) BeforeProduce(msg *Message) error {
+	if msg.Topic != "" {
+		return nil
+	}
+
+	// The payload is decoded at most once, and only for field routes.
+	var payload any
+	decoded := false
+	for _, route := range r.routes {
+		var value string
+		var ok bool
+		if route.Header != "" {
+			value, ok = msg.Header(route.Header)
+		} else {
+			if !decoded {
+				payload, decoded = decodePayload(msg.Value), true
+			}
+			value, ok = field(payload, route.Field)
+		}
+
+		if ok && (route.Value == "" || route.Value == value) {
+			msg.Topic = route.Topic
+			return nil
+		}
+	}
+	return nil
+}
+
+func (r *Router) AfterDelivery(msg *kafka.Message, err error) {}
+
+// decodePayload returns the JSON payload, or nil if it is not JSON.
+func decodePayload(value []byte) any {
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.UseNumber()
+
+	var payload any
+	if dec.Decode(&payload) != nil {
+		return nil
+	}
+	return payload
+}
+
+// field returns the scalar at path in payload as string.
+func field(payload any, path string) (string, bool) {
+	for _, name := range strings.Split(path, ".") {
+		obj, ok := payload.(map[string]any)
+		if !ok {
+			return "", false
+		}
+		if payload, ok = obj[name]; !ok {
+			return "", false
+		}
+	}
+
+	switch v := payload.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return fmt.Sprint(v), true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,84 @@
+package producer
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry"
+)
+
+// Payload formats, see Config.Format.
+const (
+	FormatRaw      = "raw"
+	FormatJSON     = "json"
+	FormatAvro     = "avro"
+	FormatProtobuf = "protobuf"
+)
+
+// Serializer encodes a value into the payload of a message for a topic.
+type Serializer interface {
+	Serialize(topic string, value any) ([]byte, error)
+}
+
+// rawSerializer passes bytes and strings through unchanged.
+type rawSerializer struct{}
+
+func (rawSerializer) Serialize(topic string, value any) ([]byte, error) {
+	switch v := value.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	default:
+		return nil, fmt.Errorf("raw format needs []byte or string, got %T", value)
+	}
+}
+
+// jsonSerializer encodes values with encoding/json.
+type jsonSerializer struct{}
+
+func (jsonSerializer) Serialize(topic string, value any) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+// NewSerializer returns the serializer for the configured format.
+func NewSerializer(cfg Config) (Serializer, error) {
+	switch cfg.Format {
+	case FormatRaw:
+		return rawSerializer{}, nil
+	case FormatJSON:
+		return jsonSerializer{}, nil
+	case FormatAvro:
+		client, err := newSchemaRegistryClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return newAvroSerializer(client, cfg), nil
+	case FormatProtobuf:
+		client, err := newSchemaRegistryClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return newProtobufSerializer(client)
+	default:
+		return nil, fmt.Errorf("unknown format %q", cfg.Format)
+	}
+}
+
+func newSchemaRegistryClient(cfg Config) (schemaregistry.Client, error) {
+	if cfg.SchemaRegistryURL == "" {
+		return nil, fmt.Errorf("format %s needs a schema registry url", cfg.Format)
+	}
+
+	srConfig := schemaregistry.NewConfig(cfg.SchemaRegistryURL)
+	if cfg.SchemaRegistryUsername != "" {
+		srConfig = schemaregistry.NewConfigWithBasicAuthentication(cfg.SchemaRegistryURL,
+			cfg.SchemaRegistryUsername, cfg.SchemaRegistryPassword)
+	}
+
+	client, err := schemaregistry.NewClient(srConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create schema registry client: %v", err)
+	}
+	return client, nil
+}
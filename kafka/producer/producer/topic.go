@@ -0,0 +1,61 @@
+package producer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// EnsureTopic verifies that the configured topic exists and has the
+// explicit partition, if one is configured. A missing topic is created
+// from the topic settings with AutoCreate, otherwise it is an error.
+func (p *Producer) EnsureTopic(ctx context.Context) error {
+	a, err := kafka.NewAdminClientFromProducer(p.producer)
+	if err != nil {
+		return fmt.Errorf("failed to create admin client: %v", err)
+	}
+	defer a.Close()
+
+	topic := p.cfg.Topic
+	result, err := a.DescribeTopics(ctx, kafka.NewTopicCollectionOfTopicNames([]string{topic}))
+	if err != nil {
+		return fmt.Errorf("failed to describe topic %s: %v", topic, err)
+	}
+
+	desc := result.TopicDescriptions[0]
+	partitions := len(desc.Partitions)
+	switch desc.Error.Code() {
+	case kafka.ErrNoError:
+	case kafka.ErrUnknownTopicOrPart:
+		if !p.cfg.AutoCreate {
+			return fmt.Errorf("topic %s does not exist, create it with the admin tool or pass -auto-create", topic)
+		}
+		if err := p.createTopic(ctx, a); err != nil {
+			return err
+		}
+		partitions = p.cfg.NumPartitions
+	default:
+		return fmt.Errorf("failed to describe topic %s: %v", topic, desc.Error)
+	}
+
+	if p.cfg.Partition >= partitions {
+		return fmt.Errorf("topic %s has %d partitions, partition %d does not exist", topic, partitions, p.cfg.Partition)
+	}
+	return nil
+}
+
+func (p *Producer) createTopic(ctx context.Context, a *kafka.AdminClient) error {
+	results, err := a.CreateTopics(ctx, []kafka.TopicSpecification{p.cfg.TopicSpecification()},
+		kafka.SetAdminOperationTimeout(10*time.Second))
+	if err != nil {
+		return fmt.Errorf("failed to create topic %s: %v", p.cfg.Topic, err)
+	}
+
+	// Another producer may have created it in the meantime.
+	if code := results[0].Error.Code(); code != kafka.ErrNoError && code != kafka.ErrTopicAlreadyExists {
+		return fmt.Errorf("failed to create topic %s: %v", p.cfg.Topic, results[0].Error)
+	}
+	return nil
+}
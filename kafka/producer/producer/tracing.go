@@ -0,0 +1,120 @@
+package producer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// headerCarrier lets the propagator read and write message headers.
+type headerCarrier struct {
+	msg *Message
+}
+
+func (c headerCarrier) Get(key string) string {
+	v, _ := c.msg.Header(key)
+	return v
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c.msg.SetHeader(key, value)
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.msg.Headers))
+	for _, h := range c.msg.Headers {
+		keys = append(keys, h.Key)
+	}
+	return keys
+}
+
+// TracingMiddleware starts a producer span for every message, injects
+// its W3C trace context into the headers and ends it on delivery, so
+// consumers can continue the trace.
+type TracingMiddleware struct {
+	tracer     trace.Tracer
+	propagator propagation.TraceContext
+	topic      string
+
+	// spans are the open spans by the Opaque of their message, which
+	// stays the same across retries.
+	spans sync.Map
+}
+
+// NewTracingMiddleware returns the middleware for a producer writing to
+// topic unless a message names another one.
+func NewTracingMiddleware(tp trace.TracerProvider, topic string) *TracingMiddleware {
+	return &TracingMiddleware{
+		tracer: tp.Tracer("kate.kafka.example/producer"),
+		topic:  topic,
+	}
+}
+
+func (t *TracingMiddleware) BeforeProduce(msg *Message) error {
+	topic := t.topic
+	if msg.Topic != "" {
+		topic = msg.Topic
+	}
+
+	// A traceparent set on the message, see SetTraceContext, is the parent.
+	carrier := headerCarrier{msg: msg}
+	parent := t.propagator.Extract(context.Background(), carrier)
+
+	ctx, span := t.tracer.Start(parent, topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationTypePublish,
+			semconv.MessagingDestinationName(topic),
+		))
+	if len(msg.Key) > 0 {
+		span.SetAttributes(semconv.MessagingKafkaMessageKey(string(msg.Key)))
+	}
+
+	t.propagator.Inject(ctx, carrier)
+	t.spans.Store(msg.attempt, span)
+	return nil
+}
+
+func (t *TracingMiddleware) AfterDelivery(msg *kafka.Message, err error) {
+	v, ok := t.spans.LoadAndDelete(msg.Opaque)
+	if !ok {
+		return
+	}
+
+	span := v.(trace.Span)
+	span.SetAttributes(
+		semconv.MessagingDestinationPartitionID(fmt.Sprint(msg.TopicPartition.Partition)),
+		attribute.Int64("messaging.kafka.offset", int64(msg.TopicPartition.Offset)),
+	)
+	endSpan(span, err)
+}
+
+// Rejected ends the span of a message which was never enqueued.
+func (t *TracingMiddleware) Rejected(msg *kafka.Message, err error) {
+	if v, ok := t.spans.LoadAndDelete(msg.Opaque); ok {
+		endSpan(v.(trace.Span), err)
+	}
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// SetTraceContext sets the traceparent header of the message to the span
+// in ctx, e.g. of the HTTP request it came with. Its producer span then
+// continues that trace instead of starting a new one.
+func (m *Message) SetTraceContext(ctx context.Context) {
+	propagation.TraceContext{}.Inject(ctx, headerCarrier{msg: m})
+}
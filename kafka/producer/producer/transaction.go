@@ -0,0 +1,132 @@
+package producer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// abortTimeout bounds aborting a transaction once the context of the
+// caller ended, a transaction left open blocks the next one.
+const abortTimeout = 10 * time.Second
+
+// Transactional reports whether the producer was configured with a transactional.id.
+func (p *Producer) Transactional() bool {
+	return p.cfg.TransactionalID != ""
+}
+
+// ProduceTransaction writes all messages atomically: read_committed
+// consumers either see every message, across all partitions they were
+// spread to, or none of them. On any error the transaction is aborted.
+func (p *Producer) ProduceTransaction(ctx context.Context, msgs []Message) error {
+	return p.transaction(ctx, msgs, nil, nil)
+}
+
+// ProduceConsumed writes msgs and commits the consumer offsets in the same
+// transaction, for consume-transform-produce pipelines: the output is
+// visible exactly when the input counts as consumed. offsets are the next
+// offsets to consume per partition, group is the metadata of the consumer.
+func (p *Producer) ProduceConsumed(ctx context.Context, msgs []Message, offsets []kafka.TopicPartition, group *kafka.ConsumerGroupMetadata) error {
+	return p.transaction(ctx, msgs, offsets, group)
+}
+
+func (p *Producer) transaction(ctx context.Context, msgs []Message, offsets []kafka.TopicPartition, group *kafka.ConsumerGroupMetadata) error {
+	if !p.Transactional() {
+		return fmt.Errorf("producer is not transactional, set a transactional id")
+	}
+
+	// Validate everything up front, nothing is produced if one message is invalid.
+	kmsgs := make([]*kafka.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		km, err := p.kafkaMessage(msg)
+		if err != nil {
+			p.rejectAll(kmsgs, err)
+			return err
+		}
+		kmsgs = append(kmsgs, km)
+	}
+
+	if err := p.producer.BeginTransaction(); err != nil {
+		err = fmt.Errorf("failed to begin transaction: %v", err)
+		p.rejectAll(kmsgs, err)
+		return err
+	}
+
+	for i, km := range kmsgs {
+		if err := p.producer.Produce(km, nil); err != nil {
+			// The messages produced already fail on delivery once aborted.
+			err = fmt.Errorf("failed to produce in transaction: %v", err)
+			p.rejectAll(kmsgs[i:], err)
+			return p.abortTransaction(ctx, err)
+		}
+	}
+
+	if len(offsets) > 0 {
+		if err := p.producer.SendOffsetsToTransaction(ctx, offsets, group); err != nil {
+			return p.abortTransaction(ctx, fmt.Errorf("failed to send offsets to transaction: %v", err))
+		}
+	}
+
+	policy := NewRetryPolicy(p.cfg)
+	for retry := 1; ; retry++ {
+		err := p.producer.CommitTransaction(ctx)
+		if err == nil {
+			return nil
+		}
+
+		kerr, ok := err.(kafka.Error)
+		switch {
+		case ok && kerr.IsRetriable():
+			// The outcome is unknown, committing again is safe.
+			if werr := wait(ctx, policy.Backoff(retry)); werr != nil {
+				return p.abortTransaction(ctx, fmt.Errorf("failed to commit transaction: %v: %v", err, werr))
+			}
+		case ok && kerr.TxnRequiresAbort():
+			return p.abortTransaction(ctx, fmt.Errorf("failed to commit transaction: %v", err))
+		default:
+			// Fatal, the producer has to be recreated.
+			return fmt.Errorf("failed to commit transaction: %v", err)
+		}
+	}
+}
+
+// rejectAll tells the middleware that kmsgs were not produced.
+func (p *Producer) rejectAll(kmsgs []*kafka.Message, err error) {
+	chain := p.chain()
+	for _, km := range kmsgs {
+		rejected(chain, km, err)
+	}
+}
+
+// abortTransaction aborts the current transaction and returns cause. It
+// takes up to abortTimeout, even if ctx ended already.
+func (p *Producer) abortTransaction(ctx context.Context, cause error) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), abortTimeout)
+	defer cancel()
+
+	policy := NewRetryPolicy(p.cfg)
+	for retry := 1; ; retry++ {
+		err := p.producer.AbortTransaction(ctx)
+		if err == nil {
+			return cause
+		}
+		if kerr, ok := err.(kafka.Error); ok && kerr.IsRetriable() && wait(ctx, policy.Backoff(retry)) == nil {
+			continue
+		}
+		return fmt.Errorf("%v, abort failed as well: %v", cause, err)
+	}
+}
+
+// wait sleeps for d, it returns the error of ctx if ctx ends first.
+func wait(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
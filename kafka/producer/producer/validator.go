@@ -0,0 +1,91 @@
+package producer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// ValidationError is returned for payloads rejected before producing.
+type ValidationError struct {
+	Topic string
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid message for topic %s: %v", e.Topic, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Validator checks a payload before it is produced to topic.
+type Validator interface {
+	Validate(topic string, value []byte) error
+}
+
+// jsonSchemaValidator rejects payloads which are not valid JSON or
+// violate the schema, so poison messages never reach the topic.
+type jsonSchemaValidator struct {
+	schema *jsonschema.Schema
+}
+
+// NewJSONSchemaValidator compiles the JSON Schema document.
+func NewJSONSchemaValidator(name string, schema []byte) (Validator, error) {
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(name, bytes.NewReader(schema)); err != nil {
+		return nil, fmt.Errorf("failed to load json schema %s: %v", name, err)
+	}
+
+	compiled, err := compiler.Compile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile json schema %s: %v", name, err)
+	}
+	return &jsonSchemaValidator{schema: compiled}, nil
+}
+
+func (v *jsonSchemaValidator) Validate(topic string, value []byte) error {
+	var doc any
+	if err := json.Unmarshal(value, &doc); err != nil {
+		return &ValidationError{Topic: topic, Err: fmt.Errorf("payload is not json: %v", err)}
+	}
+
+	if err := v.schema.Validate(doc); err != nil {
+		if verr, ok := err.(*jsonschema.ValidationError); ok {
+			// The detailed form lists every violated keyword with its location.
+			return &ValidationError{Topic: topic, Err: fmt.Errorf("%s", strings.TrimSpace(fmt.Sprintf("%#v", verr)))}
+		}
+		return &ValidationError{Topic: topic, Err: err}
+	}
+	return nil
+}
+
+// NewValidator returns the validator configured by JSONSchemaFile or
+// JSONSchemaSubject, or nil if validation is disabled.
+func NewValidator(cfg Config) (Validator, error) {
+	switch {
+	case cfg.JSONSchemaFile != "":
+		schema, err := os.ReadFile(cfg.JSONSchemaFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read json schema: %v", err)
+		}
+		return NewJSONSchemaValidator(cfg.JSONSchemaFile, schema)
+	case cfg.JSONSchemaSubject != "":
+		client, err := newSchemaRegistryClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		metadata, err := client.GetLatestSchemaMetadata(cfg.JSONSchemaSubject)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch json schema %s: %v", cfg.JSONSchemaSubject, err)
+		}
+		return NewJSONSchemaValidator(cfg.JSONSchemaSubject+".json", []byte(metadata.Schema))
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"kate.kafka.example/producer/producer"
+	"kate.telemetry"
+)
+
+// restMessage is the body of POST /topics/{topic}. A string value is
+// produced as is, any other JSON value as its JSON encoding.
+type restMessage struct {
+	Key     *string           `json:"key"`
+	Value   json.RawMessage   `json:"value"`
+	Headers map[string]string `json:"headers"`
+}
+
+// restResult tells where a posted message was written.
+type restResult struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+}
+
+type restError struct {
+	Error string `json:"error"`
+}
+
+// serveHTTP runs the REST proxy until ctx is done.
+func serveHTTP(ctx context.Context, p *producer.Producer, cfg producer.Config) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /topics/{topic}", func(w http.ResponseWriter, r *http.Request) {
+		handleProduce(w, r, p, cfg)
+	})
+
+	server := &http.Server{Addr: cfg.HTTPAddr, Handler: telemetry.Handler(mux)}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Serving REST proxy on http://%s/topics/{topic}", cfg.HTTPAddr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// handleProduce produces the posted message and waits for its delivery.
+func handleProduce(w http.ResponseWriter, r *http.Request, p *producer.Producer, cfg producer.Config) {
+	var body restMessage
+	dec := json.NewDecoder(io.LimitReader(r.Body, int64(cfg.MessageMaxBytes)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, restError{Error: fmt.Sprintf("invalid body: %v", err)})
+		return
+	}
+	if len(body.Value) == 0 {
+		writeJSON(w, http.StatusBadRequest, restError{Error: "value is required"})
+		return
+	}
+
+	msg := producer.Message{
+		Topic: r.PathValue("topic"),
+		Value: body.Value,
+	}
+	var s string
+	if json.Unmarshal(body.Value, &s) == nil {
+		msg.Value = []byte(s)
+	}
+	if body.Key != nil {
+		msg.Key = []byte(*body.Key)
+	}
+	for k, v := range body.Headers {
+		msg.SetHeader(k, v)
+	}
+	// The producer span continues the trace of the request.
+	msg.SetTraceContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(cfg.MessageTimeoutMs)*time.Millisecond)
+	defer cancel()
+
+	tp, err := p.ProduceSync(ctx, msg)
+	if err != nil {
+		writeJSON(w, produceStatus(err), restError{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, restResult{
+		Topic:     *tp.Topic,
+		Partition: tp.Partition,
+		Offset:    int64(tp.Offset),
+	})
+}
+
+// produceStatus maps a produce error to the HTTP status of the response.
+func produceStatus(err error) int {
+	var validationErr *producer.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, producer.ErrShuttingDown):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return http.StatusGatewayTimeout
+	case producer.Classify(err) == producer.ErrorRetriable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,152 @@
+// Redis stream to Kafka bridge: reads a stream with a consumer group and
+// produces every entry to the configured topic, keyed by its stream ID.
+//
+// An entry is acknowledged in Redis only after Kafka confirmed its
+// delivery. Failed entries stay pending and are produced again on the
+// next start, so the bridge delivers at least once.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/go-redis/redis/v8"
+	"kate.config"
+	"kate.kafka.example/producer/producer"
+)
+
+const usage = `Usage: redisbridge [flags] <stream> <group>
+
+Redis is set with REDIS_ADDR (default localhost:6379), REDIS_USERNAME,
+REDIS_PASSWORD and REDIS_DB. Entries are produced to -topic, other flags
+are shared with the producer, see producer -h.
+`
+
+const batchSize = 100
+
+func main() {
+	cfg, args, err := producer.LoadConfigArgs(os.Args[0], os.Args[1:])
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+	if len(args) != 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	stream, group := args[0], args[1]
+
+	rcfg := config.DefaultRedisConfig()
+	if err := config.ApplyEnv("", rcfg.RegisterFlags); err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	rdb := rcfg.NewClient()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		log.Fatal("Redis connection failed: ", err)
+	}
+
+	// Create consumer group (ignore error if it already exists)
+	rdb.XGroupCreateMkStream(ctx, stream, group, "0").Err()
+
+	p, err := producer.NewProducer(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	topicCtx, cancelTopic := context.WithTimeout(ctx, 10*time.Second)
+	err = p.EnsureTopic(topicCtx)
+	cancelTopic()
+	if err != nil {
+		p.Close()
+		log.Fatal(err)
+	}
+
+	// The key of every message is the stream ID of its entry.
+	p.OnDelivery(func(msg *kafka.Message, err error) {
+		if err != nil {
+			log.Printf("Entry %s not delivered, stays pending: %v", msg.Key, err)
+			return
+		}
+		if err := rdb.XAck(context.Background(), stream, group, string(msg.Key)).Err(); err != nil {
+			log.Printf("Failed to ack entry %s: %v", msg.Key, err)
+		}
+	})
+
+	consumer, _ := os.Hostname()
+	log.Printf("Bridging stream %s (group %s) to topic %s", stream, group, cfg.Topic)
+	if err := bridge(ctx, rdb, p, stream, group, consumer); err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("Bridge failed: %v", err)
+	}
+
+	remaining := p.Shutdown(cfg.FlushTimeoutMs)
+	stats := p.Stats()
+	fmt.Printf("Delivered: %d, Failed: %d, Retried: %d\n", stats.Delivered, stats.Failed, stats.Retried)
+	p.Close()
+	if remaining > 0 {
+		fmt.Fprintf(os.Stderr, "%d entries remained unsent and stay pending\n", remaining)
+		os.Exit(1)
+	}
+}
+
+// bridge produces the entries of the stream until ctx is done. It starts
+// with the entries still pending for this consumer from an earlier run.
+func bridge(ctx context.Context, rdb *redis.Client, p *producer.Producer, stream, group, consumer string) error {
+	start := "0"
+	for {
+		results, err := rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    group,
+			Consumer: consumer,
+			Streams:  []string{stream, start},
+			Count:    batchSize,
+			Block:    5 * time.Second,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		var n int
+		for _, result := range results {
+			for _, entry := range result.Messages {
+				if err := produceEntry(p, entry); err != nil {
+					log.Printf("Failed to produce entry %s, stays pending: %v", entry.ID, err)
+				}
+				n++
+				if start != ">" {
+					// Page through the pending entries.
+					start = entry.ID
+				}
+			}
+		}
+
+		// Pending entries are exhausted, continue with new ones.
+		if start != ">" && n < batchSize {
+			start = ">"
+		}
+	}
+}
+
+// produceEntry produces the fields of the entry as JSON object.
+func produceEntry(p *producer.Producer, entry redis.XMessage) error {
+	value, err := json.Marshal(entry.Values)
+	if err != nil {
+		return err
+	}
+
+	msg := producer.Message{Key: []byte(entry.ID), Value: value}
+	msg.SetHeader("redis-stream-id", entry.ID)
+	return p.Produce(msg)
+}
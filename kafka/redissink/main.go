@@ -0,0 +1,158 @@
+// Kafka to Redis sink: consumes the configured topics and writes every
+// message to Redis, either as string under its key or as entry of a stream.
+//
+// Offsets are committed after Redis accepted the writes, so the sink
+// delivers at least once. SET is idempotent, a redelivered message only
+// overwrites its key again; XADD appends it to the stream once more.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel"
+	"kate.config"
+	"kate.kafka.example/consumer/consumer"
+	"kate.telemetry"
+)
+
+const usage = `Usage: redissink [flags] set [<key-prefix>]
+       redissink [flags] xadd <stream>
+
+set stores the value of every message under <key-prefix><message key>,
+messages without key are skipped. xadd appends every message to the stream
+with the fields key, value, topic, partition, offset and header:<name>.
+
+Redis is set with REDIS_ADDR (default localhost:6379), REDIS_USERNAME,
+REDIS_PASSWORD and REDIS_DB. With -batch-size the writes of a batch are sent
+in one pipeline, other flags are shared with the consumer, see consumer -h.
+`
+
+// sink writes messages to Redis.
+type sink struct {
+	rdb *redis.Client
+	// mode is "set" or "xadd", target the key prefix or the stream.
+	mode   string
+	target string
+}
+
+func main() {
+	cfg, args, err := consumer.LoadConfigArgs(os.Args[0], os.Args[1:])
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+	s, err := parseArgs(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n%s", err, usage)
+		os.Exit(2)
+	}
+
+	rcfg := config.DefaultRedisConfig()
+	if err := config.ApplyEnv("", rcfg.RegisterFlags); err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := telemetry.Setup(context.Background(), "go-examples-redissink", telemetry.Config{OTLPEndpoint: cfg.OTLPEndpoint})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s.rdb = rcfg.NewClient()
+	telemetry.InstrumentRedis(s.rdb)
+	if err := s.rdb.Ping(ctx).Err(); err != nil {
+		log.Fatal("Redis connection failed: ", err)
+	}
+	defer s.rdb.Close()
+
+	c, err := consumer.NewConsumer(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.OTLPEndpoint != "" {
+		// The writes of a message are children of its consumer span.
+		c.UseTracing(otel.GetTracerProvider())
+	}
+
+	log.Printf("Sinking topics %v to Redis (%s %s)", cfg.Topics, s.mode, s.target)
+	if cfg.BatchSize > 0 {
+		err = c.RunBatch(ctx, s.write)
+	} else {
+		err = c.Run(ctx, func(ctx context.Context, msg *kafka.Message) error {
+			return s.write(ctx, []*kafka.Message{msg})
+		})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Consuming failed: %v\n", err)
+	}
+
+	if err := c.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to commit offsets: %v\n", err)
+	}
+	if err := shutdownTracing(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to export spans: %v\n", err)
+	}
+}
+
+func parseArgs(args []string) (*sink, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("missing mode")
+	}
+	switch {
+	case args[0] == "set" && len(args) <= 2:
+		s := &sink{mode: "set"}
+		if len(args) == 2 {
+			s.target = args[1]
+		}
+		return s, nil
+	case args[0] == "xadd" && len(args) == 2:
+		return &sink{mode: "xadd", target: args[1]}, nil
+	}
+	return nil, fmt.Errorf("invalid arguments %q", args)
+}
+
+// write sends the messages in one pipeline and fails if any write failed.
+func (s *sink) write(ctx context.Context, msgs []*kafka.Message) error {
+	pipe := s.rdb.Pipeline()
+	for _, msg := range msgs {
+		switch s.mode {
+		case "set":
+			if len(msg.Key) == 0 {
+				log.Printf("Skipping message without key on %s", msg.TopicPartition)
+				continue
+			}
+			pipe.Set(ctx, s.target+string(msg.Key), msg.Value, 0)
+		case "xadd":
+			pipe.XAdd(ctx, &redis.XAddArgs{Stream: s.target, Values: fields(msg)})
+		}
+	}
+	if pipe.Len() == 0 {
+		return nil
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// fields returns the stream entry of msg.
+func fields(msg *kafka.Message) map[string]interface{} {
+	values := map[string]interface{}{
+		"key":       msg.Key,
+		"value":     msg.Value,
+		"topic":     *msg.TopicPartition.Topic,
+		"partition": strconv.Itoa(int(msg.TopicPartition.Partition)),
+		"offset":    msg.TopicPartition.Offset.String(),
+	}
+	for _, h := range msg.Headers {
+		values["header:"+h.Key] = h.Value
+	}
+	return values
+}
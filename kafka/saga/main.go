@@ -0,0 +1,338 @@
+// Saga example: an order saga of three services over Kafka. The order
+// service creates a pending order, the payment service charges the
+// customer and the inventory service reserves the goods. When payment or
+// inventory reject their step, the steps before are compensated: the
+// charge refunded and the order cancelled.
+//
+// The orchestrator and the participants run in this process, each as its
+// own consumer group, as they would in separate services. Their state
+// lives in Redis: balances, stock and orders under saga:demo:* and the
+// sagas under saga:order:<id>.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/go-redis/redis/v8"
+	"kate.config"
+	"kate.kafka.example/consumer/consumer"
+	"kate.kafka.example/producer/producer"
+	"kate.kafka.example/saga/saga"
+)
+
+const usage = `Usage: saga [flags]
+
+Seeds balances and stock in Redis, runs three order sagas, one completing,
+one rejected by payment and one rejected by inventory, and prints how they
+ended. Topics saga.*.commands and saga.order.replies are created if
+missing, consumer groups are named after -group-id.
+
+Redis is set with REDIS_ADDR (default localhost:6379), REDIS_USERNAME,
+REDIS_PASSWORD and REDIS_DB, other flags are shared with the consumer, see
+consumer -h.
+`
+
+var orderSaga = saga.Definition{
+	Name: "order",
+	Steps: []saga.Step{
+		{Name: "order", Topic: "saga.order.commands"},
+		{Name: "payment", Topic: "saga.payment.commands"},
+		{Name: "inventory", Topic: "saga.inventory.commands"},
+	},
+	ReplyTopic: "saga.order.replies",
+}
+
+// order is the payload of the saga.
+type order struct {
+	ID          string `json:"id"`
+	Customer    string `json:"customer"`
+	SKU         string `json:"sku"`
+	Quantity    int64  `json:"quantity"`
+	AmountCents int64  `json:"amount_cents"`
+}
+
+const (
+	ordersKey       = "saga:demo:orders"
+	balancesKey     = "saga:demo:balances"
+	paymentsKey     = "saga:demo:payments"
+	stockKey        = "saga:demo:stock"
+	reservationsKey = "saga:demo:reservations"
+)
+
+// takeScript moves ARGV[2] of the counter ARGV[1] in KEYS[1] into the
+// record ARGV[3] in KEYS[2], unless the counter is too low. It returns 1
+// if the record exists afterwards, so a retried take does nothing.
+var takeScript = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[2], ARGV[3]) == 1 then
+  return 1
+end
+if tonumber(redis.call('HGET', KEYS[1], ARGV[1]) or '0') < tonumber(ARGV[2]) then
+  return 0
+end
+redis.call('HINCRBY', KEYS[1], ARGV[1], -ARGV[2])
+redis.call('HSET', KEYS[2], ARGV[3], ARGV[2])
+return 1
+`)
+
+// returnScript undoes takeScript, once.
+var returnScript = redis.NewScript(`
+local n = redis.call('HGET', KEYS[2], ARGV[2])
+if n then
+  redis.call('HINCRBY', KEYS[1], ARGV[1], n)
+  redis.call('HDEL', KEYS[2], ARGV[2])
+end
+return 0
+`)
+
+func main() {
+	cfg, args, err := consumer.LoadConfigArgs(os.Args[0], os.Args[1:])
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+	if len(args) > 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	rcfg := config.DefaultRedisConfig()
+	if err := config.ApplyEnv("", rcfg.RegisterFlags); err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	rdb := rcfg.NewClient()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		log.Fatal("Redis connection failed: ", err)
+	}
+	defer rdb.Close()
+
+	pcfg := producer.DefaultConfig()
+	pcfg.KafkaConfig = cfg.KafkaConfig
+	pcfg.Topic = orderSaga.ReplyTopic
+	pcfg.AutoCreate = true
+	pcfg.NumPartitions = 3
+	pcfg.ContentType = "application/json"
+	pcfg.Source = "go-examples-saga"
+	p, err := producer.NewProducer(pcfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer p.Close()
+	if err := ensureTopics(ctx, pcfg); err != nil {
+		log.Fatal(err)
+	}
+
+	sender := saga.SenderFunc(func(ctx context.Context, topic string, key, value []byte) error {
+		_, err := p.ProduceSync(ctx, producer.Message{Topic: topic, Key: key, Value: value})
+		return err
+	})
+
+	runCtx, cancelRun := context.WithCancel(ctx)
+	defer cancelRun()
+
+	orchestrator := saga.NewOrchestrator(rdb, orderSaga, sender)
+	var finished sync.WaitGroup
+	orchestrator.OnFinish(func(ctx context.Context, s saga.State) {
+		defer finished.Done()
+		var o order
+		if err := json.Unmarshal(s.Payload, &o); err != nil {
+			log.Printf("Skipping malformed order of saga %s: %v", s.ID, err)
+			return
+		}
+		status := "approved"
+		if s.Status != saga.StatusCompleted {
+			status = "rejected"
+		}
+		rdb.HSet(ctx, ordersKey, o.ID, status)
+	})
+
+	participants := map[string]*saga.Participant{
+		"order": saga.NewParticipant(rdb, orderSaga, "order", sender,
+			func(ctx context.Context, id string, payload []byte) error {
+				o, err := decodeOrder(payload)
+				if err == nil {
+					err = rdb.HSetNX(ctx, ordersKey, o.ID, "pending").Err()
+				}
+				return err
+			},
+			func(ctx context.Context, id string, payload []byte) error {
+				o, err := decodeOrder(payload)
+				if err == nil {
+					err = rdb.HSet(ctx, ordersKey, o.ID, "cancelled").Err()
+				}
+				return err
+			}),
+		"payment": saga.NewParticipant(rdb, orderSaga, "payment", sender,
+			take(rdb, balancesKey, paymentsKey, "insufficient funds", func(o order) (string, int64) { return o.Customer, o.AmountCents }),
+			giveBack(rdb, balancesKey, paymentsKey, func(o order) string { return o.Customer })),
+		"inventory": saga.NewParticipant(rdb, orderSaga, "inventory", sender,
+			take(rdb, stockKey, reservationsKey, "out of stock", func(o order) (string, int64) { return o.SKU, o.Quantity }),
+			giveBack(rdb, stockKey, reservationsKey, func(o order) string { return o.SKU })),
+	}
+
+	var wg sync.WaitGroup
+	consume := func(name, topic string, handle func(ctx context.Context, value []byte) error) {
+		ccfg := cfg
+		ccfg.Topics = []string{topic}
+		ccfg.GroupID = cfg.GroupID + "-saga-" + name
+		c, err := consumer.NewConsumer(ccfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := c.Run(runCtx, func(ctx context.Context, msg *kafka.Message) error {
+				return handle(ctx, msg.Value)
+			})
+			if err != nil {
+				log.Printf("Consumer %s failed: %v", name, err)
+			}
+			if err := c.Close(); err != nil {
+				log.Printf("Consumer %s failed to commit offsets: %v", name, err)
+			}
+		}()
+	}
+
+	consume("orchestrator", orderSaga.ReplyTopic, func(ctx context.Context, value []byte) error {
+		var r saga.Reply
+		if err := json.Unmarshal(value, &r); err != nil {
+			log.Printf("Skipping malformed reply: %v", err)
+			return nil
+		}
+		return orchestrator.HandleReply(ctx, r)
+	})
+	for _, step := range orderSaga.Steps {
+		participant := participants[step.Name]
+		consume(step.Name, step.Topic, func(ctx context.Context, value []byte) error {
+			var c saga.Command
+			if err := json.Unmarshal(value, &c); err != nil {
+				log.Printf("Skipping malformed command: %v", err)
+				return nil
+			}
+			return participant.HandleCommand(ctx, c)
+		})
+	}
+
+	// Fresh ids for every run, the balances and stock are reset
+	run := time.Now().Format("150405")
+	orders := []order{
+		{ID: run + "-1", Customer: "ada", SKU: "book", Quantity: 2, AmountCents: 3000},
+		{ID: run + "-2", Customer: "grace", SKU: "book", Quantity: 1, AmountCents: 1500},
+		{ID: run + "-3", Customer: "ada", SKU: "lamp", Quantity: 1, AmountCents: 4000},
+	}
+	rdb.HSet(ctx, balancesKey, "ada", 10000, "grace", 500)
+	rdb.HSet(ctx, stockKey, "book", 5, "lamp", 0)
+
+	for _, o := range orders {
+		finished.Add(1)
+		if err := orchestrator.Start(ctx, o.ID, o); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Started saga %s: %s buys %d %s", o.ID, o.Customer, o.Quantity, o.SKU)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		finished.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Minute):
+		log.Printf("Sagas did not finish in time")
+	case <-ctx.Done():
+	}
+	cancelRun()
+	wg.Wait()
+
+	for _, o := range orders {
+		s, err := orchestrator.State(context.Background(), o.ID)
+		if err != nil {
+			log.Printf("Failed to read saga %s: %v", o.ID, err)
+			continue
+		}
+		fmt.Printf("Saga %s %s: %v\n", o.ID, s.Status, s.Log)
+	}
+	balances, _ := rdb.HGetAll(context.Background(), balancesKey).Result()
+	stock, _ := rdb.HGetAll(context.Background(), stockKey).Result()
+	fmt.Printf("Balances %v, stock %v\n", balances, stock)
+}
+
+func decodeOrder(payload []byte) (order, error) {
+	var o order
+	if err := json.Unmarshal(payload, &o); err != nil {
+		// Rejected, retrying does not fix the payload
+		return o, fmt.Errorf("%w: malformed order: %v", saga.ErrRejected, err)
+	}
+	return o, nil
+}
+
+// take returns a handler taking the amount of an order from a counter in
+// the hash counters, recorded per saga in the hash records. It rejects
+// with reason if the counter is too low.
+func take(rdb *redis.Client, counters, records, reason string, of func(order) (string, int64)) saga.Handler {
+	return func(ctx context.Context, id string, payload []byte) error {
+		o, err := decodeOrder(payload)
+		if err != nil {
+			return err
+		}
+		field, amount := of(o)
+		ok, err := takeScript.Run(ctx, rdb, []string{counters, records}, field, amount, id).Int()
+		if err != nil {
+			return err
+		}
+		if ok == 0 {
+			return fmt.Errorf("%w: %s", saga.ErrRejected, reason)
+		}
+		return nil
+	}
+}
+
+// giveBack returns the compensation of take.
+func giveBack(rdb *redis.Client, counters, records string, of func(order) string) saga.Handler {
+	return func(ctx context.Context, id string, payload []byte) error {
+		o, err := decodeOrder(payload)
+		if err != nil {
+			return err
+		}
+		return returnScript.Run(ctx, rdb, []string{counters, records}, of(o), id).Err()
+	}
+}
+
+// ensureTopics creates the command and reply topics of the saga unless
+// they exist, with the topic settings of pcfg.
+func ensureTopics(ctx context.Context, pcfg producer.Config) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	topics := []string{orderSaga.ReplyTopic}
+	for _, step := range orderSaga.Steps {
+		topics = append(topics, step.Topic)
+	}
+	for _, topic := range topics {
+		tcfg := pcfg
+		tcfg.Topic = topic
+		p, err := producer.NewProducer(tcfg)
+		if err != nil {
+			return err
+		}
+		err = p.EnsureTopic(ctx)
+		p.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// outcomeTTL is how long a participant remembers the outcome of a
+// command, redeliveries later than that run it again.
+const outcomeTTL = 7 * 24 * time.Hour
+
+// DefaultCompensateAttempts is how often a compensation is tried before
+// the saga fails, see SetCompensateAttempts.
+const DefaultCompensateAttempts = 5
+
+// Handler runs or compensates the step of a participant for the saga with
+// the payload given to Start. Executing returns an error wrapping
+// ErrRejected when the step can not be done; compensating undoes what
+// executing did and must not reject, it is called only after executing
+// succeeded. After a crash a handler may be called again for the same
+// saga, so it must be idempotent. A compensation failing again and again
+// fails the saga, see SetCompensateAttempts.
+type Handler func(ctx context.Context, sagaID string, payload []byte) error
+
+// Participant runs one step of the sagas of a definition.
+type Participant struct {
+	rdb        *redis.Client
+	step       string
+	replyTopic string
+	sender     Sender
+	execute    Handler
+	compensate Handler
+
+	compensateAttempts int64
+}
+
+// NewParticipant returns the participant running step of def, replying
+// on the reply topic of def with sender.
+func NewParticipant(rdb *redis.Client, def Definition, step string, sender Sender, execute, compensate Handler) *Participant {
+	return &Participant{
+		rdb:        rdb,
+		step:       step,
+		replyTopic: def.ReplyTopic,
+		sender:     sender,
+		execute:    execute,
+		compensate: compensate,
+
+		compensateAttempts: DefaultCompensateAttempts,
+	}
+}
+
+// SetCompensateAttempts sets how often a compensation is tried. The
+// command is retried until then, blocking its partition, and afterwards
+// answered with a failure, which fails the saga for manual repair.
+func (p *Participant) SetCompensateAttempts(n int) {
+	p.compensateAttempts = int64(n)
+}
+
+func (p *Participant) outcomeKey(c Command) string {
+	action := "execute"
+	if c.Compensate {
+		action = "compensate"
+	}
+	return fmt.Sprintf("saga:participant:%s:%s:%s", p.step, c.SagaID, action)
+}
+
+// HandleCommand runs c and replies with its outcome. A command handled
+// before gets the same reply again without running. Errors other than
+// rejections are returned, the command is then retried.
+func (p *Participant) HandleCommand(ctx context.Context, c Command) error {
+	if c.Step != p.step {
+		return nil
+	}
+
+	outcome, err := p.rdb.Get(ctx, p.outcomeKey(c)).Result()
+	if err == redis.Nil {
+		outcome, err = p.run(ctx, c)
+	}
+	if err != nil {
+		return err
+	}
+
+	r := Reply{SagaID: c.SagaID, Step: c.Step, Compensate: c.Compensate, OK: outcome == "ok"}
+	if !r.OK {
+		r.Error = strings.TrimPrefix(outcome, "rejected: ")
+	}
+	return send(ctx, p.sender, p.replyTopic, c.SagaID, r)
+}
+
+// run calls the handler of c and records its outcome, "ok" or the reason
+// of the rejection or of giving up the compensation.
+func (p *Participant) run(ctx context.Context, c Command) (string, error) {
+	handler := p.execute
+	if c.Compensate {
+		handler = p.compensate
+	}
+
+	outcome := "ok"
+	if err := handler(ctx, c.SagaID, c.Payload); err != nil {
+		switch {
+		case c.Compensate:
+			failures, cerr := p.countFailure(ctx, c)
+			if cerr != nil {
+				return "", cerr
+			}
+			if failures < p.compensateAttempts {
+				return "", err
+			}
+			outcome = fmt.Sprintf("rejected: gave up after %d attempts: %v", failures, err)
+		case !errors.Is(err, ErrRejected):
+			return "", err
+		default:
+			outcome = "rejected: " + strings.TrimPrefix(err.Error(), ErrRejected.Error()+": ")
+		}
+	}
+
+	if err := p.rdb.Set(ctx, p.outcomeKey(c), outcome, outcomeTTL).Err(); err != nil {
+		return "", fmt.Errorf("failed to record outcome: %v", err)
+	}
+	return outcome, nil
+}
+
+// countFailure counts a failed attempt to run c and returns the failures
+// so far.
+func (p *Participant) countFailure(ctx context.Context, c Command) (int64, error) {
+	key := p.outcomeKey(c) + ":failures"
+	var incr *redis.IntCmd
+	_, err := p.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, outcomeTTL)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count failure: %v", err)
+	}
+	return incr.Val(), nil
+}
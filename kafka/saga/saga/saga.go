@@ -0,0 +1,319 @@
+// Package saga runs distributed transactions as orchestrated sagas over
+// Kafka, with their state in Redis.
+//
+// A saga is a sequence of steps, each a local transaction of a
+// participant service. The orchestrator sends the command of a step to the
+// topic of its participant and waits for the reply on its reply topic
+// before it sends the next one. When a step fails, the orchestrator undoes
+// the steps before it in reverse order by sending their compensation
+// commands, so the saga ends either completed or aborted, never half done.
+//
+// Messages are delivered at least once. The orchestrator ignores replies
+// which do not match the step it waits for, and participants remember the
+// outcome of every command, answering a redelivered one without running
+// it again. Commands and replies are keyed by saga id, so the messages of
+// one saga are handled in order by one consumer.
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	// ErrRejected is wrapped by the errors of participant handlers for
+	// business failures, e.g. insufficient funds. The step then fails and
+	// the saga is compensated; other errors are retried.
+	ErrRejected = errors.New("rejected")
+	// ErrNotFound is returned for sagas which do not exist.
+	ErrNotFound = errors.New("saga not found")
+)
+
+// Statuses of a saga.
+const (
+	StatusRunning      = "running"
+	StatusCompensating = "compensating"
+	StatusCompleted    = "completed"
+	// StatusAborted is a saga whose failed step was compensated.
+	StatusAborted = "aborted"
+	// StatusFailed is a saga whose compensation failed, it needs manual
+	// repair.
+	StatusFailed = "failed"
+)
+
+// stateTTL is how long the state of a finished saga is kept.
+const stateTTL = 7 * 24 * time.Hour
+
+// Command asks a participant to execute or compensate its step of a saga.
+type Command struct {
+	SagaID     string          `json:"saga_id"`
+	Step       string          `json:"step"`
+	Compensate bool            `json:"compensate,omitempty"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// Reply is the outcome of a command.
+type Reply struct {
+	SagaID     string `json:"saga_id"`
+	Step       string `json:"step"`
+	Compensate bool   `json:"compensate,omitempty"`
+	OK         bool   `json:"ok"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Step is a local transaction of the participant consuming Topic.
+type Step struct {
+	Name  string
+	Topic string
+}
+
+// Definition is a kind of saga.
+type Definition struct {
+	Name  string
+	Steps []Step
+	// ReplyTopic is where the participants reply to.
+	ReplyTopic string
+}
+
+// Sender produces a message, e.g. with the producer of the examples.
+type Sender interface {
+	Send(ctx context.Context, topic string, key, value []byte) error
+}
+
+// SenderFunc adapts a function to Sender.
+type SenderFunc func(ctx context.Context, topic string, key, value []byte) error
+
+func (f SenderFunc) Send(ctx context.Context, topic string, key, value []byte) error {
+	return f(ctx, topic, key, value)
+}
+
+// send encodes v and sends it keyed by the saga id.
+func send(ctx context.Context, s Sender, topic, sagaID string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := s.Send(ctx, topic, []byte(sagaID), value); err != nil {
+		return fmt.Errorf("failed to send to %s: %v", topic, err)
+	}
+	return nil
+}
+
+// State is where a saga is.
+type State struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// Step is the index of the step whose reply the saga waits for, or
+	// the last step it ran once finished.
+	Step      int             `json:"step"`
+	Payload   json.RawMessage `json:"payload"`
+	Error     string          `json:"error,omitempty"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	// Log lists what happened, oldest first.
+	Log []string `json:"log"`
+}
+
+// Finished reports whether the saga ended.
+func (s *State) Finished() bool {
+	return s.Status != StatusRunning && s.Status != StatusCompensating
+}
+
+// Orchestrator runs the sagas of a definition.
+type Orchestrator struct {
+	rdb      *redis.Client
+	def      Definition
+	sender   Sender
+	onFinish func(ctx context.Context, s State)
+}
+
+func NewOrchestrator(rdb *redis.Client, def Definition, sender Sender) *Orchestrator {
+	return &Orchestrator{rdb: rdb, def: def, sender: sender}
+}
+
+// OnFinish calls fn with the state of every saga which completed, aborted
+// or failed, e.g. to approve or reject an order.
+func (o *Orchestrator) OnFinish(fn func(ctx context.Context, s State)) {
+	o.onFinish = fn
+}
+
+func (o *Orchestrator) stateKey(id string) string {
+	return fmt.Sprintf("saga:%s:%s", o.def.Name, id)
+}
+
+func (o *Orchestrator) logKey(id string) string {
+	return fmt.Sprintf("saga:%s:%s:log", o.def.Name, id)
+}
+
+// Start starts the saga id with payload, the input of all its steps.
+// Starting a saga which exists already does nothing.
+func (o *Orchestrator) Start(ctx context.Context, id string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	created, err := o.rdb.HSetNX(ctx, o.stateKey(id), "status", StatusRunning).Result()
+	if err != nil {
+		return fmt.Errorf("failed to create saga %s: %v", id, err)
+	}
+	if !created {
+		return nil
+	}
+
+	// Saved before the command is sent, the reply may come back at once
+	s := State{ID: id, Status: StatusRunning, Step: 0, Payload: data}
+	err = o.save(ctx, s, "started")
+	if err == nil {
+		err = o.command(ctx, s, false)
+	}
+	if err != nil {
+		o.rdb.Del(context.WithoutCancel(ctx), o.stateKey(id), o.logKey(id))
+		return err
+	}
+	return nil
+}
+
+// HandleReply advances the saga of r: to the next step, into compensation
+// after a failure, or to its end. Replies the saga does not wait for, e.g.
+// redelivered ones, are ignored.
+func (o *Orchestrator) HandleReply(ctx context.Context, r Reply) error {
+	s, err := o.State(ctx, r.SagaID)
+	if errors.Is(err, ErrNotFound) {
+		log.Printf("Ignoring reply for unknown saga %s", r.SagaID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if s.Finished() || r.Step != o.def.Steps[s.Step].Name || r.Compensate != (s.Status == StatusCompensating) {
+		return nil
+	}
+
+	var event string
+	switch {
+	case !r.Compensate && r.OK:
+		event = fmt.Sprintf("%s executed", r.Step)
+		if s.Step == len(o.def.Steps)-1 {
+			s.Status = StatusCompleted
+			break
+		}
+		s.Step++
+		if err := o.command(ctx, s, false); err != nil {
+			return err
+		}
+
+	case !r.Compensate:
+		event = fmt.Sprintf("%s failed: %s", r.Step, r.Error)
+		s.Error = r.Error
+		if s.Step == 0 {
+			s.Status = StatusAborted
+			break
+		}
+		s.Status = StatusCompensating
+		s.Step--
+		if err := o.command(ctx, s, true); err != nil {
+			return err
+		}
+
+	case r.OK:
+		event = fmt.Sprintf("%s compensated", r.Step)
+		if s.Step == 0 {
+			s.Status = StatusAborted
+			break
+		}
+		s.Step--
+		if err := o.command(ctx, s, true); err != nil {
+			return err
+		}
+
+	default:
+		event = fmt.Sprintf("%s compensation failed: %s", r.Step, r.Error)
+		s.Status = StatusFailed
+		s.Error = r.Error
+	}
+
+	// The command is sent before the state is saved. If saving fails the
+	// reply is handled again and the command sent twice, which the
+	// participant recognizes. The reply to the command can not overtake
+	// the save, the replies of a saga are handled one after another.
+	if err := o.save(ctx, s, event); err != nil {
+		return err
+	}
+	if s.Finished() && o.onFinish != nil {
+		o.onFinish(ctx, s)
+	}
+	return nil
+}
+
+// command sends the command of the current step of s.
+func (o *Orchestrator) command(ctx context.Context, s State, compensate bool) error {
+	step := o.def.Steps[s.Step]
+	return send(ctx, o.sender, step.Topic, s.ID, Command{
+		SagaID:     s.ID,
+		Step:       step.Name,
+		Compensate: compensate,
+		Payload:    s.Payload,
+	})
+}
+
+// save writes s and appends event to its log, a finished saga expires.
+func (o *Orchestrator) save(ctx context.Context, s State, event string) error {
+	key, logKey := o.stateKey(s.ID), o.logKey(s.ID)
+	_, err := o.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key,
+			"status", s.Status,
+			"step", s.Step,
+			"payload", []byte(s.Payload),
+			"error", s.Error,
+			"updated_at", time.Now().UnixMilli(),
+		)
+		pipe.RPush(ctx, logKey, event)
+		if s.Finished() {
+			pipe.Expire(ctx, key, stateTTL)
+			pipe.Expire(ctx, logKey, stateTTL)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save saga %s: %v", s.ID, err)
+	}
+	return nil
+}
+
+// State returns the state of the saga id.
+func (o *Orchestrator) State(ctx context.Context, id string) (State, error) {
+	var fields *redis.StringStringMapCmd
+	var log *redis.StringSliceCmd
+	_, err := o.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		fields = pipe.HGetAll(ctx, o.stateKey(id))
+		log = pipe.LRange(ctx, o.logKey(id), 0, -1)
+		return nil
+	})
+	if err != nil {
+		return State{}, err
+	}
+	f := fields.Val()
+	if len(f) == 0 {
+		return State{}, ErrNotFound
+	}
+
+	s := State{
+		ID:      id,
+		Status:  f["status"],
+		Payload: json.RawMessage(f["payload"]),
+		Error:   f["error"],
+		Log:     log.Val(),
+	}
+	s.Step, _ = strconv.Atoi(f["step"])
+	if ms, err := strconv.ParseInt(f["updated_at"], 10, 64); err == nil {
+		s.UpdatedAt = time.UnixMilli(ms).UTC()
+	}
+	return s, nil
+}
@@ -0,0 +1,257 @@
+//go:build integration
+
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"kate.testutil"
+)
+
+var testSaga = Definition{
+	Name: "test",
+	Steps: []Step{
+		{Name: "order", Topic: "order.commands"},
+		{Name: "payment", Topic: "payment.commands"},
+		{Name: "inventory", Topic: "inventory.commands"},
+	},
+	ReplyTopic: "test.replies",
+}
+
+type message struct {
+	topic string
+	value []byte
+}
+
+// bus delivers the messages of a saga in memory instead of over Kafka,
+// recording all of them so tests can redeliver.
+type bus struct {
+	t            *testing.T
+	orchestrator *Orchestrator
+	participants map[string]*Participant
+	queue        []message
+	sent         []message
+}
+
+func (b *bus) Send(ctx context.Context, topic string, key, value []byte) error {
+	m := message{topic: topic, value: value}
+	b.queue = append(b.queue, m)
+	b.sent = append(b.sent, m)
+	return nil
+}
+
+// deliver handles queued messages until none are left.
+func (b *bus) deliver(ctx context.Context) {
+	b.t.Helper()
+	for len(b.queue) > 0 {
+		m := b.queue[0]
+		b.queue = b.queue[1:]
+		b.handle(ctx, m)
+	}
+}
+
+func (b *bus) handle(ctx context.Context, m message) {
+	b.t.Helper()
+	if err := b.dispatch(ctx, m); err != nil {
+		b.t.Fatal(err)
+	}
+}
+
+// dispatch hands m to the orchestrator or the participant of its step.
+func (b *bus) dispatch(ctx context.Context, m message) error {
+	b.t.Helper()
+	if m.topic == testSaga.ReplyTopic {
+		var r Reply
+		if err := json.Unmarshal(m.value, &r); err != nil {
+			b.t.Fatal(err)
+		}
+		return b.orchestrator.HandleReply(ctx, r)
+	}
+	var c Command
+	if err := json.Unmarshal(m.value, &c); err != nil {
+		b.t.Fatal(err)
+	}
+	return b.participants[c.Step].HandleCommand(ctx, c)
+}
+
+// newTestBus returns a bus with participants recording their calls in
+// calls, the step named reject rejects.
+func newTestBus(t *testing.T, rdb *redis.Client, reject string, calls *[]string) *bus {
+	b := &bus{t: t, participants: make(map[string]*Participant)}
+	b.orchestrator = NewOrchestrator(rdb, testSaga, b)
+	for _, step := range testSaga.Steps {
+		execute := func(ctx context.Context, id string, payload []byte) error {
+			*calls = append(*calls, step.Name)
+			if step.Name == reject {
+				return fmt.Errorf("%w: %s is not available", ErrRejected, step.Name)
+			}
+			return nil
+		}
+		compensate := func(ctx context.Context, id string, payload []byte) error {
+			*calls = append(*calls, "undo "+step.Name)
+			return nil
+		}
+		b.participants[step.Name] = NewParticipant(rdb, testSaga, step.Name, b, execute, compensate)
+	}
+	return b
+}
+
+func TestSagaCompletes(t *testing.T) {
+	ctx := context.Background()
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+
+	var calls []string
+	b := newTestBus(t, rdb, "", &calls)
+
+	var finished []State
+	b.orchestrator.OnFinish(func(ctx context.Context, s State) {
+		finished = append(finished, s)
+	})
+
+	if err := b.orchestrator.Start(ctx, "1", map[string]int{"amount": 42}); err != nil {
+		t.Fatal(err)
+	}
+	// Starting again does nothing
+	if err := b.orchestrator.Start(ctx, "1", map[string]int{"amount": 42}); err != nil {
+		t.Fatal(err)
+	}
+	b.deliver(ctx)
+
+	if want := []string{"order", "payment", "inventory"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	s, err := b.orchestrator.State(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Status != StatusCompleted || string(s.Payload) != `{"amount":42}` {
+		t.Errorf("state = %+v", s)
+	}
+	wantLog := []string{"started", "order executed", "payment executed", "inventory executed"}
+	if !reflect.DeepEqual(s.Log, wantLog) {
+		t.Errorf("log = %q, want %q", s.Log, wantLog)
+	}
+	if len(finished) != 1 || finished[0].Status != StatusCompleted {
+		t.Errorf("finished = %+v", finished)
+	}
+	if ttl := rdb.TTL(ctx, "saga:test:1").Val(); ttl <= 0 {
+		t.Errorf("TTL of finished saga = %v, want expiry", ttl)
+	}
+
+	if _, err := b.orchestrator.State(ctx, "2"); err != ErrNotFound {
+		t.Errorf("missing saga: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestSagaCompensates(t *testing.T) {
+	ctx := context.Background()
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+
+	var calls []string
+	b := newTestBus(t, rdb, "inventory", &calls)
+	if err := b.orchestrator.Start(ctx, "1", "order"); err != nil {
+		t.Fatal(err)
+	}
+	b.deliver(ctx)
+
+	want := []string{"order", "payment", "inventory", "undo payment", "undo order"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	s, err := b.orchestrator.State(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Status != StatusAborted || s.Error != "inventory is not available" {
+		t.Errorf("state = %+v", s)
+	}
+	wantLog := []string{
+		"started",
+		"order executed",
+		"payment executed",
+		"inventory failed: inventory is not available",
+		"payment compensated",
+		"order compensated",
+	}
+	if !reflect.DeepEqual(s.Log, wantLog) {
+		t.Errorf("log = %q, want %q", s.Log, wantLog)
+	}
+}
+
+func TestSagaIgnoresRedeliveries(t *testing.T) {
+	ctx := context.Background()
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+
+	var calls []string
+	b := newTestBus(t, rdb, "payment", &calls)
+	if err := b.orchestrator.Start(ctx, "1", "order"); err != nil {
+		t.Fatal(err)
+	}
+	b.deliver(ctx)
+
+	// Every command and reply once more: the participants answer from
+	// their recorded outcomes and the orchestrator ignores the replies.
+	sent := b.sent
+	for _, m := range sent {
+		b.handle(ctx, m)
+	}
+	b.deliver(ctx)
+
+	if want := []string{"order", "payment", "undo order"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	s, err := b.orchestrator.State(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLog := []string{"started", "order executed", "payment failed: payment is not available", "order compensated"}
+	if s.Status != StatusAborted || !reflect.DeepEqual(s.Log, wantLog) {
+		t.Errorf("state = %+v", s)
+	}
+}
+
+func TestSagaFailsWhenCompensationFails(t *testing.T) {
+	ctx := context.Background()
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+
+	var calls []string
+	b := newTestBus(t, rdb, "inventory", &calls)
+	payment := NewParticipant(rdb, testSaga, "payment", b,
+		func(ctx context.Context, id string, payload []byte) error { return nil },
+		func(ctx context.Context, id string, payload []byte) error { return fmt.Errorf("refunds are down") })
+	payment.SetCompensateAttempts(3)
+	b.participants["payment"] = payment
+
+	if err := b.orchestrator.Start(ctx, "1", "order"); err != nil {
+		t.Fatal(err)
+	}
+	// A failed message is delivered again, as the consumer retries it
+	failures := 0
+	for len(b.queue) > 0 {
+		m := b.queue[0]
+		if err := b.dispatch(ctx, m); err != nil {
+			failures++
+			continue
+		}
+		b.queue = b.queue[1:]
+	}
+
+	if failures != 2 {
+		t.Errorf("failures = %d, want 2", failures)
+	}
+	s, err := b.orchestrator.State(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Status != StatusFailed || s.Error != "gave up after 3 attempts: refunds are down" {
+		t.Errorf("state = %+v", s)
+	}
+	if want := []string{"order", "inventory"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v, order must not be compensated", calls, want)
+	}
+}
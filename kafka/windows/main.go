@@ -0,0 +1,330 @@
+// Windowed aggregation worker: consumes page view events and aggregates
+// them per page into time windows in Redis, see the windows package, and
+// serves the windows over HTTP:
+//
+//	go run ./windows -topics pageviews -group-id windows 500
+//
+//	curl localhost:8091/status
+//	curl 'localhost:8091/windows/home?from=2025-01-01T10:00:00Z&to=2025-01-01T11:00:00Z'
+//	curl 'localhost:8091/top?at=2025-01-01T10:30:00Z&limit=3'
+//
+// Every window has the number of views of a page, the sum, minimum and
+// maximum of the seconds spent on it and the number of distinct users.
+// Offsets are committed after Redis added an event, events are delivered
+// at least once and added once thanks to their ids.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/google/uuid"
+	"kate.config"
+	"kate.kafka.example/consumer/consumer"
+	"kate.kafka.example/producer/producer"
+	"kate.kafka.example/windows/windows"
+)
+
+const usage = `Usage: windows [flags] [<events>]
+
+Consumes the page view events of -topics as member of -group-id and
+aggregates them into windows in Redis. With <events> that many random
+events are produced to the first of -topics first, every tenth twice and
+every twentieth too late to count.
+
+The windows are set with WINDOW_NAME (default pageviews), WINDOW_SIZE
+(default 1m), WINDOW_SLIDE (default the size, tumbling windows),
+WINDOW_LATENESS (default 30s) and WINDOW_RETENTION (default 24h). The HTTP
+API listens on HTTP_ADDR (default :8091). Redis is set with REDIS_ADDR
+(default localhost:6379), REDIS_USERNAME, REDIS_PASSWORD and REDIS_DB,
+other flags are shared with the consumer, see consumer -h.
+`
+
+// event is the view of a page, the value of every message as JSON. Events
+// without time are taken to happen at the timestamp of their message.
+type event struct {
+	ID      string    `json:"id"`
+	Page    string    `json:"page"`
+	User    string    `json:"user"`
+	Seconds float64   `json:"seconds"`
+	Time    time.Time `json:"time"`
+}
+
+var pages = []string{"home", "about", "pricing", "blog", "docs"}
+
+// windowConfig is the name of the aggregated stream and its windows.
+type windowConfig struct {
+	Name    string
+	Options windows.Options
+}
+
+func defaultWindowConfig() windowConfig {
+	return windowConfig{
+		Name: "pageviews",
+		Options: windows.Options{
+			Size:      time.Minute,
+			Lateness:  30 * time.Second,
+			Retention: 24 * time.Hour,
+		},
+	}
+}
+
+func (c *windowConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Name, "window-name", c.Name, "name of the stream in the Redis keys")
+	fs.DurationVar(&c.Options.Size, "window-size", c.Options.Size, "length of a window")
+	fs.DurationVar(&c.Options.Slide, "window-slide", c.Options.Slide, "how often a window starts, 0 for tumbling windows")
+	fs.DurationVar(&c.Options.Lateness, "window-lateness", c.Options.Lateness, "how long a window accepts events after the stream time passed its end")
+	fs.DurationVar(&c.Options.Retention, "window-retention", c.Options.Retention, "how long windows are kept after they ended")
+}
+
+func main() {
+	cfg, args, err := consumer.LoadConfigArgs(os.Args[0], os.Args[1:])
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+	events, err := parseArgs(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n%s", err, usage)
+		os.Exit(2)
+	}
+
+	rcfg := config.DefaultRedisConfig()
+	wcfg := defaultWindowConfig()
+	hcfg := config.DefaultHTTPConfig()
+	// Kafka UI of the docker compose setup is on 8080, orders on 8090
+	hcfg.Addr = ":8091"
+	for _, register := range []func(*flag.FlagSet){rcfg.RegisterFlags, wcfg.registerFlags, hcfg.RegisterFlags} {
+		if err := config.ApplyEnv("", register); err != nil {
+			log.Fatal("Invalid configuration: ", err)
+		}
+	}
+	if err := hcfg.Validate(); err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	rdb := rcfg.NewClient()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		log.Fatal("Redis connection failed: ", err)
+	}
+	defer rdb.Close()
+
+	agg, err := windows.New(rdb, wcfg.Name, wcfg.Options)
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	var wg sync.WaitGroup
+	if events > 0 {
+		pcfg := producer.DefaultConfig()
+		pcfg.KafkaConfig = cfg.KafkaConfig
+		pcfg.Topic = cfg.Topics[0]
+		pcfg.Format = producer.FormatJSON
+		pcfg.ContentType = "application/json"
+		pcfg.Source = "go-examples-windows"
+		p, err := producer.NewProducer(pcfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		topicCtx, cancelTopic := context.WithTimeout(ctx, 10*time.Second)
+		err = p.EnsureTopic(topicCtx)
+		cancelTopic()
+		if err != nil {
+			p.Close()
+			log.Fatal(err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer p.Close()
+			produce(ctx, p, events, pcfg.FlushTimeoutMs, agg.Options())
+		}()
+	}
+
+	c, err := consumer.NewConsumer(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	srv := hcfg.Server(newMux(agg))
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		log.Printf("Serving windows on %s", hcfg.Addr)
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Printf("Failed to serve HTTP: %v", err)
+			stop()
+		}
+	}()
+
+	opts := agg.Options()
+	log.Printf("Aggregating %v into %v windows every %v, %v lateness", cfg.Topics, opts.Size, opts.Slide, opts.Lateness)
+	err = c.Run(ctx, func(ctx context.Context, msg *kafka.Message) error {
+		var e event
+		if err := json.Unmarshal(msg.Value, &e); err != nil || e.Page == "" {
+			// Retrying does not fix a malformed event
+			log.Printf("Skipping malformed event at %s", msg.TopicPartition)
+			return nil
+		}
+		if e.Time.IsZero() {
+			e.Time = msg.Timestamp
+		}
+
+		outcome, err := agg.Add(ctx, windows.Event{ID: e.ID, Group: e.Page, Value: e.Seconds, Distinct: e.User, Time: e.Time})
+		if err != nil {
+			return err
+		}
+		if outcome != windows.Added {
+			log.Printf("Skipping %s event %s of %s at %s", outcome, e.ID, e.Page, e.Time.Format(time.RFC3339))
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Consuming failed: %v\n", err)
+	}
+
+	srv.Shutdown(context.Background())
+	stop()
+	wg.Wait()
+	if err := c.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to commit offsets: %v\n", err)
+	}
+}
+
+func parseArgs(args []string) (int, error) {
+	switch len(args) {
+	case 0:
+		return 0, nil
+	case 1:
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid number of events %q", args[0])
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("invalid arguments %q", args)
+}
+
+// produce sends n random page views of the last seconds, keyed by page.
+// Every tenth is sent twice, as by a producer retrying after a lost ack,
+// and every twentieth is older than a window and its lateness.
+func produce(ctx context.Context, p *producer.Producer, n, flushTimeoutMs int, opts windows.Options) {
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		e := event{
+			ID:      uuid.New().String(),
+			Page:    pages[rand.IntN(len(pages))],
+			User:    fmt.Sprintf("user-%d", rand.IntN(50)),
+			Seconds: float64(rand.IntN(3000)) / 10,
+			Time:    time.Now().Add(-rand.N(10 * time.Second)),
+		}
+		if i%20 == 19 {
+			e.Time = e.Time.Add(-opts.Size - opts.Lateness)
+		}
+		value, err := p.Serialize(e)
+		if err != nil {
+			log.Printf("Failed to encode event %s: %v", e.ID, err)
+			continue
+		}
+
+		msg := producer.Message{Key: []byte(e.Page), Value: value}
+		copies := 1
+		if i%10 == 9 {
+			copies = 2
+		}
+		for j := 0; j < copies; j++ {
+			if err := p.Produce(msg); err != nil {
+				log.Printf("Failed to produce event %s: %v", e.ID, err)
+			}
+		}
+	}
+
+	remaining := p.Shutdown(flushTimeoutMs)
+	s := p.Stats()
+	log.Printf("Produced events, delivered: %d, failed: %d, unsent: %d", s.Delivered, s.Failed, remaining)
+}
+
+// newMux serves the stream status, the windows of a page and the pages
+// viewed most in a window. Times are RFC 3339.
+func newMux(agg *windows.Aggregator) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		status, err := agg.Status(r.Context())
+		writeJSON(w, status, err)
+	})
+	// from and to default to the ten windows before now
+	mux.HandleFunc("GET /windows/{group}", func(w http.ResponseWriter, r *http.Request) {
+		to, err := timeParam(r, "to", time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		from, err := timeParam(r, "from", to.Add(-10*agg.Options().Slide))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ws, err := agg.Windows(r.Context(), r.PathValue("group"), from, to)
+		writeJSON(w, ws, err)
+	})
+	// at defaults to now, limit to 10
+	mux.HandleFunc("GET /top", func(w http.ResponseWriter, r *http.Request) {
+		at, err := timeParam(r, "at", time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit := 10
+		if s := r.URL.Query().Get("limit"); s != "" {
+			if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > 100 {
+				http.Error(w, "Invalid limit, want 1 to 100", http.StatusBadRequest)
+				return
+			}
+		}
+		start, groups, err := agg.Top(r.Context(), at, limit)
+		writeJSON(w, map[string]interface{}{"start": start, "end": start.Add(agg.Options().Size), "groups": groups}, err)
+	})
+	return mux
+}
+
+// timeParam returns the RFC 3339 query parameter name, or def if unset.
+func timeParam(r *http.Request, name string, def time.Time) (time.Time, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %q, want RFC 3339", name, s)
+	}
+	return t, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}, err error) {
+	switch {
+	case errors.Is(err, windows.ErrInvalidRange):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
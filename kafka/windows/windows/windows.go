@@ -0,0 +1,377 @@
+// Package windows aggregates a stream of events into time windows in
+// Redis, like the windowed aggregations of Kafka Streams.
+//
+// Every event has a time, a group (e.g. the page viewed), a value and
+// optionally a distinct key (e.g. the user). Per group and window Redis
+// keeps the count, sum, minimum and maximum of the values in a hash and
+// the distinct keys in a HyperLogLog, which estimates their number in at
+// most 12 KB however many there are. Tumbling windows of Size follow each
+// other, sliding windows of Size start every Slide, so an event falls
+// into Size/Slide of them.
+//
+// Events arrive out of order. The stream time is the latest event time
+// seen and the watermark trails it by Lateness. A window which ended
+// before the watermark is closed: its results are final and events for it
+// are dropped as late. An event is added in one script, with its id when
+// it has one, so a redelivered event is skipped.
+package windows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	// ErrInvalidEvent is returned for events without group or time.
+	ErrInvalidEvent = errors.New("invalid event")
+	// ErrInvalidRange is returned for queries of a negative range or of
+	// more than maxQueryWindows windows.
+	ErrInvalidRange = errors.New("invalid window range")
+)
+
+const (
+	// maxEventWindows bounds Size/Slide, the windows an event is added to.
+	maxEventWindows = 100
+	// maxQueryWindows bounds the windows returned by one query.
+	maxQueryWindows = 1000
+)
+
+// Outcome is what Add did with an event.
+type Outcome int
+
+const (
+	// Added events were added to at least one open window.
+	Added Outcome = iota
+	// Duplicate events have an id which was added before.
+	Duplicate
+	// Late events fell into closed windows only and were dropped.
+	Late
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Added:
+		return "added"
+	case Duplicate:
+		return "duplicate"
+	case Late:
+		return "late"
+	}
+	return fmt.Sprintf("Outcome(%d)", int(o))
+}
+
+// addScript adds an event to its windows which are still open.
+//
+// KEYS[1] is the clock hash with the stream time and the number of late
+// events, KEYS[2] the id of the event, then follow the stats hash, the
+// distinct HyperLogLog and the groups sorted set of every window. ARGV are
+// the event time, the lateness, the retention, whether the event has an
+// id, its value, distinct key and group, the window size, all in
+// milliseconds, and then the start of every window.
+//
+// It returns the outcome and the stream time.
+var addScript = redis.NewScript(`
+local t = tonumber(ARGV[1])
+local streamTime = tonumber(redis.call('HGET', KEYS[1], 'stream_time') or t)
+if ARGV[4] == '1' and redis.call('EXISTS', KEYS[2]) == 1 then
+  return {1, streamTime}
+end
+if t > streamTime then
+  streamTime = t
+end
+redis.call('HSET', KEYS[1], 'stream_time', streamTime)
+
+local watermark = streamTime - tonumber(ARGV[2])
+local size = tonumber(ARGV[8])
+local value = tonumber(ARGV[5])
+local expireAt = 0
+for i = 9, #ARGV do
+  local finish = tonumber(ARGV[i]) + size
+  if finish > watermark then
+    local k = 3 + (i - 9) * 3
+    redis.call('HINCRBY', KEYS[k], 'count', 1)
+    redis.call('HINCRBYFLOAT', KEYS[k], 'sum', ARGV[5])
+    local min = redis.call('HGET', KEYS[k], 'min')
+    if not min or value < tonumber(min) then
+      redis.call('HSET', KEYS[k], 'min', ARGV[5])
+    end
+    local max = redis.call('HGET', KEYS[k], 'max')
+    if not max or value > tonumber(max) then
+      redis.call('HSET', KEYS[k], 'max', ARGV[5])
+    end
+    if ARGV[6] ~= '' then
+      redis.call('PFADD', KEYS[k + 1], ARGV[6])
+    end
+    redis.call('ZINCRBY', KEYS[k + 2], 1, ARGV[7])
+
+    local at = finish + tonumber(ARGV[3])
+    for j = k, k + 2 do
+      redis.call('PEXPIREAT', KEYS[j], at)
+    end
+    expireAt = math.max(expireAt, at)
+  end
+end
+
+if expireAt == 0 then
+  redis.call('HINCRBY', KEYS[1], 'late', 1)
+  return {2, streamTime}
+end
+if ARGV[4] == '1' then
+  redis.call('SET', KEYS[2], 1)
+  redis.call('PEXPIREAT', KEYS[2], expireAt)
+end
+return {0, streamTime}
+`)
+
+// Options configure an Aggregator.
+type Options struct {
+	// Size is the length of a window, default 1m.
+	Size time.Duration
+	// Slide is how often a window starts, Size divided by a whole number.
+	// 0 or Size for tumbling windows.
+	Slide time.Duration
+	// Lateness is how long after the stream time passed its end a window
+	// still accepts events.
+	Lateness time.Duration
+	// Retention is how long windows are kept after they ended, default 24h.
+	Retention time.Duration
+}
+
+// Event is an event to aggregate.
+type Event struct {
+	// ID identifies the event for skipping duplicates, optional.
+	ID       string
+	Group    string
+	Value    float64
+	Distinct string
+	Time     time.Time
+}
+
+// Window is the aggregate of the events of a group in a window.
+type Window struct {
+	Group    string    `json:"group"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Count    int64     `json:"count"`
+	Sum      float64   `json:"sum"`
+	Min      float64   `json:"min"`
+	Max      float64   `json:"max"`
+	Distinct int64     `json:"distinct"`
+	// Closed windows passed the watermark, their results are final.
+	Closed bool `json:"closed"`
+}
+
+// GroupCount is the number of events of a group in a window.
+type GroupCount struct {
+	Group string `json:"group"`
+	Count int64  `json:"count"`
+}
+
+// Status is the progress of the stream.
+type Status struct {
+	StreamTime time.Time `json:"stream_time"`
+	Watermark  time.Time `json:"watermark"`
+	// Late is the number of events dropped as late.
+	Late int64 `json:"late"`
+}
+
+// Aggregator keeps the windows of a stream under windows:<name>:* in Redis.
+type Aggregator struct {
+	rdb    *redis.Client
+	prefix string
+	opts   Options
+}
+
+// New returns an aggregator of the stream name, it fails if the options
+// are inconsistent.
+func New(rdb *redis.Client, name string, opts Options) (*Aggregator, error) {
+	if opts.Size == 0 {
+		opts.Size = time.Minute
+	}
+	if opts.Slide == 0 {
+		opts.Slide = opts.Size
+	}
+	if opts.Retention == 0 {
+		opts.Retention = 24 * time.Hour
+	}
+	switch {
+	case opts.Size < time.Millisecond || opts.Slide < time.Millisecond:
+		return nil, fmt.Errorf("window size and slide must be at least 1ms")
+	case opts.Slide > opts.Size || opts.Size%opts.Slide != 0:
+		return nil, fmt.Errorf("window size %v is not a multiple of the slide %v", opts.Size, opts.Slide)
+	case opts.Size/opts.Slide > maxEventWindows:
+		return nil, fmt.Errorf("window size %v is more than %d times the slide %v", opts.Size, maxEventWindows, opts.Slide)
+	case opts.Lateness < 0 || opts.Retention < 0:
+		return nil, fmt.Errorf("lateness and retention must not be negative")
+	}
+	return &Aggregator{rdb: rdb, prefix: "windows:" + name, opts: opts}, nil
+}
+
+// Options returns the options with the defaults applied.
+func (a *Aggregator) Options() Options {
+	return a.opts
+}
+
+func (a *Aggregator) clockKey() string { return a.prefix + ":clock" }
+
+func (a *Aggregator) seenKey(id string) string { return a.prefix + ":seen:" + id }
+
+func (a *Aggregator) statsKey(start int64, group string) string {
+	return fmt.Sprintf("%s:%d:stats:%s", a.prefix, start, group)
+}
+
+func (a *Aggregator) distinctKey(start int64, group string) string {
+	return fmt.Sprintf("%s:%d:distinct:%s", a.prefix, start, group)
+}
+
+func (a *Aggregator) groupsKey(start int64) string {
+	return fmt.Sprintf("%s:%d:groups", a.prefix, start)
+}
+
+// Add adds e to the open windows it falls into.
+func (a *Aggregator) Add(ctx context.Context, e Event) (Outcome, error) {
+	if e.Group == "" || e.Time.IsZero() {
+		return 0, fmt.Errorf("%w: group and time are required", ErrInvalidEvent)
+	}
+
+	t := e.Time.UnixMilli()
+	hasID := ""
+	if e.ID != "" {
+		hasID = "1"
+	}
+	keys := []string{a.clockKey(), a.seenKey(e.ID)}
+	args := []interface{}{
+		t, a.opts.Lateness.Milliseconds(), a.opts.Retention.Milliseconds(), hasID,
+		strconv.FormatFloat(e.Value, 'f', -1, 64), e.Distinct, e.Group, a.opts.Size.Milliseconds(),
+	}
+	for _, start := range a.starts(t) {
+		keys = append(keys, a.statsKey(start, e.Group), a.distinctKey(start, e.Group), a.groupsKey(start))
+		args = append(args, start)
+	}
+
+	res, err := addScript.Run(ctx, a.rdb, keys, args...).Int64Slice()
+	if err != nil {
+		return 0, fmt.Errorf("failed to add event to windows: %v", err)
+	}
+	return Outcome(res[0]), nil
+}
+
+// starts returns the starts of the windows containing t, latest first.
+func (a *Aggregator) starts(t int64) []int64 {
+	size, slide := a.opts.Size.Milliseconds(), a.opts.Slide.Milliseconds()
+	var starts []int64
+	for start := floor(t, slide); start > t-size; start -= slide {
+		starts = append(starts, start)
+	}
+	return starts
+}
+
+// floor rounds t down to a multiple of d, also before 1970.
+func floor(t, d int64) int64 {
+	r := t % d
+	if r < 0 {
+		r += d
+	}
+	return t - r
+}
+
+// Status returns the stream time, the watermark and the number of late
+// events. They are zero before the first event.
+func (a *Aggregator) Status(ctx context.Context) (Status, error) {
+	vals, err := a.rdb.HMGet(ctx, a.clockKey(), "stream_time", "late").Result()
+	if err != nil {
+		return Status{}, fmt.Errorf("failed to get stream time: %v", err)
+	}
+
+	var s Status
+	if v, ok := vals[0].(string); ok {
+		ms, _ := strconv.ParseInt(v, 10, 64)
+		s.StreamTime = time.UnixMilli(ms).UTC()
+		s.Watermark = s.StreamTime.Add(-a.opts.Lateness)
+	}
+	if v, ok := vals[1].(string); ok {
+		s.Late, _ = strconv.ParseInt(v, 10, 64)
+	}
+	return s, nil
+}
+
+// Windows returns the windows of group starting in [from, to), oldest
+// first. Windows without events are left out.
+func (a *Aggregator) Windows(ctx context.Context, group string, from, to time.Time) ([]Window, error) {
+	slide := a.opts.Slide.Milliseconds()
+	first := -floor(-from.UnixMilli(), slide)
+	end := to.UnixMilli()
+	if end < first {
+		return nil, fmt.Errorf("%w: %v is before %v", ErrInvalidRange, to, from)
+	}
+	if n := (end - first) / slide; n > maxQueryWindows {
+		return nil, fmt.Errorf("%w: %d windows, at most %d", ErrInvalidRange, n, maxQueryWindows)
+	}
+
+	status, err := a.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	type window struct {
+		start    int64
+		stats    *redis.StringStringMapCmd
+		distinct *redis.IntCmd
+	}
+	var windows []window
+	_, err = a.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for start := first; start < end; start += slide {
+			windows = append(windows, window{
+				start:    start,
+				stats:    pipe.HGetAll(ctx, a.statsKey(start, group)),
+				distinct: pipe.PFCount(ctx, a.distinctKey(start, group)),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get windows of %s: %v", group, err)
+	}
+
+	var result []Window
+	for _, w := range windows {
+		stats := w.stats.Val()
+		if len(stats) == 0 {
+			continue
+		}
+		win := Window{
+			Group:    group,
+			Start:    time.UnixMilli(w.start).UTC(),
+			End:      time.UnixMilli(w.start).Add(a.opts.Size).UTC(),
+			Distinct: w.distinct.Val(),
+		}
+		win.Count, _ = strconv.ParseInt(stats["count"], 10, 64)
+		win.Sum, _ = strconv.ParseFloat(stats["sum"], 64)
+		win.Min, _ = strconv.ParseFloat(stats["min"], 64)
+		win.Max, _ = strconv.ParseFloat(stats["max"], 64)
+		win.Closed = !status.StreamTime.IsZero() && !win.End.After(status.Watermark)
+		result = append(result, win)
+	}
+	return result, nil
+}
+
+// Top returns the n groups with the most events in the window starting
+// at or last before at, most first, and that window's start.
+func (a *Aggregator) Top(ctx context.Context, at time.Time, n int) (time.Time, []GroupCount, error) {
+	start := floor(at.UnixMilli(), a.opts.Slide.Milliseconds())
+	zs, err := a.rdb.ZRevRangeWithScores(ctx, a.groupsKey(start), 0, int64(n)-1).Result()
+	if err != nil {
+		return time.Time{}, nil, fmt.Errorf("failed to get top groups: %v", err)
+	}
+
+	groups := make([]GroupCount, len(zs))
+	for i, z := range zs {
+		groups[i] = GroupCount{Group: z.Member.(string), Count: int64(z.Score)}
+	}
+	return time.UnixMilli(start).UTC(), groups, nil
+}
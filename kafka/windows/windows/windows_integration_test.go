@@ -0,0 +1,145 @@
+//go:build integration
+
+package windows
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"kate.testutil"
+)
+
+var base = time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+
+func newTestAggregator(t *testing.T, opts Options) *Aggregator {
+	t.Helper()
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+	a, err := New(rdb, "test", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func add(t *testing.T, a *Aggregator, e Event, want Outcome) {
+	t.Helper()
+	got, err := a.Add(context.Background(), e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("Add(%+v) = %v, want %v", e, got, want)
+	}
+}
+
+func TestTumblingWindowsCloseAtWatermark(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAggregator(t, Options{Size: time.Minute, Lateness: 30 * time.Second})
+
+	add(t, a, Event{Group: "home", Value: 2, Distinct: "ada", Time: base.Add(10 * time.Second)}, Added)
+	add(t, a, Event{Group: "home", Value: 6, Distinct: "bob", Time: base.Add(30 * time.Second)}, Added)
+	add(t, a, Event{Group: "docs", Value: 1, Distinct: "ada", Time: base.Add(70 * time.Second)}, Added)
+	// Out of order but within the lateness
+	add(t, a, Event{Group: "home", Value: 4, Distinct: "ada", Time: base.Add(20 * time.Second)}, Added)
+
+	windows, err := a.Windows(ctx, "home", base, base.Add(2*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Window{{
+		Group: "home", Start: base, End: base.Add(time.Minute),
+		Count: 3, Sum: 12, Min: 2, Max: 6, Distinct: 2,
+	}}
+	if !reflect.DeepEqual(windows, want) {
+		t.Errorf("windows = %+v, want %+v", windows, want)
+	}
+
+	// Moves the watermark to 10:01:10, past the end of the first window
+	add(t, a, Event{Group: "docs", Value: 1, Time: base.Add(100 * time.Second)}, Added)
+	add(t, a, Event{Group: "home", Value: 100, Time: base.Add(50 * time.Second)}, Late)
+
+	windows, err = a.Windows(ctx, "home", base, base.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) != 1 || !windows[0].Closed || windows[0].Count != 3 {
+		t.Errorf("windows after watermark = %+v, want the closed window of 3 events", windows)
+	}
+
+	status, err := a.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus := Status{StreamTime: base.Add(100 * time.Second), Watermark: base.Add(70 * time.Second), Late: 1}
+	if status != wantStatus {
+		t.Errorf("status = %+v, want %+v", status, wantStatus)
+	}
+}
+
+func TestSlidingWindows(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAggregator(t, Options{Size: time.Minute, Slide: 20 * time.Second, Lateness: time.Minute})
+
+	add(t, a, Event{Group: "home", Value: 1, Time: base.Add(30 * time.Second)}, Added)
+	add(t, a, Event{Group: "docs", Value: 1, Time: base.Add(45 * time.Second)}, Added)
+	add(t, a, Event{Group: "docs", Value: 1, Time: base.Add(50 * time.Second)}, Added)
+
+	windows, err := a.Windows(ctx, "home", base.Add(-time.Minute), base.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var starts []time.Time
+	for _, w := range windows {
+		starts = append(starts, w.Start)
+	}
+	wantStarts := []time.Time{base.Add(-20 * time.Second), base, base.Add(20 * time.Second)}
+	if !reflect.DeepEqual(starts, wantStarts) {
+		t.Errorf("window starts = %v, want %v", starts, wantStarts)
+	}
+
+	start, top, err := a.Top(ctx, base.Add(25*time.Second), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTop := []GroupCount{{Group: "docs", Count: 2}, {Group: "home", Count: 1}}
+	if !start.Equal(base.Add(20*time.Second)) || !reflect.DeepEqual(top, wantTop) {
+		t.Errorf("top = %v %+v, want %v %+v", start, top, base.Add(20*time.Second), wantTop)
+	}
+}
+
+func TestRedeliveredEventsAreSkipped(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAggregator(t, Options{Size: time.Minute})
+
+	e := Event{ID: "e1", Group: "home", Value: 5, Distinct: "ada", Time: base}
+	add(t, a, e, Added)
+	add(t, a, e, Duplicate)
+
+	windows, err := a.Windows(ctx, "home", base, base.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) != 1 || windows[0].Count != 1 || windows[0].Sum != 5 {
+		t.Errorf("windows = %+v, want one event", windows)
+	}
+}
+
+func TestInvalidInput(t *testing.T) {
+	if _, err := New(nil, "test", Options{Size: time.Minute, Slide: 25 * time.Second}); err == nil {
+		t.Error("New accepted a size which is no multiple of the slide")
+	}
+
+	a := newTestAggregator(t, Options{Size: time.Second})
+	if _, err := a.Add(context.Background(), Event{Group: "home"}); !errors.Is(err, ErrInvalidEvent) {
+		t.Errorf("Add without time = %v, want ErrInvalidEvent", err)
+	}
+	if _, err := a.Windows(context.Background(), "home", base, base.Add(-time.Second)); !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("Windows of negative range = %v, want ErrInvalidRange", err)
+	}
+	if _, err := a.Windows(context.Background(), "home", base, base.Add(time.Hour)); !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("Windows of 3600 windows = %v, want ErrInvalidRange", err)
+	}
+}
@@ -0,0 +1,237 @@
+// Package workqueue implements the priority queue of the redis queue
+// example on a durable RabbitMQ queue, to compare it with Redis Streams,
+// NATS JetStream and Kafka.
+//
+// Messages are persistent and Enqueue waits for the publisher confirm, so
+// an enqueued item survives a broker restart. Deliveries are acknowledged
+// manually after processing, at most prefetch of them are unacknowledged
+// per worker. Messages which can not be processed are rejected into a dead
+// letter queue instead of being delivered again forever.
+package workqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"kate.redis.queue/queue"
+)
+
+// Handler processes the item of a delivery, like the handler of the Kafka
+// consumer. An error rejects the message into the dead letter queue.
+type Handler func(ctx context.Context, item string, priority int) error
+
+var _ queue.Queue = (*WorkQueue)(nil)
+
+// WorkQueue is a durable queue with a dead letter queue named <name>.dead.
+// It publishes and consumes on separate channels of one connection.
+type WorkQueue struct {
+	ctx  context.Context
+	name string
+
+	pub        *amqp.Channel
+	sub        *amqp.Channel
+	deliveries <-chan amqp.Delivery
+
+	// unacked counts the deliveries taken by this worker and not yet
+	// acknowledged or rejected.
+	unacked atomic.Int64
+}
+
+// NewWorkQueue declares the queue, its dead letter exchange and queue, and
+// starts consuming with at most prefetch unacknowledged deliveries.
+func NewWorkQueue(conn *amqp.Connection, name string, prefetch int) (*WorkQueue, error) {
+	wq := &WorkQueue{
+		ctx:  context.Background(),
+		name: name,
+	}
+
+	var err error
+	if wq.pub, err = conn.Channel(); err != nil {
+		return nil, fmt.Errorf("failed to open channel: %v", err)
+	}
+	if wq.sub, err = conn.Channel(); err != nil {
+		wq.pub.Close()
+		return nil, fmt.Errorf("failed to open channel: %v", err)
+	}
+
+	if err := wq.declare(); err != nil {
+		wq.Close()
+		return nil, err
+	}
+
+	// Have the broker confirm every publish
+	if err := wq.pub.Confirm(false); err != nil {
+		wq.Close()
+		return nil, fmt.Errorf("failed to enable publisher confirms: %v", err)
+	}
+
+	if err := wq.sub.Qos(prefetch, 0, false); err != nil {
+		wq.Close()
+		return nil, fmt.Errorf("failed to set prefetch: %v", err)
+	}
+	wq.deliveries, err = wq.sub.Consume(name, "", false, false, false, false, nil)
+	if err != nil {
+		wq.Close()
+		return nil, fmt.Errorf("failed to consume %s: %v", name, err)
+	}
+
+	return wq, nil
+}
+
+// declare creates the queues unless they exist, rejected messages are
+// routed through the <name>.dlx exchange to the <name>.dead queue.
+func (wq *WorkQueue) declare() error {
+	dlx := wq.name + ".dlx"
+	if err := wq.pub.ExchangeDeclare(dlx, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare exchange %s: %v", dlx, err)
+	}
+
+	dead := wq.name + ".dead"
+	if _, err := wq.pub.QueueDeclare(dead, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare queue %s: %v", dead, err)
+	}
+	if err := wq.pub.QueueBind(dead, "", dlx, false, nil); err != nil {
+		return fmt.Errorf("failed to bind queue %s: %v", dead, err)
+	}
+
+	_, err := wq.pub.QueueDeclare(wq.name, true, false, false, false, amqp.Table{
+		"x-dead-letter-exchange": dlx,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to declare queue %s: %v", wq.name, err)
+	}
+	return nil
+}
+
+// Close closes the channels, unacknowledged deliveries are redelivered.
+func (wq *WorkQueue) Close() error {
+	return errors.Join(wq.sub.Close(), wq.pub.Close())
+}
+
+// Enqueue publishes a persistent message and waits for the broker to
+// confirm it.
+func (wq *WorkQueue) Enqueue(item string, priority int) error {
+	confirm, err := wq.pub.PublishWithDeferredConfirmWithContext(wq.ctx, "", wq.name, false, false, amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		ContentType:  "text/plain",
+		Headers: amqp.Table{
+			"priority": strconv.Itoa(priority),
+			"created":  strconv.FormatInt(time.Now().UnixNano(), 10),
+		},
+		Body: []byte(item),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish: %v", err)
+	}
+
+	acked, err := confirm.WaitContext(wq.ctx)
+	if err != nil {
+		return fmt.Errorf("failed to wait for confirm: %v", err)
+	}
+	if !acked {
+		return fmt.Errorf("broker rejected message")
+	}
+	return nil
+}
+
+// Dequeue waits up to 5 seconds for the next delivery, processes and
+// acknowledges it.
+func (wq *WorkQueue) Dequeue() (string, int, error) {
+	select {
+	case d, ok := <-wq.deliveries:
+		if !ok {
+			return "", 0, fmt.Errorf("consumer of %s closed", wq.name)
+		}
+		return wq.handle(wq.ctx, d, func(ctx context.Context, item string, priority int) error {
+			// Process the message (your business logic here)
+			fmt.Printf("Processing: %s with priority %d\n", item, priority)
+			return nil
+		})
+	case <-time.After(5 * time.Second):
+		return "", 0, queue.ErrEmpty
+	}
+}
+
+// Run passes deliveries to handler until ctx is done or the connection is
+// lost. A message handler fails on is rejected into the dead letter queue,
+// it does not stop the worker.
+func (wq *WorkQueue) Run(ctx context.Context, handler Handler) error {
+	for {
+		select {
+		case d, ok := <-wq.deliveries:
+			if !ok {
+				return fmt.Errorf("consumer of %s closed", wq.name)
+			}
+			if _, _, err := wq.handle(ctx, d, handler); err != nil {
+				fmt.Printf("Dead lettered message %d: %v\n", d.DeliveryTag, err)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// handle passes the item of d to handler and acknowledges d, or rejects it
+// into the dead letter queue if it is malformed or handler fails.
+func (wq *WorkQueue) handle(ctx context.Context, d amqp.Delivery, handler Handler) (string, int, error) {
+	wq.unacked.Add(1)
+	defer wq.unacked.Add(-1)
+
+	item := string(d.Body)
+	priority, err := deliveryPriority(d)
+	if err == nil {
+		err = handler(ctx, item, priority)
+	}
+	if err != nil {
+		if nackErr := d.Nack(false, false); nackErr != nil {
+			return "", 0, fmt.Errorf("failed to reject message: %v", nackErr)
+		}
+		return "", 0, err
+	}
+
+	if err := d.Ack(false); err != nil {
+		return "", 0, fmt.Errorf("failed to ack message: %v", err)
+	}
+	return item, priority, nil
+}
+
+func deliveryPriority(d amqp.Delivery) (int, error) {
+	priorityStr, ok := d.Headers["priority"].(string)
+	if !ok {
+		return 0, fmt.Errorf("invalid priority type")
+	}
+
+	priority, err := strconv.Atoi(priorityStr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid priority value: %v", err)
+	}
+	return priority, nil
+}
+
+// GetQueueInfo returns the number of messages ready in the queue or being
+// processed by this worker, and the number being processed. RabbitMQ
+// deletes acknowledged messages and reports only ready ones over AMQP, so
+// unlike on a Redis stream the total shrinks as workers progress.
+func (wq *WorkQueue) GetQueueInfo() (int64, int64, error) {
+	q, err := wq.pub.QueueDeclarePassive(wq.name, true, false, false, false, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	pending := wq.unacked.Load()
+	return int64(q.Messages) + pending, pending, nil
+}
+
+// DeadLetters returns the number of messages in the dead letter queue.
+func (wq *WorkQueue) DeadLetters() (int, error) {
+	q, err := wq.pub.QueueDeclarePassive(wq.name+".dead", true, false, false, false, nil)
+	if err != nil {
+		return 0, err
+	}
+	return q.Messages, nil
+}
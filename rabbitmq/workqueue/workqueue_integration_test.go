@@ -0,0 +1,108 @@
+//go:build integration
+
+package workqueue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"kate.redis.queue/queue"
+	"kate.testutil"
+)
+
+func newWorkQueue(t *testing.T) *WorkQueue {
+	t.Helper()
+
+	conn, err := amqp.Dial(testutil.StartRabbitMQ(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	wq, err := NewWorkQueue(conn, "test_queue", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { wq.Close() })
+	return wq
+}
+
+func TestEnqueueDequeue(t *testing.T) {
+	wq := newWorkQueue(t)
+
+	if err := wq.Enqueue("first", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := wq.Enqueue("second", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Messages come out in the order they were published.
+	for _, want := range []struct {
+		item     string
+		priority int
+	}{{"first", 2}, {"second", 1}} {
+		item, priority, err := wq.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item != want.item || priority != want.priority {
+			t.Fatalf("got %s (priority %d), want %s (priority %d)", item, priority, want.item, want.priority)
+		}
+	}
+
+	// Acknowledged messages are gone.
+	total, pending, err := wq.GetQueueInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 0 || pending != 0 {
+		t.Fatalf("got total %d pending %d, want 0 and 0", total, pending)
+	}
+
+	if _, _, err := wq.Dequeue(); !errors.Is(err, queue.ErrEmpty) {
+		t.Fatalf("got %v from empty queue, want ErrEmpty", err)
+	}
+}
+
+func TestRunDeadLetters(t *testing.T) {
+	wq := newWorkQueue(t)
+
+	for _, item := range []string{"ok", "poison", "ok"} {
+		if err := wq.Enqueue(item, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var processed int
+	err := wq.Run(ctx, func(ctx context.Context, item string, priority int) error {
+		if item == "poison" {
+			return errors.New("poison")
+		}
+		if processed++; processed == 2 {
+			cancel()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The broker routes the rejected message asynchronously.
+	testutil.Eventually(t, 5*time.Second, 100*time.Millisecond, func() error {
+		dead, err := wq.DeadLetters()
+		if err != nil {
+			return err
+		}
+		if dead != 1 {
+			return errors.New("poison message not dead lettered")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,312 @@
+// Package cache caches computed values in Redis and protects the source
+// of the values from cache stampedes.
+//
+// A stampede happens when a popular entry expires and every request
+// recomputes it at once. GetOrCompute counters it three ways: concurrent
+// misses for a key in one process share one computation; an entry is
+// refreshed in the background, with a growing probability as it nears
+// the end of its soft TTL, before it expires; and after the soft TTL
+// callers are served the stale value while one of them refreshes it,
+// until the hard TTL ends the entry for good. Refreshing takes a short
+// lock in Redis so only one process refreshes an entry. Results of
+// ErrNotFound are cached as well, so lookups of missing keys do not go
+// through to the source every time.
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+)
+
+// ErrNotFound is returned by compute functions for values which do not
+// exist, it is cached for the negative TTL.
+var ErrNotFound = errors.New("not found")
+
+// storeScript caches the entry KEYS[1] as ARGV[1] for ARGV[2]
+// milliseconds, unless the generation KEYS[2] is no longer ARGV[3]: the
+// key was deleted while the entry was computed from the old data.
+var storeScript = redis.NewScript(`
+if (redis.call('GET', KEYS[2]) or '0') ~= ARGV[3] then
+  return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return 1
+`)
+
+// ComputeFunc computes the value of a key on a miss.
+type ComputeFunc func(ctx context.Context) ([]byte, error)
+
+// Options configure a Cache.
+type Options struct {
+	// SoftTTL is how long a value is fresh, default 1m.
+	SoftTTL time.Duration
+	// HardTTL is how long a value is kept, served stale after SoftTTL
+	// while it is refreshed. Default 10 times SoftTTL.
+	HardTTL time.Duration
+	// NegativeTTL is how long ErrNotFound is cached, 0 not to cache it.
+	NegativeTTL time.Duration
+	// Beta scales the probability of refreshing a fresh value early,
+	// values above 1 favour earlier refreshes. Default 1, negative to
+	// refresh only stale values.
+	Beta float64
+}
+
+// Stats counts the lookups. Stale hits are included in hits, computes
+// include background refreshes.
+type Stats struct {
+	Hits      int64
+	StaleHits int64
+	Misses    int64
+	Computes  int64
+	Refreshes int64
+}
+
+// Cache caches values under keys with a prefix.
+type Cache struct {
+	rdb    *redis.Client
+	prefix string
+	opts   Options
+	group  singleflight.Group
+
+	// refreshing holds the keys refreshed in the background by this
+	// process.
+	refreshing sync.Map
+
+	hits, staleHits, misses, computes, refreshes atomic.Int64
+}
+
+func New(rdb *redis.Client, prefix string, opts Options) *Cache {
+	if opts.SoftTTL <= 0 {
+		opts.SoftTTL = time.Minute
+	}
+	if opts.HardTTL < opts.SoftTTL {
+		opts.HardTTL = 10 * opts.SoftTTL
+	}
+	if opts.Beta == 0 {
+		opts.Beta = 1
+	}
+	return &Cache{rdb: rdb, prefix: prefix, opts: opts}
+}
+
+func (c *Cache) key(key string) string {
+	return fmt.Sprintf("cache:%s:%s", c.prefix, key)
+}
+
+func (c *Cache) lockKey(key string) string {
+	return fmt.Sprintf("cache:%s:%s:refresh", c.prefix, key)
+}
+
+// genKey counts the deletes of key, it lives for HardTTL after the last.
+func (c *Cache) genKey(key string) string {
+	return fmt.Sprintf("cache:%s:%s:gen", c.prefix, key)
+}
+
+// GetOrCompute returns the value of key from the cache or from compute.
+// Cache errors are not fatal, the value is then computed.
+func (c *Cache) GetOrCompute(ctx context.Context, key string, compute ComputeFunc) ([]byte, error) {
+	data, err := c.rdb.Get(ctx, c.key(key)).Bytes()
+	if err != nil && err != redis.Nil {
+		log.Printf("Failed to read cache %s: %v", key, err)
+	}
+	if e, ok := decodeEntry(data); err == nil && ok {
+		c.hits.Add(1)
+		now := time.Now()
+		switch {
+		case now.After(e.softExpiry):
+			c.staleHits.Add(1)
+			c.refresh(ctx, key, e.delta, compute)
+		case c.refreshEarly(now, e):
+			c.refresh(ctx, key, e.delta, compute)
+		}
+		return e.result()
+	}
+	c.misses.Add(1)
+
+	// Waiters share the result of the first caller, which must not be
+	// cancelled with the context of its request.
+	v, err, _ := c.group.Do(key, func() (interface{}, error) {
+		return c.computeAndStore(context.WithoutCancel(ctx), key, compute)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The waiters must not share the slice
+	value, err := v.(*entry).result()
+	return bytes.Clone(value), err
+}
+
+// refreshEarly decides whether to refresh the fresh entry e, with the
+// probabilistic early expiration of "Optimal Probabilistic Cache Stampede
+// Prevention" (Vattani et al.). The probability grows towards the soft
+// expiry, and is higher for values which take longer to compute.
+func (c *Cache) refreshEarly(now time.Time, e *entry) bool {
+	if c.opts.Beta < 0 {
+		return false
+	}
+	gap := time.Duration(float64(e.delta) * c.opts.Beta * -math.Log(1-rand.Float64()))
+	return !now.Add(gap).Before(e.softExpiry)
+}
+
+// refresh recomputes key in the background, unless this process or, as
+// far as the refresh lock tells, another one is refreshing it already.
+func (c *Cache) refresh(ctx context.Context, key string, delta time.Duration, compute ComputeFunc) {
+	if _, busy := c.refreshing.LoadOrStore(key, struct{}{}); busy {
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer c.refreshing.Delete(key)
+
+		// The lock expires on its own, a refresh failing halfway does
+		// not keep others from refreshing for long.
+		ttl := max(2*delta, time.Second)
+		ok, err := c.rdb.SetNX(ctx, c.lockKey(key), 1, ttl).Result()
+		if err != nil || !ok {
+			return
+		}
+		defer c.rdb.Del(ctx, c.lockKey(key))
+
+		c.refreshes.Add(1)
+		_, err, _ = c.group.Do(key, func() (interface{}, error) {
+			return c.computeAndStore(ctx, key, compute)
+		})
+		if err != nil {
+			log.Printf("Failed to refresh %s: %v", key, err)
+		}
+	}()
+}
+
+// computeAndStore calls compute and caches its result, it returns the
+// entry cached. ErrNotFound is returned as an entry so it can be shared
+// with the waiters like a value.
+func (c *Cache) computeAndStore(ctx context.Context, key string, compute ComputeFunc) (*entry, error) {
+	// The generation tells whether key was deleted while computing.
+	gen, err := c.rdb.Get(ctx, c.genKey(key)).Result()
+	if err == redis.Nil {
+		gen = "0"
+	} else if err != nil {
+		log.Printf("Failed to read cache generation of %s: %v", key, err)
+	}
+
+	c.computes.Add(1)
+	start := time.Now()
+	value, err := compute(ctx)
+	delta := time.Since(start)
+
+	e := &entry{value: value, delta: delta}
+	ttl := c.opts.HardTTL
+	switch {
+	case errors.Is(err, ErrNotFound):
+		e.notFound = true
+		ttl = c.opts.NegativeTTL
+		e.softExpiry = time.Now().Add(ttl)
+		if ttl <= 0 {
+			return e, nil
+		}
+	case err != nil:
+		return nil, err
+	default:
+		e.softExpiry = time.Now().Add(c.opts.SoftTTL)
+	}
+
+	keys := []string{c.key(key), c.genKey(key)}
+	if err := storeScript.Run(ctx, c.rdb, keys, e.encode(), ttl.Milliseconds(), gen).Err(); err != nil {
+		log.Printf("Failed to cache %s: %v", key, err)
+	}
+	return e, nil
+}
+
+// Delete removes keys from the cache, the next lookups compute them.
+// Values being computed meanwhile, which may have read the old data, are
+// not cached.
+func (c *Cache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	for _, key := range keys {
+		// Lookups from now on compute again instead of joining a
+		// computation which may have read the old value.
+		c.group.Forget(key)
+	}
+	_, err := c.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, c.key(key))
+			pipe.Incr(ctx, c.genKey(key))
+			pipe.PExpire(ctx, c.genKey(key), c.opts.HardTTL)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete from cache: %v", err)
+	}
+	return nil
+}
+
+// Stats returns the counters since the cache was created.
+func (c *Cache) Stats() Stats {
+	return Stats{
+		Hits:      c.hits.Load(),
+		StaleHits: c.staleHits.Load(),
+		Misses:    c.misses.Load(),
+		Computes:  c.computes.Load(),
+		Refreshes: c.refreshes.Load(),
+	}
+}
+
+// entry is a cached value with what is needed to refresh it in time.
+type entry struct {
+	value      []byte
+	notFound   bool
+	softExpiry time.Time
+	// delta is how long computing the value took.
+	delta time.Duration
+}
+
+// headerLen is the length of the encoded entry before the value: a flags
+// byte, the soft expiry in Unix milliseconds and delta in microseconds.
+const headerLen = 1 + 8 + 8
+
+const flagNotFound = 1
+
+func (e *entry) encode() []byte {
+	b := make([]byte, headerLen, headerLen+len(e.value))
+	if e.notFound {
+		b[0] = flagNotFound
+	}
+	binary.BigEndian.PutUint64(b[1:], uint64(e.softExpiry.UnixMilli()))
+	binary.BigEndian.PutUint64(b[9:], uint64(e.delta.Microseconds()))
+	return append(b, e.value...)
+}
+
+func decodeEntry(b []byte) (*entry, bool) {
+	if len(b) < headerLen {
+		return nil, false
+	}
+	return &entry{
+		value:      b[headerLen:],
+		notFound:   b[0]&flagNotFound != 0,
+		softExpiry: time.UnixMilli(int64(binary.BigEndian.Uint64(b[1:]))),
+		delta:      time.Duration(binary.BigEndian.Uint64(b[9:])) * time.Microsecond,
+	}, true
+}
+
+func (e *entry) result() ([]byte, error) {
+	if e.notFound {
+		return nil, ErrNotFound
+	}
+	return e.value, nil
+}
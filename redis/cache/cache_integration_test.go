@@ -0,0 +1,234 @@
+//go:build integration
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"kate.testutil"
+)
+
+// counter returns a compute function returning how often it was called,
+// after pausing for delay.
+func counter(delay time.Duration) (ComputeFunc, *atomic.Int64) {
+	var n atomic.Int64
+	return func(ctx context.Context) ([]byte, error) {
+		time.Sleep(delay)
+		return []byte(strconv.FormatInt(n.Add(1), 10)), nil
+	}, &n
+}
+
+func TestConcurrentMissesComputeOnce(t *testing.T) {
+	ctx := context.Background()
+	c := New(testutil.NewRedisClient(t, testutil.StartRedis(t)), "test", Options{})
+	compute, calls := counter(100 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := c.GetOrCompute(ctx, "key", compute); err != nil || string(v) != "1" {
+				t.Errorf("GetOrCompute = %q, %v, want 1", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("computed %d times, want 1", n)
+	}
+	if _, err := c.GetOrCompute(ctx, "key", compute); err != nil || c.Stats().Hits != 1 {
+		t.Errorf("stats = %+v, %v, want a hit", c.Stats(), err)
+	}
+}
+
+func TestStaleValueServedWhileRefreshing(t *testing.T) {
+	ctx := context.Background()
+	c := New(testutil.NewRedisClient(t, testutil.StartRedis(t)), "test", Options{
+		SoftTTL: 100 * time.Millisecond,
+		HardTTL: time.Minute,
+		Beta:    -1,
+	})
+	compute, _ := counter(0)
+
+	if _, err := c.GetOrCompute(ctx, "key", compute); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(150 * time.Millisecond)
+
+	v, err := c.GetOrCompute(ctx, "key", compute)
+	if err != nil || string(v) != "1" {
+		t.Fatalf("stale lookup = %q, %v, want 1", v, err)
+	}
+	testutil.Eventually(t, 5*time.Second, 20*time.Millisecond, func() error {
+		v, err := c.GetOrCompute(ctx, "key", compute)
+		if err == nil && string(v) != "2" {
+			err = fmt.Errorf("value %s not refreshed", v)
+		}
+		return err
+	})
+	if s := c.Stats(); s.StaleHits == 0 || s.Refreshes == 0 {
+		t.Errorf("stats = %+v, want stale hits and a refresh", s)
+	}
+}
+
+func TestEarlyRefresh(t *testing.T) {
+	ctx := context.Background()
+	c := New(testutil.NewRedisClient(t, testutil.StartRedis(t)), "test", Options{
+		SoftTTL: time.Minute,
+		// Refresh a value taking 10ms to compute minutes before it is stale
+		Beta: 1e5,
+	})
+	compute, calls := counter(10 * time.Millisecond)
+
+	for i := 0; i < 10 && calls.Load() < 2; i++ {
+		if _, err := c.GetOrCompute(ctx, "key", compute); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if s := c.Stats(); s.StaleHits != 0 || s.Refreshes == 0 {
+		t.Errorf("stats = %+v, want an early refresh of a fresh value", s)
+	}
+}
+
+func TestNegativeCaching(t *testing.T) {
+	ctx := context.Background()
+	c := New(testutil.NewRedisClient(t, testutil.StartRedis(t)), "test", Options{
+		NegativeTTL: 200 * time.Millisecond,
+	})
+	var calls atomic.Int64
+	missing := func(ctx context.Context) ([]byte, error) {
+		calls.Add(1)
+		return nil, ErrNotFound
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.GetOrCompute(ctx, "missing", missing); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("got %v, want ErrNotFound", err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("computed %d times, want 1", n)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	c.GetOrCompute(ctx, "missing", missing)
+	if n := calls.Load(); n != 2 {
+		t.Errorf("computed %d times after the negative TTL, want 2", n)
+	}
+
+	// Other errors are not cached
+	failing := func(ctx context.Context) ([]byte, error) { return nil, errors.New("boom") }
+	if _, err := c.GetOrCompute(ctx, "failing", failing); err == nil {
+		t.Fatal("error not returned")
+	}
+	if n, _ := c.rdb.Exists(ctx, c.key("failing")).Result(); n != 0 {
+		t.Error("error cached")
+	}
+}
+
+// missStorm runs get from b.N lookups in parallel, every key is asked for
+// by requestsPerKey of them, mostly at the same time, and reports the
+// computations per key.
+func missStorm(b *testing.B, get func(ctx context.Context, key string, compute ComputeFunc) error) {
+	const requestsPerKey = 64
+	ctx := context.Background()
+	var n, computes atomic.Int64
+	compute := func(ctx context.Context) ([]byte, error) {
+		computes.Add(1)
+		time.Sleep(time.Millisecond)
+		return []byte("value"), nil
+	}
+
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			key := fmt.Sprintf("key-%d", n.Add(1)/requestsPerKey)
+			if err := get(ctx, key, compute); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+	keys := float64(b.N)/requestsPerKey + 1
+	b.ReportMetric(float64(computes.Load())/keys, "computes/key")
+}
+
+func BenchmarkMissStorm(b *testing.B) {
+	rcfg := testutil.StartRedis(b)
+
+	b.Run("GetOrCompute", func(b *testing.B) {
+		rdb := testutil.NewRedisClient(b, rcfg)
+		rdb.FlushDB(context.Background())
+		c := New(rdb, "bench", Options{})
+		missStorm(b, func(ctx context.Context, key string, compute ComputeFunc) error {
+			_, err := c.GetOrCompute(ctx, key, compute)
+			return err
+		})
+	})
+
+	// Plain cache-aside for comparison, every concurrent miss computes
+	b.Run("CacheAside", func(b *testing.B) {
+		rdb := testutil.NewRedisClient(b, rcfg)
+		rdb.FlushDB(context.Background())
+		missStorm(b, func(ctx context.Context, key string, compute ComputeFunc) error {
+			_, err := rdb.Get(ctx, key).Bytes()
+			if err != redis.Nil {
+				return err
+			}
+			value, err := compute(ctx)
+			if err != nil {
+				return err
+			}
+			return rdb.Set(ctx, key, value, time.Minute).Err()
+		})
+	})
+}
+
+func TestDeleteDuringComputeIsNotCached(t *testing.T) {
+	ctx := context.Background()
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+	c := New(rdb, "test", Options{})
+
+	// The computation reads the old data, then the key is deleted
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.GetOrCompute(ctx, "key", func(ctx context.Context) ([]byte, error) {
+			close(started)
+			<-release
+			return []byte("old"), nil
+		})
+		done <- err
+	}()
+	<-started
+	if err := c.Delete(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if n := rdb.Exists(ctx, c.key("key")).Val(); n != 0 {
+		t.Errorf("value computed before the delete was cached")
+	}
+	compute, _ := counter(0)
+	if v, err := c.GetOrCompute(ctx, "key", compute); err != nil || string(v) != "1" {
+		t.Errorf("GetOrCompute = %q, %v, want 1", v, err)
+	}
+	if v, err := rdb.Get(ctx, c.key("key")).Bytes(); err != nil || !bytes.HasSuffix(v, []byte("1")) {
+		t.Errorf("value computed after the delete not cached: %q, %v", v, err)
+	}
+}
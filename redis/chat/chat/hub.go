@@ -0,0 +1,284 @@
+// Package chat fans chat messages out to the members of a room across any
+// number of server instances.
+//
+// Every instance subscribes once to the Redis channels of all rooms and
+// delivers what it receives to its local members, so a message sent to
+// one instance reaches the members connected to all others. Messages are
+// appended to a capped stream per room for the history shown on joining,
+// and the members of a room are tracked in a Redis set. The members of an
+// instance which crashed stay in the set until they join and leave again.
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// historyLen caps the history stream of a room, approximately.
+const historyLen = 1000
+
+// memberBuffer is how many messages a member may lag behind before it is
+// dropped, so one slow client does not stall the room.
+const memberBuffer = 64
+
+// Message types, join and leave are sent by the hub.
+const (
+	TypeMessage = "message"
+	TypeJoin    = "join"
+	TypeLeave   = "leave"
+)
+
+// Message is sent to every member of a room, as JSON on the channel and
+// in the history stream.
+type Message struct {
+	ID   string    `json:"id,omitempty"`
+	Type string    `json:"type"`
+	Room string    `json:"room"`
+	User string    `json:"user"`
+	Text string    `json:"text,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+func channelKey(room string) string { return fmt.Sprintf("chat:room:%s", room) }
+func historyKey(room string) string { return fmt.Sprintf("chat:room:%s:history", room) }
+func membersKey(room string) string { return fmt.Sprintf("chat:room:%s:members", room) }
+
+// connectionsKey counts the connections of every member of room, a user
+// may be connected on several instances or in several tabs.
+func connectionsKey(room string) string { return fmt.Sprintf("chat:room:%s:connections", room) }
+
+// joinScript counts a connection of the user and adds it to the members,
+// it returns the number of connections.
+var joinScript = redis.NewScript(`
+local n = redis.call('HINCRBY', KEYS[1], ARGV[1], 1)
+redis.call('SADD', KEYS[2], ARGV[1])
+return n
+`)
+
+// leaveScript drops a connection of the user and removes it from the
+// members with the last one, it returns the number of connections left.
+var leaveScript = redis.NewScript(`
+local n = redis.call('HINCRBY', KEYS[1], ARGV[1], -1)
+if n <= 0 then
+  redis.call('HDEL', KEYS[1], ARGV[1])
+  redis.call('SREM', KEYS[2], ARGV[1])
+end
+return n
+`)
+
+// Hub is the chat of one server instance.
+type Hub struct {
+	rdb *redis.Client
+
+	mu    sync.Mutex
+	rooms map[string]map[*Member]struct{}
+}
+
+func NewHub(rdb *redis.Client) *Hub {
+	return &Hub{
+		rdb:   rdb,
+		rooms: make(map[string]map[*Member]struct{}),
+	}
+}
+
+// Run delivers the messages published by all instances to the local
+// members until ctx is done.
+func (h *Hub) Run(ctx context.Context) error {
+	sub := h.rdb.PSubscribe(ctx, channelKey("*"))
+	defer sub.Close()
+
+	// Wait for the subscription, messages published before are missed
+	if _, err := sub.Receive(ctx); err != nil {
+		return fmt.Errorf("failed to subscribe: %v", err)
+	}
+
+	ch := sub.Channel()
+	for {
+		select {
+		case m, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			var msg Message
+			if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil {
+				log.Printf("Skipping malformed message on %s: %v", m.Channel, err)
+				continue
+			}
+			h.deliver(msg)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// deliver sends msg to the local members of its room, dropping members
+// which fell too far behind.
+func (h *Hub) deliver(msg Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for m := range h.rooms[msg.Room] {
+		select {
+		case m.send <- msg:
+		default:
+			delete(h.rooms[msg.Room], m)
+			close(m.send)
+		}
+	}
+}
+
+// Publish appends msg to the history of its room and sends it to all
+// members. It returns msg with its ID and time set.
+func (h *Hub) Publish(ctx context.Context, msg Message) (Message, error) {
+	if msg.Type == "" {
+		msg.Type = TypeMessage
+	}
+	msg.Time = time.Now().UTC()
+
+	id, err := h.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: historyKey(msg.Room),
+		MaxLen: historyLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"type": msg.Type,
+			"user": msg.User,
+			"text": msg.Text,
+			"time": msg.Time.Format(time.RFC3339Nano),
+		},
+	}).Result()
+	if err != nil {
+		return msg, fmt.Errorf("failed to append to history: %v", err)
+	}
+	msg.ID = id
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return msg, err
+	}
+	if err := h.rdb.Publish(ctx, channelKey(msg.Room), payload).Err(); err != nil {
+		return msg, fmt.Errorf("failed to publish: %v", err)
+	}
+	return msg, nil
+}
+
+// History returns up to count of the latest messages of room, oldest first.
+func (h *Hub) History(ctx context.Context, room string, count int64) ([]Message, error) {
+	entries, err := h.rdb.XRevRangeN(ctx, historyKey(room), "+", "-", count).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]Message, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		msg := Message{ID: e.ID, Room: room}
+		msg.Type, _ = e.Values["type"].(string)
+		msg.User, _ = e.Values["user"].(string)
+		msg.Text, _ = e.Values["text"].(string)
+		if t, ok := e.Values["time"].(string); ok {
+			msg.Time, _ = time.Parse(time.RFC3339Nano, t)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// Members returns the users in room on any instance, sorted.
+func (h *Hub) Members(ctx context.Context, room string) ([]string, error) {
+	return h.rdb.Sort(ctx, membersKey(room), &redis.Sort{Alpha: true}).Result()
+}
+
+// Rooms returns the rooms with members.
+func (h *Hub) Rooms(ctx context.Context) ([]string, error) {
+	var rooms []string
+	iter := h.rdb.Scan(ctx, 0, membersKey("*"), 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		rooms = append(rooms, strings.TrimSuffix(strings.TrimPrefix(key, "chat:room:"), ":members"))
+	}
+	return rooms, iter.Err()
+}
+
+// Member is a user connected to a room on this instance.
+type Member struct {
+	hub  *Hub
+	Room string
+	User string
+	send chan Message
+}
+
+// Join adds user to room, announcing it unless the user was connected to
+// the room already. The member receives the messages of the room until
+// Leave, or until it falls behind.
+func (h *Hub) Join(ctx context.Context, room, user string) (*Member, error) {
+	m := &Member{hub: h, Room: room, User: user, send: make(chan Message, memberBuffer)}
+	h.mu.Lock()
+	if h.rooms[room] == nil {
+		h.rooms[room] = make(map[*Member]struct{})
+	}
+	h.rooms[room][m] = struct{}{}
+	h.mu.Unlock()
+
+	n, err := joinScript.Run(ctx, h.rdb, []string{connectionsKey(room), membersKey(room)}, user).Int64()
+	if err != nil {
+		h.remove(m)
+		return nil, fmt.Errorf("failed to add member: %v", err)
+	}
+	if n == 1 {
+		if _, err := h.Publish(ctx, Message{Type: TypeJoin, Room: room, User: user}); err != nil {
+			m.Leave(ctx)
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// remove stops delivering to m.
+func (h *Hub) remove(m *Member) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.rooms[m.Room][m]; ok {
+		delete(h.rooms[m.Room], m)
+		close(m.send)
+	}
+	if len(h.rooms[m.Room]) == 0 {
+		delete(h.rooms, m.Room)
+	}
+}
+
+// Messages returns the messages of the room, it is closed when the member
+// left or was dropped.
+func (m *Member) Messages() <-chan Message {
+	return m.send
+}
+
+// Send publishes text to the room.
+func (m *Member) Send(ctx context.Context, text string) error {
+	_, err := m.hub.Publish(ctx, Message{Room: m.Room, User: m.User, Text: text})
+	return err
+}
+
+// Leave removes the member from the room, announcing it if this was the
+// last connection of the user to the room.
+func (m *Member) Leave(ctx context.Context) error {
+	h := m.hub
+	h.remove(m)
+
+	n, err := leaveScript.Run(ctx, h.rdb, []string{connectionsKey(m.Room), membersKey(m.Room)}, m.User).Int64()
+	if err != nil {
+		return fmt.Errorf("failed to remove member: %v", err)
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err = h.Publish(ctx, Message{Type: TypeLeave, Room: m.Room, User: m.User})
+	return err
+}
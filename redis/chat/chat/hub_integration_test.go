@@ -0,0 +1,134 @@
+//go:build integration
+
+package chat
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"kate.testutil"
+)
+
+// next returns the next message of m or fails after a timeout.
+func next(t *testing.T, m *Member) Message {
+	t.Helper()
+
+	select {
+	case msg, ok := <-m.Messages():
+		if !ok {
+			t.Fatalf("messages of %s closed", m.User)
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no message for %s", m.User)
+	}
+	return Message{}
+}
+
+func TestChatAcrossInstances(t *testing.T) {
+	rcfg := testutil.StartRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Two instances sharing one Redis
+	a := NewHub(testutil.NewRedisClient(t, rcfg))
+	b := NewHub(testutil.NewRedisClient(t, rcfg))
+	for _, h := range []*Hub{a, b} {
+		go h.Run(ctx)
+	}
+	// Run subscribes asynchronously, wait until both instances listen.
+	testutil.Eventually(t, 5*time.Second, 50*time.Millisecond, func() error {
+		n, err := a.rdb.PubSubNumPat(ctx).Result()
+		if err == nil && n < 2 {
+			err = fmt.Errorf("%d instances subscribed, want 2", n)
+		}
+		return err
+	})
+
+	ada, err := a.Join(ctx, "lobby", "ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := next(t, ada); msg.Type != TypeJoin || msg.User != "ada" {
+		t.Fatalf("got %+v, want join of ada", msg)
+	}
+
+	grace, err := b.Join(ctx, "lobby", "grace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := next(t, ada); msg.Type != TypeJoin || msg.User != "grace" {
+		t.Fatalf("got %+v, want join of grace", msg)
+	}
+	next(t, grace)
+
+	if err := ada.Send(ctx, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*Member{ada, grace} {
+		if msg := next(t, m); msg.Text != "hello" || msg.User != "ada" {
+			t.Fatalf("%s got %+v, want hello from ada", m.User, msg)
+		}
+	}
+
+	members, err := a.Members(ctx, "lobby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(members, []string{"ada", "grace"}) {
+		t.Fatalf("got members %q, want [ada grace]", members)
+	}
+
+	// A second connection of grace is not announced, and grace stays a
+	// member until both connections left.
+	grace2, err := a.Join(ctx, "lobby", "grace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := grace.Leave(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := ada.Send(ctx, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	if msg := next(t, grace2); msg.Text != "bye" {
+		t.Fatalf("got %+v, want bye", msg)
+	}
+	next(t, ada)
+
+	if err := grace2.Leave(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if msg := next(t, ada); msg.Type != TypeLeave || msg.User != "grace" {
+		t.Fatalf("got %+v, want leave of grace", msg)
+	}
+	if members, err = a.Members(ctx, "lobby"); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(members, []string{"ada"}) {
+		t.Fatalf("got members %q, want [ada]", members)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+	hub := NewHub(rdb)
+	ctx := context.Background()
+
+	for _, text := range []string{"one", "two", "three"} {
+		if _, err := hub.Publish(ctx, Message{Room: "lobby", User: "ada", Text: text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	history, err := hub.History(ctx, "lobby", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Text != "two" || history[1].Text != "three" {
+		t.Fatalf("got %+v, want two and three", history)
+	}
+}
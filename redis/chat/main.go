@@ -0,0 +1,117 @@
+// Chat service: WebSocket chat rooms on Redis Pub/Sub. Start several
+// instances on different -http-addr and connect clients to any of them,
+// e.g. with websocat:
+//
+//	websocat 'ws://localhost:8080/ws?room=lobby&user=ada'
+//	websocat 'ws://localhost:8081/ws?room=lobby&user=grace'
+//
+// GET /rooms lists the rooms with members, /rooms/{room}/members the
+// members of a room on all instances and /rooms/{room}/history?count=N
+// the latest messages.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"kate.config"
+	"kate.redis.chat/chat"
+)
+
+// Config is the Redis server and the listen address, set with flags, the
+// matching variables (e.g. REDIS_ADDR for -redis-addr) or the -config file.
+type Config struct {
+	Redis config.RedisConfig `yaml:"redis"`
+	HTTP  config.HTTPConfig  `yaml:"http"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Redis: config.DefaultRedisConfig(),
+		HTTP:  config.DefaultHTTPConfig(),
+	}
+}
+
+func (c *Config) registerFlags(fs *flag.FlagSet) {
+	c.Redis.RegisterFlags(fs)
+	c.HTTP.RegisterFlags(fs)
+}
+
+func (c *Config) validate() error {
+	if err := c.Redis.Validate(); err != nil {
+		return err
+	}
+	return c.HTTP.Validate()
+}
+
+func main() {
+	cfg, _, err := config.Load(os.Args[0], "", os.Args[1:], defaultConfig, (*Config).registerFlags)
+	if err == nil {
+		err = cfg.validate()
+	}
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	rdb := cfg.Redis.NewClient()
+	defer rdb.Close()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		log.Fatal("Redis connection failed: ", err)
+	}
+
+	hub := chat.NewHub(rdb)
+	go func() {
+		if err := hub.Run(ctx); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ws", wsHandler(hub))
+	mux.HandleFunc("GET /rooms", func(w http.ResponseWriter, r *http.Request) {
+		rooms, err := hub.Rooms(r.Context())
+		writeJSON(w, rooms, err)
+	})
+	mux.HandleFunc("GET /rooms/{room}/members", func(w http.ResponseWriter, r *http.Request) {
+		members, err := hub.Members(r.Context(), r.PathValue("room"))
+		writeJSON(w, members, err)
+	})
+	mux.HandleFunc("GET /rooms/{room}/history", func(w http.ResponseWriter, r *http.Request) {
+		count, err := strconv.ParseInt(r.URL.Query().Get("count"), 10, 64)
+		if err != nil || count < 1 {
+			count = historyCount
+		}
+		history, err := hub.History(r.Context(), r.PathValue("room"), count)
+		writeJSON(w, history, err)
+	})
+
+	srv := cfg.HTTP.Server(mux)
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+
+	log.Printf("Chat service listening on %s", cfg.HTTP.Addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"kate.redis.chat/chat"
+)
+
+const (
+	// writeWait is how long writing a frame may take.
+	writeWait = 10 * time.Second
+	// pongWait is how long a client may stay silent, it is pinged more often.
+	pongWait     = 60 * time.Second
+	pingInterval = pongWait * 9 / 10
+	// maxMessageSize limits the text of a chat message.
+	maxMessageSize = 4096
+	// historyCount is how many messages a client gets on joining.
+	historyCount = 50
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// wsHandler connects a WebSocket client to a room. The client receives the
+// latest history and then every message of the room as JSON, and sends
+// chat messages as text frames.
+func wsHandler(hub *chat.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		room := r.URL.Query().Get("room")
+		user := r.URL.Query().Get("user")
+		if !validName(room) || !validName(user) {
+			http.Error(w, "room and user are required, letters, digits, - and _ only", http.StatusBadRequest)
+			return
+		}
+
+		history, err := hub.History(r.Context(), room, historyCount)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade replied to the client already
+			return
+		}
+		defer conn.Close()
+
+		ctx := context.WithoutCancel(r.Context())
+		m, err := hub.Join(ctx, room, user)
+		if err != nil {
+			log.Printf("Failed to join %s to %s: %v", user, room, err)
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "join failed"))
+			return
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			writePump(conn, history, m.Messages())
+		}()
+
+		readPump(ctx, conn, m)
+		if err := m.Leave(ctx); err != nil {
+			log.Printf("Failed to remove %s from %s: %v", user, room, err)
+		}
+		<-done
+	}
+}
+
+// readPump sends the text frames of the client to the room until the
+// client goes away.
+func readPump(ctx context.Context, conn *websocket.Conn, m *chat.Member) {
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, text, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if len(text) == 0 {
+			continue
+		}
+		if err := m.Send(ctx, string(text)); err != nil {
+			log.Printf("Failed to send message of %s: %v", m.User, err)
+		}
+	}
+}
+
+// writePump writes the history and then the messages of the room to the
+// client, pinging it in between. It closes the connection when messages
+// is closed, i.e. the member left or fell behind, or a write fails, which
+// also ends readPump.
+func writePump(conn *websocket.Conn, history []chat.Message, messages <-chan chat.Message) {
+	ticker := time.NewTicker(pingInterval)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+
+	for _, msg := range history {
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := conn.WriteJSON(msg); err != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case msg, ok := <-messages:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func validName(s string) bool {
+	if s == "" || len(s) > 64 {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
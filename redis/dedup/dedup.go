@@ -0,0 +1,331 @@
+// Package dedup remembers the ids of handled events in Redis, so events
+// delivered twice, e.g. by Kafka after a crash or a producer retry, can be
+// dropped.
+//
+// Ids go into probabilistic filters, which take a few bits per id instead
+// of a key each. A filter may claim an id was seen which was not, at the
+// configured error rate, but never misses one which was added. With the
+// RedisBloom module a filter is a Bloom filter (BF.INSERT, BF.EXISTS) or
+// a Cuckoo filter (CF.INSERTNX, CF.EXISTS); without it a Bloom filter is
+// built from a plain Redis bitmap with BITFIELD.
+//
+// Filters only fill up, so ids are remembered for a window: every window
+// starts a new filter and the one of the window before stays readable
+// until it ends. An id is therefore remembered for at least one window
+// and at most two.
+package dedup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Kinds of filters.
+const (
+	// KindAuto uses a Bloom filter of RedisBloom if the server has the
+	// module loaded, and a bitmap otherwise.
+	KindAuto   = "auto"
+	KindBloom  = "bloom"
+	KindCuckoo = "cuckoo"
+	KindBitmap = "bitmap"
+)
+
+// ErrUnsupported is returned by New for the Bloom and Cuckoo kinds when
+// the server does not have RedisBloom loaded.
+var ErrUnsupported = errors.New("filter kind not supported by the server")
+
+// maxBits is the largest bitmap Redis holds, 512 MB.
+const maxBits = 1 << 32
+
+// Options configure a Filter.
+type Options struct {
+	// Kind is one of the Kind constants, default KindAuto.
+	Kind string
+	// Capacity is how many ids a window holds at ErrorRate, default one
+	// million. Bloom filters of RedisBloom grow beyond it, bitmaps and
+	// Cuckoo filters get less accurate.
+	Capacity int64
+	// ErrorRate is the probability of claiming an unseen id was seen,
+	// default 0.001. Cuckoo filters ignore it.
+	ErrorRate float64
+	// Window is how long a filter takes new ids, default 24h.
+	Window time.Duration
+}
+
+// backend is a kind of filter in Redis. Its methods queue commands on a
+// pipeline and return a function reporting the result after it ran.
+type backend interface {
+	// add adds id to the filter at key, the result is whether it was not
+	// in the filter yet.
+	add(ctx context.Context, pipe redis.Pipeliner, key, id string) func() (bool, error)
+	// exists reports whether id may be in the filter at key.
+	exists(ctx context.Context, pipe redis.Pipeliner, key, id string) func() (bool, error)
+}
+
+// Filter remembers ids for a window.
+type Filter struct {
+	rdb     *redis.Client
+	name    string
+	kind    string
+	window  time.Duration
+	backend backend
+	now     func() time.Time
+}
+
+// New returns the filter name, filters of different names are
+// independent. It asks the server whether it has RedisBloom loaded.
+func New(ctx context.Context, rdb *redis.Client, name string, opts Options) (*Filter, error) {
+	if opts.Kind == "" {
+		opts.Kind = KindAuto
+	}
+	if opts.Capacity <= 0 {
+		opts.Capacity = 1_000_000
+	}
+	if opts.ErrorRate <= 0 || opts.ErrorRate >= 1 {
+		opts.ErrorRate = 0.001
+	}
+	if opts.Window <= 0 {
+		opts.Window = 24 * time.Hour
+	}
+
+	kind := opts.Kind
+	switch kind {
+	case KindAuto, KindBloom, KindCuckoo:
+		ok, err := hasBloom(ctx, rdb)
+		if err != nil {
+			return nil, err
+		}
+		if !ok && kind != KindAuto {
+			return nil, fmt.Errorf("%w: %s needs the RedisBloom module", ErrUnsupported, kind)
+		}
+		if kind == KindAuto {
+			kind = KindBitmap
+			if ok {
+				kind = KindBloom
+			}
+		}
+	case KindBitmap:
+	default:
+		return nil, fmt.Errorf("unknown filter kind %q", kind)
+	}
+
+	f := &Filter{rdb: rdb, name: name, kind: kind, window: opts.Window, now: time.Now}
+	switch kind {
+	case KindBloom:
+		f.backend = bloom{capacity: opts.Capacity, errorRate: opts.ErrorRate}
+	case KindCuckoo:
+		f.backend = cuckoo{capacity: opts.Capacity}
+	default:
+		f.backend = newBitmap(opts.Capacity, opts.ErrorRate)
+	}
+	return f, nil
+}
+
+// hasBloom reports whether the server knows the commands of RedisBloom.
+func hasBloom(ctx context.Context, rdb *redis.Client) (bool, error) {
+	err := rdb.Do(ctx, "BF.EXISTS", "dedup:probe", "x").Err()
+	if err != nil && strings.HasPrefix(err.Error(), "ERR unknown command") {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to probe for RedisBloom: %v", err)
+	}
+	return true, nil
+}
+
+// Kind returns the kind of the filter, never KindAuto.
+func (f *Filter) Kind() string {
+	return f.kind
+}
+
+// keys returns the filter of the current window, how long it is kept and
+// the filter of the window before.
+func (f *Filter) keys() (current string, expireAt time.Time, previous string) {
+	w := f.now().UnixMilli() / f.window.Milliseconds()
+	current = fmt.Sprintf("dedup:%s:%d", f.name, w)
+	previous = fmt.Sprintf("dedup:%s:%d", f.name, w-1)
+	expireAt = time.UnixMilli((w + 2) * f.window.Milliseconds())
+	return current, expireAt, previous
+}
+
+// Add adds id and reports whether it was new, i.e. not seen in this
+// window or the one before.
+func (f *Filter) Add(ctx context.Context, id string) (bool, error) {
+	current, expireAt, previous := f.keys()
+
+	var seenBefore, added func() (bool, error)
+	_, err := f.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		seenBefore = f.backend.exists(ctx, pipe, previous, id)
+		added = f.backend.add(ctx, pipe, current, id)
+		pipe.PExpireAt(ctx, current, expireAt)
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to add %s: %v", id, err)
+	}
+
+	seen, err := seenBefore()
+	if err != nil {
+		return false, err
+	}
+	isNew, err := added()
+	if err != nil {
+		return false, err
+	}
+	return isNew && !seen, nil
+}
+
+// Exists reports whether id may have been added in this window or the one
+// before. False is certain, true wrong at the error rate.
+func (f *Filter) Exists(ctx context.Context, id string) (bool, error) {
+	current, _, previous := f.keys()
+
+	var inCurrent, inPrevious func() (bool, error)
+	_, err := f.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		inCurrent = f.backend.exists(ctx, pipe, current, id)
+		inPrevious = f.backend.exists(ctx, pipe, previous, id)
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to look up %s: %v", id, err)
+	}
+
+	for _, in := range []func() (bool, error){inCurrent, inPrevious} {
+		ok, err := in()
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// bloom is a Bloom filter of RedisBloom, created on the first add with
+// the capacity and error rate.
+type bloom struct {
+	capacity  int64
+	errorRate float64
+}
+
+func (b bloom) add(ctx context.Context, pipe redis.Pipeliner, key, id string) func() (bool, error) {
+	cmd := pipe.Do(ctx, "BF.INSERT", key, "CAPACITY", b.capacity, "ERROR", b.errorRate, "ITEMS", id)
+	return func() (bool, error) {
+		added, err := cmd.Int64Slice()
+		if err != nil {
+			return false, err
+		}
+		return len(added) == 1 && added[0] == 1, nil
+	}
+}
+
+func (b bloom) exists(ctx context.Context, pipe redis.Pipeliner, key, id string) func() (bool, error) {
+	return boolResult(pipe.Do(ctx, "BF.EXISTS", key, id))
+}
+
+// cuckoo is a Cuckoo filter of RedisBloom. Unlike Bloom filters it could
+// delete ids, but it has a fixed size and rejects ids when full.
+type cuckoo struct {
+	capacity int64
+}
+
+func (c cuckoo) add(ctx context.Context, pipe redis.Pipeliner, key, id string) func() (bool, error) {
+	cmd := pipe.Do(ctx, "CF.INSERTNX", key, "CAPACITY", c.capacity, "ITEMS", id)
+	return func() (bool, error) {
+		added, err := cmd.Int64Slice()
+		if err != nil {
+			return false, err
+		}
+		if len(added) == 1 && added[0] == -1 {
+			return false, fmt.Errorf("cuckoo filter %s is full", key)
+		}
+		return len(added) == 1 && added[0] == 1, nil
+	}
+}
+
+func (c cuckoo) exists(ctx context.Context, pipe redis.Pipeliner, key, id string) func() (bool, error) {
+	return boolResult(pipe.Do(ctx, "CF.EXISTS", key, id))
+}
+
+func boolResult(cmd *redis.Cmd) func() (bool, error) {
+	return func() (bool, error) {
+		n, err := cmd.Int64()
+		return n == 1, err
+	}
+}
+
+// bitmap is a Bloom filter of m bits with k hash functions in a plain
+// Redis string. BITFIELD sets or reads all bits of an id in one command.
+type bitmap struct {
+	m, k uint64
+}
+
+// newBitmap sizes the bitmap for capacity ids at errorRate.
+func newBitmap(capacity int64, errorRate float64) bitmap {
+	n := float64(capacity)
+	m := math.Ceil(-n * math.Log(errorRate) / (math.Ln2 * math.Ln2))
+	m = math.Min(m, maxBits)
+	k := math.Max(1, math.Round(m/n*math.Ln2))
+	return bitmap{m: uint64(m), k: uint64(k)}
+}
+
+// offsets returns the bits of id, derived from two hashes by double
+// hashing.
+func (b bitmap) offsets(id string) []uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	h1 := h.Sum64()
+	h.Write([]byte{0})
+	h2 := h.Sum64() | 1
+
+	offsets := make([]uint64, b.k)
+	for i := range offsets {
+		offsets[i] = (h1 + uint64(i)*h2) % b.m
+	}
+	return offsets
+}
+
+func (b bitmap) add(ctx context.Context, pipe redis.Pipeliner, key, id string) func() (bool, error) {
+	var args []interface{}
+	for _, off := range b.offsets(id) {
+		args = append(args, "SET", "u1", off, 1)
+	}
+	cmd := pipe.BitField(ctx, key, args...)
+	return func() (bool, error) {
+		old, err := cmd.Result()
+		if err != nil {
+			return false, err
+		}
+		for _, bit := range old {
+			if bit == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+func (b bitmap) exists(ctx context.Context, pipe redis.Pipeliner, key, id string) func() (bool, error) {
+	var args []interface{}
+	for _, off := range b.offsets(id) {
+		args = append(args, "GET", "u1", off)
+	}
+	cmd := pipe.BitField(ctx, key, args...)
+	return func() (bool, error) {
+		bits, err := cmd.Result()
+		if err != nil {
+			return false, err
+		}
+		for _, bit := range bits {
+			if bit == 0 {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+}
@@ -0,0 +1,120 @@
+//go:build integration
+
+package dedup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"kate.testutil"
+)
+
+func newTestFilter(t *testing.T, rdb *redis.Client, opts Options, now *time.Time) *Filter {
+	t.Helper()
+	f, err := New(context.Background(), rdb, "test-"+opts.Kind, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.now = func() time.Time { return *now }
+	return f
+}
+
+// testKind checks that a filter of kind remembers ids for one to two
+// windows and rarely claims to have seen new ones.
+func testKind(t *testing.T, rdb *redis.Client, kind string) {
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	f := newTestFilter(t, rdb, Options{Kind: kind, Capacity: 10_000, ErrorRate: 0.01, Window: time.Hour}, &now)
+
+	for i := 0; i < 1000; i++ {
+		added, err := f.Add(ctx, fmt.Sprintf("event-%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !added {
+			t.Errorf("event-%d not new", i)
+		}
+	}
+	if added, err := f.Add(ctx, "event-1"); err != nil || added {
+		t.Errorf("adding event-1 again = %v, %v, want false", added, err)
+	}
+
+	falsePositives := 0
+	for i := 1000; i < 2000; i++ {
+		seen, err := f.Exists(ctx, fmt.Sprintf("event-%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen {
+			falsePositives++
+		}
+	}
+	if falsePositives > 20 {
+		t.Errorf("%d of 1000 unseen ids exist, want about 1%%", falsePositives)
+	}
+
+	// Remembered through the next window, forgotten after
+	now = now.Add(time.Hour)
+	if seen, err := f.Exists(ctx, "event-2"); err != nil || !seen {
+		t.Errorf("event-2 in next window = %v, %v, want true", seen, err)
+	}
+	if added, err := f.Add(ctx, "event-3"); err != nil || added {
+		t.Errorf("adding event-3 in next window = %v, %v, want false", added, err)
+	}
+	now = now.Add(time.Hour)
+	if seen, err := f.Exists(ctx, "event-2"); err != nil || seen {
+		t.Errorf("event-2 two windows later = %v, %v, want false", seen, err)
+	}
+	// Added again in the window before, so still there
+	if seen, err := f.Exists(ctx, "event-3"); err != nil || !seen {
+		t.Errorf("event-3 two windows later = %v, %v, want true", seen, err)
+	}
+}
+
+func TestBitmap(t *testing.T) {
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+	testKind(t, rdb, KindBitmap)
+
+	// Plain Redis has no RedisBloom
+	now := time.Now()
+	if f := newTestFilter(t, rdb, Options{}, &now); f.Kind() != KindBitmap {
+		t.Errorf("auto kind = %s, want bitmap", f.Kind())
+	}
+	if _, err := New(context.Background(), rdb, "bloom", Options{Kind: KindBloom}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("bloom without RedisBloom: got %v, want ErrUnsupported", err)
+	}
+}
+
+func TestRedisBloom(t *testing.T) {
+	rdb := testutil.NewRedisClient(t, testutil.StartRedisStack(t))
+
+	now := time.Now()
+	if f := newTestFilter(t, rdb, Options{}, &now); f.Kind() != KindBloom {
+		t.Errorf("auto kind = %s, want bloom", f.Kind())
+	}
+	t.Run("bloom", func(t *testing.T) { testKind(t, rdb, KindBloom) })
+	t.Run("cuckoo", func(t *testing.T) { testKind(t, rdb, KindCuckoo) })
+}
+
+func TestFiltersExpire(t *testing.T) {
+	ctx := context.Background()
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+	now := time.Now()
+	f := newTestFilter(t, rdb, Options{Kind: KindBitmap, Capacity: 100, Window: time.Minute}, &now)
+
+	if _, err := f.Add(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	current, expireAt, _ := f.keys()
+	ttl, err := rdb.PTTL(ctx, current).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Until(expireAt); ttl <= 0 || ttl > want+time.Second {
+		t.Errorf("ttl = %v, want about %v", ttl, want)
+	}
+}
@@ -0,0 +1,187 @@
+package featureflags
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// resyncInterval is how often a client reloads all flags, updates
+// published while its subscription was reconnecting are lost.
+const resyncInterval = 30 * time.Second
+
+// watchBuffer is how many updates a watcher may lag behind before it is
+// dropped.
+const watchBuffer = 16
+
+// Client keeps a copy of all flags current with the updates published by
+// any store.
+type Client struct {
+	store *Store
+
+	mu       sync.RWMutex
+	flags    map[string]Flag
+	watchers map[chan Update]struct{}
+}
+
+func NewClient(store *Store) *Client {
+	return &Client{
+		store:    store,
+		flags:    make(map[string]Flag),
+		watchers: make(map[chan Update]struct{}),
+	}
+}
+
+// Run loads the flags and applies the updates until ctx is done. Flags
+// evaluate as disabled until the first load.
+func (c *Client) Run(ctx context.Context) error {
+	sub := c.store.rdb.Subscribe(ctx, updateChannel)
+	defer sub.Close()
+
+	// Subscribe before loading, so no update falls between the two
+	if _, err := sub.Receive(ctx); err != nil {
+		return fmt.Errorf("failed to subscribe: %v", err)
+	}
+	if err := c.reload(ctx); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(resyncInterval)
+	defer ticker.Stop()
+
+	ch := sub.Channel()
+	for {
+		select {
+		case m, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			var u Update
+			if err := json.Unmarshal([]byte(m.Payload), &u); err != nil {
+				log.Printf("Skipping malformed flag update: %v", err)
+				continue
+			}
+			c.apply(u)
+		case <-ticker.C:
+			if err := c.reload(ctx); err != nil {
+				log.Printf("Failed to reload flags: %v", err)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// reload replaces the flags with those in the store, notifying watchers of
+// the flags which changed.
+func (c *Client) reload(ctx context.Context) error {
+	flags, err := c.store.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load flags: %v", err)
+	}
+
+	seen := make(map[string]bool, len(flags))
+	for _, f := range flags {
+		seen[f.Name] = true
+		c.apply(Update{Flag: f})
+	}
+
+	c.mu.RLock()
+	var gone []string
+	for name := range c.flags {
+		if !seen[name] {
+			gone = append(gone, name)
+		}
+	}
+	c.mu.RUnlock()
+	for _, name := range gone {
+		c.apply(Update{Flag: Flag{Name: name}, Deleted: true})
+	}
+	return nil
+}
+
+// apply stores u and passes it to the watchers unless it changes nothing.
+func (c *Client) apply(u Update) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	old, ok := c.flags[u.Flag.Name]
+	switch {
+	case u.Deleted && !ok:
+		return
+	case u.Deleted:
+		delete(c.flags, u.Flag.Name)
+	case ok && !u.Flag.UpdatedAt.After(old.UpdatedAt):
+		// The version we have, or a reload which read the flag before
+		// the update we applied
+		return
+	default:
+		c.flags[u.Flag.Name] = u.Flag
+	}
+
+	for ch := range c.watchers {
+		select {
+		case ch <- u:
+		default:
+			delete(c.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Enabled reports whether the flag name is enabled for user, unknown flags
+// are disabled.
+func (c *Client) Enabled(name, user string) bool {
+	c.mu.RLock()
+	f, ok := c.flags[name]
+	c.mu.RUnlock()
+	return ok && f.EnabledFor(user)
+}
+
+// Flags returns all flags sorted by name.
+func (c *Client) Flags() []Flag {
+	c.mu.RLock()
+	flags := make([]Flag, 0, len(c.flags))
+	for _, f := range c.flags {
+		flags = append(flags, f)
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return flags
+}
+
+// Evaluate returns whether each flag is enabled for user.
+func (c *Client) Evaluate(user string) map[string]bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	result := make(map[string]bool, len(c.flags))
+	for name, f := range c.flags {
+		result[name] = f.EnabledFor(user)
+	}
+	return result
+}
+
+// Watch returns the updates applied from now on and a function to stop
+// watching. The channel is closed when stopped, or when the watcher fell
+// too far behind.
+func (c *Client) Watch() (<-chan Update, func()) {
+	ch := make(chan Update, watchBuffer)
+	c.mu.Lock()
+	c.watchers[ch] = struct{}{}
+	c.mu.Unlock()
+
+	return ch, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if _, ok := c.watchers[ch]; ok {
+			delete(c.watchers, ch)
+			close(ch)
+		}
+	}
+}
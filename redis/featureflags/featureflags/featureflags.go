@@ -0,0 +1,197 @@
+// Package featureflags stores feature flags in Redis and evaluates them
+// locally in every service instance.
+//
+// A flag is a hash with whether it is enabled and the percentage of users
+// it is rolled out to. Writes publish the new flag on a channel, clients
+// keep a copy of all flags current with it and evaluate without a round
+// trip to Redis. Users are bucketed by a hash of the flag and user names,
+// so a user keeps seeing a flag while its rollout grows and different
+// flags reach different users.
+package featureflags
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrNotFound is returned for flags which do not exist.
+var ErrNotFound = errors.New("flag not found")
+
+const (
+	namesKey      = "featureflags:flags"
+	updateChannel = "featureflags:updates"
+)
+
+func flagKey(name string) string { return fmt.Sprintf("featureflags:flag:%s", name) }
+
+// Flag is a feature and who sees it.
+type Flag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Enabled     bool   `json:"enabled"`
+	// Rollout is the percentage of users the flag is enabled for, 0 to 100.
+	Rollout   int       `json:"rollout"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate checks the settings of f.
+func (f *Flag) Validate() error {
+	if f.Name == "" {
+		return fmt.Errorf("flag name is required")
+	}
+	if f.Rollout < 0 || f.Rollout > 100 {
+		return fmt.Errorf("rollout must be between 0 and 100, got %d", f.Rollout)
+	}
+	return nil
+}
+
+// EnabledFor reports whether f is enabled for user.
+func (f *Flag) EnabledFor(user string) bool {
+	if !f.Enabled || f.Rollout <= 0 {
+		return false
+	}
+	return f.Rollout >= 100 || Bucket(f.Name, user) < f.Rollout
+}
+
+// Bucket returns the bucket of user for flag, from 0 to 99. A flag rolled
+// out to n percent is enabled for the users in the buckets below n.
+func Bucket(flag, user string) int {
+	h := fnv.New32a()
+	h.Write([]byte(flag))
+	h.Write([]byte{0})
+	h.Write([]byte(user))
+	return int(h.Sum32() % 100)
+}
+
+// Update is published when a flag is set or deleted.
+type Update struct {
+	Flag    Flag `json:"flag"`
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// Store reads and writes the flags in Redis.
+type Store struct {
+	rdb *redis.Client
+}
+
+func NewStore(rdb *redis.Client) *Store {
+	return &Store{rdb: rdb}
+}
+
+// Get returns the flag name.
+func (s *Store) Get(ctx context.Context, name string) (Flag, error) {
+	fields, err := s.rdb.HGetAll(ctx, flagKey(name)).Result()
+	if err != nil {
+		return Flag{}, err
+	}
+	if len(fields) == 0 {
+		return Flag{}, ErrNotFound
+	}
+	return parseFlag(name, fields), nil
+}
+
+// List returns all flags.
+func (s *Store) List(ctx context.Context) ([]Flag, error) {
+	names, err := s.rdb.Sort(ctx, namesKey, &redis.Sort{Alpha: true}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := make([]*redis.StringStringMapCmd, len(names))
+	_, err = s.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, name := range names {
+			cmds[i] = pipe.HGetAll(ctx, flagKey(name))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read flags: %v", err)
+	}
+
+	flags := make([]Flag, 0, len(names))
+	for i, cmd := range cmds {
+		// A flag deleted between SORT and HGETALL
+		if len(cmd.Val()) == 0 {
+			continue
+		}
+		flags = append(flags, parseFlag(names[i], cmd.Val()))
+	}
+	return flags, nil
+}
+
+func parseFlag(name string, fields map[string]string) Flag {
+	f := Flag{
+		Name:        name,
+		Description: fields["description"],
+		Enabled:     fields["enabled"] == "1",
+	}
+	f.Rollout, _ = strconv.Atoi(fields["rollout"])
+	if ns, err := strconv.ParseInt(fields["updated_at"], 10, 64); err == nil {
+		f.UpdatedAt = time.Unix(0, ns).UTC()
+	}
+	return f
+}
+
+// Set creates or replaces f and publishes it to the clients. It returns f
+// with the time of the update.
+func (s *Store) Set(ctx context.Context, f Flag) (Flag, error) {
+	if err := f.Validate(); err != nil {
+		return f, err
+	}
+	f.UpdatedAt = time.Now().UTC()
+
+	enabled := "0"
+	if f.Enabled {
+		enabled = "1"
+	}
+	payload, err := json.Marshal(Update{Flag: f})
+	if err != nil {
+		return f, err
+	}
+
+	_, err = s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, flagKey(f.Name),
+			"description", f.Description,
+			"enabled", enabled,
+			"rollout", f.Rollout,
+			"updated_at", f.UpdatedAt.UnixNano(),
+		)
+		pipe.SAdd(ctx, namesKey, f.Name)
+		pipe.Publish(ctx, updateChannel, payload)
+		return nil
+	})
+	if err != nil {
+		return f, fmt.Errorf("failed to set flag %s: %v", f.Name, err)
+	}
+	return f, nil
+}
+
+// Delete removes the flag name and publishes its deletion.
+func (s *Store) Delete(ctx context.Context, name string) error {
+	payload, err := json.Marshal(Update{Flag: Flag{Name: name}, Deleted: true})
+	if err != nil {
+		return err
+	}
+
+	var del *redis.IntCmd
+	_, err = s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		del = pipe.Del(ctx, flagKey(name))
+		pipe.SRem(ctx, namesKey, name)
+		pipe.Publish(ctx, updateChannel, payload)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete flag %s: %v", name, err)
+	}
+	if del.Val() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+//go:build integration
+
+package featureflags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"kate.testutil"
+)
+
+func TestBucketing(t *testing.T) {
+	f := Flag{Name: "checkout", Enabled: true, Rollout: 25}
+	wider := Flag{Name: "checkout", Enabled: true, Rollout: 50}
+
+	enabled := 0
+	for i := 0; i < 10000; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		if f.EnabledFor(user) {
+			enabled++
+			// Growing the rollout keeps the users who had the flag
+			if !wider.EnabledFor(user) {
+				t.Fatalf("%s lost the flag when the rollout grew", user)
+			}
+		}
+	}
+	if enabled < 2300 || enabled > 2700 {
+		t.Errorf("flag enabled for %d of 10000 users, want about 2500", enabled)
+	}
+
+	f.Enabled = false
+	if f.EnabledFor("user-1") {
+		t.Error("disabled flag enabled")
+	}
+}
+
+func TestClientFollowsUpdates(t *testing.T) {
+	rcfg := testutil.StartRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewStore(testutil.NewRedisClient(t, rcfg))
+	if _, err := store.Set(ctx, Flag{Name: "old", Enabled: true, Rollout: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A client of another instance
+	client := NewClient(NewStore(testutil.NewRedisClient(t, rcfg)))
+	updates, stop := client.Watch()
+	defer stop()
+	go client.Run(ctx)
+
+	testutil.Eventually(t, 5*time.Second, 50*time.Millisecond, func() error {
+		if !client.Enabled("old", "ada") {
+			return fmt.Errorf("existing flag not loaded")
+		}
+		return nil
+	})
+
+	if _, err := store.Set(ctx, Flag{Name: "new", Enabled: true, Rollout: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "old"); err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.Eventually(t, 5*time.Second, 50*time.Millisecond, func() error {
+		if !client.Enabled("new", "ada") || client.Enabled("old", "ada") {
+			return fmt.Errorf("flags are %v", client.Evaluate("ada"))
+		}
+		return nil
+	})
+
+	// Watchers saw the load and both updates
+	var got []string
+	for len(got) < 3 {
+		select {
+		case u := <-updates:
+			got = append(got, fmt.Sprintf("%s deleted=%v", u.Flag.Name, u.Deleted))
+		case <-time.After(time.Second):
+			t.Fatalf("updates = %v, want 3", got)
+		}
+	}
+	want := []string{"old deleted=false", "new deleted=false", "old deleted=true"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("updates = %v, want %v", got, want)
+	}
+
+	if err := store.Delete(ctx, "old"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleting a missing flag: got %v, want ErrNotFound", err)
+	}
+	if _, err := store.Set(ctx, Flag{Name: "bad", Rollout: 101}); err == nil {
+		t.Error("rollout above 100 accepted")
+	}
+}
@@ -0,0 +1,227 @@
+// Feature flag service: an admin API to manage flags and an evaluation API
+// for clients, which can stream their flags to follow changes live.
+//
+//	curl -X PUT localhost:8080/flags/new-checkout -H 'Authorization: Bearer secret' \
+//		-d '{"enabled":true,"rollout":25,"description":"one page checkout"}'
+//	curl 'localhost:8080/evaluate?user=ada'
+//	curl -N 'localhost:8080/stream?user=ada'
+//
+// Every instance serves the flags from memory and learns about changes
+// made on any instance through Redis Pub/Sub. The admin endpoints need the
+// -admin-token as bearer token unless it is empty.
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"kate.config"
+	"kate.redis.featureflags/featureflags"
+)
+
+// keepAliveInterval is how often an idle stream sends a comment, so
+// proxies do not close it.
+const keepAliveInterval = 15 * time.Second
+
+// Config is the Redis server, the listen address and the admin token, set
+// with flags, the matching variables (e.g. REDIS_ADDR for -redis-addr) or
+// the -config file.
+type Config struct {
+	Redis      config.RedisConfig `yaml:"redis"`
+	HTTP       config.HTTPConfig  `yaml:"http"`
+	AdminToken string             `yaml:"admin_token"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Redis: config.DefaultRedisConfig(),
+		HTTP:  config.DefaultHTTPConfig(),
+	}
+}
+
+func (c *Config) registerFlags(fs *flag.FlagSet) {
+	c.Redis.RegisterFlags(fs)
+	c.HTTP.RegisterFlags(fs)
+	fs.StringVar(&c.AdminToken, "admin-token", c.AdminToken, "bearer token of the admin endpoints, empty to leave them open")
+}
+
+func (c *Config) validate() error {
+	if err := c.Redis.Validate(); err != nil {
+		return err
+	}
+	return c.HTTP.Validate()
+}
+
+func main() {
+	cfg, _, err := config.Load(os.Args[0], "", os.Args[1:], defaultConfig, (*Config).registerFlags)
+	if err == nil {
+		err = cfg.validate()
+	}
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	rdb := cfg.Redis.NewClient()
+	defer rdb.Close()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		log.Fatal("Redis connection failed: ", err)
+	}
+
+	store := featureflags.NewStore(rdb)
+	client := featureflags.NewClient(store)
+	go func() {
+		if err := client.Run(ctx); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	admin := adminOnly(cfg.AdminToken)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /flags", admin(func(w http.ResponseWriter, r *http.Request) {
+		flags, err := store.List(r.Context())
+		writeJSON(w, flags, err)
+	}))
+	mux.HandleFunc("GET /flags/{name}", admin(func(w http.ResponseWriter, r *http.Request) {
+		f, err := store.Get(r.Context(), r.PathValue("name"))
+		writeJSON(w, f, err)
+	}))
+	mux.HandleFunc("PUT /flags/{name}", admin(func(w http.ResponseWriter, r *http.Request) {
+		var f featureflags.Flag
+		if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		f.Name = r.PathValue("name")
+		if err := f.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f, err := store.Set(r.Context(), f)
+		writeJSON(w, f, err)
+	}))
+	mux.HandleFunc("DELETE /flags/{name}", admin(func(w http.ResponseWriter, r *http.Request) {
+		if err := store.Delete(r.Context(), r.PathValue("name")); err != nil {
+			writeJSON(w, nil, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	mux.HandleFunc("GET /evaluate", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, client.Evaluate(r.URL.Query().Get("user")), nil)
+	})
+	mux.HandleFunc("GET /stream", streamHandler(client))
+
+	srv := cfg.HTTP.Server(mux)
+	// Requests end with ctx, Shutdown would wait for the streams forever
+	srv.BaseContext = func(net.Listener) context.Context { return ctx }
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+
+	log.Printf("Feature flag service listening on %s", cfg.HTTP.Addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// adminOnly wraps handlers to require token as bearer token, unless it is
+// empty.
+func adminOnly(token string) func(http.HandlerFunc) http.HandlerFunc {
+	want := []byte("Bearer " + token)
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			got := []byte(r.Header.Get("Authorization"))
+			if token != "" && subtle.ConstantTimeCompare(got, want) != 1 {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// streamHandler sends the flags of the user parameter as server-sent
+// events, once on connecting and again whenever a flag changes.
+func streamHandler(client *featureflags.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.URL.Query().Get("user")
+		updates, stopWatching := client.Watch()
+		defer stopWatching()
+
+		// The stream outlives the write timeout of the server
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+
+		send := func() error {
+			data, err := json.Marshal(client.Evaluate(user))
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "event: flags\ndata: %s\n\n", data); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+		if err := send(); err != nil {
+			return
+		}
+
+		ticker := time.NewTicker(keepAliveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case _, ok := <-updates:
+				if !ok {
+					// Fell behind, the client reconnects and starts over
+					return
+				}
+				if err := send(); err != nil {
+					return
+				}
+			case <-ticker.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+				if err := rc.Flush(); err != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}, err error) {
+	if errors.Is(err, featureflags.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,188 @@
+// Package leaderboard ranks users by score in Redis sorted sets, all time
+// and per day and week.
+//
+// Tied users share a rank, as in sports: scores 30, 20, 20, 10 rank 1, 2,
+// 2, 4. The rank of a user is therefore the number of users with a higher
+// score plus one, not its position in the sorted set, which would order
+// ties by name.
+package leaderboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrNotRanked is returned for users without a score on a board.
+var ErrNotRanked = errors.New("user not ranked")
+
+// Period is the time span of a board.
+type Period string
+
+const (
+	AllTime Period = "all"
+	Daily   Period = "daily"
+	Weekly  Period = "weekly"
+)
+
+// Periodic boards are kept for a while to look back at past winners.
+const (
+	dailyRetention  = 31 * 24 * time.Hour
+	weeklyRetention = 13 * 7 * 24 * time.Hour
+)
+
+// ParsePeriod returns the period named s.
+func ParsePeriod(s string) (Period, error) {
+	switch p := Period(s); p {
+	case AllTime, Daily, Weekly:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown period %q, want all, daily or weekly", s)
+}
+
+// Entry is a user on a board.
+type Entry struct {
+	User  string  `json:"user"`
+	Score float64 `json:"score"`
+	Rank  int64   `json:"rank"`
+}
+
+// Leaderboard keeps the all time, daily and weekly boards of a game.
+type Leaderboard struct {
+	rdb  *redis.Client
+	name string
+	now  func() time.Time
+}
+
+func New(rdb *redis.Client, name string) *Leaderboard {
+	return &Leaderboard{rdb: rdb, name: name, now: time.Now}
+}
+
+// key returns the key of the board of period p containing t, daily and
+// weekly boards use UTC days and ISO weeks.
+func (lb *Leaderboard) key(p Period, t time.Time) string {
+	t = t.UTC()
+	switch p {
+	case Daily:
+		return fmt.Sprintf("leaderboard:%s:daily:%s", lb.name, t.Format("2006-01-02"))
+	case Weekly:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("leaderboard:%s:weekly:%d-W%02d", lb.name, year, week)
+	}
+	return fmt.Sprintf("leaderboard:%s:all", lb.name)
+}
+
+// IncrScore adds delta to the score of user on all boards and returns the
+// new all time score.
+func (lb *Leaderboard) IncrScore(ctx context.Context, user string, delta float64) (float64, error) {
+	now := lb.now()
+	daily, weekly := lb.key(Daily, now), lb.key(Weekly, now)
+
+	var total *redis.FloatCmd
+	_, err := lb.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		total = pipe.ZIncrBy(ctx, lb.key(AllTime, now), delta, user)
+		pipe.ZIncrBy(ctx, daily, delta, user)
+		pipe.Expire(ctx, daily, dailyRetention)
+		pipe.ZIncrBy(ctx, weekly, delta, user)
+		pipe.Expire(ctx, weekly, weeklyRetention)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment score: %v", err)
+	}
+	return total.Val(), nil
+}
+
+// Current returns the board of period p containing now.
+func (lb *Leaderboard) Current(p Period) *Board {
+	return lb.Board(p, lb.now())
+}
+
+// Board returns the board of period p containing t.
+func (lb *Leaderboard) Board(p Period, t time.Time) *Board {
+	return &Board{rdb: lb.rdb, key: lb.key(p, t)}
+}
+
+// Board is one ranking, e.g. of a day.
+type Board struct {
+	rdb *redis.Client
+	key string
+}
+
+// Count returns the number of users on the board.
+func (b *Board) Count(ctx context.Context) (int64, error) {
+	return b.rdb.ZCard(ctx, b.key).Result()
+}
+
+// Rank returns the entry of user.
+func (b *Board) Rank(ctx context.Context, user string) (Entry, error) {
+	score, err := b.rdb.ZScore(ctx, b.key, user).Result()
+	if err == redis.Nil {
+		return Entry{}, ErrNotRanked
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+
+	higher, err := b.rdb.ZCount(ctx, b.key, "("+formatScore(score), "+inf").Result()
+	if err != nil {
+		return Entry{}, err
+	}
+	return Entry{User: user, Score: score, Rank: higher + 1}, nil
+}
+
+// Top returns count entries starting at position offset, 0 is the top.
+func (b *Board) Top(ctx context.Context, offset, count int64) ([]Entry, error) {
+	if count <= 0 {
+		return nil, nil
+	}
+	return b.rangeAt(ctx, offset, offset+count-1)
+}
+
+// Around returns user with up to n entries above and below.
+func (b *Board) Around(ctx context.Context, user string, n int64) ([]Entry, error) {
+	pos, err := b.rdb.ZRevRank(ctx, b.key, user).Result()
+	if err == redis.Nil {
+		return nil, ErrNotRanked
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b.rangeAt(ctx, max(pos-n, 0), pos+n)
+}
+
+// rangeAt returns the entries from position start to stop, inclusive.
+func (b *Board) rangeAt(ctx context.Context, start, stop int64) ([]Entry, error) {
+	zs, err := b.rdb.ZRevRangeWithScores(ctx, b.key, start, stop).Result()
+	if err != nil || len(zs) == 0 {
+		return nil, err
+	}
+
+	// Only the first entry may tie with entries before the range, the
+	// others rank by position unless they tie with their predecessor.
+	first, err := b.rdb.ZCount(ctx, b.key, "("+formatScore(zs[0].Score), "+inf").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, len(zs))
+	for i, z := range zs {
+		e := Entry{User: z.Member.(string), Score: z.Score, Rank: start + int64(i) + 1}
+		switch {
+		case i == 0:
+			e.Rank = first + 1
+		case z.Score == zs[i-1].Score:
+			e.Rank = entries[i-1].Rank
+		}
+		entries[i] = e
+	}
+	return entries, nil
+}
+
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
@@ -0,0 +1,139 @@
+//go:build integration
+
+package leaderboard
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"kate.testutil"
+)
+
+func newTestLeaderboard(t *testing.T, now time.Time) *Leaderboard {
+	t.Helper()
+	lb := New(testutil.NewRedisClient(t, testutil.StartRedis(t)), "test")
+	lb.now = func() time.Time { return now }
+	return lb
+}
+
+func TestTiesShareRank(t *testing.T) {
+	ctx := context.Background()
+	lb := newTestLeaderboard(t, time.Now())
+
+	for user, score := range map[string]float64{"ada": 30, "bob": 20, "cy": 20, "dan": 10} {
+		if _, err := lb.IncrScore(ctx, user, score); err != nil {
+			t.Fatal(err)
+		}
+	}
+	board := lb.Current(AllTime)
+
+	want := map[string]int64{"ada": 1, "bob": 2, "cy": 2, "dan": 4}
+	for user, rank := range want {
+		e, err := board.Rank(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Rank != rank {
+			t.Errorf("rank of %s = %d, want %d", user, e.Rank, rank)
+		}
+	}
+
+	// A page starting in the middle of a tie must keep the shared rank
+	entries, err := board.Top(ctx, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ranks []int64
+	for _, e := range entries {
+		ranks = append(ranks, e.Rank)
+	}
+	if !reflect.DeepEqual(ranks, []int64{2, 4}) {
+		t.Errorf("ranks of page 2 = %v, want [2 4]", ranks)
+	}
+
+	if _, err := board.Rank(ctx, "eve"); !errors.Is(err, ErrNotRanked) {
+		t.Errorf("rank of unknown user: got %v, want ErrNotRanked", err)
+	}
+}
+
+func TestTopAndAround(t *testing.T) {
+	ctx := context.Background()
+	lb := newTestLeaderboard(t, time.Now())
+
+	users := []string{"u0", "u1", "u2", "u3", "u4", "u5", "u6", "u7", "u8", "u9"}
+	for i, user := range users {
+		if _, err := lb.IncrScore(ctx, user, float64(100-i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	board := lb.Current(AllTime)
+
+	page, err := board.Top(ctx, 3, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 3 || page[0].User != "u3" || page[0].Rank != 4 || page[2].User != "u5" {
+		t.Errorf("page 2 = %+v, want u3 to u5 from rank 4", page)
+	}
+
+	around, err := board.Around(ctx, "u1", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(around) != 4 || around[0].User != "u0" || around[3].User != "u3" {
+		t.Errorf("around u1 = %+v, want u0 to u3", around)
+	}
+}
+
+func TestPeriodicBoards(t *testing.T) {
+	ctx := context.Background()
+	monday := time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC)
+	lb := newTestLeaderboard(t, monday)
+
+	if _, err := lb.IncrScore(ctx, "ada", 10); err != nil {
+		t.Fatal(err)
+	}
+	lb.now = func() time.Time { return monday.AddDate(0, 0, 1) }
+	total, err := lb.IncrScore(ctx, "ada", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 15 {
+		t.Errorf("all time score = %v, want 15", total)
+	}
+
+	for _, tc := range []struct {
+		board *Board
+		want  float64
+	}{
+		{lb.Board(Daily, monday), 10},
+		{lb.Current(Daily), 5},
+		{lb.Current(Weekly), 15},
+		{lb.Board(Weekly, monday.AddDate(0, 0, 7)), 0},
+	} {
+		e, err := tc.board.Rank(ctx, "ada")
+		if tc.want == 0 {
+			if !errors.Is(err, ErrNotRanked) {
+				t.Errorf("%s: got %v, want ErrNotRanked", tc.board.key, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Score != tc.want {
+			t.Errorf("%s: score = %v, want %v", tc.board.key, e.Score, tc.want)
+		}
+	}
+
+	ttl, err := lb.rdb.TTL(ctx, lb.key(Daily, monday)).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= 0 || ttl > dailyRetention {
+		t.Errorf("TTL of daily board = %v, want up to %v", ttl, dailyRetention)
+	}
+}
@@ -0,0 +1,206 @@
+// Leaderboard service: ranks players of a game all time, per day and per
+// week. Add points and query the boards, e.g.
+//
+//	curl -X POST localhost:8080/scores -d '{"user":"ada","points":30}'
+//	curl 'localhost:8080/leaderboard?period=daily&page=1&size=10'
+//	curl 'localhost:8080/users/ada/rank?period=weekly'
+//	curl 'localhost:8080/users/ada/around?n=2'
+//
+// GET /leaderboard takes a date=YYYY-MM-DD to show the board of a past day
+// or of the week containing it. Tied players share a rank.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"kate.config"
+	"kate.redis.leaderboard/leaderboard"
+)
+
+// maxPageSize caps the entries returned by one request.
+const maxPageSize = 100
+
+// Config is the Redis server, the listen address and the game, set with
+// flags, the matching variables (e.g. REDIS_ADDR for -redis-addr) or the
+// -config file.
+type Config struct {
+	Redis config.RedisConfig `yaml:"redis"`
+	HTTP  config.HTTPConfig  `yaml:"http"`
+	Game  string             `yaml:"game"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Redis: config.DefaultRedisConfig(),
+		HTTP:  config.DefaultHTTPConfig(),
+		Game:  "default",
+	}
+}
+
+func (c *Config) registerFlags(fs *flag.FlagSet) {
+	c.Redis.RegisterFlags(fs)
+	c.HTTP.RegisterFlags(fs)
+	fs.StringVar(&c.Game, "game", c.Game, "name of the game, each game has its own boards")
+}
+
+func (c *Config) validate() error {
+	if err := c.Redis.Validate(); err != nil {
+		return err
+	}
+	if c.Game == "" {
+		return fmt.Errorf("game is required")
+	}
+	return c.HTTP.Validate()
+}
+
+func main() {
+	cfg, _, err := config.Load(os.Args[0], "", os.Args[1:], defaultConfig, (*Config).registerFlags)
+	if err == nil {
+		err = cfg.validate()
+	}
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	rdb := cfg.Redis.NewClient()
+	defer rdb.Close()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		log.Fatal("Redis connection failed: ", err)
+	}
+
+	lb := leaderboard.New(rdb, cfg.Game)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /scores", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			User   string  `json:"user"`
+			Points float64 `json:"points"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.User == "" {
+			http.Error(w, "Invalid JSON, user is required", http.StatusBadRequest)
+			return
+		}
+		score, err := lb.IncrScore(r.Context(), req.User, req.Points)
+		writeJSON(w, map[string]interface{}{"user": req.User, "score": score}, err)
+	})
+	mux.HandleFunc("GET /leaderboard", func(w http.ResponseWriter, r *http.Request) {
+		board, err := boardOf(lb, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		page := intParam(r, "page", 1, 1<<20)
+		size := intParam(r, "size", 10, maxPageSize)
+
+		total, err := board.Count(r.Context())
+		if err != nil {
+			writeJSON(w, nil, err)
+			return
+		}
+		entries, err := board.Top(r.Context(), (page-1)*size, size)
+		writeJSON(w, map[string]interface{}{
+			"page":    page,
+			"size":    size,
+			"total":   total,
+			"entries": nonNil(entries),
+		}, err)
+	})
+	mux.HandleFunc("GET /users/{user}/rank", func(w http.ResponseWriter, r *http.Request) {
+		board, err := boardOf(lb, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		entry, err := board.Rank(r.Context(), r.PathValue("user"))
+		writeJSON(w, entry, err)
+	})
+	mux.HandleFunc("GET /users/{user}/around", func(w http.ResponseWriter, r *http.Request) {
+		board, err := boardOf(lb, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		n := intParam(r, "n", 5, maxPageSize/2)
+		entries, err := board.Around(r.Context(), r.PathValue("user"), n)
+		writeJSON(w, entries, err)
+	})
+
+	srv := cfg.HTTP.Server(mux)
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+
+	log.Printf("Leaderboard service listening on %s", cfg.HTTP.Addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// boardOf returns the board selected by the period and date parameters of
+// r, the current all time board by default.
+func boardOf(lb *leaderboard.Leaderboard, r *http.Request) (*leaderboard.Board, error) {
+	period := leaderboard.AllTime
+	if s := r.URL.Query().Get("period"); s != "" {
+		p, err := leaderboard.ParsePeriod(s)
+		if err != nil {
+			return nil, err
+		}
+		period = p
+	}
+
+	s := r.URL.Query().Get("date")
+	if s == "" {
+		return lb.Current(period), nil
+	}
+	date, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %q, want YYYY-MM-DD", s)
+	}
+	return lb.Board(period, date), nil
+}
+
+// intParam returns the query parameter name of r clamped to [1, max], or
+// def if it is missing or invalid.
+func intParam(r *http.Request, name string, def, max int64) int64 {
+	n, err := strconv.ParseInt(r.URL.Query().Get(name), 10, 64)
+	if err != nil || n < 1 {
+		return def
+	}
+	return min(n, max)
+}
+
+// nonNil encodes an empty page as [] rather than null.
+func nonNil(entries []leaderboard.Entry) []leaderboard.Entry {
+	if entries == nil {
+		return []leaderboard.Entry{}
+	}
+	return entries
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}, err error) {
+	if errors.Is(err, leaderboard.ErrNotRanked) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,297 @@
+// Package locks implements distributed locks on Redis.
+//
+// A lock is a key set with SET NX PX to a random token, so only its owner
+// can release or extend it, which scripts check atomically. NewLocker
+// locks on one Redis server. NewRedlock locks on a majority of independent
+// servers with the Redlock algorithm, so a lock survives the failure of a
+// minority of them. The time the servers took to answer and a clock drift
+// margin are deducted from the validity of a lock.
+//
+// A lock expires after its TTL unless extended, which the watchdog does in
+// the background until the lock is released. If extending fails, e.g.
+// because the process was paused for longer than the TTL, the lock is
+// lost and Lost is closed: the work it protected must stop.
+package locks
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	// ErrNotAcquired is returned by TryAcquire when the lock is held by
+	// someone else.
+	ErrNotAcquired = errors.New("lock not acquired")
+	// ErrNotHeld is returned when extending or releasing a lock which
+	// expired, another owner may hold it now.
+	ErrNotHeld = errors.New("lock not held")
+)
+
+// releaseScript deletes the lock only if it still holds the token.
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// extendScript resets the expiry of the lock only if it still holds the token.
+var extendScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// Options configure a Locker.
+type Options struct {
+	// TTL is how long a lock is valid unless extended, default 10s.
+	TTL time.Duration
+	// RetryDelay is the pause between the attempts of Acquire, a random
+	// jitter of up to half of it is added. Default 50ms.
+	RetryDelay time.Duration
+	// Watchdog extends held locks every TTL/3 until they are released.
+	Watchdog bool
+}
+
+// Locker acquires locks on one or, with Redlock, several Redis servers.
+type Locker struct {
+	nodes  []*redis.Client
+	opts   Options
+	quorum int
+}
+
+// NewLocker returns a locker on a single Redis server.
+func NewLocker(rdb *redis.Client, opts Options) *Locker {
+	return NewRedlock([]*redis.Client{rdb}, opts)
+}
+
+// NewRedlock returns a locker acquiring locks on a majority of nodes, which
+// must be independent servers, not replicas of each other.
+func NewRedlock(nodes []*redis.Client, opts Options) *Locker {
+	if opts.TTL <= 0 {
+		opts.TTL = 10 * time.Second
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = 50 * time.Millisecond
+	}
+	return &Locker{
+		nodes:  nodes,
+		opts:   opts,
+		quorum: len(nodes)/2 + 1,
+	}
+}
+
+// Acquire tries to acquire the lock on key until it succeeds or ctx is done.
+func (l *Locker) Acquire(ctx context.Context, key string) (*Lock, error) {
+	for {
+		lk, err := l.TryAcquire(ctx, key)
+		if !errors.Is(err, ErrNotAcquired) {
+			return lk, err
+		}
+
+		delay := l.opts.RetryDelay + rand.N(l.opts.RetryDelay/2+1)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// TryAcquire acquires the lock on key or returns ErrNotAcquired at once.
+func (l *Locker) TryAcquire(ctx context.Context, key string) (*Lock, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	n := l.onNodes(ctx, func(ctx context.Context, rdb *redis.Client) (bool, error) {
+		return rdb.SetNX(ctx, key, token, l.opts.TTL).Result()
+	})
+
+	until, ok := l.valid(start, n)
+	if !ok {
+		// Undo the partial acquisition so others need not wait for expiry
+		l.release(context.WithoutCancel(ctx), key, token)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrNotAcquired
+	}
+
+	lk := &Lock{
+		locker: l,
+		key:    key,
+		token:  token,
+		until:  until,
+		lost:   make(chan struct{}),
+	}
+	if l.opts.Watchdog {
+		lk.stop = make(chan struct{})
+		lk.done = make(chan struct{})
+		go lk.watchdog()
+	}
+	return lk, nil
+}
+
+// valid reports whether n nodes are a quorum and returns until when the
+// lock taken at start is valid.
+func (l *Locker) valid(start time.Time, n int) (time.Time, bool) {
+	drift := l.opts.TTL/100 + 2*time.Millisecond
+	until := start.Add(l.opts.TTL - drift)
+	return until, n >= l.quorum && time.Now().Before(until)
+}
+
+// onNodes runs op on all nodes concurrently and returns on how many it
+// succeeded. A node which does not answer within a tenth of the TTL is
+// skipped, waiting longer would eat up the validity of the lock.
+func (l *Locker) onNodes(ctx context.Context, op func(context.Context, *redis.Client) (bool, error)) int {
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+		n  int
+	)
+	for _, rdb := range l.nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, l.opts.TTL/10)
+			defer cancel()
+
+			if ok, err := op(ctx, rdb); err == nil && ok {
+				mu.Lock()
+				n++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return n
+}
+
+// release deletes the lock on all nodes and returns on how many it was held.
+func (l *Locker) release(ctx context.Context, key, token string) int {
+	return l.onNodes(ctx, func(ctx context.Context, rdb *redis.Client) (bool, error) {
+		n, err := releaseScript.Run(ctx, rdb, []string{key}, token).Int()
+		return n == 1, err
+	})
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := crand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Lock is an acquired lock.
+type Lock struct {
+	locker *Locker
+	key    string
+	token  string
+
+	mu    sync.Mutex
+	until time.Time
+
+	// stop ends the watchdog, which closes done. lost is closed when the
+	// watchdog failed to extend the lock.
+	stop chan struct{}
+	done chan struct{}
+	lost chan struct{}
+}
+
+// Key returns the locked key.
+func (lk *Lock) Key() string {
+	return lk.key
+}
+
+// Until returns when the lock expires unless it is extended.
+func (lk *Lock) Until() time.Time {
+	lk.mu.Lock()
+	defer lk.mu.Unlock()
+	return lk.until
+}
+
+// Lost is closed when the watchdog failed to extend the lock.
+func (lk *Lock) Lost() <-chan struct{} {
+	return lk.lost
+}
+
+// Extend resets the TTL of the lock, it returns ErrNotHeld if the lock
+// expired before.
+func (lk *Lock) Extend(ctx context.Context) error {
+	l := lk.locker
+	ttl := l.opts.TTL.Milliseconds()
+
+	start := time.Now()
+	n := l.onNodes(ctx, func(ctx context.Context, rdb *redis.Client) (bool, error) {
+		n, err := extendScript.Run(ctx, rdb, []string{lk.key}, lk.token, ttl).Int()
+		return n == 1, err
+	})
+
+	until, ok := l.valid(start, n)
+	if !ok {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return ErrNotHeld
+	}
+
+	lk.mu.Lock()
+	lk.until = until
+	lk.mu.Unlock()
+	return nil
+}
+
+// Release stops the watchdog and deletes the lock. It returns ErrNotHeld
+// if the lock had expired, the protected work may have overlapped with
+// another owner then.
+func (lk *Lock) Release(ctx context.Context) error {
+	if lk.stop != nil {
+		close(lk.stop)
+		<-lk.done
+		lk.stop = nil
+	}
+
+	if lk.locker.release(ctx, lk.key, lk.token) < lk.locker.quorum {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return ErrNotHeld
+	}
+	return nil
+}
+
+// watchdog extends the lock every TTL/3 until it is released.
+func (lk *Lock) watchdog() {
+	defer close(lk.done)
+
+	interval := lk.locker.opts.TTL / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			err := lk.Extend(ctx)
+			cancel()
+			if err != nil {
+				close(lk.lost)
+				return
+			}
+		case <-lk.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,168 @@
+//go:build integration
+
+package locks
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"kate.testutil"
+)
+
+func TestContention(t *testing.T) {
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+	locker := NewLocker(rdb, Options{TTL: time.Second, RetryDelay: 5 * time.Millisecond})
+	ctx := context.Background()
+
+	// Unprotected read-modify-writes of concurrent workers lose updates.
+	const workers, increments = 8, 10
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				lock, err := locker.Acquire(ctx, "contended")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				n, _ := rdb.Get(ctx, "counter").Int()
+				rdb.Set(ctx, "counter", n+1, 0)
+				if err := lock.Release(ctx); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	n, err := rdb.Get(ctx, "counter").Int()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != workers*increments {
+		t.Fatalf("got counter %d, want %d", n, workers*increments)
+	}
+}
+
+func TestTryAcquireHeld(t *testing.T) {
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+	locker := NewLocker(rdb, Options{TTL: 5 * time.Second})
+	ctx := context.Background()
+
+	lock, err := locker.TryAcquire(ctx, "held")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := locker.TryAcquire(ctx, "held"); !errors.Is(err, ErrNotAcquired) {
+		t.Fatalf("got %v acquiring a held lock, want ErrNotAcquired", err)
+	}
+
+	// Acquire gives up with its context.
+	acquireCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if _, err := locker.Acquire(acquireCtx, "held"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want deadline exceeded", err)
+	}
+
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := locker.TryAcquire(ctx, "held"); err != nil {
+		t.Fatalf("got %v acquiring a released lock", err)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+	locker := NewLocker(rdb, Options{TTL: 200 * time.Millisecond})
+	ctx := context.Background()
+
+	first, err := locker.TryAcquire(ctx, "expiring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	second, err := locker.TryAcquire(ctx, "expiring")
+	if err != nil {
+		t.Fatalf("got %v acquiring an expired lock", err)
+	}
+
+	// The first owner can neither extend nor release the lock of the second.
+	if err := first.Extend(ctx); !errors.Is(err, ErrNotHeld) {
+		t.Fatalf("got %v extending an expired lock, want ErrNotHeld", err)
+	}
+	if err := first.Release(ctx); !errors.Is(err, ErrNotHeld) {
+		t.Fatalf("got %v releasing an expired lock, want ErrNotHeld", err)
+	}
+	if err := second.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+	locker := NewLocker(rdb, Options{TTL: 300 * time.Millisecond, Watchdog: true})
+	ctx := context.Background()
+
+	lock, err := locker.TryAcquire(ctx, "watched")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Held for several TTLs.
+	time.Sleep(time.Second)
+	if _, err := locker.TryAcquire(ctx, "watched"); !errors.Is(err, ErrNotAcquired) {
+		t.Fatalf("got %v, want the watchdog to keep the lock", err)
+	}
+
+	// Deleting the key behind the back of the owner loses the lock.
+	rdb.Del(ctx, "watched")
+	select {
+	case <-lock.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("lock not reported lost")
+	}
+	if err := lock.Release(ctx); !errors.Is(err, ErrNotHeld) {
+		t.Fatalf("got %v releasing a lost lock, want ErrNotHeld", err)
+	}
+}
+
+func TestRedlockQuorum(t *testing.T) {
+	var nodes []*redis.Client
+	for i := 0; i < 3; i++ {
+		nodes = append(nodes, testutil.NewRedisClient(t, testutil.StartRedis(t)))
+	}
+	locker := NewRedlock(nodes, Options{TTL: 5 * time.Second})
+	ctx := context.Background()
+
+	// Held by someone else on one node, two are still a majority.
+	nodes[0].Set(ctx, "minority", "other", time.Minute)
+	lock, err := locker.TryAcquire(ctx, "minority")
+	if err != nil {
+		t.Fatalf("got %v with a majority of nodes free", err)
+	}
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := nodes[0].Get(ctx, "minority").Result(); v != "other" {
+		t.Fatalf("release deleted the lock of another owner, got %q", v)
+	}
+
+	// Held on two nodes, no majority. The partially acquired node is
+	// released again.
+	nodes[0].Set(ctx, "majority", "other", time.Minute)
+	nodes[1].Set(ctx, "majority", "other", time.Minute)
+	if _, err := locker.TryAcquire(ctx, "majority"); !errors.Is(err, ErrNotAcquired) {
+		t.Fatalf("got %v without a majority, want ErrNotAcquired", err)
+	}
+	if n, _ := nodes[2].Exists(ctx, "majority").Result(); n != 0 {
+		t.Fatal("partial lock not released")
+	}
+}
@@ -0,0 +1,131 @@
+// Distributed lock example: workers increment a counter in Redis with a
+// read-modify-write which would lose updates without the lock. With
+// -redlock-addrs the lock is taken on a majority of those servers instead
+// of the one of -redis-addr.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"kate.config"
+	"kate.redis.locks/locks"
+)
+
+// Config is the Redis server of the counter and optionally the Redlock
+// servers, set with flags, the matching variables (e.g. REDIS_ADDR for
+// -redis-addr) or the -config file.
+type Config struct {
+	Redis        config.RedisConfig `yaml:"redis"`
+	RedlockAddrs string             `yaml:"redlock_addrs"`
+	Workers      int                `yaml:"workers"`
+	Increments   int                `yaml:"increments"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Redis:      config.DefaultRedisConfig(),
+		Workers:    5,
+		Increments: 20,
+	}
+}
+
+func (c *Config) registerFlags(fs *flag.FlagSet) {
+	c.Redis.RegisterFlags(fs)
+	fs.StringVar(&c.RedlockAddrs, "redlock-addrs", c.RedlockAddrs, "comma separated host:port of independent Redis servers to lock on with Redlock")
+	fs.IntVar(&c.Workers, "workers", c.Workers, "number of concurrent workers")
+	fs.IntVar(&c.Increments, "increments", c.Increments, "increments per worker")
+}
+
+func (c *Config) validate() error {
+	if err := c.Redis.Validate(); err != nil {
+		return err
+	}
+	if c.Workers < 1 || c.Increments < 1 {
+		return fmt.Errorf("workers and increments must be positive")
+	}
+	return nil
+}
+
+func main() {
+	cfg, _, err := config.Load(os.Args[0], "", os.Args[1:], defaultConfig, (*Config).registerFlags)
+	if err == nil {
+		err = cfg.validate()
+	}
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	ctx := context.Background()
+	rdb := cfg.Redis.NewClient()
+	defer rdb.Close()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		log.Fatal("Redis connection failed: ", err)
+	}
+
+	opts := locks.Options{TTL: 2 * time.Second, Watchdog: true}
+	locker := locks.NewLocker(rdb, opts)
+	if cfg.RedlockAddrs != "" {
+		var nodes []*redis.Client
+		for _, addr := range strings.Split(cfg.RedlockAddrs, ",") {
+			node := redis.NewClient(&redis.Options{Addr: strings.TrimSpace(addr)})
+			defer node.Close()
+			nodes = append(nodes, node)
+		}
+		locker = locks.NewRedlock(nodes, opts)
+		log.Printf("Locking with Redlock on %d servers", len(nodes))
+	}
+
+	const counter = "locks:example:counter"
+	if err := rdb.Set(ctx, counter, 0, 0).Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for w := 0; w < cfg.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < cfg.Increments; i++ {
+				if err := increment(ctx, locker, rdb, counter); err != nil {
+					log.Printf("Worker %d: %v", w, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	n, err := rdb.Get(ctx, counter).Int()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Counter is %d, want %d, took %v\n", n, cfg.Workers*cfg.Increments, time.Since(start))
+}
+
+// increment reads, increments and writes the counter under the lock.
+func increment(ctx context.Context, locker *locks.Locker, rdb *redis.Client, counter string) error {
+	acquireCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	lock, err := locker.Acquire(acquireCtx, counter+":lock")
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to acquire lock: %v", err)
+	}
+
+	n, err := rdb.Get(ctx, counter).Int()
+	if err == nil {
+		err = rdb.Set(ctx, counter, n+1, 0).Err()
+	}
+
+	if rerr := lock.Release(ctx); rerr != nil && err == nil {
+		err = fmt.Errorf("failed to release lock: %v", rerr)
+	}
+	return err
+}
@@ -0,0 +1,337 @@
+// Nearby service: tracks where the entities of a fleet, e.g. couriers, are
+// and finds those close to a point. Report positions, search and follow
+// the fleet live, e.g.
+//
+//	curl -X PUT localhost:8080/entities/courier-1/location -d '{"lat":52.5200,"lon":13.4050}'
+//	curl 'localhost:8080/nearby?lat=52.52&lon=13.40&radius=2000&limit=10'
+//	curl -N 'localhost:8080/stream?lat=52.52&lon=13.40&radius=2000'
+//
+// Radii are in meters. The stream sends a location event whenever an
+// entity moves and a removed event when it stops being tracked; given a
+// circle it starts with the entities inside and only follows those, an
+// entity leaving the circle counts as removed. Entities which did not
+// report for -max-age are removed.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"kate.config"
+	"kate.redis.nearby/nearby"
+)
+
+const (
+	// maxResults caps the entities returned by one search.
+	maxResults = 100
+	// maxRadius caps the radius of searches and streams in meters.
+	maxRadius = 100_000
+	// keepAliveInterval is how often an idle stream sends a comment, so
+	// proxies do not close it.
+	keepAliveInterval = 15 * time.Second
+)
+
+// Config is the Redis server, the listen address and the fleet, set with
+// flags, the matching variables (e.g. REDIS_ADDR for -redis-addr) or the
+// -config file.
+type Config struct {
+	Redis  config.RedisConfig `yaml:"redis"`
+	HTTP   config.HTTPConfig  `yaml:"http"`
+	Fleet  string             `yaml:"fleet"`
+	MaxAge time.Duration      `yaml:"max_age"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Redis:  config.DefaultRedisConfig(),
+		HTTP:   config.DefaultHTTPConfig(),
+		Fleet:  "default",
+		MaxAge: 10 * time.Minute,
+	}
+}
+
+func (c *Config) registerFlags(fs *flag.FlagSet) {
+	c.Redis.RegisterFlags(fs)
+	c.HTTP.RegisterFlags(fs)
+	fs.StringVar(&c.Fleet, "fleet", c.Fleet, "name of the fleet, each fleet is tracked on its own")
+	fs.DurationVar(&c.MaxAge, "max-age", c.MaxAge, "remove entities which did not report for this long, 0 keeps them")
+}
+
+func (c *Config) validate() error {
+	if err := c.Redis.Validate(); err != nil {
+		return err
+	}
+	if c.Fleet == "" {
+		return fmt.Errorf("fleet is required")
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("max-age must not be negative")
+	}
+	return c.HTTP.Validate()
+}
+
+func main() {
+	cfg, _, err := config.Load(os.Args[0], "", os.Args[1:], defaultConfig, (*Config).registerFlags)
+	if err == nil {
+		err = cfg.validate()
+	}
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	rdb := cfg.Redis.NewClient()
+	defer rdb.Close()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		log.Fatal("Redis connection failed: ", err)
+	}
+
+	tracker := nearby.New(rdb, cfg.Fleet)
+	if cfg.MaxAge > 0 {
+		go prune(ctx, tracker, cfg.MaxAge)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /entities/{id}/location", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Lat *float64 `json:"lat"`
+			Lon *float64 `json:"lon"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Lat == nil || req.Lon == nil {
+			http.Error(w, "Invalid JSON, lat and lon are required", http.StatusBadRequest)
+			return
+		}
+		loc, err := tracker.Update(r.Context(), r.PathValue("id"), *req.Lat, *req.Lon)
+		writeJSON(w, loc, err)
+	})
+	mux.HandleFunc("GET /entities/{id}", func(w http.ResponseWriter, r *http.Request) {
+		loc, err := tracker.Get(r.Context(), r.PathValue("id"))
+		writeJSON(w, loc, err)
+	})
+	mux.HandleFunc("DELETE /entities/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if err := tracker.Remove(r.Context(), r.PathValue("id")); err != nil {
+			writeJSON(w, nil, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /nearby", func(w http.ResponseWriter, r *http.Request) {
+		c, err := circleOf(r, true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit := 20
+		if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+			limit = min(n, maxResults)
+		}
+		results, err := tracker.Nearby(r.Context(), c.lat, c.lon, c.radius, limit)
+		if results == nil {
+			results = []nearby.Result{}
+		}
+		writeJSON(w, results, err)
+	})
+	mux.HandleFunc("GET /stream", streamHandler(tracker))
+
+	srv := cfg.HTTP.Server(mux)
+	// Requests end with ctx, Shutdown would wait for the streams forever
+	srv.BaseContext = func(net.Listener) context.Context { return ctx }
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+
+	log.Printf("Nearby service listening on %s", cfg.HTTP.Addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// prune removes the entities which did not report for maxAge, checking
+// every tenth of it until ctx is done.
+func prune(ctx context.Context, tracker *nearby.Tracker, maxAge time.Duration) {
+	ticker := time.NewTicker(max(maxAge/10, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n, err := tracker.Prune(ctx, maxAge)
+			if err != nil {
+				log.Printf("Failed to prune: %v", err)
+			} else if n > 0 {
+				log.Printf("Pruned %d entities which stopped reporting", n)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// circle is an area given by the lat, lon and radius parameters.
+type circle struct {
+	lat, lon, radius float64
+}
+
+func (c circle) contains(loc *nearby.Location) bool {
+	return nearby.Distance(c.lat, c.lon, loc.Latitude, loc.Longitude) <= c.radius
+}
+
+// circleOf parses the circle of r. Unless required, a request without
+// parameters returns a nil circle.
+func circleOf(r *http.Request, required bool) (*circle, error) {
+	q := r.URL.Query()
+	if !required && q.Get("lat") == "" && q.Get("lon") == "" && q.Get("radius") == "" {
+		return nil, nil
+	}
+
+	var c circle
+	var err error
+	if c.lat, err = strconv.ParseFloat(q.Get("lat"), 64); err != nil {
+		return nil, fmt.Errorf("invalid lat %q", q.Get("lat"))
+	}
+	if c.lon, err = strconv.ParseFloat(q.Get("lon"), 64); err != nil {
+		return nil, fmt.Errorf("invalid lon %q", q.Get("lon"))
+	}
+	if err := nearby.ValidatePosition(c.lat, c.lon); err != nil {
+		return nil, err
+	}
+	c.radius = 1000
+	if s := q.Get("radius"); s != "" {
+		c.radius, err = strconv.ParseFloat(s, 64)
+		if err != nil || !(c.radius > 0 && c.radius <= maxRadius) {
+			return nil, fmt.Errorf("invalid radius %q, want meters up to %d", s, maxRadius)
+		}
+	}
+	return &c, nil
+}
+
+// streamHandler sends the events of the fleet as server-sent events. Given
+// a circle, it first sends the entities inside and then follows only
+// those, reporting entities which leave it as removed.
+func streamHandler(tracker *nearby.Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		area, err := circleOf(r, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Watch before searching, so no move falls between the two
+		events, err := tracker.Watch(r.Context())
+		if err != nil {
+			writeJSON(w, nil, err)
+			return
+		}
+		inside := make(map[string]bool)
+		var initial []nearby.Result
+		if area != nil {
+			initial, err = tracker.Nearby(r.Context(), area.lat, area.lon, area.radius, maxResults)
+			if err != nil {
+				writeJSON(w, nil, err)
+				return
+			}
+		}
+
+		// The stream outlives the write timeout of the server
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+
+		send := func(e nearby.Event) error {
+			name := "location"
+			if e.Removed {
+				name = "removed"
+				e.Location = nil
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+			return err
+		}
+		for _, res := range initial {
+			inside[res.ID] = true
+			if err := send(nearby.Event{ID: res.ID, Location: &res.Location}); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		ticker := time.NewTicker(keepAliveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case e, ok := <-events:
+				if !ok {
+					// Redis went away, the client reconnects
+					return
+				}
+				if area != nil {
+					in := !e.Removed && area.contains(e.Location)
+					if !in && !inside[e.ID] {
+						continue
+					}
+					e.Removed = !in
+					if in {
+						inside[e.ID] = true
+					} else {
+						delete(inside, e.ID)
+					}
+				}
+				if err := send(e); err != nil {
+					return
+				}
+				if err := rc.Flush(); err != nil {
+					return
+				}
+			case <-ticker.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+				if err := rc.Flush(); err != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}, err error) {
+	if errors.Is(err, nearby.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, nearby.ErrInvalidPosition) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
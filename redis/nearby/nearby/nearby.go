@@ -0,0 +1,315 @@
+// Package nearby tracks the positions of moving entities, e.g. couriers or
+// vehicles, in Redis and finds those near a point.
+//
+// The positions of a fleet are members of a geo set, a sorted set scored
+// by the geohash of each position, written with GEOADD and searched with
+// GEOSEARCH. A second sorted set holds when each entity reported last, so
+// entities which stopped reporting can be pruned. Every change is
+// published on a channel for watchers to follow the fleet live.
+package nearby
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	// ErrNotFound is returned for entities which are not tracked.
+	ErrNotFound = errors.New("entity not found")
+	// ErrInvalidPosition is wrapped by the errors of positions Redis can
+	// not index.
+	ErrInvalidPosition = errors.New("invalid position")
+)
+
+// Limits of the positions Redis indexes, the poles are not covered by the
+// Web Mercator projection of geohashes.
+const (
+	MaxLatitude  = 85.05112878
+	MaxLongitude = 180
+)
+
+// earthRadius is the radius in meters Redis computes distances with.
+const earthRadius = 6372797.560856
+
+// removeScript stops tracking the entities in ARGV and publishes their
+// removal, it returns how many were tracked. Removing and publishing in
+// one step keeps the removal ordered with concurrent updates.
+var removeScript = redis.NewScript(`
+local n = 0
+for _, id in ipairs(ARGV) do
+  if redis.call('ZREM', KEYS[1], id) == 1 then
+    redis.call('ZREM', KEYS[2], id)
+    redis.call('PUBLISH', KEYS[3], cjson.encode({id = id, removed = true}))
+    n = n + 1
+  end
+end
+return n
+`)
+
+// pruneScript removes like removeScript up to ARGV[2] entities which did
+// not report since ARGV[1], in unix milliseconds.
+var pruneScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', '(' .. ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, id in ipairs(ids) do
+  redis.call('ZREM', KEYS[1], id)
+  redis.call('ZREM', KEYS[2], id)
+  redis.call('PUBLISH', KEYS[3], cjson.encode({id = id, removed = true}))
+end
+return #ids
+`)
+
+// pruneBatch is how many entities one call of pruneScript removes at most,
+// so a large backlog does not block Redis.
+const pruneBatch = 1000
+
+// Location is the last reported position of an entity.
+type Location struct {
+	ID        string    `json:"id"`
+	Latitude  float64   `json:"lat"`
+	Longitude float64   `json:"lon"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Result is an entity found near a point.
+type Result struct {
+	Location
+	// Distance is the distance to the point in meters.
+	Distance float64 `json:"distance"`
+}
+
+// Event is published when an entity moved or was removed.
+type Event struct {
+	ID string `json:"id"`
+	// Location is the new position, nil if the entity was removed.
+	Location *Location `json:"location,omitempty"`
+	Removed  bool      `json:"removed,omitempty"`
+}
+
+// ValidatePosition checks that Redis can index the position.
+func ValidatePosition(lat, lon float64) error {
+	if math.IsNaN(lat) || math.Abs(lat) > MaxLatitude {
+		return fmt.Errorf("%w: latitude must be between %v and %v, got %v", ErrInvalidPosition, -MaxLatitude, MaxLatitude, lat)
+	}
+	if math.IsNaN(lon) || math.Abs(lon) > MaxLongitude {
+		return fmt.Errorf("%w: longitude must be between %v and %v, got %v", ErrInvalidPosition, -MaxLongitude, MaxLongitude, lon)
+	}
+	return nil
+}
+
+// Distance returns the great circle distance in meters between two
+// positions, as Redis computes it.
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	u := math.Sin((rad(lat2) - rad(lat1)) / 2)
+	v := math.Sin((rad(lon2) - rad(lon1)) / 2)
+	a := u*u + math.Cos(rad(lat1))*math.Cos(rad(lat2))*v*v
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// Tracker tracks the entities of a fleet.
+type Tracker struct {
+	rdb     *redis.Client
+	geoKey  string
+	seenKey string
+	channel string
+	now     func() time.Time
+}
+
+// New returns the tracker of fleet, fleets are tracked independently.
+func New(rdb *redis.Client, fleet string) *Tracker {
+	return &Tracker{
+		rdb:     rdb,
+		geoKey:  fmt.Sprintf("nearby:%s:positions", fleet),
+		seenKey: fmt.Sprintf("nearby:%s:seen", fleet),
+		channel: fmt.Sprintf("nearby:%s:updates", fleet),
+		now:     time.Now,
+	}
+}
+
+// Update sets the position of the entity id and publishes it.
+func (t *Tracker) Update(ctx context.Context, id string, lat, lon float64) (Location, error) {
+	if id == "" {
+		return Location{}, fmt.Errorf("%w: entity id is required", ErrInvalidPosition)
+	}
+	if err := ValidatePosition(lat, lon); err != nil {
+		return Location{}, err
+	}
+
+	loc := Location{ID: id, Latitude: lat, Longitude: lon, UpdatedAt: t.now().Truncate(time.Millisecond)}
+	event, err := json.Marshal(Event{ID: id, Location: &loc})
+	if err != nil {
+		return Location{}, err
+	}
+	_, err = t.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.GeoAdd(ctx, t.geoKey, &redis.GeoLocation{Name: id, Latitude: lat, Longitude: lon})
+		pipe.ZAdd(ctx, t.seenKey, &redis.Z{Score: float64(loc.UpdatedAt.UnixMilli()), Member: id})
+		pipe.Publish(ctx, t.channel, event)
+		return nil
+	})
+	if err != nil {
+		return Location{}, fmt.Errorf("failed to update %s: %v", id, err)
+	}
+	return loc, nil
+}
+
+// Remove stops tracking the entity id.
+func (t *Tracker) Remove(ctx context.Context, id string) error {
+	n, err := removeScript.Run(ctx, t.rdb, t.keys(), id).Int()
+	if err != nil {
+		return fmt.Errorf("failed to remove %s: %v", id, err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Prune removes the entities which did not report for maxAge and returns
+// how many.
+func (t *Tracker) Prune(ctx context.Context, maxAge time.Duration) (int, error) {
+	cutoff := t.now().Add(-maxAge).UnixMilli()
+	total := 0
+	for {
+		n, err := pruneScript.Run(ctx, t.rdb, t.keys(), cutoff, pruneBatch).Int()
+		if err != nil {
+			return total, fmt.Errorf("failed to prune: %v", err)
+		}
+		total += n
+		if n < pruneBatch {
+			return total, nil
+		}
+	}
+}
+
+func (t *Tracker) keys() []string {
+	return []string{t.geoKey, t.seenKey, t.channel}
+}
+
+// Get returns the last position of the entity id.
+func (t *Tracker) Get(ctx context.Context, id string) (Location, error) {
+	var pos *redis.GeoPosCmd
+	var seen *redis.FloatCmd
+	_, err := t.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pos = pipe.GeoPos(ctx, t.geoKey, id)
+		seen = pipe.ZScore(ctx, t.seenKey, id)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return Location{}, err
+	}
+	if p := pos.Val(); len(p) == 0 || p[0] == nil {
+		return Location{}, ErrNotFound
+	}
+	p := pos.Val()[0]
+	return Location{
+		ID:        id,
+		Latitude:  p.Latitude,
+		Longitude: p.Longitude,
+		UpdatedAt: time.UnixMilli(int64(seen.Val())),
+	}, nil
+}
+
+// Nearby returns up to limit entities within radius meters of the
+// position, nearest first.
+func (t *Tracker) Nearby(ctx context.Context, lat, lon, radius float64, limit int) ([]Result, error) {
+	if err := ValidatePosition(lat, lon); err != nil {
+		return nil, err
+	}
+	found, err := t.rdb.GeoSearchLocation(ctx, t.geoKey, &redis.GeoSearchLocationQuery{
+		GeoSearchQuery: redis.GeoSearchQuery{
+			Latitude:   lat,
+			Longitude:  lon,
+			Radius:     radius,
+			RadiusUnit: "m",
+			Sort:       "ASC",
+			Count:      limit,
+		},
+		WithCoord: true,
+		WithDist:  true,
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %v", err)
+	}
+	if len(found) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, len(found))
+	for i, l := range found {
+		ids[i] = l.Name
+	}
+	seen, err := t.rdb.ZMScore(ctx, t.seenKey, ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, 0, len(found))
+	for i, l := range found {
+		if seen[i] == 0 {
+			// Removed since the search
+			continue
+		}
+		results = append(results, Result{
+			Location: Location{
+				ID:        l.Name,
+				Latitude:  l.Latitude,
+				Longitude: l.Longitude,
+				UpdatedAt: time.UnixMilli(int64(seen[i])),
+			},
+			Distance: l.Dist,
+		})
+	}
+	return results, nil
+}
+
+// Count returns how many entities are tracked.
+func (t *Tracker) Count(ctx context.Context) (int64, error) {
+	return t.rdb.ZCard(ctx, t.geoKey).Result()
+}
+
+// Watch returns the events of the fleet from now on. The channel is closed
+// when ctx is done or the subscription failed. Every watcher holds a
+// connection to Redis.
+func (t *Tracker) Watch(ctx context.Context) (<-chan Event, error) {
+	sub := t.rdb.Subscribe(ctx, t.channel)
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, fmt.Errorf("failed to subscribe: %v", err)
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer sub.Close()
+
+		ch := sub.Channel()
+		for {
+			select {
+			case m, ok := <-ch:
+				if !ok {
+					return
+				}
+				var e Event
+				if err := json.Unmarshal([]byte(m.Payload), &e); err != nil {
+					log.Printf("Skipping malformed event: %v", err)
+					continue
+				}
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
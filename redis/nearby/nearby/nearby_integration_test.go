@@ -0,0 +1,168 @@
+//go:build integration
+
+package nearby
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"kate.testutil"
+)
+
+func newTestTracker(t *testing.T, now *time.Time) *Tracker {
+	t.Helper()
+	tr := New(testutil.NewRedisClient(t, testutil.StartRedis(t)), "test")
+	tr.now = func() time.Time { return *now }
+	return tr
+}
+
+// Around Alexanderplatz, Berlin
+var positions = map[string][2]float64{
+	"near":  {52.5219, 13.4132},
+	"mid":   {52.5163, 13.3777},
+	"far":   {52.4862, 13.4250},
+	"other": {48.8566, 2.3522},
+}
+
+func TestNearbyFindsNearestFirst(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	tr := newTestTracker(t, &now)
+
+	for id, p := range positions {
+		if _, err := tr.Update(ctx, id, p[0], p[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := tr.Nearby(ctx, 52.5219, 13.4132, 5000, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, r := range results {
+		ids = append(ids, r.ID)
+		p := positions[r.ID]
+		if d := Distance(52.5219, 13.4132, p[0], p[1]); math.Abs(r.Distance-d) > 1 {
+			t.Errorf("distance of %s = %v, want %v", r.ID, r.Distance, d)
+		}
+		if !r.UpdatedAt.Equal(now.Truncate(time.Millisecond)) {
+			t.Errorf("updated at of %s = %v, want %v", r.ID, r.UpdatedAt, now)
+		}
+	}
+	if want := []string{"near", "mid", "far"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("nearby = %v, want %v", ids, want)
+	}
+
+	results, err = tr.Nearby(ctx, 52.5219, 13.4132, 5000, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Errorf("nearby with limit 2 returned %d", len(results))
+	}
+
+	loc, err := tr.Get(ctx, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Geohashes keep positions to well below a meter
+	if Distance(loc.Latitude, loc.Longitude, 48.8566, 2.3522) > 1 {
+		t.Errorf("position of other = %+v", loc)
+	}
+}
+
+func TestRemoveAndPrune(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	tr := newTestTracker(t, &now)
+
+	for _, id := range []string{"a", "b", "c"} {
+		if _, err := tr.Update(ctx, id, 52.52, 13.40); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now = now.Add(time.Minute)
+	if _, err := tr.Update(ctx, "c", 52.53, 13.41); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tr.Remove(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Remove(ctx, "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("removing twice: got %v, want ErrNotFound", err)
+	}
+	if _, err := tr.Get(ctx, "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("getting removed: got %v, want ErrNotFound", err)
+	}
+
+	n, err := tr.Prune(ctx, 30*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("pruned %d, want only b", n)
+	}
+	if count, err := tr.Count(ctx); err != nil || count != 1 {
+		t.Errorf("count = %d, %v, want 1", count, err)
+	}
+}
+
+func TestWatchFollowsChanges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	now := time.Now()
+	tr := newTestTracker(t, &now)
+
+	events, err := tr.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.Update(ctx, "a", 52.52, 13.40); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Remove(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	next := func() Event {
+		t.Helper()
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+			return Event{}
+		}
+	}
+	if e := next(); e.ID != "a" || e.Removed || e.Location == nil || e.Location.Latitude != 52.52 {
+		t.Errorf("update event = %+v", e)
+	}
+	if e := next(); e.ID != "a" || !e.Removed || e.Location != nil {
+		t.Errorf("remove event = %+v", e)
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestInvalidPositions(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	tr := newTestTracker(t, &now)
+
+	for _, p := range [][2]float64{{86, 0}, {-90, 0}, {0, 181}, {math.NaN(), 0}} {
+		if _, err := tr.Update(ctx, "a", p[0], p[1]); !errors.Is(err, ErrInvalidPosition) {
+			t.Errorf("update to %v: got %v, want ErrInvalidPosition", p, err)
+		}
+	}
+	if _, err := tr.Update(ctx, "", 0, 0); !errors.Is(err, ErrInvalidPosition) {
+		t.Errorf("update without id: got %v, want ErrInvalidPosition", err)
+	}
+}
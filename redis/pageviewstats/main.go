@@ -3,87 +3,85 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
+	"kate.config"
+	"kate.httpserver"
+	"kate.redis.cache"
+	"kate.redis.pageviewstats/stats"
+	"kate.telemetry"
 )
 
-type StatsCounter struct {
-	rdb *redis.Client
-	ctx context.Context
+// Config is the Redis server, the listen address and server options,
+// where to export spans to and how long page stats are cached, set with
+// flags, the matching variables (e.g. REDIS_ADDR for -redis-addr) or the
+// -config file.
+type Config struct {
+	Redis     config.RedisConfig `yaml:"redis"`
+	HTTP      config.HTTPConfig  `yaml:"http"`
+	Server    httpserver.Options `yaml:"server"`
+	Telemetry telemetry.Config   `yaml:"telemetry"`
+	// CacheTTL is how long the stats of a page are fresh, they are served
+	// stale for up to ten times as long while they are refreshed.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
 }
 
-func NewStatsCounter(rdb *redis.Client) *StatsCounter {
-	return &StatsCounter{
-		rdb: rdb,
-		ctx: context.Background(),
+func defaultConfig() Config {
+	return Config{
+		Redis:    config.DefaultRedisConfig(),
+		HTTP:     config.DefaultHTTPConfig(),
+		Server:   httpserver.DefaultOptions(),
+		CacheTTL: time.Second,
 	}
 }
 
-// Track basic page view
-func (sc *StatsCounter) TrackPageView(page string, userID string) error {
-	now := time.Now()
-
-	_, err := sc.rdb.Pipelined(sc.ctx, func(pipe redis.Pipeliner) error {
-		// Total views
-		pipe.Incr(sc.ctx, fmt.Sprintf("stats:page:%s:total", page))
-
-		// Daily views
-		dailyKey := fmt.Sprintf("stats:page:%s:%s", page, now.Format("2006-01-02"))
-		pipe.Incr(sc.ctx, dailyKey)
-		pipe.Expire(sc.ctx, dailyKey, 48*time.Hour) // Keep for 2 days
-
-		// Hourly views (for real-time analytics)
-		hourlyKey := fmt.Sprintf("stats:page:%s:%s", page, now.Format("2006-01-02-15"))
-		pipe.Incr(sc.ctx, hourlyKey)
-		pipe.Expire(sc.ctx, hourlyKey, 48*time.Hour)
-
-		// Unique visitors using HyperLogLog
-		if userID != "" {
-			pipe.PFAdd(sc.ctx, fmt.Sprintf("stats:page:%s:unique_visitors", page), userID)
-		}
-
-		return nil
-	})
-
-	return err
+func (c *Config) registerFlags(fs *flag.FlagSet) {
+	c.Redis.RegisterFlags(fs)
+	c.HTTP.RegisterFlags(fs)
+	c.Server.RegisterFlags(fs)
+	c.Telemetry.RegisterFlags(fs)
+	fs.DurationVar(&c.CacheTTL, "cache-ttl", c.CacheTTL, "how long the stats of a page are cached")
 }
 
-// Get page statistics
-func (sc *StatsCounter) GetPageStats(page string) (map[string]interface{}, error) {
-	cmds, err := sc.rdb.Pipelined(sc.ctx, func(pipe redis.Pipeliner) error {
-		pipe.Get(sc.ctx, fmt.Sprintf("stats:page:%s:total", page))
-		pipe.Get(sc.ctx, fmt.Sprintf("stats:page:%s:%s", page, time.Now().Format("2006-01-02")))
-		pipe.PFCount(sc.ctx, fmt.Sprintf("stats:page:%s:unique_visitors", page))
-		return nil
-	})
-
-	if err != nil && err != redis.Nil {
-		return nil, err
+func (c *Config) validate() error {
+	if err := c.Redis.Validate(); err != nil {
+		return err
 	}
-
-	stats := map[string]interface{}{
-		"total_views":     cmds[0].(*redis.StringCmd).Val(),
-		"today_views":     cmds[1].(*redis.StringCmd).Val(),
-		"unique_visitors": cmds[2].(*redis.IntCmd).Val(),
+	if c.CacheTTL <= 0 {
+		return fmt.Errorf("cache ttl must be positive, got %v", c.CacheTTL)
 	}
-
-	return stats, nil
+	if err := c.Server.Validate(); err != nil {
+		return err
+	}
+	return c.HTTP.Validate()
 }
 
 func main() {
+	cfg, _, err := config.Load(os.Args[0], "", os.Args[1:], defaultConfig, (*Config).registerFlags)
+	if err == nil {
+		err = cfg.validate()
+	}
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
 	ctx := context.Background()
 
+	// Spans are exported in batches while the server runs.
+	shutdownTracing, err := telemetry.Setup(ctx, "go-examples-pageviewstats", cfg.Telemetry)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Initialize Redis
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379",
-		Password: "",
-		DB:       0,
-	})
+	rdb := cfg.Redis.NewClient()
+	telemetry.InstrumentRedis(rdb)
 
 	// Test connection
 	if _, err := rdb.Ping(ctx).Result(); err != nil {
@@ -91,10 +89,21 @@ func main() {
 	}
 	fmt.Println("✅ Redis connected!")
 
-	statsCounter := NewStatsCounter(rdb)
+	statsCounter := stats.NewStatsCounter(rdb)
+	// Dashboards polling a popular page share one read of its stats
+	statsCache := cache.New(rdb, "pageviewstats", cache.Options{SoftTTL: cfg.CacheTTL})
+
+	srv := httpserver.New("pageviewstats", cfg.HTTP, cfg.Server)
+	srv.Use(telemetry.Handler)
+	srv.AddReadinessCheck("redis", func(ctx context.Context) error {
+		return rdb.Ping(ctx).Err()
+	})
+	// Hooks run last added first: spans are exported before Redis closes
+	srv.OnShutdown(func(context.Context) error { return rdb.Close() })
+	srv.OnShutdown(shutdownTracing)
 
 	// HTTP Handlers
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	srv.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{
 			"message": "Redis Stats API",
@@ -107,7 +116,7 @@ POST /clear           - Clear all statistics
 		})
 	})
 
-	http.HandleFunc("/stats/", func(w http.ResponseWriter, r *http.Request) {
+	srv.HandleFunc("/stats/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -119,17 +128,23 @@ POST /clear           - Clear all statistics
 			return
 		}
 
-		stats, err := statsCounter.GetPageStats(page)
+		data, err := statsCache.GetOrCompute(r.Context(), page, func(ctx context.Context) ([]byte, error) {
+			stats, err := statsCounter.WithContext(ctx).GetPageStats(page)
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(stats)
+		})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(stats)
+		w.Write(data)
 	})
 
-	http.HandleFunc("/click/", func(w http.ResponseWriter, r *http.Request) {
+	srv.HandleFunc("/click/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -142,7 +157,7 @@ POST /clear           - Clear all statistics
 		}
 
 		userID := uuid.New().String()
-		err := statsCounter.TrackPageView(page, userID)
+		err := statsCounter.WithContext(r.Context()).TrackPageView(page, userID)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -157,13 +172,16 @@ POST /clear           - Clear all statistics
 	})
 
 	// Start server
-	port := ":8080"
-	fmt.Printf("🚀 Server starting on http://localhost%s\n", port)
+	fmt.Printf("🚀 Server starting on %s\n", cfg.HTTP.Addr)
 	fmt.Println("📊 Available endpoints:")
-	fmt.Println("   GET  http://localhost:8080/stats")
-	fmt.Println("   GET  http://localhost:8080/stats/home")
-	fmt.Println("   POST http://localhost:8080/click/about")
-	fmt.Println("   POST http://localhost:8080/clear")
+	fmt.Println("   GET  /stats")
+	fmt.Println("   GET  /stats/home")
+	fmt.Println("   POST /click/about")
+	fmt.Println("   POST /clear")
+
+	fmt.Println("   GET  /healthz, /readyz, /metrics")
 
-	log.Fatal(http.ListenAndServe(port, nil))
+	if err := srv.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,156 @@
+// Package stats counts page views in Redis: totals, daily and hourly
+// views and unique visitors.
+package stats
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"kate.redis.dedup"
+)
+
+// seenTTL is how long the ids of tracked events are remembered, events
+// redelivered later than that are counted again.
+const seenTTL = 48 * time.Hour
+
+type StatsCounter struct {
+	rdb   *redis.Client
+	ctx   context.Context
+	dedup *dedup.Filter
+}
+
+func NewStatsCounter(rdb *redis.Client) *StatsCounter {
+	return &StatsCounter{
+		rdb: rdb,
+		ctx: context.Background(),
+	}
+}
+
+// WithContext returns a counter sending its commands with ctx, e.g. to
+// trace them as part of a request.
+func (sc *StatsCounter) WithContext(ctx context.Context) *StatsCounter {
+	return &StatsCounter{rdb: sc.rdb, ctx: ctx, dedup: sc.dedup}
+}
+
+// WithDedup returns a counter remembering the event ids of
+// TrackPageViewOnce in filter rather than in a key per event, which takes
+// far less memory. The filter mistakes new events for duplicates at its
+// error rate, and an event redelivered after a crash between counting and
+// remembering it is counted twice.
+func (sc *StatsCounter) WithDedup(filter *dedup.Filter) *StatsCounter {
+	return &StatsCounter{rdb: sc.rdb, ctx: sc.ctx, dedup: filter}
+}
+
+// Track basic page view
+func (sc *StatsCounter) TrackPageView(page string, userID string) error {
+	now := time.Now()
+
+	_, err := sc.rdb.Pipelined(sc.ctx, func(pipe redis.Pipeliner) error {
+		sc.track(pipe, page, userID, now)
+		return nil
+	})
+
+	return err
+}
+
+// TrackPageViewOnce tracks the page view of event eventID unless it was
+// tracked before, it reports whether it counted the view. The id is
+// recorded in the same transaction as the counters, so an event which is
+// delivered again, e.g. by Kafka after a crash, is never counted twice.
+// With WithDedup the filter decides instead.
+func (sc *StatsCounter) TrackPageViewOnce(eventID, page, userID string) (bool, error) {
+	if sc.dedup != nil {
+		return sc.trackDeduped(eventID, page, userID)
+	}
+
+	seenKey := fmt.Sprintf("stats:event:%s", eventID)
+	now := time.Now()
+
+	for {
+		tracked := false
+		err := sc.rdb.Watch(sc.ctx, func(tx *redis.Tx) error {
+			n, err := tx.Exists(sc.ctx, seenKey).Result()
+			if err != nil || n > 0 {
+				return err
+			}
+
+			_, err = tx.TxPipelined(sc.ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(sc.ctx, seenKey, 1, seenTTL)
+				sc.track(pipe, page, userID, now)
+				return nil
+			})
+			tracked = err == nil
+			return err
+		}, seenKey)
+
+		// Another client tracked an event with the same id meanwhile,
+		// look again whether it was this one.
+		if err == redis.TxFailedErr {
+			continue
+		}
+		return tracked, err
+	}
+}
+
+// trackDeduped tracks the page view unless the filter saw eventID. The
+// id is added only after counting, so a failed attempt is retried.
+func (sc *StatsCounter) trackDeduped(eventID, page, userID string) (bool, error) {
+	seen, err := sc.dedup.Exists(sc.ctx, eventID)
+	if err != nil || seen {
+		return false, err
+	}
+	if err := sc.TrackPageView(page, userID); err != nil {
+		return false, err
+	}
+	if _, err := sc.dedup.Add(sc.ctx, eventID); err != nil {
+		// Counted, a redelivery of the event will be counted again
+		log.Printf("Failed to remember event %s: %v", eventID, err)
+	}
+	return true, nil
+}
+
+// track queues the commands counting a page view on pipe.
+func (sc *StatsCounter) track(pipe redis.Pipeliner, page, userID string, now time.Time) {
+	// Total views
+	pipe.Incr(sc.ctx, fmt.Sprintf("stats:page:%s:total", page))
+
+	// Daily views
+	dailyKey := fmt.Sprintf("stats:page:%s:%s", page, now.Format("2006-01-02"))
+	pipe.Incr(sc.ctx, dailyKey)
+	pipe.Expire(sc.ctx, dailyKey, 48*time.Hour) // Keep for 2 days
+
+	// Hourly views (for real-time analytics)
+	hourlyKey := fmt.Sprintf("stats:page:%s:%s", page, now.Format("2006-01-02-15"))
+	pipe.Incr(sc.ctx, hourlyKey)
+	pipe.Expire(sc.ctx, hourlyKey, 48*time.Hour)
+
+	// Unique visitors using HyperLogLog
+	if userID != "" {
+		pipe.PFAdd(sc.ctx, fmt.Sprintf("stats:page:%s:unique_visitors", page), userID)
+	}
+}
+
+// Get page statistics
+func (sc *StatsCounter) GetPageStats(page string) (map[string]interface{}, error) {
+	cmds, err := sc.rdb.Pipelined(sc.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Get(sc.ctx, fmt.Sprintf("stats:page:%s:total", page))
+		pipe.Get(sc.ctx, fmt.Sprintf("stats:page:%s:%s", page, time.Now().Format("2006-01-02")))
+		pipe.PFCount(sc.ctx, fmt.Sprintf("stats:page:%s:unique_visitors", page))
+		return nil
+	})
+
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	stats := map[string]interface{}{
+		"total_views":     cmds[0].(*redis.StringCmd).Val(),
+		"today_views":     cmds[1].(*redis.StringCmd).Val(),
+		"unique_visitors": cmds[2].(*redis.IntCmd).Val(),
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,92 @@
+//go:build integration
+
+package stats
+
+import (
+	"context"
+	"testing"
+
+	"kate.redis.dedup"
+	"kate.testutil"
+)
+
+func TestTrackPageView(t *testing.T) {
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+	testutil.SeedRedis(t, rdb, map[string]string{
+		"stats:page:home:total": "10",
+	})
+	sc := NewStatsCounter(rdb)
+
+	for _, user := range []string{"alice", "bob", "alice"} {
+		if err := sc.TrackPageView("home", user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := sc.GetPageStats("home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stats["total_views"]; got != "13" {
+		t.Errorf("got total views %v, want 13", got)
+	}
+	if got := stats["today_views"]; got != "3" {
+		t.Errorf("got today views %v, want 3", got)
+	}
+	if got := stats["unique_visitors"]; got != int64(2) {
+		t.Errorf("got unique visitors %v, want 2", got)
+	}
+}
+
+func TestTrackPageViewOnce(t *testing.T) {
+	sc := NewStatsCounter(testutil.NewRedisClient(t, testutil.StartRedis(t)))
+
+	for i, want := range []bool{true, false} {
+		tracked, err := sc.TrackPageViewOnce("event-1", "home", "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tracked != want {
+			t.Fatalf("attempt %d: got tracked %v, want %v", i+1, tracked, want)
+		}
+	}
+
+	stats, err := sc.GetPageStats("home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stats["total_views"]; got != "1" {
+		t.Errorf("got total views %v, want 1", got)
+	}
+}
+
+func TestTrackPageViewOnceWithDedup(t *testing.T) {
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+	filter, err := dedup.New(context.Background(), rdb, "stats", dedup.Options{Capacity: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := NewStatsCounter(rdb).WithDedup(filter)
+
+	for i, event := range []string{"event-1", "event-2", "event-1"} {
+		tracked, err := sc.WithContext(context.Background()).TrackPageViewOnce(event, "home", "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := i < 2; tracked != want {
+			t.Fatalf("%s: got tracked %v, want %v", event, tracked, want)
+		}
+	}
+
+	stats, err := sc.GetPageStats("home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stats["total_views"]; got != "2" {
+		t.Errorf("got total views %v, want 2", got)
+	}
+	// The ids live in the filter, not in a key per event
+	if n, err := rdb.Exists(context.Background(), "stats:event:event-1").Result(); err != nil || n != 0 {
+		t.Errorf("event key exists: %d, %v", n, err)
+	}
+}
@@ -2,30 +2,96 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"os"
 
-	"github.com/go-redis/redis/v8"
+	"github.com/nats-io/nats.go"
+	"kate.config"
 	"kate.redis.queue/queue"
 )
 
+// Config selects the broker of the queue with -broker, Redis (the default)
+// or NATS JetStream. The Redis server is set with -redis-* flags, REDIS_*
+// variables or the redis section of the -config file, the NATS server with
+// -nats-url or NATS_URL.
+type Config struct {
+	Broker  string             `yaml:"broker"`
+	Redis   config.RedisConfig `yaml:"redis"`
+	NATSURL string             `yaml:"nats_url"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Broker:  "redis",
+		Redis:   config.DefaultRedisConfig(),
+		NATSURL: nats.DefaultURL,
+	}
+}
+
+func (c *Config) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Broker, "broker", c.Broker, "broker of the queue, redis or nats")
+	c.Redis.RegisterFlags(fs)
+	fs.StringVar(&c.NATSURL, "nats-url", c.NATSURL, "NATS server URL, used with -broker nats")
+}
+
+func (c *Config) validate() error {
+	switch c.Broker {
+	case "redis":
+		return c.Redis.Validate()
+	case "nats":
+		if c.NATSURL == "" {
+			return fmt.Errorf("nats-url is required")
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid broker %q, want redis or nats", c.Broker)
+}
+
 func main() {
-	// Initialize Redis client
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379",
-		Password: "", // no password set
-		DB:       0,  // use default DB
-	})
-
-	// Test connection
-	ctx := context.Background()
-	if err := rdb.Ping(ctx).Err(); err != nil {
-		log.Fatal("Redis connection failed:", err)
+	cfg, _, err := config.Load(os.Args[0], "", os.Args[1:], defaultConfig, (*Config).registerFlags)
+	if err == nil {
+		err = cfg.validate()
+	}
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
 	}
 
 	// Create priority queue
-	pq := queue.NewStreamPriorityQueue(rdb, "my_priority_stream", "worker_group")
+	var pq queue.Queue
+	switch cfg.Broker {
+	case "redis":
+		// Initialize Redis client
+		rdb := cfg.Redis.NewClient()
+		defer rdb.Close()
 
+		// Test connection
+		ctx := context.Background()
+		if err := rdb.Ping(ctx).Err(); err != nil {
+			log.Fatal("Redis connection failed:", err)
+		}
+
+		pq = queue.NewStreamPriorityQueue(rdb, "my_priority_stream", "worker_group")
+	case "nats":
+		nc, err := nats.Connect(cfg.NATSURL)
+		if err != nil {
+			log.Fatal("NATS connection failed:", err)
+		}
+		defer nc.Close()
+
+		pq, err = queue.NewJetStreamPriorityQueue(nc, "my_priority_stream", "worker_group")
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	run(pq)
+}
+
+// run is the worker code, the same for every broker.
+func run(pq queue.Queue) {
 	// Enqueue some items with different priorities
 	fmt.Println("Enqueueing items...")
 	items := []struct {
@@ -55,10 +121,14 @@ func main() {
 		fmt.Printf("Queue info - Total: %d, Pending: %d\n", total, pending)
 	}
 
-	// Peek at the next message
-	item, priority, err := pq.Peek()
-	if err == nil {
-		fmt.Printf("Next message: %s (priority %d)\n", item, priority)
+	// Peek at the next message, only Redis can look at the stream
+	// without a delivery
+	spq, isRedis := pq.(*queue.StreamPriorityQueue)
+	if isRedis {
+		item, priority, err := spq.Peek()
+		if err == nil {
+			fmt.Printf("Next message: %s (priority %d)\n", item, priority)
+		}
 	}
 
 	// Dequeue and process messages
@@ -66,7 +136,7 @@ func main() {
 	for i := 0; i < 5; i++ {
 		item, priority, err := pq.Dequeue()
 		if err != nil {
-			if err == redis.Nil {
+			if errors.Is(err, queue.ErrEmpty) {
 				fmt.Println("No more messages in queue")
 				break
 			}
@@ -76,11 +146,15 @@ func main() {
 		fmt.Printf("Dequeued: %s (priority %d)\n", item, priority)
 	}
 
-	// Demonstrate advanced dequeue with claiming
+	// Demonstrate advanced dequeue with claiming, JetStream redelivers
+	// unacknowledged messages by itself
+	if !isRedis {
+		return
+	}
 	fmt.Println("\nTrying advanced dequeue...")
-	item, priority, err = pq.DequeueWithPriority()
+	item, priority, err := spq.DequeueWithPriority()
 	if err != nil {
-		if err == redis.Nil {
+		if errors.Is(err, queue.ErrEmpty) {
 			fmt.Println("No messages available")
 		} else {
 			log.Printf("Advanced dequeue error: %v", err)
@@ -0,0 +1,65 @@
+//go:build integration
+
+package queue
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"kate.testutil"
+)
+
+func TestJetStreamEnqueueDequeue(t *testing.T) {
+	nc, err := nats.Connect(testutil.StartNATS(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	pq, err := NewJetStreamPriorityQueue(nc, "test_stream", "test_group")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pq.Enqueue("first", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := pq.Enqueue("second", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	total, pending, err := pq.GetQueueInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || pending != 0 {
+		t.Fatalf("got total %d pending %d, want 2 and 0", total, pending)
+	}
+
+	// Messages come out in the order they were published.
+	for _, want := range []struct {
+		item     string
+		priority int
+	}{{"first", 2}, {"second", 1}} {
+		item, priority, err := pq.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item != want.item || priority != want.priority {
+			t.Fatalf("got %s (priority %d), want %s (priority %d)", item, priority, want.item, want.priority)
+		}
+	}
+
+	// Dequeue acknowledges, nothing is left pending.
+	if _, pending, err = pq.GetQueueInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if pending != 0 {
+		t.Fatalf("got %d pending, want 0", pending)
+	}
+
+	if _, _, err := pq.Dequeue(); !errors.Is(err, ErrEmpty) {
+		t.Fatalf("got %v from empty queue, want ErrEmpty", err)
+	}
+}
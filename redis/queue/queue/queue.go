@@ -1,7 +1,10 @@
+// Package queue implements a priority queue on Redis Streams and on NATS
+// JetStream behind the same Queue interface.
 package queue
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"time"
@@ -9,6 +12,27 @@ import (
 	"github.com/go-redis/redis/v8"
 )
 
+// ErrEmpty is returned by Dequeue when no item arrived in time.
+var ErrEmpty = errors.New("queue is empty")
+
+// Queue is a work queue of prioritized items, delivered at least once to
+// the workers sharing it.
+type Queue interface {
+	// Enqueue adds item with priority, lower values are more urgent.
+	Enqueue(item string, priority int) error
+	// Dequeue waits a few seconds for the next item, processes and
+	// acknowledges it. It returns ErrEmpty if none arrived.
+	Dequeue() (string, int, error)
+	// GetQueueInfo returns the number of items in the queue and the
+	// number delivered but not acknowledged yet.
+	GetQueueInfo() (int64, int64, error)
+}
+
+var (
+	_ Queue = (*StreamPriorityQueue)(nil)
+	_ Queue = (*JetStreamPriorityQueue)(nil)
+)
+
 type StreamPriorityQueue struct {
 	client *redis.Client
 	ctx    context.Context
@@ -51,12 +75,12 @@ func (pq *StreamPriorityQueue) Dequeue() (string, int, error) {
 		Block:    5 * time.Second,
 	}).Result()
 
-	if err != nil {
+	if err != nil && err != redis.Nil {
 		return "", 0, err
 	}
 
-	if len(results) == 0 || len(results[0].Messages) == 0 {
-		return "", 0, redis.Nil
+	if err == redis.Nil || len(results) == 0 || len(results[0].Messages) == 0 {
+		return "", 0, ErrEmpty
 	}
 
 	return pq.processMessage(results[0].Messages[0])
@@ -180,7 +204,7 @@ func (pq *StreamPriorityQueue) Peek() (string, int, error) {
 	}
 
 	if len(results) == 0 || len(results[0].Messages) == 0 {
-		return "", 0, redis.Nil
+		return "", 0, ErrEmpty
 	}
 
 	msg := results[0].Messages[0]
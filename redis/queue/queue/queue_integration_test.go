@@ -0,0 +1,51 @@
+//go:build integration
+
+package queue
+
+import (
+	"testing"
+
+	"kate.testutil"
+)
+
+func TestEnqueueDequeue(t *testing.T) {
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+	pq := NewStreamPriorityQueue(rdb, "test_stream", "test_group")
+
+	if err := pq.Enqueue("first", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := pq.Enqueue("second", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	total, pending, err := pq.GetQueueInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || pending != 0 {
+		t.Fatalf("got total %d pending %d, want 2 and 0", total, pending)
+	}
+
+	// Entries come out in the order they were added.
+	for _, want := range []struct {
+		item     string
+		priority int
+	}{{"first", 2}, {"second", 1}} {
+		item, priority, err := pq.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item != want.item || priority != want.priority {
+			t.Fatalf("got %s (priority %d), want %s (priority %d)", item, priority, want.item, want.priority)
+		}
+	}
+
+	// Dequeue acknowledges, nothing is left pending.
+	if _, pending, err = pq.GetQueueInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if pending != 0 {
+		t.Fatalf("got %d pending, want 0", pending)
+	}
+}
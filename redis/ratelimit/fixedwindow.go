@@ -0,0 +1,43 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// fixedWindowScript counts a request and starts the window with the first,
+// it returns the count and the milliseconds left in the window.
+var fixedWindowScript = redis.NewScript(`
+local n = redis.call('INCR', KEYS[1])
+if n == 1 then
+  redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return {n, redis.call('PTTL', KEYS[1])}
+`)
+
+// FixedWindow allows limit requests per window.
+type FixedWindow struct {
+	rdb    *redis.Client
+	limit  int64
+	window time.Duration
+}
+
+func NewFixedWindow(rdb *redis.Client, limit int64, window time.Duration) *FixedWindow {
+	return &FixedWindow{rdb: rdb, limit: limit, window: window}
+}
+
+func (l *FixedWindow) Allow(ctx context.Context, key string) (Result, error) {
+	res, err := fixedWindowScript.Run(ctx, l.rdb, []string{"ratelimit:fixed:" + key}, l.window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+
+	n, ttl := res[0], res[1]
+	r := Result{Allowed: n <= l.limit, Limit: l.limit, Remaining: max(l.limit-n, 0)}
+	if !r.Allowed {
+		r.RetryAfter = time.Duration(ttl) * time.Millisecond
+	}
+	return r, nil
+}
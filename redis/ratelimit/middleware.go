@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// KeyFunc returns the key a request is limited by.
+type KeyFunc func(r *http.Request) string
+
+// ClientIP limits by the address of the client. Behind a proxy the
+// address of the proxy would be limited, use a KeyFunc reading the header
+// the proxy sets instead.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Middleware rejects requests over the limit with 429 Too Many Requests
+// and a Retry-After header, and reports the limit in X-RateLimit-Limit and
+// X-RateLimit-Remaining. If Redis fails, requests are let through: an
+// outage of the limiter should not take the service down.
+func Middleware(l Limiter, key KeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res, err := l.Allow(r.Context(), key(r))
+			if err != nil {
+				log.Printf("Rate limiter failed, allowing request: %v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(res.Limit, 10))
+			w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(res.Remaining, 10))
+			if !res.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(res.RetryAfter.Seconds()))))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+// Package ratelimit limits how often a key, e.g. a client address, may do
+// something, with the state in Redis so all instances of a service share
+// the limit.
+//
+// Every algorithm is a Lua script, so checking and updating the state is
+// atomic, and takes the time from the Redis server, so the clocks of the
+// instances need not agree:
+//
+//   - FixedWindow counts requests per window starting with the first one.
+//     Cheap, but allows twice the limit around a window boundary.
+//   - SlidingWindowLog keeps the time of every allowed request of the last
+//     window in a sorted set. Exact, but memory grows with the limit.
+//   - TokenBucket refills tokens at a steady rate up to a burst size.
+//     Smooths traffic in constant memory.
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Result is the outcome of Allow.
+type Result struct {
+	Allowed bool
+	// Limit is the number of requests allowed per window, or the burst.
+	Limit int64
+	// Remaining is how many more requests are allowed right now.
+	Remaining int64
+	// RetryAfter is how long to wait until a request is allowed again,
+	// zero if Allowed.
+	RetryAfter time.Duration
+}
+
+// Limiter decides whether the request of a key is allowed and records it.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Result, error)
+}
+
+// nowMs is the Lua snippet reading the Redis server time in milliseconds.
+const nowMs = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+`
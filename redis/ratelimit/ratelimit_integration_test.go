@@ -0,0 +1,115 @@
+//go:build integration
+
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"kate.testutil"
+)
+
+// allowN calls Allow n times and returns how many were allowed and the
+// last result.
+func allowN(t *testing.T, l Limiter, key string, n int) (int, Result) {
+	t.Helper()
+
+	var allowed int
+	var res Result
+	for i := 0; i < n; i++ {
+		var err error
+		if res, err = l.Allow(context.Background(), key); err != nil {
+			t.Fatal(err)
+		}
+		if res.Allowed {
+			allowed++
+		}
+	}
+	return allowed, res
+}
+
+func TestLimiters(t *testing.T) {
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+
+	for _, tc := range []struct {
+		name    string
+		limiter Limiter
+		wait    time.Duration
+	}{
+		{"fixed window", NewFixedWindow(rdb, 5, 500*time.Millisecond), 600 * time.Millisecond},
+		{"sliding window log", NewSlidingWindowLog(rdb, 5, 500*time.Millisecond), 600 * time.Millisecond},
+		// 10 tokens per second, a token every 100ms
+		{"token bucket", NewTokenBucket(rdb, 10, 5), 150 * time.Millisecond},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			allowed, res := allowN(t, tc.limiter, "client", 8)
+			if allowed != 5 {
+				t.Fatalf("allowed %d of 8 requests, want 5", allowed)
+			}
+			if res.Allowed || res.Remaining != 0 || res.RetryAfter <= 0 {
+				t.Fatalf("got %+v for a request over the limit", res)
+			}
+
+			// Other keys have their own limit.
+			if allowed, _ := allowN(t, tc.limiter, "other", 1); allowed != 1 {
+				t.Fatal("request of another key not allowed")
+			}
+
+			time.Sleep(tc.wait)
+			if allowed, _ := allowN(t, tc.limiter, "client", 1); allowed != 1 {
+				t.Fatalf("request not allowed after %v", tc.wait)
+			}
+		})
+	}
+}
+
+func TestSlidingWindowLogHasNoBoundary(t *testing.T) {
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+	l := NewSlidingWindowLog(rdb, 4, time.Second)
+
+	allowN(t, l, "client", 2)
+	time.Sleep(600 * time.Millisecond)
+	allowN(t, l, "client", 2)
+
+	// The first two requests are still within the last second.
+	time.Sleep(200 * time.Millisecond)
+	if allowed, _ := allowN(t, l, "client", 1); allowed != 0 {
+		t.Fatal("allowed a fifth request within a second")
+	}
+
+	// The first two left the window.
+	time.Sleep(300 * time.Millisecond)
+	if allowed, _ := allowN(t, l, "client", 2); allowed != 2 {
+		t.Fatalf("allowed %d requests after the first left the window, want 2", allowed)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+	l := NewFixedWindow(rdb, 2, time.Minute)
+	h := Middleware(l, ClientIP)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for i, want := range []int{http.StatusNoContent, http.StatusNoContent, http.StatusTooManyRequests} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != want {
+			t.Fatalf("request %d: got status %d, want %d", i, rec.Code, want)
+		}
+		if i == 2 && rec.Header().Get("Retry-After") == "" {
+			t.Fatal("no Retry-After on rejected request")
+		}
+	}
+
+	// Requests pass while Redis is down.
+	rdb.Close()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("got status %d without Redis, want the request to pass", rec.Code)
+	}
+}
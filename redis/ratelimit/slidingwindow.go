@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// slidingWindowScript drops the requests older than the window and records
+// the request if fewer than the limit remain. It returns whether it was
+// allowed, the remaining requests and the milliseconds until the oldest
+// request leaves the window.
+var slidingWindowScript = redis.NewScript(nowMs + `
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local n = redis.call('ZCARD', KEYS[1])
+if n < limit then
+  redis.call('ZADD', KEYS[1], now, now .. '-' .. ARGV[3])
+  redis.call('PEXPIRE', KEYS[1], window)
+  return {1, limit - n - 1, 0}
+end
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+return {0, 0, tonumber(oldest[2]) + window - now}
+`)
+
+// SlidingWindowLog allows limit requests in any window long period.
+type SlidingWindowLog struct {
+	rdb    *redis.Client
+	limit  int64
+	window time.Duration
+
+	// seq makes the members of requests in the same millisecond unique,
+	// with a random start as several instances record in one set.
+	seq atomic.Uint64
+}
+
+func NewSlidingWindowLog(rdb *redis.Client, limit int64, window time.Duration) *SlidingWindowLog {
+	l := &SlidingWindowLog{rdb: rdb, limit: limit, window: window}
+	l.seq.Store(uint64(time.Now().UnixNano()))
+	return l
+}
+
+func (l *SlidingWindowLog) Allow(ctx context.Context, key string) (Result, error) {
+	member := strconv.FormatUint(l.seq.Add(1), 36)
+	res, err := slidingWindowScript.Run(ctx, l.rdb, []string{"ratelimit:log:" + key},
+		l.window.Milliseconds(), l.limit, member).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Allowed:    res[0] == 1,
+		Limit:      l.limit,
+		Remaining:  res[1],
+		RetryAfter: time.Duration(res[2]) * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// tokenBucketScript refills the bucket for the time since the last request
+// and takes a token if there is one. It returns whether it was allowed,
+// the whole tokens left and the milliseconds until the next token.
+var tokenBucketScript = redis.NewScript(nowMs + `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(b[1]) or burst
+local ts = tonumber(b[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+local allowed, retry = 0, 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  retry = math.ceil((1 - tokens) / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate))
+return {allowed, math.floor(tokens), retry}
+`)
+
+// TokenBucket allows bursts of up to burst requests and rate requests per
+// second on average.
+type TokenBucket struct {
+	rdb   *redis.Client
+	rate  float64
+	burst int64
+}
+
+func NewTokenBucket(rdb *redis.Client, rate float64, burst int64) *TokenBucket {
+	return &TokenBucket{rdb: rdb, rate: rate, burst: burst}
+}
+
+func (l *TokenBucket) Allow(ctx context.Context, key string) (Result, error) {
+	// The script works in milliseconds
+	res, err := tokenBucketScript.Run(ctx, l.rdb, []string{"ratelimit:bucket:" + key},
+		l.rate/1000, l.burst).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Allowed:    res[0] == 1,
+		Limit:      l.burst,
+		Remaining:  res[1],
+		RetryAfter: time.Duration(res[2]) * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,303 @@
+// Package search indexes the entries of the key-value service for full
+// text search with RediSearch.
+//
+// Every entry has a document, a hash under the prefix of the index, which
+// RediSearch indexes by itself as it is written (FT.ADD of RediSearch 1 is
+// gone, documents are plain hashes since version 2). Writers put the
+// document in the same transaction as the entry and with the same expiry,
+// so the index always matches the entries: an expired entry drops out of
+// the index with its document.
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	// ErrUnsupported is returned by EnsureIndex when the server does not
+	// have RediSearch loaded.
+	ErrUnsupported = errors.New("search not supported by the server")
+	// ErrInvalidQuery is wrapped by the errors of queries RediSearch can
+	// not parse.
+	ErrInvalidQuery = errors.New("invalid query")
+)
+
+// Sort orders of a Query.
+const (
+	SortRelevance = "relevance"
+	SortNewest    = "newest"
+)
+
+// Query selects documents. All set filters must match.
+type Query struct {
+	// Text is a RediSearch query on the key and value, e.g. "red|blue"
+	// or "shoe*", empty to match all documents.
+	Text string
+	// Namespaces are the parts of the keys before the first colon, e.g.
+	// "user" of "user:42", any of which matches.
+	Namespaces []string
+	// UpdatedAfter and UpdatedBefore bound when the entry was written.
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+	// Sort is SortRelevance (default) or SortNewest.
+	Sort string
+	// Offset and Limit page through the hits, Limit defaults to 10.
+	Offset, Limit int
+	// Highlight returns the fragments of the values matching Text with
+	// the matched terms between HighlightOpen and HighlightClose,
+	// default <b> and </b>.
+	Highlight                     bool
+	HighlightOpen, HighlightClose string
+}
+
+// Hit is a found entry.
+type Hit struct {
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	Namespace string    `json:"namespace"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Highlight is set with Query.Highlight.
+	Highlight string `json:"highlight,omitempty"`
+}
+
+// Result is a page of hits and how many documents matched in total.
+type Result struct {
+	Total int64 `json:"total"`
+	Hits  []Hit `json:"hits"`
+}
+
+// Index is the search index of the entries of a store.
+type Index struct {
+	rdb    *redis.Client
+	name   string
+	prefix string
+	now    func() time.Time
+}
+
+// New returns the index name, call EnsureIndex before searching it.
+func New(rdb *redis.Client, name string) *Index {
+	return &Index{
+		rdb:    rdb,
+		name:   fmt.Sprintf("search:%s", name),
+		prefix: fmt.Sprintf("search:%s:doc:", name),
+		now:    time.Now,
+	}
+}
+
+// Prefix returns the prefix of the document keys.
+func (ix *Index) Prefix() string {
+	return ix.prefix
+}
+
+// EnsureIndex creates the index unless it exists. Documents written
+// before are indexed in the background.
+func (ix *Index) EnsureIndex(ctx context.Context) error {
+	err := ix.rdb.Do(ctx, "FT.CREATE", ix.name,
+		"ON", "HASH", "PREFIX", 1, ix.prefix,
+		"SCHEMA",
+		"key", "TEXT", "WEIGHT", 2,
+		"value", "TEXT",
+		"namespace", "TAG",
+		"updated", "NUMERIC", "SORTABLE",
+	).Err()
+	switch {
+	case err == nil:
+		return nil
+	case strings.Contains(err.Error(), "Index already exists"):
+		return nil
+	case strings.HasPrefix(err.Error(), "ERR unknown command"):
+		return fmt.Errorf("%w: RediSearch is not loaded", ErrUnsupported)
+	}
+	return fmt.Errorf("failed to create index %s: %v", ix.name, err)
+}
+
+// Namespace returns the part of key before the first colon, or "" if key
+// has none.
+func Namespace(key string) string {
+	ns, _, ok := strings.Cut(key, ":")
+	if !ok {
+		return ""
+	}
+	return ns
+}
+
+// Put queues writing the document of the entry key on pipe, to run in the
+// transaction writing the entry. It expires after ttl unless ttl is 0.
+func (ix *Index) Put(ctx context.Context, pipe redis.Pipeliner, key, value string, ttl time.Duration) {
+	doc := ix.prefix + key
+	pipe.HSet(ctx, doc,
+		"key", key,
+		"value", value,
+		"namespace", Namespace(key),
+		"updated", ix.now().UnixMilli(),
+	)
+	if ttl > 0 {
+		pipe.Expire(ctx, doc, ttl)
+	} else {
+		pipe.Persist(ctx, doc)
+	}
+}
+
+// Remove queues deleting the document of the entry key on pipe.
+func (ix *Index) Remove(ctx context.Context, pipe redis.Pipeliner, key string) {
+	pipe.Del(ctx, ix.prefix+key)
+}
+
+// Search returns the documents matching q.
+func (ix *Index) Search(ctx context.Context, q Query) (Result, error) {
+	if q.Limit <= 0 {
+		q.Limit = 10
+	}
+	if q.HighlightOpen == "" && q.HighlightClose == "" {
+		q.HighlightOpen, q.HighlightClose = "<b>", "</b>"
+	}
+
+	args := []interface{}{"FT.SEARCH", ix.name, q.query(),
+		"RETURN", 4, "key", "value", "namespace", "updated"}
+	if q.Highlight && q.Text != "" {
+		args = append(args,
+			"SUMMARIZE", "FIELDS", 1, "value", "FRAGS", 3, "LEN", 20,
+			"HIGHLIGHT", "FIELDS", 1, "value", "TAGS", q.HighlightOpen, q.HighlightClose)
+	}
+	switch q.Sort {
+	case "", SortRelevance:
+	case SortNewest:
+		args = append(args, "SORTBY", "updated", "DESC")
+	default:
+		return Result{}, fmt.Errorf("%w: unknown sort %q", ErrInvalidQuery, q.Sort)
+	}
+	args = append(args, "LIMIT", q.Offset, q.Limit)
+
+	reply, err := ix.rdb.Do(ctx, args...).Slice()
+	if err != nil {
+		if strings.Contains(err.Error(), "Syntax error") {
+			return Result{}, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+		}
+		return Result{}, fmt.Errorf("failed to search: %v", err)
+	}
+	res, err := parseReply(reply)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if q.Highlight && q.Text != "" {
+		// The value came back summarized, read the whole one
+		if err := ix.highlight(ctx, res.Hits); err != nil {
+			return Result{}, err
+		}
+	}
+	return res, nil
+}
+
+// highlight moves the summarized values of hits to Highlight and reads
+// the values again.
+func (ix *Index) highlight(ctx context.Context, hits []Hit) error {
+	if len(hits) == 0 {
+		return nil
+	}
+	cmds := make([]*redis.StringCmd, len(hits))
+	_, err := ix.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, h := range hits {
+			cmds[i] = pipe.HGet(ctx, ix.prefix+h.Key, "value")
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("failed to read values: %v", err)
+	}
+	for i := range hits {
+		hits[i].Highlight = hits[i].Value
+		// An expired document keeps its summary as value
+		if v, err := cmds[i].Result(); err == nil {
+			hits[i].Value = v
+		}
+	}
+	return nil
+}
+
+// query returns the RediSearch query of q.
+func (q *Query) query() string {
+	var parts []string
+	if text := strings.TrimSpace(q.Text); text != "" {
+		parts = append(parts, "("+text+")")
+	}
+	if len(q.Namespaces) > 0 {
+		tags := make([]string, len(q.Namespaces))
+		for i, ns := range q.Namespaces {
+			tags[i] = escapeTag(ns)
+		}
+		parts = append(parts, "@namespace:{"+strings.Join(tags, " | ")+"}")
+	}
+	if !q.UpdatedAfter.IsZero() || !q.UpdatedBefore.IsZero() {
+		from, to := "-inf", "+inf"
+		if !q.UpdatedAfter.IsZero() {
+			from = strconv.FormatInt(q.UpdatedAfter.UnixMilli(), 10)
+		}
+		if !q.UpdatedBefore.IsZero() {
+			to = strconv.FormatInt(q.UpdatedBefore.UnixMilli(), 10)
+		}
+		parts = append(parts, fmt.Sprintf("@updated:[%s %s]", from, to))
+	}
+	if len(parts) == 0 {
+		return "*"
+	}
+	return strings.Join(parts, " ")
+}
+
+// escapeTag escapes the punctuation and spaces of a tag value, which
+// would otherwise be part of the query syntax.
+func escapeTag(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if !(r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r > 127) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// parseReply reads the reply of FT.SEARCH: the total, then the id and
+// field-value pairs of every document.
+func parseReply(reply []interface{}) (Result, error) {
+	if len(reply) == 0 {
+		return Result{}, fmt.Errorf("empty search reply")
+	}
+	total, ok := reply[0].(int64)
+	if !ok {
+		return Result{}, fmt.Errorf("unexpected search reply %v", reply[0])
+	}
+
+	res := Result{Total: total, Hits: []Hit{}}
+	for i := 1; i+1 < len(reply); i += 2 {
+		fields, ok := reply[i+1].([]interface{})
+		if !ok {
+			return Result{}, fmt.Errorf("unexpected fields of document %v", reply[i])
+		}
+		var h Hit
+		for j := 0; j+1 < len(fields); j += 2 {
+			name, _ := fields[j].(string)
+			value, _ := fields[j+1].(string)
+			switch name {
+			case "key":
+				h.Key = value
+			case "value":
+				h.Value = value
+			case "namespace":
+				h.Namespace = value
+			case "updated":
+				ms, _ := strconv.ParseInt(value, 10, 64)
+				h.UpdatedAt = time.UnixMilli(ms)
+			}
+		}
+		res.Hits = append(res.Hits, h)
+	}
+	return res, nil
+}
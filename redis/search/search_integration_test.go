@@ -0,0 +1,202 @@
+//go:build integration
+
+package search
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"kate.testutil"
+)
+
+// newTestIndex returns an index holding entries written at now.
+func newTestIndex(t *testing.T, now time.Time, entries map[string]string) (*Index, *redis.Client) {
+	t.Helper()
+	ctx := context.Background()
+	rdb := testutil.NewRedisClient(t, testutil.StartRedisStack(t))
+	ix := New(rdb, "test")
+	ix.now = func() time.Time { return now }
+
+	if err := ix.EnsureIndex(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Again, as every service instance does on start
+	if err := ix.EnsureIndex(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, value := range entries {
+		put(t, ix, rdb, key, value, 0)
+	}
+	return ix, rdb
+}
+
+// put writes an entry the way the key-value service does.
+func put(t *testing.T, ix *Index, rdb *redis.Client, key, value string, ttl time.Duration) {
+	t.Helper()
+	ctx := context.Background()
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, key, value, ttl)
+		ix.Put(ctx, pipe, key, value, ttl)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func keys(res Result) []string {
+	var keys []string
+	for _, h := range res.Hits {
+		keys = append(keys, h.Key)
+	}
+	return keys
+}
+
+var testEntries = map[string]string{
+	"product:1": "red running shoe with a light sole",
+	"product:2": "blue hiking boot, waterproof",
+	"product:3": "red rain jacket",
+	"note:1":    "buy new running shoes before the race",
+	"plain":     "no namespace here",
+}
+
+func TestSearchFiltersAndPages(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	ix, _ := newTestIndex(t, now, testEntries)
+
+	res, err := ix.Search(ctx, Query{Text: "running", Sort: SortNewest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Total != 2 {
+		t.Errorf("total of running = %d, want 2: %v", res.Total, keys(res))
+	}
+
+	res, err = ix.Search(ctx, Query{Text: "red", Namespaces: []string{"product"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Total != 2 {
+		t.Errorf("total of red products = %d, want 2: %v", res.Total, keys(res))
+	}
+	for _, h := range res.Hits {
+		if h.Namespace != "product" || h.Value != testEntries[h.Key] || !h.UpdatedAt.Equal(now) {
+			t.Errorf("hit = %+v", h)
+		}
+	}
+
+	// All documents, two per page
+	var all []string
+	for offset := 0; ; offset += 2 {
+		res, err := ix.Search(ctx, Query{Offset: offset, Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Total != int64(len(testEntries)) {
+			t.Fatalf("total = %d, want %d", res.Total, len(testEntries))
+		}
+		if len(res.Hits) == 0 {
+			break
+		}
+		all = append(all, keys(res)...)
+	}
+	if len(all) != len(testEntries) {
+		t.Errorf("pages returned %v", all)
+	}
+
+	// Namespaces with punctuation are escaped
+	res, err = ix.Search(ctx, Query{Namespaces: []string{"no-such", "note"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys(res), []string{"note:1"}) {
+		t.Errorf("note namespace = %v", keys(res))
+	}
+
+	res, err = ix.Search(ctx, Query{UpdatedAfter: now.Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Total != 0 {
+		t.Errorf("updated after now = %v", keys(res))
+	}
+}
+
+func TestSearchHighlights(t *testing.T) {
+	ctx := context.Background()
+	ix, _ := newTestIndex(t, time.Now(), testEntries)
+
+	res, err := ix.Search(ctx, Query{Text: "waterproof", Highlight: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Hits) != 1 {
+		t.Fatalf("hits = %v", keys(res))
+	}
+	h := res.Hits[0]
+	if h.Value != testEntries["product:2"] {
+		t.Errorf("value = %q, want the whole value", h.Value)
+	}
+	if want := "<b>waterproof</b>"; !strings.Contains(h.Highlight, want) {
+		t.Errorf("highlight = %q, want %s in it", h.Highlight, want)
+	}
+
+	if _, err := ix.Search(ctx, Query{Text: "(unbalanced"}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("invalid query: got %v, want ErrInvalidQuery", err)
+	}
+}
+
+func TestIndexFollowsWrites(t *testing.T) {
+	ctx := context.Background()
+	ix, rdb := newTestIndex(t, time.Now(), nil)
+
+	put(t, ix, rdb, "product:1", "green shoe", time.Second)
+	put(t, ix, rdb, "product:2", "green hat", 0)
+	put(t, ix, rdb, "product:2", "yellow hat", 0)
+
+	res, err := ix.Search(ctx, Query{Text: "green"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys(res), []string{"product:1"}) {
+		t.Errorf("green after overwrite = %v", keys(res))
+	}
+
+	// The document expires with its entry
+	testutil.Eventually(t, 5*time.Second, 100*time.Millisecond, func() error {
+		res, err := ix.Search(ctx, Query{Text: "green"})
+		if err != nil {
+			return err
+		}
+		if res.Total != 0 {
+			return errors.New("expired entry still found")
+		}
+		return nil
+	})
+
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, "product:2")
+		ix.Remove(ctx, pipe, "product:2")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := ix.Search(ctx, Query{}); err != nil || res.Total != 0 {
+		t.Errorf("after remove = %v, %v", keys(res), err)
+	}
+}
+
+func TestWithoutRediSearch(t *testing.T) {
+	ix := New(testutil.NewRedisClient(t, testutil.StartRedis(t)), "test")
+	if err := ix.EnsureIndex(context.Background()); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("got %v, want ErrUnsupported", err)
+	}
+}
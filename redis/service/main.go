@@ -2,30 +2,118 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"kate.config"
+	"kate.httpserver"
+	"kate.redis.cache"
+	"kate.redis.ratelimit"
+	"kate.redis.search"
+	"kate.redis.sessions"
+	"kate.telemetry"
 )
 
 var ctx = context.Background()
 var rdb *redis.Client
+var sessionStore *sessions.Store
+var keysCache *cache.Cache
+
+// searchIndex is nil when Redis has no RediSearch.
+var searchIndex *search.Index
+
+// entryTTL is how long the entries of /set live.
+const entryTTL = 10 * time.Minute
+
+// maxSearchSize caps the hits of one /search page.
+const maxSearchSize = 100
+
+// internalPrefixes are the keys of the sessions, rate limits, cache and
+// search index, which share Redis with the entries. Clients can neither
+// read nor write them, or they could e.g. edit their own session.
+var internalPrefixes = []string{"session:", "ratelimit:", "cache:", "search:"}
+
+// isInternal reports whether key belongs to the service rather than to
+// the clients.
+func isInternal(key string) bool {
+	for _, prefix := range internalPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Config is the settings of the service, set with flags, the matching
+// variables (e.g. REDIS_ADDR for -redis-addr) or the -config file.
+type Config struct {
+	Redis     config.RedisConfig `yaml:"redis"`
+	HTTP      config.HTTPConfig  `yaml:"http"`
+	Server    httpserver.Options `yaml:"server"`
+	Telemetry telemetry.Config   `yaml:"telemetry"`
+	// RateLimit is how many requests a client may send per minute, in
+	// bursts of up to as many, 0 for no limit.
+	RateLimit int64 `yaml:"rate_limit"`
+	// SessionSecret signs the session cookies, all instances need the same.
+	SessionSecret string `yaml:"session_secret"`
+	// CacheTTL is how long the listing of /keys is fresh, it is served
+	// stale for up to ten times as long while it is refreshed.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Redis:    config.DefaultRedisConfig(),
+		HTTP:     config.DefaultHTTPConfig(),
+		Server:   httpserver.DefaultOptions(),
+		CacheTTL: 5 * time.Second,
+	}
+}
+
+func (c *Config) registerFlags(fs *flag.FlagSet) {
+	c.Redis.RegisterFlags(fs)
+	c.HTTP.RegisterFlags(fs)
+	c.Server.RegisterFlags(fs)
+	c.Telemetry.RegisterFlags(fs)
+	fs.Int64Var(&c.RateLimit, "rate-limit", c.RateLimit, "requests per minute and client, 0 for no limit")
+	fs.StringVar(&c.SessionSecret, "session-secret", c.SessionSecret, "secret signing session cookies, random if empty")
+	fs.DurationVar(&c.CacheTTL, "cache-ttl", c.CacheTTL, "how long the listing of /keys is cached")
+}
+
+func (c *Config) validate() error {
+	if err := c.Redis.Validate(); err != nil {
+		return err
+	}
+	if c.RateLimit < 0 {
+		return fmt.Errorf("rate limit must not be negative, got %d", c.RateLimit)
+	}
+	if c.CacheTTL <= 0 {
+		return fmt.Errorf("cache ttl must be positive, got %v", c.CacheTTL)
+	}
+	if err := c.Server.Validate(); err != nil {
+		return err
+	}
+	return c.HTTP.Validate()
+}
 
 type Message struct {
 	Key   string `json:"key"`
 	Value string `json:"value"`
 }
 
-func initRedis() {
-	// Connect to Redis running in Docker on localhost:6379
-	rdb = redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379", // Docker exposes Redis on host's localhost
-		Password: "",               // no password set
-		DB:       0,                // use default DB
-	})
+func initRedis(cfg config.RedisConfig) {
+	rdb = cfg.NewClient()
+	telemetry.InstrumentRedis(rdb)
 
 	// Test connection with retry logic
 	var err error
@@ -56,12 +144,26 @@ func setHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
+	if isInternal(msg.Key) {
+		http.Error(w, "Key prefix is reserved", http.StatusForbidden)
+		return
+	}
 
-	err := rdb.Set(ctx, msg.Key, msg.Value, 10*time.Minute).Err()
+	// The search document is written and expires with the entry
+	_, err := rdb.TxPipelined(r.Context(), func(pipe redis.Pipeliner) error {
+		pipe.Set(r.Context(), msg.Key, msg.Value, entryTTL)
+		if searchIndex != nil {
+			searchIndex.Put(r.Context(), pipe, msg.Key, msg.Value, entryTTL)
+		}
+		return nil
+	})
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Redis error: %v", err), http.StatusInternalServerError)
 		return
 	}
+	if err := keysCache.Delete(r.Context(), "keys"); err != nil {
+		log.Printf("Failed to invalidate /keys: %v", err)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -81,8 +183,12 @@ func getHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Key parameter is required", http.StatusBadRequest)
 		return
 	}
+	if isInternal(key) {
+		http.Error(w, "Key prefix is reserved", http.StatusForbidden)
+		return
+	}
 
-	val, err := rdb.Get(ctx, key).Result()
+	val, err := rdb.Get(r.Context(), key).Result()
 	if err == redis.Nil {
 		http.Error(w, "Key not found", http.StatusNotFound)
 		return
@@ -104,26 +210,101 @@ func getAllHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	keys, err := rdb.Keys(ctx, "*").Result()
+	// KEYS and a GET per key are expensive, so concurrent requests share
+	// one listing, refreshed in the background once it is stale.
+	data, err := keysCache.GetOrCompute(r.Context(), "keys", listKeys)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Redis error: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// listKeys returns all keys and their values as JSON, except the internal
+// ones of the sessions, rate limits, cache and search index.
+func listKeys(ctx context.Context) ([]byte, error) {
+	keys, err := rdb.Keys(ctx, "*").Result()
+	if err != nil {
+		return nil, err
+	}
+
 	result := make(map[string]string)
 	for _, key := range keys {
+		if isInternal(key) {
+			continue
+		}
 		val, err := rdb.Get(ctx, key).Result()
 		if err == nil {
 			result[key] = val
 		}
 	}
+	return json.Marshal(result)
+}
+
+// searchHandler finds entries by the RediSearch query q, filtered by
+// namespace (the part of the key before the first colon, comma separated
+// for several), updated_after and updated_before (RFC 3339), e.g.
+//
+//	curl 'localhost:8080/search?q=shoe*&namespace=product&highlight=true&page=2&size=10'
+//
+// sort=newest orders by write time instead of relevance.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	if searchIndex == nil {
+		http.Error(w, "Search needs Redis with RediSearch", http.StatusNotImplemented)
+		return
+	}
+
+	params := r.URL.Query()
+	q := search.Query{
+		Text:      params.Get("q"),
+		Sort:      params.Get("sort"),
+		Highlight: params.Get("highlight") == "true",
+	}
+	if ns := params.Get("namespace"); ns != "" {
+		q.Namespaces = strings.Split(ns, ",")
+	}
+	for name, t := range map[string]*time.Time{"updated_after": &q.UpdatedAfter, "updated_before": &q.UpdatedBefore} {
+		if s := params.Get(name); s != "" {
+			v, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid %s %q, want RFC 3339", name, s), http.StatusBadRequest)
+				return
+			}
+			*t = v
+		}
+	}
+	page, size := 1, 10
+	if n, err := strconv.Atoi(params.Get("page")); err == nil && n > 0 {
+		page = n
+	}
+	if n, err := strconv.Atoi(params.Get("size")); err == nil && n > 0 {
+		size = min(n, maxSearchSize)
+	}
+	q.Offset, q.Limit = (page-1)*size, size
+
+	res, err := searchIndex.Search(r.Context(), q)
+	if errors.Is(err, search.ErrInvalidQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Redis error: %v", err), http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(result)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"page":  page,
+		"size":  size,
+		"total": res.Total,
+		"hits":  res.Hits,
+	})
 }
 
 func infoHandler(w http.ResponseWriter, r *http.Request) {
-	info, err := rdb.Info(ctx).Result()
+	info, err := rdb.Info(r.Context()).Result()
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Redis error: %v", err), http.StatusInternalServerError)
 		return
@@ -133,15 +314,120 @@ func infoHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Redis Info:\n%s", info)
 }
 
+// loginHandler starts a session for the user, under a new id so a
+// session id planted before the login is useless.
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		User string `json:"user"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.User == "" {
+		http.Error(w, "Invalid JSON, user is required", http.StatusBadRequest)
+		return
+	}
+
+	s := sessions.FromContext(r.Context())
+	s.Values["user"] = req.User
+	s.Values["login"] = time.Now().UTC().Format(time.RFC3339)
+	if err := sessionStore.Rotate(r.Context(), w, s); err != nil {
+		http.Error(w, fmt.Sprintf("Session error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "success",
+		"user":   req.User,
+	})
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if err := sessionStore.Destroy(r.Context(), w, sessions.FromContext(r.Context())); err != nil {
+		http.Error(w, fmt.Sprintf("Session error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// meHandler returns the values of the session.
+func meHandler(w http.ResponseWriter, r *http.Request) {
+	s := sessions.FromContext(r.Context())
+	if s.Values["user"] == nil {
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Values)
+}
+
+// sessionSecret returns the configured secret or a random one, which only
+// works with a single instance and until it restarts.
+func sessionSecret(secret string) []byte {
+	if secret != "" {
+		return []byte(secret)
+	}
+
+	log.Println("No -session-secret, sessions end when the service restarts")
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	return b
+}
+
 func main() {
-	initRedis()
+	cfg, _, err := config.Load(os.Args[0], "", os.Args[1:], defaultConfig, (*Config).registerFlags)
+	if err == nil {
+		err = cfg.validate()
+	}
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
 
-	http.HandleFunc("/set", setHandler)
-	http.HandleFunc("/get", getHandler)
-	http.HandleFunc("/keys", getAllHandler)
-	http.HandleFunc("/info", infoHandler)
+	// Spans are exported in batches while the server runs.
+	shutdownTracing, err := telemetry.Setup(ctx, "go-examples-service", cfg.Telemetry)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	initRedis(cfg.Redis)
+
+	keysCache = cache.New(rdb, "service", cache.Options{SoftTTL: cfg.CacheTTL})
+
+	searchIndex = search.New(rdb, "kv")
+	if err := searchIndex.EnsureIndex(ctx); err != nil {
+		log.Printf("Search disabled: %v", err)
+		searchIndex = nil
+	}
+
+	srv := httpserver.New("Key-value service", cfg.HTTP, cfg.Server)
+	srv.AddReadinessCheck("redis", func(ctx context.Context) error {
+		return rdb.Ping(ctx).Err()
+	})
+	// Hooks run last added first: spans are exported before Redis closes
+	srv.OnShutdown(func(context.Context) error { return rdb.Close() })
+	srv.OnShutdown(shutdownTracing)
+
+	srv.HandleFunc("/set", setHandler)
+	srv.HandleFunc("/get", getHandler)
+	srv.HandleFunc("/keys", getAllHandler)
+	srv.HandleFunc("/info", infoHandler)
+	srv.HandleFunc("GET /search", searchHandler)
+
+	sessionStore = sessions.NewStore(rdb, sessionSecret(cfg.SessionSecret), sessions.Options{})
+	srv.HandleFunc("POST /login", loginHandler)
+	srv.HandleFunc("POST /logout", logoutHandler)
+	srv.HandleFunc("GET /me", meHandler)
 
-	log.Println("Go application starting on :8080")
-	log.Println("Redis server should be running in Docker on localhost:6379")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	srv.Use(telemetry.Handler)
+	if cfg.RateLimit > 0 {
+		limiter := ratelimit.NewTokenBucket(rdb, float64(cfg.RateLimit)/60, cfg.RateLimit)
+		srv.Use(ratelimit.Middleware(limiter, ratelimit.ClientIP))
+	}
+	srv.Use(sessionStore.Middleware)
+
+	log.Printf("Redis server should be running on %s", cfg.Redis.Addr)
+	if err := srv.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,44 @@
+package sessions
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// Codec encodes the values of a session for Redis.
+type Codec interface {
+	Marshal(v map[string]interface{}) ([]byte, error)
+	Unmarshal(data []byte, v *map[string]interface{}) error
+}
+
+var (
+	// JSON stores readable values, numbers come back as float64 and
+	// structs as maps.
+	JSON Codec = jsonCodec{}
+	// Gob keeps the Go types of the values, which must be registered with
+	// gob.Register.
+	Gob Codec = gobCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v map[string]interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v *map[string]interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v *map[string]interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
@@ -0,0 +1,33 @@
+package sessions
+
+import (
+	"context"
+	"log"
+	"net/http"
+)
+
+type contextKey struct{}
+
+// FromContext returns the session loaded by Middleware, nil outside of it.
+func FromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(contextKey{}).(*Session)
+	return s
+}
+
+// Middleware loads the session of every request into its context, see
+// FromContext, and renews the cookie of existing sessions so it expires
+// with the session. Handlers changing the session call Save.
+func (st *Store) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := st.Get(r.Context(), r)
+		if err != nil {
+			log.Printf("Failed to load session: %v", err)
+			http.Error(w, "Session unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if !s.IsNew() {
+			st.setCookie(w, s.id, st.opts.TTL)
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, s)))
+	})
+}
@@ -0,0 +1,203 @@
+// Package sessions stores HTTP sessions in Redis.
+//
+// The cookie holds only the session id, signed with HMAC-SHA256 so ids
+// can not be guessed or forged, and the values live in Redis under
+// session:<id>. Sessions expire after being idle for the TTL: every read
+// resets the expiry of the key and the cookie. Rotate the id when the
+// privileges of a session change, e.g. on login, to defeat session
+// fixation.
+package sessions
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrInvalidCookie is returned for session cookies with a bad signature.
+var ErrInvalidCookie = errors.New("invalid session cookie")
+
+// Options configure a Store.
+type Options struct {
+	// CookieName is the name of the session cookie, default "session".
+	CookieName string
+	// TTL is how long a session lives without requests, default 30m.
+	TTL time.Duration
+	// Secure sends the cookie over HTTPS only.
+	Secure bool
+	// Codec encodes the values, default JSON.
+	Codec Codec
+}
+
+// Store loads and saves sessions.
+type Store struct {
+	rdb    *redis.Client
+	secret []byte
+	opts   Options
+}
+
+// NewStore returns a store signing session ids with secret, which must be
+// the same on all instances and should be at least 32 random bytes.
+func NewStore(rdb *redis.Client, secret []byte, opts Options) *Store {
+	if opts.CookieName == "" {
+		opts.CookieName = "session"
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = 30 * time.Minute
+	}
+	if opts.Codec == nil {
+		opts.Codec = JSON
+	}
+	return &Store{rdb: rdb, secret: secret, opts: opts}
+}
+
+// Session is the state of one client.
+type Session struct {
+	id     string
+	Values map[string]interface{}
+}
+
+// ID returns the id of the session, empty until it was saved.
+func (s *Session) ID() string {
+	return s.id
+}
+
+// IsNew reports whether the session was not saved yet.
+func (s *Session) IsNew() bool {
+	return s.id == ""
+}
+
+func sessionKey(id string) string {
+	return fmt.Sprintf("session:%s", id)
+}
+
+// Get returns the session of the request and resets its expiry, or a new
+// session if the request has none or it expired.
+func (st *Store) Get(ctx context.Context, r *http.Request) (*Session, error) {
+	s := &Session{Values: make(map[string]interface{})}
+
+	c, err := r.Cookie(st.opts.CookieName)
+	if err != nil {
+		return s, nil
+	}
+	id, err := st.verify(c.Value)
+	if err != nil {
+		return s, nil
+	}
+
+	data, err := st.rdb.GetEx(ctx, sessionKey(id), st.opts.TTL).Bytes()
+	if err == redis.Nil {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session: %v", err)
+	}
+	if err := st.opts.Codec.Unmarshal(data, &s.Values); err != nil {
+		return nil, fmt.Errorf("failed to decode session: %v", err)
+	}
+	s.id = id
+	return s, nil
+}
+
+// Save stores the session, creating its id and cookie if it is new.
+// Call it before writing the response body, as it sets a cookie.
+func (st *Store) Save(ctx context.Context, w http.ResponseWriter, s *Session) error {
+	data, err := st.opts.Codec.Marshal(s.Values)
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %v", err)
+	}
+
+	id := s.id
+	if id == "" {
+		if id, err = newID(); err != nil {
+			return err
+		}
+	}
+	if err := st.rdb.Set(ctx, sessionKey(id), data, st.opts.TTL).Err(); err != nil {
+		return fmt.Errorf("failed to save session: %v", err)
+	}
+
+	s.id = id
+	st.setCookie(w, id, st.opts.TTL)
+	return nil
+}
+
+// Rotate moves the session to a new id, the old id is no longer valid.
+func (st *Store) Rotate(ctx context.Context, w http.ResponseWriter, s *Session) error {
+	old := s.id
+	s.id = ""
+	if err := st.Save(ctx, w, s); err != nil {
+		s.id = old
+		return err
+	}
+	if old != "" {
+		if err := st.rdb.Del(ctx, sessionKey(old)).Err(); err != nil {
+			return fmt.Errorf("failed to delete old session: %v", err)
+		}
+	}
+	return nil
+}
+
+// Destroy deletes the session and its cookie.
+func (st *Store) Destroy(ctx context.Context, w http.ResponseWriter, s *Session) error {
+	if s.id != "" {
+		if err := st.rdb.Del(ctx, sessionKey(s.id)).Err(); err != nil {
+			return fmt.Errorf("failed to delete session: %v", err)
+		}
+	}
+	s.id = ""
+	s.Values = make(map[string]interface{})
+	st.setCookie(w, "", -1)
+	return nil
+}
+
+// setCookie sets the signed id, or deletes the cookie with a negative ttl.
+func (st *Store) setCookie(w http.ResponseWriter, id string, ttl time.Duration) {
+	c := &http.Cookie{
+		Name:     st.opts.CookieName,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   st.opts.Secure,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(ttl.Seconds()),
+	}
+	if ttl < 0 {
+		c.MaxAge = -1
+	} else {
+		c.Value = st.sign(id)
+	}
+	http.SetCookie(w, c)
+}
+
+// sign returns id.<base64 HMAC of id>.
+func (st *Store) sign(id string) string {
+	mac := hmac.New(sha256.New, st.secret)
+	mac.Write([]byte(id))
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns the id of a signed cookie value.
+func (st *Store) verify(value string) (string, error) {
+	id, _, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(value), []byte(st.sign(id))) {
+		return "", ErrInvalidCookie
+	}
+	return id, nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,185 @@
+//go:build integration
+
+package sessions
+
+import (
+	"context"
+	"encoding/gob"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"kate.testutil"
+)
+
+// request returns a request carrying the cookies set on rec.
+func request(rec *httptest.ResponseRecorder) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range rec.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestSaveGet(t *testing.T) {
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+	st := NewStore(rdb, []byte("secret"), Options{})
+	ctx := context.Background()
+
+	s, err := st.Get(ctx, httptest.NewRequest(http.MethodGet, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsNew() {
+		t.Fatal("session of a request without cookie is not new")
+	}
+
+	s.Values["user"] = "ada"
+	rec := httptest.NewRecorder()
+	if err := st.Save(ctx, rec, s); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := st.Get(ctx, request(rec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.IsNew() || got.ID() != s.ID() || got.Values["user"] != "ada" {
+		t.Fatalf("got session %q with %v, want %q with user ada", got.ID(), got.Values, s.ID())
+	}
+
+	// A forged id is not accepted.
+	forged := httptest.NewRequest(http.MethodGet, "/", nil)
+	forged.AddCookie(&http.Cookie{Name: "session", Value: s.ID() + ".forged"})
+	if got, err = st.Get(ctx, forged); err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsNew() {
+		t.Fatal("forged cookie loaded the session")
+	}
+}
+
+func TestRotateDestroy(t *testing.T) {
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+	st := NewStore(rdb, []byte("secret"), Options{})
+	ctx := context.Background()
+
+	s := &Session{Values: map[string]interface{}{"user": "ada"}}
+	before := httptest.NewRecorder()
+	if err := st.Save(ctx, before, s); err != nil {
+		t.Fatal(err)
+	}
+	oldID := s.ID()
+
+	after := httptest.NewRecorder()
+	if err := st.Rotate(ctx, after, s); err != nil {
+		t.Fatal(err)
+	}
+	if s.ID() == oldID {
+		t.Fatal("rotate kept the id")
+	}
+
+	if got, _ := st.Get(ctx, request(before)); !got.IsNew() {
+		t.Fatal("old id still valid after rotate")
+	}
+	got, err := st.Get(ctx, request(after))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Values["user"] != "ada" {
+		t.Fatalf("got values %v after rotate, want user ada", got.Values)
+	}
+
+	rec := httptest.NewRecorder()
+	if err := st.Destroy(ctx, rec, got); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := st.Get(ctx, request(after)); !got.IsNew() {
+		t.Fatal("session still valid after destroy")
+	}
+	if c := rec.Result().Cookies(); len(c) != 1 || c[0].MaxAge >= 0 {
+		t.Fatalf("got cookies %v, want the session cookie deleted", c)
+	}
+}
+
+func TestSlidingExpiry(t *testing.T) {
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+	st := NewStore(rdb, []byte("secret"), Options{TTL: time.Second})
+	ctx := context.Background()
+
+	s := &Session{Values: map[string]interface{}{"user": "ada"}}
+	rec := httptest.NewRecorder()
+	if err := st.Save(ctx, rec, s); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every read extends the session by the TTL.
+	for i := 0; i < 3; i++ {
+		time.Sleep(600 * time.Millisecond)
+		if got, _ := st.Get(ctx, request(rec)); got.IsNew() {
+			t.Fatalf("session expired after %d reads", i)
+		}
+	}
+
+	time.Sleep(1200 * time.Millisecond)
+	if got, _ := st.Get(ctx, request(rec)); !got.IsNew() {
+		t.Fatal("idle session did not expire")
+	}
+}
+
+type profile struct {
+	Name  string
+	Admin bool
+}
+
+func TestGob(t *testing.T) {
+	gob.Register(profile{})
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+	st := NewStore(rdb, []byte("secret"), Options{Codec: Gob})
+	ctx := context.Background()
+
+	s := &Session{Values: map[string]interface{}{"profile": profile{Name: "ada", Admin: true}}}
+	rec := httptest.NewRecorder()
+	if err := st.Save(ctx, rec, s); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := st.Get(ctx, request(rec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := got.Values["profile"].(profile); !ok || !p.Admin {
+		t.Fatalf("got %#v, want the profile struct", got.Values["profile"])
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+	st := NewStore(rdb, []byte("secret"), Options{})
+
+	h := st.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := FromContext(r.Context())
+		n, _ := s.Values["visits"].(float64)
+		s.Values["visits"] = n + 1
+		if err := st.Save(r.Context(), w, s); err != nil {
+			t.Error(err)
+		}
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	for i := 0; i < 2; i++ {
+		next := httptest.NewRecorder()
+		h.ServeHTTP(next, request(rec))
+		rec = next
+	}
+
+	s, err := st.Get(context.Background(), request(rec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Values["visits"] != 3.0 {
+		t.Fatalf("got %v visits, want 3", s.Values["visits"])
+	}
+}
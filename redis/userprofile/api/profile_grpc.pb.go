@@ -0,0 +1,209 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: profile.proto
+
+package api
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	UserProfiles_GetProfile_FullMethodName         = "/kate.redis.userprofile.UserProfiles/GetProfile"
+	UserProfiles_UpdateProfile_FullMethodName      = "/kate.redis.userprofile.UserProfiles/UpdateProfile"
+	UserProfiles_InvalidateProfiles_FullMethodName = "/kate.redis.userprofile.UserProfiles/InvalidateProfiles"
+)
+
+// UserProfilesClient is the client API for UserProfiles service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// UserProfiles looks up user profiles, cached in Redis.
+type UserProfilesClient interface {
+	// GetProfile returns the profile of a user, from the cache if possible.
+	GetProfile(ctx context.Context, in *GetProfileRequest, opts ...grpc.CallOption) (*Profile, error)
+	// UpdateProfile stores a profile and drops its cached copy.
+	UpdateProfile(ctx context.Context, in *Profile, opts ...grpc.CallOption) (*Profile, error)
+	// InvalidateProfiles drops the cached copies of profiles, e.g. after
+	// they were changed in the store by another service.
+	InvalidateProfiles(ctx context.Context, in *InvalidateProfilesRequest, opts ...grpc.CallOption) (*InvalidateProfilesResponse, error)
+}
+
+type userProfilesClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewUserProfilesClient(cc grpc.ClientConnInterface) UserProfilesClient {
+	return &userProfilesClient{cc}
+}
+
+func (c *userProfilesClient) GetProfile(ctx context.Context, in *GetProfileRequest, opts ...grpc.CallOption) (*Profile, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Profile)
+	err := c.cc.Invoke(ctx, UserProfiles_GetProfile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userProfilesClient) UpdateProfile(ctx context.Context, in *Profile, opts ...grpc.CallOption) (*Profile, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Profile)
+	err := c.cc.Invoke(ctx, UserProfiles_UpdateProfile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userProfilesClient) InvalidateProfiles(ctx context.Context, in *InvalidateProfilesRequest, opts ...grpc.CallOption) (*InvalidateProfilesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(InvalidateProfilesResponse)
+	err := c.cc.Invoke(ctx, UserProfiles_InvalidateProfiles_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UserProfilesServer is the server API for UserProfiles service.
+// All implementations must embed UnimplementedUserProfilesServer
+// for forward compatibility.
+//
+// UserProfiles looks up user profiles, cached in Redis.
+type UserProfilesServer interface {
+	// GetProfile returns the profile of a user, from the cache if possible.
+	GetProfile(context.Context, *GetProfileRequest) (*Profile, error)
+	// UpdateProfile stores a profile and drops its cached copy.
+	UpdateProfile(context.Context, *Profile) (*Profile, error)
+	// InvalidateProfiles drops the cached copies of profiles, e.g. after
+	// they were changed in the store by another service.
+	InvalidateProfiles(context.Context, *InvalidateProfilesRequest) (*InvalidateProfilesResponse, error)
+	mustEmbedUnimplementedUserProfilesServer()
+}
+
+// UnimplementedUserProfilesServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedUserProfilesServer struct{}
+
+func (UnimplementedUserProfilesServer) GetProfile(context.Context, *GetProfileRequest) (*Profile, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetProfile not implemented")
+}
+func (UnimplementedUserProfilesServer) UpdateProfile(context.Context, *Profile) (*Profile, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateProfile not implemented")
+}
+func (UnimplementedUserProfilesServer) InvalidateProfiles(context.Context, *InvalidateProfilesRequest) (*InvalidateProfilesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method InvalidateProfiles not implemented")
+}
+func (UnimplementedUserProfilesServer) mustEmbedUnimplementedUserProfilesServer() {}
+func (UnimplementedUserProfilesServer) testEmbeddedByValue()                      {}
+
+// UnsafeUserProfilesServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to UserProfilesServer will
+// result in compilation errors.
+type UnsafeUserProfilesServer interface {
+	mustEmbedUnimplementedUserProfilesServer()
+}
+
+func RegisterUserProfilesServer(s grpc.ServiceRegistrar, srv UserProfilesServer) {
+	// If the following call pancis, it indicates UnimplementedUserProfilesServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&UserProfiles_ServiceDesc, srv)
+}
+
+func _UserProfiles_GetProfile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetProfileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserProfilesServer).GetProfile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UserProfiles_GetProfile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserProfilesServer).GetProfile(ctx, req.(*GetProfileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserProfiles_UpdateProfile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Profile)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserProfilesServer).UpdateProfile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UserProfiles_UpdateProfile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserProfilesServer).UpdateProfile(ctx, req.(*Profile))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserProfiles_InvalidateProfiles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InvalidateProfilesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserProfilesServer).InvalidateProfiles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UserProfiles_InvalidateProfiles_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserProfilesServer).InvalidateProfiles(ctx, req.(*InvalidateProfilesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// UserProfiles_ServiceDesc is the grpc.ServiceDesc for UserProfiles service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var UserProfiles_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "kate.redis.userprofile.UserProfiles",
+	HandlerType: (*UserProfilesServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetProfile",
+			Handler:    _UserProfiles_GetProfile_Handler,
+		},
+		{
+			MethodName: "UpdateProfile",
+			Handler:    _UserProfiles_UpdateProfile_Handler,
+		},
+		{
+			MethodName: "InvalidateProfiles",
+			Handler:    _UserProfiles_InvalidateProfiles_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "profile.proto",
+}
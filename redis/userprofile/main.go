@@ -0,0 +1,113 @@
+// User profile service: a gRPC service looking up profiles through a
+// Redis cache-aside layer, see package repository.
+//
+// Try it with grpcurl, the server supports reflection:
+//
+//	grpcurl -plaintext -d '{"user_id":"1"}' localhost:50051 kate.redis.userprofile.UserProfiles/GetProfile
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+	"kate.config"
+	"kate.redis.userprofile/api"
+	"kate.redis.userprofile/repository"
+)
+
+// Config is the Redis server, the listen address and the cache TTL, set
+// with flags, the matching variables (e.g. REDIS_ADDR for -redis-addr) or
+// the -config file.
+type Config struct {
+	Redis    config.RedisConfig `yaml:"redis"`
+	GRPCAddr string             `yaml:"grpc_addr"`
+	CacheTTL time.Duration      `yaml:"cache_ttl"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Redis:    config.DefaultRedisConfig(),
+		GRPCAddr: ":50051",
+		CacheTTL: 5 * time.Minute,
+	}
+}
+
+func (c *Config) registerFlags(fs *flag.FlagSet) {
+	c.Redis.RegisterFlags(fs)
+	fs.StringVar(&c.GRPCAddr, "grpc-addr", c.GRPCAddr, "gRPC listen address")
+	fs.DurationVar(&c.CacheTTL, "cache-ttl", c.CacheTTL, "how long profiles stay cached")
+}
+
+func (c *Config) validate() error {
+	if err := c.Redis.Validate(); err != nil {
+		return err
+	}
+	if c.GRPCAddr == "" {
+		return fmt.Errorf("grpc address is required")
+	}
+	if c.CacheTTL <= 0 {
+		return fmt.Errorf("cache ttl must be positive, got %v", c.CacheTTL)
+	}
+	return nil
+}
+
+// seed are the profiles of the in-memory store.
+var seed = []*api.Profile{
+	{UserId: "1", Name: "Ada", Email: "ada@example.com", Bio: "Writes programs for engines"},
+	{UserId: "2", Name: "Grace", Email: "grace@example.com", Bio: "Finds bugs, literally"},
+	{UserId: "3", Name: "Linus", Email: "linus@example.com", Bio: "Just a hobby"},
+}
+
+func main() {
+	cfg, _, err := config.Load(os.Args[0], "", os.Args[1:], defaultConfig, (*Config).registerFlags)
+	if err == nil {
+		err = cfg.validate()
+	}
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	rdb := cfg.Redis.NewClient()
+	defer rdb.Close()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		log.Fatal("Redis connection failed: ", err)
+	}
+
+	// Every store access takes 100ms, as a query to a loaded database.
+	store := repository.NewMemoryStore(100*time.Millisecond, seed...)
+	repo := repository.NewRepository(rdb, store, cfg.CacheTTL)
+
+	lis, err := net.Listen("tcp", cfg.GRPCAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	srv := grpc.NewServer()
+	api.RegisterUserProfilesServer(srv, &server{repo: repo})
+	reflection.Register(srv)
+
+	go func() {
+		<-ctx.Done()
+		srv.GracefulStop()
+	}()
+
+	log.Printf("User profile service listening on %s", lis.Addr())
+	if err := srv.Serve(lis); err != nil {
+		log.Fatal(err)
+	}
+
+	s := repo.Stats()
+	log.Printf("Cache hits: %d, misses: %d, store loads: %d", s.Hits, s.Misses, s.Loads)
+}
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"kate.redis.userprofile/api"
+)
+
+// MemoryStore stands in for a database, every call takes latency.
+type MemoryStore struct {
+	latency time.Duration
+
+	mu       sync.RWMutex
+	profiles map[string]*api.Profile
+}
+
+func NewMemoryStore(latency time.Duration, profiles ...*api.Profile) *MemoryStore {
+	s := &MemoryStore{
+		latency:  latency,
+		profiles: make(map[string]*api.Profile),
+	}
+	for _, p := range profiles {
+		s.profiles[p.UserId] = proto.Clone(p).(*api.Profile)
+	}
+	return s
+}
+
+func (s *MemoryStore) wait(ctx context.Context) error {
+	select {
+	case <-time.After(s.latency):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, userID string) (*api.Profile, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.profiles[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return proto.Clone(p).(*api.Profile), nil
+}
+
+func (s *MemoryStore) Put(ctx context.Context, p *api.Profile) error {
+	if err := s.wait(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.profiles[p.UserId] = proto.Clone(p).(*api.Profile)
+	return nil
+}
@@ -0,0 +1,140 @@
+// Package repository loads user profiles from a store through a Redis
+// cache-aside layer.
+//
+// A lookup reads the cache first; on a miss it loads the profile from the
+// store and caches it with a TTL. Concurrent misses for the same user are
+// coalesced into one store load, so a popular profile expiring does not
+// send a burst of queries to the store. Writes go to the store and then
+// delete the cached copy, the next lookup caches the new version.
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/protobuf/proto"
+	"kate.redis.userprofile/api"
+)
+
+// ErrNotFound is returned for users without a profile.
+var ErrNotFound = errors.New("profile not found")
+
+// Store is the system of record of the profiles, e.g. a SQL database.
+type Store interface {
+	// Get returns the profile of userID or ErrNotFound.
+	Get(ctx context.Context, userID string) (*api.Profile, error)
+	Put(ctx context.Context, p *api.Profile) error
+}
+
+// Stats counts the cache lookups, loads are the misses which went to the
+// store after coalescing.
+type Stats struct {
+	Hits   int64
+	Misses int64
+	Loads  int64
+}
+
+// Repository reads profiles from the cache and falls back to the store.
+type Repository struct {
+	rdb   *redis.Client
+	store Store
+	ttl   time.Duration
+	group singleflight.Group
+
+	hits, misses, loads atomic.Int64
+}
+
+func NewRepository(rdb *redis.Client, store Store, ttl time.Duration) *Repository {
+	return &Repository{rdb: rdb, store: store, ttl: ttl}
+}
+
+func cacheKey(userID string) string {
+	return fmt.Sprintf("profile:%s", userID)
+}
+
+// Get returns the profile of userID from the cache or the store. Cache
+// errors are not fatal, the profile is then served from the store.
+func (r *Repository) Get(ctx context.Context, userID string) (*api.Profile, error) {
+	data, err := r.rdb.Get(ctx, cacheKey(userID)).Bytes()
+	if err == nil {
+		p := &api.Profile{}
+		if err := proto.Unmarshal(data, p); err == nil {
+			r.hits.Add(1)
+			return p, nil
+		}
+	}
+	r.misses.Add(1)
+
+	// Waiters share the result of the first caller, which must not be
+	// cancelled with the context of its request.
+	v, err, _ := r.group.Do(userID, func() (interface{}, error) {
+		return r.load(context.WithoutCancel(ctx), userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return proto.Clone(v.(*api.Profile)).(*api.Profile), nil
+}
+
+// load reads the profile from the store and caches it.
+func (r *Repository) load(ctx context.Context, userID string) (*api.Profile, error) {
+	r.loads.Add(1)
+	p, err := r.store.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := proto.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode profile: %v", err)
+	}
+	if err := r.rdb.Set(ctx, cacheKey(userID), data, r.ttl).Err(); err != nil {
+		log.Printf("Failed to cache profile %s: %v", userID, err)
+	}
+	return p, nil
+}
+
+// Put writes the profile to the store and invalidates its cached copy.
+func (r *Repository) Put(ctx context.Context, p *api.Profile) error {
+	if err := r.store.Put(ctx, p); err != nil {
+		return err
+	}
+	_, err := r.Invalidate(ctx, p.UserId)
+	return err
+}
+
+// Invalidate deletes the cached profiles of userIDs and returns how many
+// were cached.
+func (r *Repository) Invalidate(ctx context.Context, userIDs ...string) (int64, error) {
+	if len(userIDs) == 0 {
+		return 0, nil
+	}
+
+	keys := make([]string, len(userIDs))
+	for i, id := range userIDs {
+		keys[i] = cacheKey(id)
+		// Lookups from now on load again instead of joining a load
+		// which may have read the old version.
+		r.group.Forget(id)
+	}
+	n, err := r.rdb.Del(ctx, keys...).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to invalidate profiles: %v", err)
+	}
+	return n, nil
+}
+
+// Stats returns the counters since the repository was created.
+func (r *Repository) Stats() Stats {
+	return Stats{
+		Hits:   r.hits.Load(),
+		Misses: r.misses.Load(),
+		Loads:  r.loads.Load(),
+	}
+}
@@ -0,0 +1,92 @@
+//go:build integration
+
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"kate.redis.userprofile/api"
+	"kate.testutil"
+)
+
+func TestGetCoalescesMisses(t *testing.T) {
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+	store := NewMemoryStore(200*time.Millisecond, &api.Profile{UserId: "1", Name: "Ada"})
+	repo := NewRepository(rdb, store, time.Minute)
+	ctx := context.Background()
+
+	// A burst of lookups of an uncached profile loads it once.
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p, err := repo.Get(ctx, "1")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if p.Name != "Ada" {
+				t.Errorf("got name %q, want Ada", p.Name)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if s := repo.Stats(); s.Loads != 1 {
+		t.Fatalf("got %d loads, want 1", s.Loads)
+	}
+
+	if _, err := repo.Get(ctx, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if s := repo.Stats(); s.Hits != 1 || s.Loads != 1 {
+		t.Fatalf("got %d hits and %d loads, want 1 and 1", s.Hits, s.Loads)
+	}
+
+	ttl, err := rdb.TTL(ctx, cacheKey("1")).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("got ttl %v, want up to 1m", ttl)
+	}
+}
+
+func TestPutInvalidates(t *testing.T) {
+	rdb := testutil.NewRedisClient(t, testutil.StartRedis(t))
+	store := NewMemoryStore(0, &api.Profile{UserId: "1", Name: "Ada"})
+	repo := NewRepository(rdb, store, time.Minute)
+	ctx := context.Background()
+
+	if _, err := repo.Get(ctx, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Put(ctx, &api.Profile{UserId: "1", Name: "Ada Lovelace"}); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := repo.Get(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "Ada Lovelace" {
+		t.Fatalf("got name %q after update, want Ada Lovelace", p.Name)
+	}
+
+	n, err := repo.Invalidate(ctx, "1", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("invalidated %d profiles, want 1", n)
+	}
+
+	if _, err := repo.Get(ctx, "2"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v for unknown user, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"kate.redis.userprofile/api"
+	"kate.redis.userprofile/repository"
+)
+
+// server implements the UserProfiles service on the repository.
+type server struct {
+	api.UnimplementedUserProfilesServer
+	repo *repository.Repository
+}
+
+func (s *server) GetProfile(ctx context.Context, req *api.GetProfileRequest) (*api.Profile, error) {
+	if req.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	p, err := s.repo.Get(ctx, req.UserId)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "no profile of user %s", req.UserId)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get profile: %v", err)
+	}
+	return p, nil
+}
+
+func (s *server) UpdateProfile(ctx context.Context, p *api.Profile) (*api.Profile, error) {
+	if p.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	if err := s.repo.Put(ctx, p); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to update profile: %v", err)
+	}
+	return p, nil
+}
+
+func (s *server) InvalidateProfiles(ctx context.Context, req *api.InvalidateProfilesRequest) (*api.InvalidateProfilesResponse, error) {
+	n, err := s.repo.Invalidate(ctx, req.UserIds...)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "%v", err)
+	}
+	return &api.InvalidateProfilesResponse{Invalidated: n}, nil
+}
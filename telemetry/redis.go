@@ -0,0 +1,63 @@
+package telemetry
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentRedis starts a client span for every command and pipeline
+// rdb sends, as child of the span in the context of the call.
+func InstrumentRedis(rdb *redis.Client) {
+	rdb.AddHook(redisHook{tracer: otel.Tracer(instrumentation)})
+}
+
+// redisHook implements redis.Hook.
+type redisHook struct {
+	tracer trace.Tracer
+}
+
+func (h redisHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	ctx, _ = h.tracer.Start(ctx, cmd.FullName(),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemRedis, semconv.DBOperationName(cmd.FullName())))
+	return ctx, nil
+}
+
+func (h redisHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	end(trace.SpanFromContext(ctx), cmd.Err())
+	return nil
+}
+
+func (h redisHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	ctx, _ = h.tracer.Start(ctx, "pipeline",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemRedis, attribute.Int("db.redis.commands", len(cmds))))
+	return ctx, nil
+}
+
+func (h redisHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if err = cmd.Err(); err != nil && err != redis.Nil {
+			break
+		}
+	}
+	end(trace.SpanFromContext(ctx), err)
+	return nil
+}
+
+// end records err on span unless it is redis.Nil, a missing key, and
+// ends the span.
+func end(span trace.Span, err error) {
+	if err != nil && err != redis.Nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
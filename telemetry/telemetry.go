@@ -0,0 +1,74 @@
+// Package telemetry sets up the OpenTelemetry tracing shared by the
+// examples and instruments HTTP servers and Redis clients, so a single
+// trace follows a request or event through all of them. The Kafka
+// producer and consumer carry the trace in the W3C traceparent header.
+package telemetry
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// instrumentation is the name of the tracers of this package.
+const instrumentation = "kate.telemetry"
+
+// Config is where spans are exported to.
+type Config struct {
+	// OTLPEndpoint is the host:port of an OTLP/HTTP collector, tracing
+	// is off when it is empty.
+	OTLPEndpoint string `yaml:"otlp_endpoint"`
+}
+
+// RegisterFlags binds the fields to flags of fs.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.OTLPEndpoint, "otlp-endpoint", c.OTLPEndpoint, "export spans via OTLP/HTTP to this host:port, e.g. localhost:4318")
+}
+
+// NewTracerProvider returns a provider exporting the spans of service via
+// OTLP over HTTP to endpoint. It has to be shut down to flush the spans.
+func NewTracerProvider(ctx context.Context, service, endpoint string) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracehttp.New(ctx,
+		otlptracehttp.WithEndpoint(endpoint),
+		otlptracehttp.WithInsecure())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create otlp exporter: %v", err)
+	}
+
+	res, err := resource.Merge(resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service)))
+	if err != nil {
+		return nil, err
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	), nil
+}
+
+// Setup installs a provider exporting the spans of service as the global
+// one, which Handler and InstrumentRedis use, together with the W3C trace
+// context propagator. The returned function flushes the spans, it has to
+// be called before the program exits. Without an endpoint tracing stays
+// off and the function does nothing.
+func Setup(ctx context.Context, service string, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	if cfg.OTLPEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	tp, err := NewTracerProvider(ctx, service, cfg.OTLPEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
@@ -0,0 +1,183 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/testcontainers/testcontainers-go"
+	tckafka "github.com/testcontainers/testcontainers-go/modules/kafka"
+	"kate.config"
+)
+
+// KafkaImage is a single KRaft broker.
+const KafkaImage = "confluentinc/confluent-local:7.5.0"
+
+// Record is a message to seed a topic with.
+type Record struct {
+	Key   string
+	Value string
+}
+
+// StartKafka starts a broker which is removed when the test ends, and
+// returns how to connect to it.
+func StartKafka(t *testing.T) config.KafkaConfig {
+	t.Helper()
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+
+	ctr, err := tckafka.Run(ctx, KafkaImage, tckafka.WithClusterID("go-examples"))
+	testcontainers.CleanupContainer(t, ctr)
+	if err != nil {
+		t.Fatalf("failed to start kafka: %v", err)
+	}
+
+	brokers, err := ctr.Brokers(ctx)
+	if err != nil {
+		t.Fatalf("failed to get kafka brokers: %v", err)
+	}
+
+	cfg := config.DefaultKafkaConfig()
+	cfg.BootstrapServers = strings.Join(brokers, ",")
+	if err := WaitForKafka(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// WaitForKafka blocks until the cluster returns its metadata or ctx is done.
+func WaitForKafka(ctx context.Context, cfg config.KafkaConfig) error {
+	a, err := kafka.NewAdminClient(configMap(cfg))
+	if err != nil {
+		return fmt.Errorf("failed to create admin client: %v", err)
+	}
+	defer a.Close()
+
+	return WaitFor(ctx, 500*time.Millisecond, func() error {
+		md, err := a.GetMetadata(nil, true, 1000)
+		if err != nil {
+			return err
+		}
+		if len(md.Brokers) == 0 {
+			return fmt.Errorf("no brokers available")
+		}
+		return nil
+	})
+}
+
+// CreateTopic creates topic with the given number of partitions.
+func CreateTopic(t testing.TB, cfg config.KafkaConfig, topic string, partitions int) {
+	t.Helper()
+
+	a, err := kafka.NewAdminClient(configMap(cfg))
+	if err != nil {
+		t.Fatalf("failed to create admin client: %v", err)
+	}
+	defer a.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	results, err := a.CreateTopics(ctx, []kafka.TopicSpecification{{
+		Topic:             topic,
+		NumPartitions:     partitions,
+		ReplicationFactor: 1,
+	}})
+	if err != nil {
+		t.Fatalf("failed to create topic %s: %v", topic, err)
+	}
+	for _, r := range results {
+		if r.Error.Code() != kafka.ErrNoError && r.Error.Code() != kafka.ErrTopicAlreadyExists {
+			t.Fatalf("failed to create topic %s: %v", r.Topic, r.Error)
+		}
+	}
+}
+
+// SeedKafka produces the records to topic and waits until all of them
+// were acknowledged.
+func SeedKafka(t testing.TB, cfg config.KafkaConfig, topic string, records ...Record) {
+	t.Helper()
+
+	cm := configMap(cfg)
+	cm.SetKey("acks", "all")
+	p, err := kafka.NewProducer(cm)
+	if err != nil {
+		t.Fatalf("failed to create producer: %v", err)
+	}
+	defer p.Close()
+
+	deliveryChan := make(chan kafka.Event, len(records))
+	for _, r := range records {
+		msg := &kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+			Value:          []byte(r.Value),
+		}
+		if r.Key != "" {
+			msg.Key = []byte(r.Key)
+		}
+		if err := p.Produce(msg, deliveryChan); err != nil {
+			t.Fatalf("failed to produce to %s: %v", topic, err)
+		}
+	}
+
+	timeout := time.After(30 * time.Second)
+	for range records {
+		select {
+		case e := <-deliveryChan:
+			if m := e.(*kafka.Message); m.TopicPartition.Error != nil {
+				t.Fatalf("failed to deliver to %s: %v", topic, m.TopicPartition.Error)
+			}
+		case <-timeout:
+			t.Fatalf("timed out seeding %s", topic)
+		}
+	}
+}
+
+// ConsumeKafka reads n messages of topic from the beginning, failing the
+// test if they do not arrive within 30 seconds.
+func ConsumeKafka(t testing.TB, cfg config.KafkaConfig, topic string, n int) []*kafka.Message {
+	t.Helper()
+
+	cm := configMap(cfg)
+	cm.SetKey("group.id", "testutil-"+topic)
+	cm.SetKey("auto.offset.reset", "earliest")
+	c, err := kafka.NewConsumer(cm)
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.Subscribe(topic, nil); err != nil {
+		t.Fatalf("failed to subscribe to %s: %v", topic, err)
+	}
+
+	var msgs []*kafka.Message
+	deadline := time.Now().Add(30 * time.Second)
+	for len(msgs) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d messages from %s, want %d", len(msgs), topic, n)
+		}
+		msg, err := c.ReadMessage(time.Second)
+		if err != nil {
+			if err.(kafka.Error).IsTimeout() {
+				continue
+			}
+			t.Fatalf("failed to read from %s: %v", topic, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func configMap(cfg config.KafkaConfig) *kafka.ConfigMap {
+	cm := &kafka.ConfigMap{}
+	for k, v := range cfg.ClientProperties() {
+		cm.SetKey(k, v)
+	}
+	return cm
+}
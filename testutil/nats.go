@@ -0,0 +1,42 @@
+package testutil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// NATSImage is the NATS image of the redis docker-compose setup.
+const NATSImage = "nats:2.10-alpine"
+
+// StartNATS starts a NATS server with JetStream which is removed when the
+// test ends, and returns its URL.
+func StartNATS(t *testing.T) string {
+	t.Helper()
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+
+	ctr, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        NATSImage,
+			Cmd:          []string{"-js"},
+			ExposedPorts: []string{"4222/tcp"},
+			WaitingFor:   wait.ForLog("Server is ready"),
+		},
+		Started: true,
+	})
+	testcontainers.CleanupContainer(t, ctr)
+	if err != nil {
+		t.Fatalf("failed to start nats: %v", err)
+	}
+
+	url, err := ctr.PortEndpoint(ctx, "4222/tcp", "nats")
+	if err != nil {
+		t.Fatalf("failed to get nats url: %v", err)
+	}
+	return url
+}
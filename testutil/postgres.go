@@ -0,0 +1,40 @@
+package testutil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/testcontainers/testcontainers-go"
+	tcpostgres "github.com/testcontainers/testcontainers-go/modules/postgres"
+)
+
+// PostgresImage is the image of the kafka docker-compose setup.
+const PostgresImage = "postgres:16"
+
+// StartPostgres starts a PostgreSQL server which is removed when the test
+// ends, runs the SQL files initScripts in it and returns its URL.
+func StartPostgres(t *testing.T, initScripts ...string) string {
+	t.Helper()
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+
+	ctr, err := tcpostgres.Run(ctx, PostgresImage,
+		tcpostgres.WithDatabase("outbox"),
+		tcpostgres.WithUsername("outbox"),
+		tcpostgres.WithPassword("outbox"),
+		tcpostgres.WithInitScripts(initScripts...),
+		tcpostgres.BasicWaitStrategies(),
+	)
+	testcontainers.CleanupContainer(t, ctr)
+	if err != nil {
+		t.Fatalf("failed to start postgres: %v", err)
+	}
+
+	url, err := ctr.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		t.Fatalf("failed to get postgres url: %v", err)
+	}
+	return url
+}
@@ -0,0 +1,42 @@
+package testutil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// RabbitMQImage is the image of the rabbitmq docker-compose setup, without
+// the management plugin.
+const RabbitMQImage = "rabbitmq:3.13-alpine"
+
+// StartRabbitMQ starts a RabbitMQ broker which is removed when the test
+// ends, and returns its AMQP URL for the guest user.
+func StartRabbitMQ(t *testing.T) string {
+	t.Helper()
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+
+	ctr, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        RabbitMQImage,
+			ExposedPorts: []string{"5672/tcp"},
+			WaitingFor:   wait.ForLog("Server startup complete"),
+		},
+		Started: true,
+	})
+	testcontainers.CleanupContainer(t, ctr)
+	if err != nil {
+		t.Fatalf("failed to start rabbitmq: %v", err)
+	}
+
+	addr, err := ctr.PortEndpoint(ctx, "5672/tcp", "")
+	if err != nil {
+		t.Fatalf("failed to get rabbitmq address: %v", err)
+	}
+	return "amqp://guest:guest@" + addr + "/"
+}
@@ -0,0 +1,93 @@
+package testutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/testcontainers/testcontainers-go"
+	tcredis "github.com/testcontainers/testcontainers-go/modules/redis"
+	"kate.config"
+)
+
+// Images of the redis docker-compose setup. Redis Stack adds modules like
+// RedisBloom and RediSearch.
+const (
+	RedisImage      = "redis:7-alpine"
+	RedisStackImage = "redis/redis-stack-server:7.2.0-v10"
+)
+
+// StartRedis starts a Redis server which is removed when the test ends,
+// and returns how to connect to it. Benchmarks may use it as well.
+func StartRedis(t testing.TB) config.RedisConfig {
+	t.Helper()
+	return startRedis(t, RedisImage)
+}
+
+// StartRedisStack is StartRedis with the modules of Redis Stack loaded.
+func StartRedisStack(t testing.TB) config.RedisConfig {
+	t.Helper()
+	return startRedis(t, RedisStackImage)
+}
+
+func startRedis(t testing.TB, image string) config.RedisConfig {
+	t.Helper()
+	skipWithoutDocker(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+
+	ctr, err := tcredis.Run(ctx, image)
+	testcontainers.CleanupContainer(t, ctr)
+	if err != nil {
+		t.Fatalf("failed to start redis: %v", err)
+	}
+
+	addr, err := ctr.PortEndpoint(ctx, "6379/tcp", "")
+	if err != nil {
+		t.Fatalf("failed to get redis address: %v", err)
+	}
+
+	cfg := config.DefaultRedisConfig()
+	cfg.Addr = addr
+	if err := WaitForRedis(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// NewRedisClient returns a client for cfg which is closed when the test ends.
+func NewRedisClient(t testing.TB, cfg config.RedisConfig) *redis.Client {
+	t.Helper()
+
+	rdb := cfg.NewClient()
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+// WaitForRedis blocks until the server answers PING or ctx is done.
+func WaitForRedis(ctx context.Context, cfg config.RedisConfig) error {
+	rdb := cfg.NewClient()
+	defer rdb.Close()
+
+	return WaitFor(ctx, 100*time.Millisecond, func() error {
+		return rdb.Ping(ctx).Err()
+	})
+}
+
+// SeedRedis sets the given string keys, without expiry.
+func SeedRedis(t testing.TB, rdb *redis.Client, values map[string]string) {
+	t.Helper()
+
+	ctx := context.Background()
+	_, err := rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, value := range values {
+			pipe.Set(ctx, key, value, 0)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to seed redis: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+// Package testutil starts Redis, Kafka and the other servers in containers
+// for the integration tests of the examples, and seeds them with data.
+//
+// The tests carry the integration build tag and need Docker:
+//
+//	go test -tags integration ./...
+//
+// Without a reachable Docker daemon they are skipped.
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+)
+
+// startTimeout bounds pulling an image and starting its container.
+const startTimeout = 3 * time.Minute
+
+// Eventually calls cond every interval until it returns nil, and fails
+// the test with its last error once timeout passed.
+func Eventually(t testing.TB, timeout, interval time.Duration, cond func() error) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := WaitFor(ctx, interval, cond); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// WaitFor calls cond every interval until it returns nil or ctx is done.
+func WaitFor(ctx context.Context, interval time.Duration, cond func() error) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := cond()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("condition not met in time: %v", err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// skipWithoutDocker skips tb unless Docker is reachable, like
+// testcontainers.SkipIfProviderIsNotHealthy but for benchmarks too.
+func skipWithoutDocker(tb testing.TB) {
+	tb.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			tb.Skipf("Docker is not running: %v", r)
+		}
+	}()
+
+	provider, err := testcontainers.ProviderDocker.GetProvider()
+	if err == nil {
+		err = provider.Health(context.Background())
+	}
+	if err != nil {
+		tb.Skipf("Docker is not running: %v", err)
+	}
+}